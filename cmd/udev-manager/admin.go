@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/ydb-platform/udev-manager/internal/plugin"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// Endpoints of the admin API.
+const (
+	resourcesPath = "/resources"
+	debugPath     = "/debug/resources"
+	discoveryPath = "/discovery"
+	devicesPath   = "/devices"
+	hupPath       = "/hup"
+)
+
+// deviceInfo is a udev device as the admin API dumps it.
+type deviceInfo struct {
+	Id         udev.Id           `json:"id"`
+	Parent     udev.Id           `json:"parent,omitempty"`
+	Subsystem  string            `json:"subsystem"`
+	DevType    string            `json:"devtype,omitempty"`
+	DevNode    string            `json:"devnode,omitempty"`
+	DevLinks   []string          `json:"devlinks,omitempty"`
+	NumaNode   int               `json:"numaNode"`
+	Tags       []string          `json:"tags,omitempty"`
+	Properties map[string]string `json:"properties"`
+	SysAttrs   map[string]string `json:"sysattrs"`
+}
+
+func makeDeviceInfo(dev udev.Device) deviceInfo {
+	info := deviceInfo{
+		Id:         dev.Id(),
+		Subsystem:  dev.Subsystem(),
+		DevType:    dev.DevType(),
+		DevNode:    dev.DevNode(),
+		DevLinks:   dev.DevLinks(),
+		NumaNode:   dev.NumaNode(),
+		Tags:       dev.Tags(),
+		Properties: dev.Properties(),
+		SysAttrs:   dev.SystemAttributes(),
+	}
+	if parent := dev.Parent(); parent != nil {
+		info.Parent = parent.Id()
+	}
+	return info
+}
+
+// findDevices returns the devices whose sysfs path, kernel name or device
+// node is name, sorted by sysfs path.
+func findDevices(discovery udev.Discovery, name string) []udev.Device {
+	if dev := discovery.DeviceById(udev.Id(name)); dev != nil {
+		return []udev.Device{dev}
+	}
+	found := make([]udev.Device, 0)
+	for _, dev := range discovery.State(func(dev udev.Device) bool {
+		return udev.Sysname(dev) == name || (dev.DevNode() != "" && dev.DevNode() == name)
+	}) {
+		found = append(found, dev)
+	}
+	slices.SortFunc(found, func(a, b udev.Device) int {
+		return strings.Compare(string(a.Id()), string(b.Id()))
+	})
+	return found
+}
+
+// handleAdmin adds the introspection endpoints of registry and discovery to
+// admin:
+//
+//	GET  /resources          resources, their instances and kubelet registration
+//	GET  /debug/resources    the same with the last health transitions of instances
+//	GET  /discovery          discovery statistics
+//	GET  /devices?name=NAME  devices by sysfs path, kernel name or device node
+//	POST /hup                re-register all plugins with kubelet
+func handleAdmin(admin *http.ServeMux, registry *plugin.Registry, discovery udev.Discovery) {
+	admin.HandleFunc("GET "+resourcesPath, func(resp http.ResponseWriter, req *http.Request) {
+		writeJSON(resp, registry.Status())
+	})
+	admin.HandleFunc("GET "+debugPath, func(resp http.ResponseWriter, req *http.Request) {
+		writeJSON(resp, registry.Debug())
+	})
+	admin.HandleFunc("GET "+discoveryPath, func(resp http.ResponseWriter, req *http.Request) {
+		writeJSON(resp, discovery.Stats())
+	})
+	admin.HandleFunc("GET "+devicesPath, func(resp http.ResponseWriter, req *http.Request) {
+		name := req.URL.Query().Get("name")
+		if name == "" {
+			http.Error(resp, "name must be set", http.StatusBadRequest)
+			return
+		}
+		devices := findDevices(discovery, name)
+		if len(devices) == 0 {
+			http.Error(resp, "no device "+name, http.StatusNotFound)
+			return
+		}
+		infos := make([]deviceInfo, len(devices))
+		for i, dev := range devices {
+			infos[i] = makeDeviceInfo(dev)
+		}
+		writeJSON(resp, infos)
+	})
+	admin.HandleFunc("POST "+hupPath, func(resp http.ResponseWriter, req *http.Request) {
+		registry.Hup()
+		resp.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func writeJSON(resp http.ResponseWriter, value any) {
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(value); err != nil {
+		klog.Errorf("admin: failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/ydb-platform/udev-manager/internal/plugin"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+var _ = Describe("Admin API", func() {
+	var (
+		ctx     context.Context
+		tmpDir  string
+		kubelet *fakeKubelet
+		socket  string
+	)
+
+	// ctl runs the ctl subcommand against the admin socket and returns its
+	// exit code and output.
+	ctl := func(args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCtl(append([]string{"-socket", socket}, args...), &stdout, &stderr)
+		return code, stdout.String() + stderr.String()
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "dp")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() { os.RemoveAll(tmpDir) })
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+
+		discovery := udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+		discovery.AddDevice(makePartitionDevice("/sys/block/nvme0n1/nvme0n1p1", "/dev/nvme0n1p1", "nvme_disk01"))
+
+		var kubeSock string
+		kubelet, kubeSock = startFakeKubelet(tmpDir)
+		DeferCleanup(kubelet.Stop)
+
+		config := mustParseYAML(`
+domain: ydb.tech
+partitions:
+  - matcher: "nvme_(.*)"
+maintenance:
+  stateFile: ` + filepath.Join(tmpDir, "cordons.json") + `
+driveInventory:
+  path: ` + filepath.Join(tmpDir, "drives.json") + `
+`)
+		admin := http.NewServeMux()
+		_, cleanup, err := startApp(ctx, wg, discovery, config, admin,
+			plugin.WithPluginDir(tmpDir+"/"),
+			plugin.WithKubeletSocket(kubeSock),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(cleanup)
+		waitForRegistrations(kubelet, 1)
+
+		socket = filepath.Join(tmpDir, "admin", "admin.sock")
+		closeAdmin, err := serveAdmin(socket, admin)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(closeAdmin)
+	})
+
+	It("lists resources with their registration and subscribers", func() {
+		code, out := ctl("resources")
+		Expect(code).To(Equal(0), out)
+		Expect(out).To(MatchRegexp(`ydb.tech/part-disk01\s+\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\s+0\s+1\s+0`))
+
+		client, conn := dialPlugin(waitForSockets(tmpDir)[0])
+		DeferCleanup(func() { conn.Close() })
+		stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+
+		var statuses []plugin.ResourceStatus
+		Eventually(func() int {
+			code, out := ctl("-o", "json", "resources")
+			Expect(code).To(Equal(0), out)
+			Expect(json.Unmarshal([]byte(out), &statuses)).To(Succeed())
+			return statuses[0].Subscribers
+		}).Should(Equal(1))
+		Expect(statuses[0].Registered).To(BeTrue())
+	})
+
+	It("lists instances with their health and devices", func() {
+		code, out := ctl("instances", "ydb.tech/part-disk01")
+		Expect(code).To(Equal(0), out)
+		Expect(out).To(MatchRegexp(`ydb.tech/part-disk01\s+disk01\s+Healthy\s+\S+ \S+\s+/sys/block/nvme0n1/nvme0n1p1\s+udev: found /sys/block/nvme0n1/nvme0n1p1\n`))
+
+		Expect(ctl("cordon", "disk01", "disk", "swap")).To(Equal(0))
+		code, out = ctl("-o", "json", "instances", "ydb.tech/part-disk01")
+		Expect(code).To(Equal(0), out)
+		var instances []plugin.InstanceStatus
+		Expect(json.Unmarshal([]byte(out), &instances)).To(Succeed())
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].Id).To(BeEquivalentTo("disk01"))
+		Expect(instances[0].Health).To(Equal("Unhealthy"))
+		Expect(instances[0].Reason).To(HavePrefix("cordoned as disk01 since "))
+		Expect(instances[0].Since).NotTo(BeZero())
+		Expect(instances[0].Devices).To(Equal([]string{"/sys/block/nvme0n1/nvme0n1p1"}))
+
+		code, out = ctl("cordons")
+		Expect(code).To(Equal(0), out)
+		Expect(out).To(MatchRegexp(`disk01\s+.*\s+api\s+disk swap`))
+		Expect(ctl("uncordon", "disk01")).To(Equal(0))
+
+		code, out = ctl("instances", "ydb.tech/part-missing")
+		Expect(code).To(Equal(1))
+		Expect(out).To(ContainSubstring("no resource ydb.tech/part-missing"))
+	})
+
+	It("serves the health history of instances", func() {
+		Expect(ctl("cordon", "disk01", "disk", "swap")).To(Equal(0))
+		Expect(ctl("uncordon", "disk01")).To(Equal(0))
+
+		var statuses []plugin.ResourceStatus
+		_, err := newCtlClient(socket).get(debugPath, &statuses)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statuses).To(HaveLen(1))
+		history := statuses[0].Instances[0].History
+		Expect(history).To(HaveLen(3))
+		Expect(history[0]).To(MatchFields(IgnoreExtras, Fields{
+			"Health": Equal("Healthy"),
+			"Cause":  Equal("registered"),
+		}))
+		Expect(history[1]).To(MatchFields(IgnoreExtras, Fields{
+			"Health": Equal("Unhealthy"),
+			"Reason": ContainSubstring("disk swap"),
+			"Cause":  Equal("maintenance: cordoned disk01"),
+		}))
+		Expect(history[2]).To(MatchFields(IgnoreExtras, Fields{
+			"Health": Equal("Healthy"),
+			"Cause":  Equal("maintenance: uncordoned disk01"),
+		}))
+	})
+
+	It("serves the drive inventory and writes it to a file", func() {
+		var drives []plugin.Drive
+		_, err := newCtlClient(socket).get(drivesPath, &drives)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(drives).To(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"Resource": Equal("ydb.tech/part-disk01"),
+			"Id":       BeEquivalentTo("disk01"),
+			"DevNode":  Equal("/dev/nvme0n1p1"),
+			"Label":    Equal("nvme_disk01"),
+			"Serial":   Equal("SN-nvme_disk01"),
+			"Health":   Equal("Healthy"),
+		})))
+
+		data, err := os.ReadFile(filepath.Join(tmpDir, "drives.json"))
+		Expect(err).NotTo(HaveOccurred())
+		var written []plugin.Drive
+		Expect(json.Unmarshal(data, &written)).To(Succeed())
+		Expect(written).To(Equal(drives))
+	})
+
+	It("shows discovery statistics and dumps devices", func() {
+		code, out := ctl("discovery")
+		Expect(code).To(Equal(0), out)
+		Expect(out).To(MatchRegexp(`DEVICES\s+1\n`))
+		Expect(out).To(MatchRegexp(`block\s+1\n`))
+
+		code, out = ctl("device", "nvme0n1p1")
+		Expect(code).To(Equal(0), out)
+		Expect(out).To(MatchRegexp(`DEVNODE\s+/dev/nvme0n1p1\n`))
+		Expect(out).To(MatchRegexp(`E: PARTNAME\s+nvme_disk01\n`))
+		Expect(out).To(MatchRegexp(`A: serial\s+SN-nvme_disk01\n`))
+
+		code, out = ctl("-o", "json", "device", "/dev/nvme0n1p1")
+		Expect(code).To(Equal(0), out)
+		Expect(out).To(ContainSubstring(`"id": "/sys/block/nvme0n1/nvme0n1p1"`))
+
+		code, out = ctl("device", "sda")
+		Expect(code).To(Equal(1))
+		Expect(out).To(ContainSubstring("no device sda"))
+	})
+
+	It("re-registers plugins on hup", func() {
+		Expect(ctl("hup")).To(Equal(0))
+		waitForRegistrations(kubelet, 2)
+	})
+
+	It("takes flags after the command", func() {
+		code, out := ctl("resources", "-o", "json")
+		Expect(code).To(Equal(0), out)
+		var statuses []plugin.ResourceStatus
+		Expect(json.Unmarshal([]byte(out), &statuses)).To(Succeed())
+
+		code, out = ctl("instances", "ydb.tech/part-disk01", "-o", "json")
+		Expect(code).To(Equal(0), out)
+		var instances []plugin.InstanceStatus
+		Expect(json.Unmarshal([]byte(out), &instances)).To(Succeed())
+		Expect(instances).To(HaveLen(1))
+
+		Expect(ctl("cordon", "disk01", "--", "-o", "json")).To(Equal(0))
+		code, out = ctl("cordons")
+		Expect(code).To(Equal(0), out)
+		Expect(out).To(MatchRegexp(`disk01\s+.*\s+api\s+-o json`))
+		Expect(ctl("uncordon", "disk01")).To(Equal(0))
+
+		code, out = ctl("resources", "-x")
+		Expect(code).To(Equal(2))
+		Expect(out).To(ContainSubstring("usage: udev-manager ctl"))
+	})
+
+	It("rejects unknown commands and unreachable sockets", func() {
+		code, out := ctl("frobnicate")
+		Expect(code).To(Equal(2))
+		Expect(out).To(ContainSubstring("usage: udev-manager ctl"))
+
+		socket = filepath.Join(tmpDir, "missing.sock")
+		code, _ = ctl("resources")
+		Expect(code).To(Equal(1))
+	})
+})
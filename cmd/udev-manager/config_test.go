@@ -2,9 +2,13 @@ package main
 
 import (
 	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/kube"
+	"github.com/ydb-platform/udev-manager/internal/plugin"
 )
 
 // parseYAML is a test helper that parses a YAML string into a appConfig.
@@ -60,6 +64,19 @@ health_check_port: 9090
 		Expect(cfg.HealthCheckPort).To(BeEquivalentTo(9090))
 	})
 
+	It("defaults sysfs_root to /sys", func() {
+		cfg := mustParseYAML(minimalValidConfig)
+		Expect(cfg.SysfsRoot).To(Equal("/sys"))
+	})
+
+	It("rejects a relative sysfs_root", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+sysfs_root: host/sys
+`)
+		Expect(err).To(MatchError(ContainSubstring(".sysfs_root")))
+	})
+
 	It("accepts disable_topology_hints flag", func() {
 		cfg := mustParseYAML(`
 domain: ydb.tech
@@ -126,6 +143,105 @@ var _ = Describe("partitionsConfig.validate", func() {
 		Expect(pc.validate()).To(MatchError(ContainSubstring(".domain")))
 	})
 
+	It("defaults to device mode", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`}
+		Expect(pc.validate()).NotTo(HaveOccurred())
+		Expect(pc.Mode).To(Equal(partitionModeDevice))
+		_, ok := pc.mountConfig()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("defaults the staging dir in mount mode", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, Mode: "mount", FsType: "ext4", ReadOnly: true}
+		Expect(pc.validate()).NotTo(HaveOccurred())
+		mountConfig, ok := pc.mountConfig()
+		Expect(ok).To(BeTrue())
+		Expect(mountConfig.StagingDir).To(Equal(defaultStagingDir))
+		Expect(mountConfig.FsType).To(Equal("ext4"))
+		Expect(mountConfig.ReadOnly).To(BeTrue())
+	})
+
+	It("requires fsType in mount mode", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, Mode: "mount"}
+		Expect(pc.validate()).To(MatchError(ContainSubstring(".fsType")))
+	})
+
+	It("rejects a relative containerPath", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, templatesConfig: templatesConfig{ContainerPath: "data/{{ .Label }}"}}
+		Expect(pc.validate()).To(MatchError(ContainSubstring("must be absolute")))
+	})
+
+	It("accepts templates that render on a sample partition", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, templatesConfig: templatesConfig{
+			ContainerPath: "/dev/ydb/{{ .Label }}",
+			Env: []envTemplateConfig{
+				{Name: "YDB_DISK_{{ .Label | upper }}", Value: "{{ .ContainerPath }}"},
+				{Name: "YDB_DISK_{{ .Label | upper }}_SERIAL", Value: `{{ .Property "ID_SERIAL_SHORT" }}`},
+			},
+		}}
+		Expect(pc.validate()).NotTo(HaveOccurred())
+		Expect(pc.options()).To(HaveLen(3))
+	})
+
+	It("rejects a template that does not parse", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, templatesConfig: templatesConfig{
+			Env: []envTemplateConfig{{Name: "YDB_{{ .Label", Value: "x"}},
+		}}
+		Expect(pc.validate()).To(MatchError(ContainSubstring(".env[0].name")))
+	})
+
+	It("rejects a template that fails on a sample partition", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, templatesConfig: templatesConfig{
+			ContainerPath: "/dev/ydb/{{ .NoSuchField }}",
+		}}
+		Expect(pc.validate()).To(MatchError(ContainSubstring("sample partition")))
+	})
+
+	It("rejects env names that are not variable names", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, templatesConfig: templatesConfig{
+			Env: []envTemplateConfig{{Name: "YDB-{{ .Label }}", Value: "x"}},
+		}}
+		Expect(pc.validate()).To(MatchError(ContainSubstring("not a valid variable name")))
+	})
+
+	It("defaults to one writable replica", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`}
+		Expect(pc.validate()).NotTo(HaveOccurred())
+		Expect(pc.Replicas).To(Equal(1))
+		Expect(pc.Permissions).To(Equal("rw"))
+	})
+
+	It("accepts read-only replicas", func() {
+		pc := &partitionsConfig{Matcher: `refdata_(.*)`, Replicas: 8, Permissions: "r"}
+		Expect(pc.validate()).NotTo(HaveOccurred())
+	})
+
+	It("rejects writable replicas unless forced", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, Replicas: 2}
+		Expect(pc.validate()).To(MatchError(ContainSubstring(".replicas")))
+
+		pc = &partitionsConfig{Matcher: `data_(.*)`, Replicas: 2, ForceSharedWrite: true}
+		Expect(pc.validate()).NotTo(HaveOccurred())
+	})
+
+	It("treats read-only mounts as read-only replicas", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, Mode: "mount", FsType: "ext4", Replicas: 2}
+		Expect(pc.validate()).To(MatchError(ContainSubstring(".replicas")))
+
+		pc = &partitionsConfig{Matcher: `data_(.*)`, Mode: "mount", FsType: "ext4", ReadOnly: true, Replicas: 2}
+		Expect(pc.validate()).NotTo(HaveOccurred())
+	})
+
+	It("rejects unknown permissions", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, Permissions: "w"}
+		Expect(pc.validate()).To(MatchError(ContainSubstring(".permissions")))
+	})
+
+	It("rejects an unknown mode", func() {
+		pc := &partitionsConfig{Matcher: `data_(.*)`, Mode: "bind"}
+		Expect(pc.validate()).To(MatchError(ContainSubstring(".mode")))
+	})
+
 	It("compiles matcher so it can be used after validate", func() {
 		pc := &partitionsConfig{Matcher: `nvme_(disk\d+)`}
 		Expect(pc.validate()).NotTo(HaveOccurred())
@@ -178,6 +294,38 @@ var _ = Describe("batchPartitionsConfig.validate", func() {
 		Expect(bc.validate()).NotTo(HaveOccurred())
 	})
 
+	It("accepts expected members and a minimum member count", func() {
+		bc := &batchPartitionsConfig{
+			Name:       "nvme-set",
+			Matcher:    `nvme_(data_\d+)`,
+			Expected:   []string{"data_01", "data_02"},
+			MinMembers: 2,
+		}
+		Expect(bc.validate()).NotTo(HaveOccurred())
+		Expect(bc.options()).To(HaveLen(2))
+	})
+
+	It("adds the NUMA alignment option when numaAligned is set", func() {
+		bc := &batchPartitionsConfig{Name: "nvme-set", Matcher: `nvme.*`, NumaAligned: true}
+		Expect(bc.validate()).NotTo(HaveOccurred())
+		Expect(bc.options()).To(HaveLen(1))
+	})
+
+	It("rejects a negative minMembers", func() {
+		bc := &batchPartitionsConfig{Name: "nvme-set", Matcher: `nvme.*`, MinMembers: -1}
+		Expect(bc.validate()).To(MatchError(ContainSubstring(".minMembers")))
+	})
+
+	It("rejects an empty expected label", func() {
+		bc := &batchPartitionsConfig{Name: "nvme-set", Matcher: `nvme.*`, Expected: []string{""}}
+		Expect(bc.validate()).To(MatchError(ContainSubstring(".expected[0]")))
+	})
+
+	It("rejects duplicate expected labels", func() {
+		bc := &batchPartitionsConfig{Name: "nvme-set", Matcher: `nvme.*`, Expected: []string{"a", "a"}}
+		Expect(bc.validate()).To(MatchError(ContainSubstring("duplicate label")))
+	})
+
 	It("compiles matcher so it can be used after validate", func() {
 		bc := &batchPartitionsConfig{Name: "nvme-set", Matcher: `nvme_data_\d+`}
 		Expect(bc.validate()).NotTo(HaveOccurred())
@@ -186,6 +334,64 @@ var _ = Describe("batchPartitionsConfig.validate", func() {
 	})
 })
 
+var _ = Describe("diskGroupsConfig.validate", func() {
+	It("defaults the name to the serial of the disk", func() {
+		dc := &diskGroupsConfig{Matcher: `^ydb_(.*)$`}
+		Expect(dc.validate()).NotTo(HaveOccurred())
+		Expect(dc.Name).To(Equal(plugin.DefaultDiskGroupName))
+		Expect(dc.name).NotTo(BeNil())
+		Expect(dc.matcher).NotTo(BeNil())
+	})
+
+	It("accepts a name template using the disk properties", func() {
+		dc := &diskGroupsConfig{Name: `{{ .Model | lower }}-{{ .Property "ID_WWN" }}`, Matcher: `^ydb_`}
+		Expect(dc.validate()).NotTo(HaveOccurred())
+	})
+
+	It("rejects a name template that does not parse", func() {
+		dc := &diskGroupsConfig{Name: `{{ .Serial`, Matcher: `^ydb_`}
+		Expect(dc.validate()).To(MatchError(ContainSubstring(".name")))
+	})
+
+	It("rejects a name template that fails on a sample disk", func() {
+		dc := &diskGroupsConfig{Name: `{{ .Nope }}`, Matcher: `^ydb_`}
+		Expect(dc.validate()).To(MatchError(ContainSubstring("sample disk")))
+	})
+
+	It("rejects an invalid regexp", func() {
+		dc := &diskGroupsConfig{Matcher: `[`}
+		Expect(dc.validate()).To(MatchError(ContainSubstring(".matcher")))
+	})
+
+	It("rejects an invalid domain override", func() {
+		dc := &diskGroupsConfig{Matcher: `^ydb_`, DomainOverride: "bad domain"}
+		Expect(dc.validate()).To(MatchError(ContainSubstring(".domain")))
+	})
+
+	It("rejects duplicate expected labels", func() {
+		dc := &diskGroupsConfig{Matcher: `^ydb_(.*)$`, Expected: []string{"a", "a"}}
+		Expect(dc.validate()).To(MatchError(ContainSubstring("duplicate label")))
+	})
+
+	It("rejects a negative pruneAfter", func() {
+		dc := &diskGroupsConfig{Matcher: `^ydb_(.*)$`, PruneAfter: -time.Hour}
+		Expect(dc.validate()).To(MatchError(ContainSubstring(".pruneAfter")))
+	})
+
+	It("converts the quorum, NUMA, template and pruning settings into options", func() {
+		dc := &diskGroupsConfig{
+			Matcher:         `^ydb_(.*)$`,
+			Expected:        []string{"data_01"},
+			MinMembers:      2,
+			NumaAligned:     true,
+			PruneAfter:      24 * time.Hour,
+			templatesConfig: templatesConfig{ContainerPath: "/dev/ydb/{{ .Label }}"},
+		}
+		Expect(dc.validate()).NotTo(HaveOccurred())
+		Expect(dc.options()).To(HaveLen(5))
+	})
+})
+
 var _ = Describe("netBWConfig.validate", func() {
 	It("accepts a valid matcher", func() {
 		nc := &netBWConfig{Matcher: `eth.*`, MbpsPerShare: 100}
@@ -198,6 +404,23 @@ var _ = Describe("netBWConfig.validate", func() {
 		Expect(nc.validate()).To(MatchError(ContainSubstring(".matcher")))
 	})
 
+	It("accepts an absolute allocationDir", func() {
+		nc := &netBWConfig{Matcher: `eth.*`, MbpsPerShare: 100, AllocationDir: "/run/udev-manager/netbw"}
+		Expect(nc.validate()).NotTo(HaveOccurred())
+		Expect(nc.options()).To(HaveLen(1))
+	})
+
+	It("turns reservedMbps and fallbackSpeedMbps into options", func() {
+		nc := &netBWConfig{Matcher: `eth.*`, MbpsPerShare: 100, ReservedMbps: 1000, FallbackSpeedMbps: 10000}
+		Expect(nc.validate()).NotTo(HaveOccurred())
+		Expect(nc.options()).To(HaveLen(2))
+	})
+
+	It("rejects a relative allocationDir", func() {
+		nc := &netBWConfig{Matcher: `eth.*`, MbpsPerShare: 100, AllocationDir: "netbw"}
+		Expect(nc.validate()).To(MatchError(ContainSubstring(".allocationDir")))
+	})
+
 	It("compiles matcher so it can be used after validate", func() {
 		nc := &netBWConfig{Matcher: `eth\d+`}
 		Expect(nc.validate()).NotTo(HaveOccurred())
@@ -217,6 +440,75 @@ var _ = Describe("netRdmaConfig.validate", func() {
 		nc := &netRdmaConfig{Matcher: `[`}
 		Expect(nc.validate()).To(MatchError(ContainSubstring(".matcher")))
 	})
+
+	It("accepts the ipv4 and ipv6 families", func() {
+		for _, family := range []string{"ipv4", "ipv6"} {
+			nc := &netRdmaConfig{Matcher: `ib.*`, ResourceCount: 2, IPFamily: family, MountSysfs: true}
+			Expect(nc.validate()).NotTo(HaveOccurred())
+			Expect(nc.options()).To(HaveLen(2))
+		}
+	})
+
+	It("rejects an unknown ipFamily", func() {
+		nc := &netRdmaConfig{Matcher: `ib.*`, ResourceCount: 2, IPFamily: "inet"}
+		Expect(nc.validate()).To(MatchError(ContainSubstring(".ipFamily")))
+	})
+})
+
+var _ = Describe("sriovConfig.validate", func() {
+	It("accepts a valid matcher", func() {
+		sc := &sriovConfig{Matcher: `ens1(f\d+)`}
+		Expect(sc.validate()).NotTo(HaveOccurred())
+		Expect(sc.matcher.MatchString("ens1f0")).To(BeTrue())
+	})
+
+	It("rejects an invalid regexp", func() {
+		sc := &sriovConfig{Matcher: `[`}
+		Expect(sc.validate()).To(MatchError(ContainSubstring(".matcher")))
+	})
+})
+
+var _ = Describe("vfioConfig.validate", func() {
+	It("accepts a name with one matcher", func() {
+		vc := &vfioConfig{Name: "nvme", VendorDevice: `144d:a80a`}
+		Expect(vc.validate()).NotTo(HaveOccurred())
+		Expect(vc.matcher.VendorDevice.MatchString("144d:a80a")).To(BeTrue())
+		Expect(vc.matcher.Class).To(BeNil())
+		Expect(vc.matcher.Address).To(BeNil())
+	})
+
+	It("rejects an invalid name", func() {
+		vc := &vfioConfig{Name: "nvme/0", Class: `0x0108..`}
+		Expect(vc.validate()).To(MatchError(ContainSubstring(".name")))
+	})
+
+	It("requires at least one matcher", func() {
+		vc := &vfioConfig{Name: "nvme"}
+		Expect(vc.validate()).To(MatchError(ContainSubstring("vendorDevice, class or address")))
+	})
+
+	It("rejects an invalid regexp", func() {
+		vc := &vfioConfig{Name: "nvme", Address: `[`}
+		Expect(vc.validate()).To(MatchError(ContainSubstring(".address")))
+	})
+})
+
+var _ = Describe("nvmeNamespaceConfig.validate", func() {
+	It("accepts a name and a matcher", func() {
+		nc := &nvmeNamespaceConfig{Name: "nvme", Matcher: `^nvme\d+n1$`}
+		Expect(nc.validate()).NotTo(HaveOccurred())
+		Expect(nc.matcher.MatchString("nvme3n1")).To(BeTrue())
+	})
+
+	It("rejects an invalid name", func() {
+		nc := &nvmeNamespaceConfig{Matcher: `.*`}
+		Expect(nc.validate()).To(MatchError(ContainSubstring(".name")))
+	})
+
+	It("rejects an invalid regexp", func() {
+		nc := &nvmeNamespaceConfig{Name: "nvme", Matcher: `[`}
+		Expect(nc.validate()).To(MatchError(ContainSubstring(".matcher")))
+	})
 })
 
 var _ = Describe("hostDevConfig.validate", func() {
@@ -308,3 +600,281 @@ networkRdma:
 		Expect(err.Error()).To(ContainSubstring(".networkRdma[0]"))
 	})
 })
+
+var _ = Describe("volumeConfig.validate", func() {
+	It("accepts a name with one matcher", func() {
+		vc := &volumeConfig{Name: "lvm", VG: `^ydb$`}
+		Expect(vc.validate()).NotTo(HaveOccurred())
+		Expect(vc.matcher.VG.MatchString("ydb")).To(BeTrue())
+		Expect(vc.matcher.Name).To(BeNil())
+		Expect(vc.matcher.Level).To(BeNil())
+	})
+
+	It("rejects an invalid name", func() {
+		vc := &volumeConfig{Name: "lvm/0", Matcher: `.*`}
+		Expect(vc.validate()).To(MatchError(ContainSubstring(".name")))
+	})
+
+	It("requires at least one matcher", func() {
+		vc := &volumeConfig{Name: "lvm"}
+		Expect(vc.validate()).To(MatchError(ContainSubstring("matcher, vg, lv, uuid or level")))
+	})
+
+	It("rejects a level together with device-mapper matchers", func() {
+		vc := &volumeConfig{Name: "raid", Level: `^raid1$`, UUID: `^LVM-`}
+		Expect(vc.validate()).To(MatchError(ContainSubstring(".level")))
+	})
+
+	It("rejects an invalid regexp", func() {
+		vc := &volumeConfig{Name: "lvm", LV: `[`}
+		Expect(vc.validate()).To(MatchError(ContainSubstring(".lv")))
+	})
+})
+
+var _ = Describe("appConfig resource names", func() {
+	It("rejects two resources with the same name", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+volumes:
+  - name: lvm
+    vg: '^ydb$'
+  - name: lvm
+    vg: '^data$'
+`)
+		Expect(err).To(MatchError(ContainSubstring(`.volumes[1]: resource "ydb.tech/volume-lvm" is also defined by .volumes[0]`)))
+	})
+
+	It("accepts the same name for different kinds of resources", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+vfio:
+  - name: nvme
+    vendorDevice: '^144d:a80a$'
+nvmeNamespaces:
+  - name: nvme
+    matcher: '^nvme\d+n1$'
+volumes:
+  - name: nvme
+    matcher: '^nvme$'
+`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("accepts the same batch name in another domain", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+batchPartitions:
+  - name: data
+    matcher: '^data_'
+  - name: data
+    matcher: '^data_'
+    domain: storage.example.com
+`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("kmsgConfig", func() {
+	It("is off unless the kmsg section is set", func() {
+		cfg := mustParseYAML(`
+domain: ydb.tech
+`)
+		Expect(cfg.Kmsg).To(BeNil())
+	})
+
+	It("parses the threshold and window", func() {
+		cfg := mustParseYAML(`
+domain: ydb.tech
+kmsg:
+  threshold: 3
+  window: 1h
+`)
+		Expect(cfg.Kmsg).NotTo(BeNil())
+		Expect(cfg.Kmsg.watcherConfig()).To(Equal(plugin.KmsgConfig{Threshold: 3, Window: time.Hour, StateFile: defaultKmsgStateFile}))
+	})
+
+	It("rejects relative paths and negative limits", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+kmsg:
+  path: kmsg
+  stateFile: io-errors.json
+  threshold: -1
+  window: -1m
+`)
+		Expect(err).To(MatchError(ContainSubstring(".kmsg: .path")))
+		Expect(err).To(MatchError(ContainSubstring(".stateFile")))
+		Expect(err).To(MatchError(ContainSubstring(".threshold")))
+		Expect(err).To(MatchError(ContainSubstring(".window")))
+	})
+})
+
+var _ = Describe("smartConfig", func() {
+	It("parses the commands, period and limits", func() {
+		cfg := mustParseYAML(`
+domain: ydb.tech
+smart:
+  nvme: /usr/sbin/nvme
+  period: 1h
+  mediaErrors: 1
+  percentageUsed: 95
+  temperature: 70
+`)
+		Expect(cfg.Smart).NotTo(BeNil())
+		Expect(cfg.Smart.prober()).To(Equal(&plugin.SmartProber{NvmePath: "/usr/sbin/nvme"}))
+		Expect(cfg.Smart.probeConfig()).To(Equal(plugin.DiskProbeConfig{
+			Period:         time.Hour,
+			MediaErrors:    1,
+			PercentageUsed: 95,
+			Temperature:    70,
+		}))
+	})
+
+	It("rejects negative limits", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+smart:
+  period: -1m
+  percentageUsed: -1
+`)
+		Expect(err).To(MatchError(ContainSubstring(".smart: .period")))
+		Expect(err).To(MatchError(ContainSubstring(".percentageUsed")))
+	})
+})
+
+var _ = Describe("maintenanceConfig", func() {
+	It("defaults the admin socket and the state file", func() {
+		cfg := mustParseYAML(`
+domain: ydb.tech
+maintenance:
+  markerDir: /var/lib/udev-manager/cordon
+`)
+		Expect(cfg.AdminSocket).To(Equal(defaultAdminSocket))
+		Expect(cfg.Maintenance.maintenanceConfig()).To(Equal(plugin.MaintenanceConfig{
+			StateFile: defaultCordonStateFile,
+			MarkerDir: "/var/lib/udev-manager/cordon",
+		}))
+	})
+
+	It("rejects relative paths", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+admin_socket: admin.sock
+maintenance:
+  stateFile: cordons.json
+  markerDir: cordon
+`)
+		Expect(err).To(MatchError(ContainSubstring(".admin_socket")))
+		Expect(err).To(MatchError(ContainSubstring(".maintenance: .stateFile")))
+		Expect(err).To(MatchError(ContainSubstring(".markerDir")))
+	})
+})
+
+var _ = Describe("kubernetesConfig", func() {
+	It("is off unless the kubernetes section is set", func() {
+		cfg := mustParseYAML(`
+domain: ydb.tech
+`)
+		Expect(cfg.Kubernetes).To(BeNil())
+	})
+
+	It("defaults the node name and the condition", func() {
+		GinkgoT().Setenv(nodeNameEnv, "node-1")
+		cfg := mustParseYAML(`
+domain: ydb.tech
+kubernetes:
+  server: https://10.96.0.1:443
+  tokenFile: /etc/udev-manager/token
+  eventNamespace: kube-system
+`)
+		Expect(cfg.Kubernetes.reporterConfig()).To(Equal(plugin.NodeReporterConfig{
+			Node:          "node-1",
+			Namespace:     "kube-system",
+			ConditionType: plugin.DefaultNodeConditionType,
+		}))
+		Expect(cfg.Kubernetes.clientConfig()).To(Equal(kube.Config{
+			Server:    "https://10.96.0.1:443",
+			TokenFile: "/etc/udev-manager/token",
+		}))
+	})
+
+	It("uses the service account without a server", func() {
+		GinkgoT().Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+		GinkgoT().Setenv("KUBERNETES_SERVICE_PORT", "443")
+		cfg := mustParseYAML(`
+domain: ydb.tech
+kubernetes:
+  nodeName: node-1
+  caFile: /etc/udev-manager/ca.crt
+`)
+		Expect(cfg.Kubernetes.clientConfig()).To(Equal(kube.Config{
+			Server:    "https://10.96.0.1:443",
+			TokenFile: kube.ServiceAccountTokenFile,
+			CAFile:    "/etc/udev-manager/ca.crt",
+		}))
+	})
+
+	It("rejects invalid servers, paths and condition types and a missing node name", func() {
+		GinkgoT().Setenv(nodeNameEnv, "")
+		_, err := parseYAML(`
+domain: ydb.tech
+kubernetes:
+  server: 10.96.0.1:443
+  tokenFile: token
+  condition: "Devices Healthy"
+  resync: -1m
+`)
+		Expect(err).To(MatchError(ContainSubstring(".kubernetes: .server")))
+		Expect(err).To(MatchError(ContainSubstring(".tokenFile")))
+		Expect(err).To(MatchError(ContainSubstring(".nodeName")))
+		Expect(err).To(MatchError(ContainSubstring(".condition")))
+		Expect(err).To(MatchError(ContainSubstring(".resync")))
+	})
+})
+
+var _ = Describe("nfdConfig", func() {
+	It("defaults the directory, file and label", func() {
+		cfg := mustParseYAML(`
+domain: ydb.tech
+nfd: {}
+`)
+		config := cfg.Nfd.featureFileConfig(cfg.SysfsRoot)
+		Expect(config.Dir).To(Equal(plugin.DefaultFeatureDir))
+		Expect(config.File).To(Equal(plugin.DefaultFeatureFile))
+		Expect(config.Label).NotTo(BeNil())
+	})
+
+	It("rejects relative directories, paths as file names and invalid labels", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+nfd:
+  dir: features.d
+  file: ../udev-manager
+  label: "ydb tech/{{ .Kind }}"
+`)
+		Expect(err).To(MatchError(ContainSubstring(".nfd: .dir")))
+		Expect(err).To(MatchError(ContainSubstring(".file")))
+		Expect(err).To(MatchError(ContainSubstring(".label: template fails on a sample feature")))
+	})
+})
+
+var _ = Describe("driveInventoryConfig", func() {
+	It("defaults the path", func() {
+		cfg := mustParseYAML(`
+domain: ydb.tech
+driveInventory: {}
+`)
+		Expect(cfg.DriveInventory.inventoryConfig()).To(Equal(plugin.DriveInventoryConfig{
+			Path: plugin.DefaultDriveInventoryPath,
+		}))
+	})
+
+	It("rejects relative paths", func() {
+		_, err := parseYAML(`
+domain: ydb.tech
+driveInventory:
+  path: drives.json
+`)
+		Expect(err).To(MatchError(ContainSubstring(".driveInventory: .path")))
+	})
+})
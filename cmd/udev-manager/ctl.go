@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ydb-platform/udev-manager/internal/plugin"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+const ctlUsage = `usage: udev-manager ctl [-socket PATH] [-o table|json] COMMAND [ARGS]
+
+commands:
+  resources                  list resources, their kubelet registration and ListAndWatch subscribers
+  instances [RESOURCE]       list instances with their health and backing devices
+  discovery                  show udev discovery statistics
+  device NAME                dump a device by sysfs path, kernel name or device node
+  hup                        re-register all plugins with kubelet
+  cordons                    list cordons
+  cordon TARGET [REASON...]  cordon a device or instance
+  uncordon TARGET            lift a cordon
+
+Flags may also follow COMMAND and its arguments; put "--" before arguments
+that start with "-".
+`
+
+// ctlClient talks to the admin API on a Unix socket.
+type ctlClient struct {
+	http *http.Client
+}
+
+func newCtlClient(socket string) *ctlClient {
+	return &ctlClient{http: &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+// do sends a request to the admin API and returns the response body, or an
+// error carrying the body of a failed request.
+func (c *ctlClient) do(method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, "http://admin"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("%s %s: %s", method, path, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// get fetches path and decodes it into value.
+func (c *ctlClient) get(path string, value any) ([]byte, error) {
+	out, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(out, value); err != nil {
+		return nil, fmt.Errorf("failed to parse response of %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// runCtl runs the ctl subcommand with args and returns its exit code.
+func runCtl(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { _, _ = fmt.Fprint(stderr, ctlUsage) }
+	socket := flags.String("socket", defaultAdminSocket, "admin socket of udev-manager")
+	output := flags.String("o", "table", `output format, "table" or "json"`)
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(args) == 0 || (*output != "table" && *output != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	ctl := &ctl{client: newCtlClient(*socket), out: stdout, json: *output == "json"}
+	if err := ctl.run(args[0], args[1:]); err != nil {
+		if errors.Is(err, errCtlUsage) {
+			flags.Usage()
+			return 2
+		}
+		_, _ = fmt.Fprintf(stderr, "udev-manager ctl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseInterspersed parses the flags in args wherever they are, unlike
+// flags.Parse, which stops at the first argument that is not a flag, and
+// returns the other arguments. Everything after "--" is an argument.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if parsed := args[:len(args)-len(rest)]; len(parsed) > 0 && parsed[len(parsed)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// errCtlUsage is returned for commands given the wrong arguments.
+var errCtlUsage = errors.New("invalid arguments")
+
+type ctl struct {
+	client *ctlClient
+	out    io.Writer
+	json   bool
+}
+
+func (c *ctl) run(command string, args []string) error {
+	switch command {
+	case "resources":
+		return c.resources(args)
+	case "instances":
+		return c.instances(args)
+	case "discovery":
+		return c.discovery(args)
+	case "device":
+		return c.device(args)
+	case "hup":
+		if len(args) != 0 {
+			return errCtlUsage
+		}
+		_, err := c.client.do(http.MethodPost, hupPath, nil)
+		return err
+	case "cordons":
+		return c.cordons(args)
+	case "cordon":
+		if len(args) == 0 {
+			return errCtlUsage
+		}
+		body, err := json.Marshal(plugin.Cordon{Target: args[0], Reason: strings.Join(args[1:], " ")})
+		if err != nil {
+			return err
+		}
+		_, err = c.client.do(http.MethodPost, cordonsPath, bytes.NewReader(body))
+		return err
+	case "uncordon":
+		if len(args) != 1 {
+			return errCtlUsage
+		}
+		_, err := c.client.do(http.MethodDelete, cordonsPath+"?target="+url.QueryEscape(args[0]), nil)
+		return err
+	default:
+		return errCtlUsage
+	}
+}
+
+// printJSON writes the response body out indented.
+func (c *ctl) printJSON(out []byte) error {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out, "", "  "); err != nil {
+		return err
+	}
+	_, err := c.out.Write(indented.Bytes())
+	return err
+}
+
+func (c *ctl) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+}
+
+func (c *ctl) resources(args []string) error {
+	if len(args) != 0 {
+		return errCtlUsage
+	}
+	var statuses []plugin.ResourceStatus
+	out, err := c.client.get(resourcesPath, &statuses)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(out)
+	}
+
+	w := c.table()
+	_, _ = fmt.Fprintln(w, "NAME\tREGISTERED\tSUBSCRIBERS\tINSTANCES\tUNHEALTHY")
+	for _, status := range statuses {
+		registered := "no"
+		switch {
+		case status.Registered:
+			registered = status.RegisteredAt.Local().Format(time.DateTime)
+		case status.RegisterError != "":
+			registered = "no: " + status.RegisterError
+		}
+		unhealthy := 0
+		for _, instance := range status.Instances {
+			if instance.Health != (plugin.Healthy{}).String() {
+				unhealthy++
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", status.Name, registered, status.Subscribers, len(status.Instances), unhealthy)
+	}
+	return w.Flush()
+}
+
+func (c *ctl) instances(args []string) error {
+	if len(args) > 1 {
+		return errCtlUsage
+	}
+	var statuses []plugin.ResourceStatus
+	out, err := c.client.get(resourcesPath, &statuses)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		statuses = slices.DeleteFunc(statuses, func(status plugin.ResourceStatus) bool {
+			return status.Name != args[0]
+		})
+		if len(statuses) == 0 {
+			return fmt.Errorf("no resource %s", args[0])
+		}
+	}
+	if c.json {
+		if len(args) == 0 {
+			return c.printJSON(out)
+		}
+		out, err := json.Marshal(statuses[0].Instances)
+		if err != nil {
+			return err
+		}
+		return c.printJSON(out)
+	}
+
+	w := c.table()
+	_, _ = fmt.Fprintln(w, "RESOURCE\tID\tHEALTH\tSINCE\tDEVICES\tREASON")
+	for _, status := range statuses {
+		for _, instance := range status.Instances {
+			since := ""
+			if !instance.Since.IsZero() {
+				since = instance.Since.Local().Format(time.DateTime)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				status.Name, instance.Id, instance.Health, since, strings.Join(instance.Devices, ","), instance.Reason)
+		}
+	}
+	return w.Flush()
+}
+
+func (c *ctl) discovery(args []string) error {
+	if len(args) != 0 {
+		return errCtlUsage
+	}
+	var stats udev.Stats
+	out, err := c.client.get(discoveryPath, &stats)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(out)
+	}
+
+	lastEvent := "never"
+	if !stats.LastEvent.IsZero() {
+		lastEvent = stats.LastEvent.Local().Format(time.DateTime)
+	}
+	w := c.table()
+	_, _ = fmt.Fprintf(w, "DEVICES\t%d\n", stats.Devices)
+	_, _ = fmt.Fprintf(w, "ADDED\t%d\n", stats.Added)
+	_, _ = fmt.Fprintf(w, "REMOVED\t%d\n", stats.Removed)
+	_, _ = fmt.Fprintf(w, "RECONNECTS\t%d\n", stats.Reconnects)
+	_, _ = fmt.Fprintf(w, "LAST EVENT\t%s\n", lastEvent)
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "SUBSYSTEM\tDEVICES")
+	for _, subsystem := range sortedKeys(stats.Subsystems) {
+		_, _ = fmt.Fprintf(w, "%s\t%d\n", subsystem, stats.Subsystems[subsystem])
+	}
+	return w.Flush()
+}
+
+func (c *ctl) device(args []string) error {
+	if len(args) != 1 {
+		return errCtlUsage
+	}
+	var infos []deviceInfo
+	out, err := c.client.get(devicesPath+"?name="+url.QueryEscape(args[0]), &infos)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(out)
+	}
+
+	w := c.table()
+	for i, info := range infos {
+		if i > 0 {
+			_, _ = fmt.Fprintln(w)
+		}
+		_, _ = fmt.Fprintf(w, "ID\t%s\n", info.Id)
+		_, _ = fmt.Fprintf(w, "PARENT\t%s\n", info.Parent)
+		_, _ = fmt.Fprintf(w, "SUBSYSTEM\t%s\n", info.Subsystem)
+		_, _ = fmt.Fprintf(w, "DEVTYPE\t%s\n", info.DevType)
+		_, _ = fmt.Fprintf(w, "DEVNODE\t%s\n", info.DevNode)
+		_, _ = fmt.Fprintf(w, "DEVLINKS\t%s\n", strings.Join(info.DevLinks, " "))
+		_, _ = fmt.Fprintf(w, "NUMA NODE\t%d\n", info.NumaNode)
+		_, _ = fmt.Fprintf(w, "TAGS\t%s\n", strings.Join(info.Tags, " "))
+		for _, key := range sortedKeys(info.Properties) {
+			_, _ = fmt.Fprintf(w, "E: %s\t%s\n", key, info.Properties[key])
+		}
+		for _, key := range sortedKeys(info.SysAttrs) {
+			_, _ = fmt.Fprintf(w, "A: %s\t%s\n", key, info.SysAttrs[key])
+		}
+	}
+	return w.Flush()
+}
+
+func (c *ctl) cordons(args []string) error {
+	if len(args) != 0 {
+		return errCtlUsage
+	}
+	var cordons []plugin.Cordon
+	out, err := c.client.get(cordonsPath, &cordons)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(out)
+	}
+
+	w := c.table()
+	_, _ = fmt.Fprintln(w, "TARGET\tSINCE\tSOURCE\tREASON")
+	for _, cordon := range cordons {
+		source := "api"
+		if cordon.Marker {
+			source = "marker"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", cordon.Target, cordon.Since.Local().Format(time.DateTime), source, cordon.Reason)
+	}
+	return w.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
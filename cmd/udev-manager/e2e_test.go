@@ -15,6 +15,7 @@ import (
 
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 
+	"github.com/ydb-platform/udev-manager/internal/kube"
 	"github.com/ydb-platform/udev-manager/internal/plugin"
 	"github.com/ydb-platform/udev-manager/internal/udev"
 )
@@ -61,7 +62,7 @@ func startTestApp(
 	discovery *udev.FakeDiscovery, config *appConfig,
 	tmpDir, kubeSock string,
 ) *plugin.Registry {
-	registry, cleanup, err := startApp(ctx, wg, discovery, config,
+	registry, cleanup, err := startApp(ctx, wg, discovery, config, http.NewServeMux(),
 		plugin.WithPluginDir(tmpDir+"/"),
 		plugin.WithKubeletSocket(kubeSock),
 	)
@@ -176,6 +177,67 @@ partitions:
 		})
 	})
 
+	Describe("Kubernetes node reporting", func() {
+		It("creates events and keeps the node condition as devices come and go", func() {
+			server := kube.NewFakeAPIServer()
+			DeferCleanup(server.Close)
+
+			dev := makePartitionDevice("/sys/block/nvme0n1/nvme0n1p1", "/dev/nvme0n1p1", "nvme_disk01")
+			discovery.AddDevice(dev)
+			config := mustParseYAML(`
+domain: ydb.tech
+partitions:
+  - matcher: "nvme_(.*)"
+kubernetes:
+  server: ` + server.URL + `
+  nodeName: node-1
+`)
+			startTestApp(ctx, wg, discovery, config, tmpDir, kubeSock)
+			waitForRegistrations(kubelet, 1)
+
+			condition := func() []kube.NodeCondition { return server.Conditions("node-1") }
+			Eventually(condition).Should(ConsistOf(HaveField("Status", kube.ConditionTrue)))
+
+			discovery.Emit(udev.Removed{Device: dev})
+			Eventually(condition).Should(ConsistOf(And(
+				HaveField("Type", "UdevManagerDevicesHealthy"),
+				HaveField("Status", kube.ConditionFalse),
+				HaveField("Message", "ydb.tech/part-disk01/disk01: udev: removed /sys/block/nvme0n1/nvme0n1p1"),
+			)))
+			Eventually(server.Events).Should(ContainElement(And(
+				HaveField("Reason", "DeviceUnhealthy"),
+				HaveField("Message", ContainSubstring("ydb.tech/part-disk01 instance disk01 is Unhealthy")),
+			)))
+		})
+	})
+
+	Describe("Node Feature Discovery", func() {
+		It("publishes the healthy instances of resources as labels", func() {
+			dev := makePartitionDevice("/sys/block/nvme0n1/nvme0n1p1", "/dev/nvme0n1p1", "nvme_disk01")
+			discovery.AddDevice(dev)
+			dir := filepath.Join(tmpDir, "features.d")
+			config := mustParseYAML(`
+domain: ydb.tech
+partitions:
+  - matcher: "nvme_(.*)"
+nfd:
+  dir: ` + dir + `
+  label: "ydb.tech/{{ .Kind }}{{ with .Name }}-{{ . }}{{ end }}"
+`)
+			startTestApp(ctx, wg, discovery, config, tmpDir, kubeSock)
+			waitForRegistrations(kubelet, 1)
+
+			features := func() string {
+				data, _ := os.ReadFile(filepath.Join(dir, "udev-manager"))
+				return string(data)
+			}
+			Eventually(features).Should(ContainSubstring("ydb.tech/resource-ydb.tech_part-disk01=1\n"))
+
+			discovery.Emit(udev.Removed{Device: dev})
+			Eventually(features).Should(ContainSubstring("ydb.tech/resource-ydb.tech_part-disk01=0\n"))
+		})
+	})
+
 	Describe("Partition with topology hints", func() {
 		It("includes NUMA node in ListAndWatch when device has one", func() {
 			dev := makePartitionDevice("/sys/block/nvme0n1/nvme0n1p1", "/dev/nvme0n1p1", "nvme_disk01").
@@ -399,10 +461,53 @@ batchPartitions:
 				Expect(d.Health).To(Equal("Unhealthy"))
 			}
 		})
+
+		It("goes unhealthy when an expected member is lost and reports it on allocate", func() {
+			dev1 := makePartitionDevice("/sys/block/nvme0n1/nvme0n1p1", "/dev/nvme0n1p1", "nvme_data_01")
+			dev2 := makePartitionDevice("/sys/block/nvme0n2/nvme0n2p1", "/dev/nvme0n2p1", "nvme_data_02")
+			discovery.AddDevice(dev1)
+			discovery.AddDevice(dev2)
+
+			config := mustParseYAML(`
+domain: ydb.tech
+batchPartitions:
+  - name: nvme-set
+    matcher: "nvme_(data_.*)"
+    expected: [data_01, data_02]
+`)
+
+			startTestApp(ctx, wg, discovery, config, tmpDir, kubeSock)
+			sockets := waitForSockets(tmpDir)
+
+			client, conn := dialPlugin(sockets[0])
+			DeferCleanup(func() { conn.Close() })
+
+			stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp := recvWithTimeout(stream, 5*time.Second)
+			Expect(resp.Devices).To(HaveLen(1))
+			Expect(resp.Devices[0].Health).To(Equal("Healthy"))
+
+			By("removing one expected member makes the seat unhealthy")
+			discovery.Emit(udev.Removed{Device: dev1})
+
+			drainUntil(stream, 5*time.Second, func(r *pluginapi.ListAndWatchResponse) bool {
+				return len(r.Devices) == 1 && r.Devices[0].Health == "Unhealthy"
+			})
+
+			allocResp, err := client.Allocate(ctx, &pluginapi.AllocateRequest{
+				ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+					{DevicesIDs: []string{"0"}},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocResp.ContainerResponses[0].Envs).To(HaveKeyWithValue("YDB_TECH_BATCH_NVME_SET_MISSING", "data_01"))
+		})
 	})
 
 	Describe("Network bandwidth", func() {
-		It("creates shares based on speed/mbpsPerShare and returns empty allocate response", func() {
+		It("creates shares based on speed/mbpsPerShare and returns bandwidth metadata on allocate", func() {
 			dev := makeNetDevice("/sys/class/net/eth0", "eth0", 10000, "up")
 			discovery.AddDevice(dev)
 
@@ -433,17 +538,21 @@ networkBandwidth:
 				Expect(d.Health).To(Equal("Healthy"))
 			}
 
-			By("allocate returns empty response (no devices, mounts, or envs)")
+			By("allocate returns bandwidth metadata summed over the allocated shares")
 			allocResp, err := client.Allocate(ctx, &pluginapi.AllocateRequest{
 				ContainerRequests: []*pluginapi.ContainerAllocateRequest{
-					{DevicesIDs: []string{"eth0_0"}},
+					{DevicesIDs: []string{"eth0_0", "eth0_1", "eth0_2"}},
 				},
 			})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(allocResp.ContainerResponses).To(HaveLen(1))
-			Expect(allocResp.ContainerResponses[0].Devices).To(BeEmpty())
-			Expect(allocResp.ContainerResponses[0].Mounts).To(BeEmpty())
-			Expect(allocResp.ContainerResponses[0].Envs).To(BeEmpty())
+			cr := allocResp.ContainerResponses[0]
+			Expect(cr.Devices).To(BeEmpty())
+			Expect(cr.Mounts).To(BeEmpty())
+			Expect(cr.Envs).To(HaveKeyWithValue("YDB_TECH_NETBW_0_ETH0_MBPS", "3000"))
+			Expect(cr.Envs).To(HaveKeyWithValue("YDB_TECH_NETBW_0_INTERFACES", "eth0"))
+			Expect(cr.Annotations).To(HaveKeyWithValue("ydb.tech/netbw-0.eth0.mbps", "3000"))
+			Expect(cr.Annotations).NotTo(HaveKey("kubernetes.io/egress-bandwidth"))
 		})
 
 		It("reports unhealthy when interface operstate is not up", func() {
@@ -472,6 +581,115 @@ networkBandwidth:
 		})
 	})
 
+	Describe("SR-IOV", func() {
+		It("exposes the VFs of a PF as one resource and passes the VF on allocate", func() {
+			sysfsRoot := GinkgoT().TempDir()
+			devices := filepath.Join(sysfsRoot, "bus", "pci", "devices")
+			Expect(os.MkdirAll(filepath.Join(devices, "0000:3b:00.0", "net", "ens1f0"), 0o755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(sysfsRoot, "class", "net", "ens1f0"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(sysfsRoot, "class", "net", "ens1f0", "operstate"), []byte("up\n"), 0o644)).To(Succeed())
+			for i, vf := range []string{"0000:3b:02.0", "0000:3b:02.1"} {
+				Expect(os.MkdirAll(filepath.Join(devices, vf, "net", fmt.Sprintf("ens1f0v%d", i)), 0o755)).To(Succeed())
+				Expect(os.Symlink("../0000:3b:00.0", filepath.Join(devices, vf, "physfn"))).To(Succeed())
+				discovery.AddDevice(udev.NewFakeDevice(udev.Id("/sys/devices/pci0000:3a/0000:3a:00.0/" + vf)).
+					WithSubsystem("pci"))
+			}
+			// the PF itself is not a VF and must not be exposed
+			discovery.AddDevice(udev.NewFakeDevice("/sys/devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0").
+				WithSubsystem("pci"))
+
+			config := mustParseYAML(fmt.Sprintf(`
+domain: ydb.tech
+sysfs_root: %s
+sriov:
+  - matcher: "ens1(f.*)"
+`, sysfsRoot))
+
+			startTestApp(ctx, wg, discovery, config, tmpDir, kubeSock)
+
+			waitForRegistrations(kubelet, 1)
+			Expect(kubelet.Registrations()[0].ResourceName).To(Equal("ydb.tech/sriov-f0"))
+
+			sockets := waitForSockets(tmpDir)
+			client, conn := dialPlugin(sockets[0])
+			DeferCleanup(func() { conn.Close() })
+
+			stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			resp := recvWithTimeout(stream, 5*time.Second)
+			Expect(resp.Devices).To(HaveLen(2))
+			for _, d := range resp.Devices {
+				Expect(d.Health).To(Equal(pluginapi.Healthy))
+			}
+
+			allocResp, err := client.Allocate(ctx, &pluginapi.AllocateRequest{
+				ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+					{DevicesIDs: []string{"0000:3b:02.1"}},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			cr := allocResp.ContainerResponses[0]
+			Expect(cr.Envs).To(HaveKeyWithValue("YDB_TECH_SRIOV_F0_PCI_ADDRESSES", "0000:3b:02.1"))
+			Expect(cr.Envs).To(HaveKeyWithValue("YDB_TECH_SRIOV_F0_NETDEVS", "ens1f0v1"))
+		})
+	})
+
+	Describe("VFIO", func() {
+		It("exposes an IOMMU group as one device and passes its VFIO devices on allocate", func() {
+			sysfsRoot := GinkgoT().TempDir()
+			devices := filepath.Join(sysfsRoot, "bus", "pci", "devices")
+			groupDevices := filepath.Join(sysfsRoot, "kernel", "iommu_groups", "42", "devices")
+			Expect(os.MkdirAll(groupDevices, 0o755)).To(Succeed())
+			for _, addr := range []string{"0000:5e:00.0", "0000:5e:00.1"} {
+				Expect(os.MkdirAll(filepath.Join(devices, addr), 0o755)).To(Succeed())
+				Expect(os.Symlink("../../../kernel/iommu_groups/42", filepath.Join(devices, addr, "iommu_group"))).To(Succeed())
+				Expect(os.Symlink("../../../bus/pci/drivers/vfio-pci", filepath.Join(devices, addr, "driver"))).To(Succeed())
+				Expect(os.Symlink("../../../../bus/pci/devices/"+addr, filepath.Join(groupDevices, addr))).To(Succeed())
+				discovery.AddDevice(udev.NewFakeDevice(udev.Id("/sys/devices/pci0000:5d/0000:5d:00.0/"+addr)).
+					WithSubsystem("pci").
+					WithSysAttr("vendor", "0x15b3").
+					WithSysAttr("device", "0x1017").
+					WithSysAttr("class", "0x020000"))
+			}
+
+			config := mustParseYAML(fmt.Sprintf(`
+domain: ydb.tech
+sysfs_root: %s
+vfio:
+  - name: cx5
+    vendorDevice: "15b3:1017"
+`, sysfsRoot))
+
+			startTestApp(ctx, wg, discovery, config, tmpDir, kubeSock)
+
+			waitForRegistrations(kubelet, 1)
+			Expect(kubelet.Registrations()[0].ResourceName).To(Equal("ydb.tech/vfio-cx5"))
+
+			sockets := waitForSockets(tmpDir)
+			client, conn := dialPlugin(sockets[0])
+			DeferCleanup(func() { conn.Close() })
+
+			stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			resp := recvWithTimeout(stream, 5*time.Second)
+			Expect(resp.Devices).To(HaveLen(1))
+			Expect(resp.Devices[0].ID).To(Equal("42"))
+			Expect(resp.Devices[0].Health).To(Equal(pluginapi.Healthy))
+
+			allocResp, err := client.Allocate(ctx, &pluginapi.AllocateRequest{
+				ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+					{DevicesIDs: []string{"42"}},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			cr := allocResp.ContainerResponses[0]
+			Expect(cr.Devices).To(HaveLen(2))
+			Expect(cr.Devices[0].HostPath).To(Equal("/dev/vfio/vfio"))
+			Expect(cr.Devices[1].HostPath).To(Equal("/dev/vfio/42"))
+			Expect(cr.Envs).To(HaveKeyWithValue("PCI_RESOURCE_YDB_TECH_VFIO-CX5", "0000:5e:00.0,0000:5e:00.1"))
+		})
+	})
+
 	Describe("Multiple resource types", func() {
 		It("registers independent resources from one config", func() {
 			partDev := makePartitionDevice("/sys/block/nvme0n1/nvme0n1p1", "/dev/nvme0n1p1", "nvme_disk01")
@@ -722,7 +940,7 @@ partitions:
 			appCtx, appCancel := context.WithCancel(ctx)
 			appWg := &sync.WaitGroup{}
 
-			registry, cleanup, err := startApp(appCtx, appWg, discovery, config,
+			registry, cleanup, err := startApp(appCtx, appWg, discovery, config, http.NewServeMux(),
 				plugin.WithPluginDir(tmpDir+"/"),
 				plugin.WithKubeletSocket(kubeSock),
 			)
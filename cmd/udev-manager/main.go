@@ -6,18 +6,24 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
 	"syscall"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
 	"k8s.io/klog/v2"
 
+	"github.com/ydb-platform/udev-manager/internal/kube"
 	"github.com/ydb-platform/udev-manager/internal/mux"
 	"github.com/ydb-platform/udev-manager/internal/plugin"
 	"github.com/ydb-platform/udev-manager/internal/udev"
@@ -25,7 +31,18 @@ import (
 
 const defaultHealthcheckPort = 8080
 
+// defaultAdminSocket is where the admin API is served.
+const defaultAdminSocket = "/var/run/udev-manager/admin.sock"
+
+// defaultStagingDir is where partitions with mode: mount are mounted on the
+// host.
+const defaultStagingDir = "/var/lib/udev-manager/mounts"
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	appContext, appCancel := context.WithCancel(context.Background())
 	appWaitGroup := &sync.WaitGroup{}
 	defer appWaitGroup.Wait()
@@ -41,13 +58,22 @@ func main() {
 	}
 	defer devDiscovery.Close()
 
-	registry, cleanup, err := startApp(appContext, appWaitGroup, devDiscovery, flags.config)
+	adminMux := http.NewServeMux()
+	registry, cleanup, err := startApp(appContext, appWaitGroup, devDiscovery, flags.config, adminMux)
 	if err != nil {
 		klog.Fatalf("failed to start app: %v", err)
 		os.Exit(1)
 	}
 	defer cleanup()
 
+	klog.Infof("Starting admin server on socket %s", flags.config.AdminSocket)
+	closeAdmin, err := serveAdmin(flags.config.AdminSocket, adminMux)
+	if err != nil {
+		klog.Errorf("failed to start admin server: %v", err)
+	} else {
+		defer closeAdmin()
+	}
+
 	healthCheckAddr := fmt.Sprintf(":%d", flags.config.HealthCheckPort)
 	klog.Infof("Starting /healthz server on port %s", healthCheckAddr)
 	healthMux := http.NewServeMux()
@@ -75,35 +101,173 @@ func main() {
 	}
 }
 
+// serveAdmin serves handler on the Unix socket at path, which only root may
+// connect to. The returned function stops serving.
+func serveAdmin(path string, handler http.Handler) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create admin socket directory: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove admin socket %q: %w", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on admin socket %q: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict admin socket %q: %w", path, err)
+	}
+
+	srv := &http.Server{Handler: handler}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("admin server error: %v", err)
+		}
+	}()
+	return func() {
+		if err := srv.Close(); err != nil {
+			klog.Errorf("failed to close admin server: %v", err)
+		}
+	}, nil
+}
+
 // startApp wires up the device plugin pipeline: creates a Registry, connects
-// Scatters for each configured resource type to the given discovery, and
-// returns the registry plus a cleanup function that tears down all scatters.
+// Scatters for each configured resource type to the given discovery, adds the
+// endpoints of optional features to the admin API, and returns the registry
+// plus a cleanup function that tears down all scatters.
 func startApp(
 	ctx context.Context,
 	wg *sync.WaitGroup,
 	discovery udev.Discovery,
 	config *appConfig,
+	admin *http.ServeMux,
 	registryOpts ...plugin.RegistryOption,
 ) (*plugin.Registry, mux.CancelFunc, error) {
+	var maintenance *plugin.Maintenance
+	if config.Maintenance != nil {
+		var err error
+		maintenance, err = plugin.NewMaintenance(config.Maintenance.maintenanceConfig())
+		if err != nil {
+			return nil, nil, err
+		}
+		registryOpts = append(registryOpts, plugin.WithInstanceCheck(maintenance))
+	}
+
+	cancel := mux.CancelFunc(func() {})
+	if config.Kubernetes != nil {
+		clientConfig, err := config.Kubernetes.clientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		client, err := kube.NewClient(clientConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+		}
+		reporter, stop := plugin.NewNodeReporter(client, config.Kubernetes.reporterConfig())
+		registryOpts = append(registryOpts, plugin.WithObserver(reporter))
+		cancel = stop
+	}
+
+	var features *plugin.FeatureFile
+	if config.Nfd != nil {
+		var err error
+		features, err = plugin.NewFeatureFile(config.Nfd.featureFileConfig(config.SysfsRoot))
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		registryOpts = append(registryOpts, plugin.WithObserver(features))
+	}
+
+	var drives *plugin.DriveInventory
+	if config.DriveInventory != nil {
+		drives = plugin.NewDriveInventory(config.DriveInventory.inventoryConfig())
+		registryOpts = append(registryOpts, plugin.WithObserver(drives))
+	}
+
 	registry, err := plugin.NewRegistry(ctx, wg, registryOpts...)
 	if err != nil {
+		cancel()
 		return nil, nil, fmt.Errorf("failed to create plugin registry: %w", err)
 	}
 
 	domain := config.DeviceDomain
 
-	cancel := mux.CancelFunc(func() {})
+	handleAdmin(admin, registry, discovery)
+
+	if maintenance != nil {
+		stop, err := maintenance.Watch(registry)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		admin.Handle(cordonsPath, maintenance)
+		cancel = mux.ChainCancelFunc(stop, cancel)
+	}
+
+	if features != nil {
+		stop, err := features.Watch(registry, discovery)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		cancel = mux.ChainCancelFunc(stop, cancel)
+	}
+
+	if drives != nil {
+		stop, err := drives.Watch(registry, discovery)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		admin.Handle(drivesPath, drives)
+		cancel = mux.ChainCancelFunc(stop, cancel)
+	}
+
+	var diskHealth []plugin.DiskHealth
+	if config.Kmsg != nil {
+		watcher, stop, err := plugin.NewKmsgWatcher(discovery, registry, config.Kmsg.watcherConfig())
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		diskHealth = append(diskHealth, watcher)
+		admin.Handle(ioErrorsPath, watcher)
+		cancel = mux.ChainCancelFunc(stop, cancel)
+	}
+	if config.Smart != nil {
+		probed, stop := plugin.NewProbedDiskHealth(discovery, registry, config.Smart.prober(), config.Smart.probeConfig())
+		diskHealth = append(diskHealth, probed)
+		cancel = mux.ChainCancelFunc(stop, cancel)
+	}
+
 	for _, partConfig := range config.Partitions {
 		partDomain := partConfig.DomainOverride
 		if partDomain == "" {
 			partDomain = domain
 		}
+		partOpts := append(partConfig.options(), plugin.WithPartitionSysfsRoot(config.SysfsRoot))
+		for _, h := range diskHealth {
+			partOpts = append(partOpts, plugin.WithPartitionDiskHealth(h))
+		}
+		if mountConfig, ok := partConfig.mountConfig(); ok {
+			mounter, unmount, err := plugin.NewPartitionMounter(discovery, mountConfig)
+			if err != nil {
+				cancel()
+				return nil, nil, err
+			}
+			partOpts = append(partOpts, plugin.WithPartitionMounter(mounter))
+			// unmount only after the scatter is gone
+			cancel = mux.ChainCancelFunc(unmount, cancel)
+		}
 		cancel = mux.ChainCancelFunc(
 			plugin.NewScatter(
 				discovery,
 				registry,
-				plugin.PartitionLabelMatcherTemplater(partDomain, partConfig.matcher),
-				plugin.PartitionLabelMatcherInstances(partDomain, partConfig.matcher, config.DisableTopologyHints),
+				plugin.PartitionLabelMatcherTemplater(partDomain, partConfig.matcher, partOpts...),
+				plugin.PartitionLabelMatcherInstances(partDomain, partConfig.matcher, config.DisableTopologyHints, partOpts...),
+				plugin.WithScatterRelated(plugin.PartitionMultipathRelated(partOpts...)),
 			),
 			cancel,
 		)
@@ -114,6 +278,13 @@ func startApp(
 		if batchDomain == "" {
 			batchDomain = domain
 		}
+		batchOpts := append(batchConfig.options(),
+			plugin.WithBatchTopologyHints(!config.DisableTopologyHints),
+			plugin.WithBatchSysfsRoot(config.SysfsRoot),
+		)
+		for _, h := range diskHealth {
+			batchOpts = append(batchOpts, plugin.WithBatchDiskHealth(h))
+		}
 		cancel = mux.ChainCancelFunc(
 			plugin.NewBatchPartitionScatter(
 				discovery,
@@ -122,30 +293,122 @@ func startApp(
 				batchConfig.Name,
 				batchConfig.matcher,
 				batchConfig.Count,
+				batchOpts...,
+			),
+			cancel,
+		)
+	}
+
+	for _, diskConfig := range config.DiskGroups {
+		diskDomain := diskConfig.DomainOverride
+		if diskDomain == "" {
+			diskDomain = domain
+		}
+		diskOpts := append(diskConfig.options(),
+			plugin.WithBatchTopologyHints(!config.DisableTopologyHints),
+			plugin.WithBatchSysfsRoot(config.SysfsRoot),
+		)
+		for _, h := range diskHealth {
+			diskOpts = append(diskOpts, plugin.WithBatchDiskHealth(h))
+		}
+		cancel = mux.ChainCancelFunc(
+			plugin.NewDiskGroupScatter(
+				discovery,
+				registry,
+				diskDomain,
+				diskConfig.name,
+				diskConfig.matcher,
+				diskOpts...,
 			),
 			cancel,
 		)
 	}
 
 	for _, netBWConfig := range config.NetworkBandwidth {
+		bonds := plugin.NewNetBWBonds(config.SysfsRoot)
 		cancel = mux.ChainCancelFunc(
 			plugin.NewScatter(
 				discovery,
 				registry,
 				plugin.NetBWMatcherTemplater(domain, netBWConfig.matcher),
-				plugin.NetBWMatcherInstances(domain, netBWConfig.matcher, netBWConfig.MbpsPerShare),
+				plugin.NetBWMatcherInstances(
+					domain,
+					netBWConfig.matcher,
+					netBWConfig.MbpsPerShare,
+					config.DisableTopologyHints,
+					append(netBWConfig.options(), plugin.WithBondSlaves(bonds))...,
+				),
+				plugin.WithScatterChanges(),
+				plugin.WithScatterRelated(bonds.Related),
 			),
 			cancel,
 		)
 	}
 
 	for _, netRdmaConfig := range config.NetworkRdma {
+		rdmaSettings := plugin.NewNetRdmaSettings(plugin.NewSysfsRdmaTopology(config.SysfsRoot), netRdmaConfig.options()...)
+		cancel = mux.ChainCancelFunc(
+			plugin.NewScatter(
+				discovery,
+				registry,
+				plugin.NetRdmaMatcherTemplater(domain, netRdmaConfig.matcher, rdmaSettings),
+				plugin.NetRdmaMatcherInstances(domain, netRdmaConfig.matcher, int(netRdmaConfig.ResourceCount), config.DisableTopologyHints, rdmaSettings),
+				plugin.WithScatterChanges(),
+			),
+			cancel,
+		)
+	}
+
+	for _, sriovConfig := range config.Sriov {
+		sriovSettings := plugin.NewSriovSettings(config.SysfsRoot, plugin.NewSysfsRdmaTopology(config.SysfsRoot))
+		cancel = mux.ChainCancelFunc(
+			plugin.NewScatter(
+				discovery,
+				registry,
+				plugin.SriovMatcherTemplater(domain, sriovConfig.matcher, sriovSettings),
+				plugin.SriovMatcherInstances(domain, sriovConfig.matcher, config.DisableTopologyHints, sriovSettings),
+				plugin.WithScatterChanges(),
+			),
+			cancel,
+		)
+	}
+
+	for _, vfioConfig := range config.Vfio {
+		vfioSettings := plugin.NewVfioSettings(config.SysfsRoot)
+		cancel = mux.ChainCancelFunc(
+			plugin.NewScatter(
+				discovery,
+				registry,
+				plugin.VfioMatcherTemplater(domain, vfioConfig.Name, vfioConfig.matcher, vfioSettings),
+				plugin.VfioMatcherInstances(domain, vfioConfig.Name, vfioConfig.matcher, config.DisableTopologyHints, vfioSettings),
+				plugin.WithScatterChanges(),
+			),
+			cancel,
+		)
+	}
+
+	for _, nvmeConfig := range config.NvmeNamespaces {
+		nvmeSettings := plugin.NewNvmeSettings(config.SysfsRoot)
+		cancel = mux.ChainCancelFunc(
+			plugin.NewScatter(
+				discovery,
+				registry,
+				plugin.NvmeNamespaceTemplater(domain, nvmeConfig.Name, nvmeConfig.matcher, nvmeSettings),
+				plugin.NvmeNamespaceInstances(domain, nvmeConfig.matcher, config.DisableTopologyHints, nvmeSettings),
+			),
+			cancel,
+		)
+	}
+
+	for _, volumeConfig := range config.Volumes {
+		volumeSettings := plugin.NewVolumeSettings(config.SysfsRoot)
 		cancel = mux.ChainCancelFunc(
 			plugin.NewScatter(
 				discovery,
 				registry,
-				plugin.NetRdmaMatcherTemplater(domain, netRdmaConfig.matcher),
-				plugin.NetRdmaMatcherInstances(domain, netRdmaConfig.matcher, int(netRdmaConfig.ResourceCount)),
+				plugin.VolumeMatcherTemplater(domain, volumeConfig.Name, volumeConfig.matcher),
+				plugin.VolumeMatcherInstances(domain, volumeConfig.matcher, volumeSettings),
+				plugin.WithScatterChanges(),
 			),
 			cancel,
 		)
@@ -266,13 +529,31 @@ func initFlags() flagValues {
 }
 
 var (
+	resourceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._-]*[a-zA-Z0-9])?$`)
 	deviceDomainRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
 )
 
+// Modes of passing partitions to containers.
+const (
+	partitionModeDevice = "device" // the raw block device (default)
+	partitionModeMount  = "mount"  // the filesystem, mounted by udev-manager
+)
+
 type partitionsConfig struct {
 	Matcher        string `yaml:"matcher"`          // matcher should be a valid regular expression
 	DomainOverride string `yaml:"domain,omitempty"` // optional override for the domain
 
+	Mode       string `yaml:"mode,omitempty"`       // "device" (default) or "mount"
+	FsType     string `yaml:"fsType,omitempty"`     // filesystem expected in mount mode
+	StagingDir string `yaml:"stagingDir,omitempty"` // host directory for mounts, defaults to defaultStagingDir
+	ReadOnly   bool   `yaml:"readOnly,omitempty"`   // mount read-only
+
+	Replicas         int    `yaml:"replicas,omitempty"`         // instances per partition, default 1
+	Permissions      string `yaml:"permissions,omitempty"`      // device permissions: "r", "rw" (default) or "rwm"
+	ForceSharedWrite bool   `yaml:"forceSharedWrite,omitempty"` // allow writable partitions with several replicas
+
+	templatesConfig `yaml:",inline"`
+
 	matcher *regexp.Regexp // compiled matcher if the config is valid
 }
 
@@ -291,14 +572,135 @@ func (pc *partitionsConfig) validate() error {
 		return fmt.Errorf(".matcher: %q must have at most one capturing group", pc.Matcher)
 	}
 	pc.matcher = matcher
+
+	switch pc.Mode {
+	case "":
+		pc.Mode = partitionModeDevice
+	case partitionModeDevice:
+	case partitionModeMount:
+		if pc.FsType == "" {
+			return fmt.Errorf(".fsType: must be set in mode %q", partitionModeMount)
+		}
+		if pc.StagingDir == "" {
+			pc.StagingDir = defaultStagingDir
+		}
+		if !filepath.IsAbs(pc.StagingDir) {
+			return fmt.Errorf(".stagingDir: %q must be an absolute path", pc.StagingDir)
+		}
+	default:
+		return fmt.Errorf(".mode: %q must be %q or %q", pc.Mode, partitionModeDevice, partitionModeMount)
+	}
+
+	if pc.Replicas < 0 {
+		return fmt.Errorf(".replicas: must be >= 0, got %d", pc.Replicas)
+	}
+	if pc.Replicas == 0 {
+		pc.Replicas = 1
+	}
+	switch pc.Permissions {
+	case "":
+		pc.Permissions = "rw"
+	case "r", "rw", "rwm":
+	default:
+		return fmt.Errorf(".permissions: %q must be one of \"r\", \"rw\" or \"rwm\"", pc.Permissions)
+	}
+	if pc.Replicas > 1 && pc.writable() && !pc.ForceSharedWrite {
+		return fmt.Errorf(".replicas: %d writable replicas would share a partition; make it read-only or set forceSharedWrite", pc.Replicas)
+	}
+	return pc.templatesConfig.validate()
+}
+
+// writable reports whether containers can write to the partitions.
+func (pc *partitionsConfig) writable() bool {
+	if pc.Mode == partitionModeMount {
+		return !pc.ReadOnly
+	}
+	return strings.Contains(pc.Permissions, "w")
+}
+
+// mountConfig returns how to mount the partitions, or false if they are
+// passed as devices.
+func (pc *partitionsConfig) mountConfig() (plugin.PartitionMountConfig, bool) {
+	if pc.Mode != partitionModeMount {
+		return plugin.PartitionMountConfig{}, false
+	}
+	return plugin.PartitionMountConfig{
+		StagingDir: pc.StagingDir,
+		FsType:     pc.FsType,
+		ReadOnly:   pc.ReadOnly,
+	}, true
+}
+
+// options returns the plugin options of the partition instances.
+func (pc *partitionsConfig) options() []plugin.PartitionOption {
+	opts := []plugin.PartitionOption{
+		plugin.WithPartitionReplicas(pc.Replicas),
+		plugin.WithPartitionPermissions(pc.Permissions),
+	}
+	if pc.templates != nil {
+		opts = append(opts, plugin.WithPartitionTemplates(pc.templates))
+	}
+	return opts
+}
+
+type envTemplateConfig struct {
+	Name  string `yaml:"name"`  // template of the variable name
+	Value string `yaml:"value"` // template of the value
+}
+
+// templatesConfig customizes how partitions appear in containers with Go
+// templates, see [plugin.PartitionTemplateData].
+type templatesConfig struct {
+	ContainerPath string              `yaml:"containerPath,omitempty"` // template of the device or mount path
+	Env           []envTemplateConfig `yaml:"env,omitempty"`           // replaces the default environment
+
+	templates *plugin.PartitionTemplates // parsed templates if any are set
+}
+
+func (tc *templatesConfig) validate() error {
+	if tc.ContainerPath == "" && len(tc.Env) == 0 {
+		return nil
+	}
+	templates := &plugin.PartitionTemplates{}
+	var errs error
+	if tc.ContainerPath != "" {
+		tmpl, err := plugin.ParsePartitionTemplate("containerPath", tc.ContainerPath)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".containerPath: %w", err))
+		}
+		templates.ContainerPath = tmpl
+	}
+	for i, env := range tc.Env {
+		name, err := plugin.ParsePartitionTemplate("name", env.Name)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".env[%d].name: %w", i, err))
+		}
+		value, err := plugin.ParsePartitionTemplate("value", env.Value)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".env[%d].value: %w", i, err))
+		}
+		templates.Envs = append(templates.Envs, plugin.EnvTemplate{Name: name, Value: value})
+	}
+	if errs != nil {
+		return errs
+	}
+	if err := templates.Validate(); err != nil {
+		return fmt.Errorf("templates fail on a sample partition: %w", err)
+	}
+	tc.templates = templates
 	return nil
 }
 
 type batchPartitionsConfig struct {
-	Name           string `yaml:"name"`
-	Matcher        string `yaml:"matcher"`
-	Count          int    `yaml:"count,omitempty"` // default 1
-	DomainOverride string `yaml:"domain,omitempty"`
+	Name           string   `yaml:"name"`
+	Matcher        string   `yaml:"matcher"`
+	Count          int      `yaml:"count,omitempty"` // default 1
+	DomainOverride string   `yaml:"domain,omitempty"`
+	Expected       []string `yaml:"expected,omitempty"`    // labels that must all be present
+	MinMembers     int      `yaml:"minMembers,omitempty"`  // minimum number of present partitions
+	NumaAligned    bool     `yaml:"numaAligned,omitempty"` // advertise only while members share a NUMA node
+
+	templatesConfig `yaml:",inline"`
 
 	matcher *regexp.Regexp // compiled matcher if the config is valid
 }
@@ -323,7 +725,118 @@ func (bc *batchPartitionsConfig) validate() error {
 	if bc.Count == 0 {
 		bc.Count = 1
 	}
-	return nil
+	if bc.MinMembers < 0 {
+		return fmt.Errorf(".minMembers: must be >= 0, got %d", bc.MinMembers)
+	}
+	seen := make(map[string]struct{}, len(bc.Expected))
+	for i, label := range bc.Expected {
+		if label == "" {
+			return fmt.Errorf(".expected[%d]: must not be empty", i)
+		}
+		if _, dup := seen[label]; dup {
+			return fmt.Errorf(".expected[%d]: duplicate label %q", i, label)
+		}
+		seen[label] = struct{}{}
+	}
+	return bc.templatesConfig.validate()
+}
+
+// options converts the quorum, NUMA and template settings into batch
+// partition options.
+func (bc *batchPartitionsConfig) options() []plugin.BatchPartitionOption {
+	var opts []plugin.BatchPartitionOption
+	if len(bc.Expected) > 0 {
+		opts = append(opts, plugin.WithExpectedMembers(bc.Expected...))
+	}
+	if bc.MinMembers > 0 {
+		opts = append(opts, plugin.WithMinMembers(bc.MinMembers))
+	}
+	if bc.NumaAligned {
+		opts = append(opts, plugin.WithNumaAligned())
+	}
+	if bc.templates != nil {
+		opts = append(opts, plugin.WithBatchTemplates(bc.templates))
+	}
+	return opts
+}
+
+type diskGroupsConfig struct {
+	Name           string   `yaml:"name,omitempty"` // template of the resource name, default "disk-{{ or .Serial .WWN .Name }}"
+	Matcher        string   `yaml:"matcher"`
+	DomainOverride string   `yaml:"domain,omitempty"`
+	Expected       []string `yaml:"expected,omitempty"`    // labels that must all be present on a disk
+	MinMembers     int      `yaml:"minMembers,omitempty"`  // minimum number of present partitions on a disk
+	NumaAligned    bool     `yaml:"numaAligned,omitempty"` // advertise only while members share a NUMA node
+	// remove resources of disks gone for this long, e.g. "24h"; kept if unset
+	PruneAfter time.Duration `yaml:"pruneAfter,omitempty"`
+
+	templatesConfig `yaml:",inline"`
+
+	name    *template.Template // parsed name template if the config is valid
+	matcher *regexp.Regexp     // compiled matcher if the config is valid
+}
+
+func (dc *diskGroupsConfig) validate() error {
+	if dc.Name == "" {
+		dc.Name = plugin.DefaultDiskGroupName
+	}
+	name, err := plugin.ParsePartitionTemplate("name", dc.Name)
+	if err != nil {
+		return fmt.Errorf(".name: %w", err)
+	}
+	if err := plugin.ValidateDiskGroupName(name); err != nil {
+		return fmt.Errorf(".name: template fails on a sample disk: %w", err)
+	}
+	dc.name = name
+	if dc.DomainOverride != "" {
+		if !deviceDomainRegex.MatchString(dc.DomainOverride) {
+			return fmt.Errorf(".domain: %q must be a valid domain name", dc.DomainOverride)
+		}
+	}
+	matcher, err := regexp.Compile(dc.Matcher)
+	if err != nil {
+		return fmt.Errorf(".matcher: %q must be a valid regexp: %w", dc.Matcher, err)
+	}
+	dc.matcher = matcher
+	if dc.MinMembers < 0 {
+		return fmt.Errorf(".minMembers: must be >= 0, got %d", dc.MinMembers)
+	}
+	if dc.PruneAfter < 0 {
+		return fmt.Errorf(".pruneAfter: %s must not be negative", dc.PruneAfter)
+	}
+	seen := make(map[string]struct{}, len(dc.Expected))
+	for i, label := range dc.Expected {
+		if label == "" {
+			return fmt.Errorf(".expected[%d]: must not be empty", i)
+		}
+		if _, dup := seen[label]; dup {
+			return fmt.Errorf(".expected[%d]: duplicate label %q", i, label)
+		}
+		seen[label] = struct{}{}
+	}
+	return dc.templatesConfig.validate()
+}
+
+// options converts the quorum, NUMA, template and pruning settings into
+// options of every disk group.
+func (dc *diskGroupsConfig) options() []plugin.BatchPartitionOption {
+	var opts []plugin.BatchPartitionOption
+	if len(dc.Expected) > 0 {
+		opts = append(opts, plugin.WithExpectedMembers(dc.Expected...))
+	}
+	if dc.MinMembers > 0 {
+		opts = append(opts, plugin.WithMinMembers(dc.MinMembers))
+	}
+	if dc.NumaAligned {
+		opts = append(opts, plugin.WithNumaAligned())
+	}
+	if dc.templates != nil {
+		opts = append(opts, plugin.WithBatchTemplates(dc.templates))
+	}
+	if dc.PruneAfter > 0 {
+		opts = append(opts, plugin.WithDiskGroupPruneAfter(dc.PruneAfter))
+	}
+	return opts
 }
 
 type hostDevConfig struct {
@@ -344,8 +857,12 @@ func (hc *hostDevConfig) validate() error {
 }
 
 type netBWConfig struct {
-	Matcher      string `yaml:"matcher"` // matcher should be a valid regular expression
-	MbpsPerShare uint   `yaml:"mbpsPerShare"`
+	Matcher       string `yaml:"matcher"` // matcher should be a valid regular expression
+	MbpsPerShare  uint   `yaml:"mbpsPerShare"`
+	AllocationDir string `yaml:"allocationDir,omitempty"` // optional directory for per-allocation JSON files
+
+	ReservedMbps      uint `yaml:"reservedMbps,omitempty"`      // bandwidth kept out of the shares
+	FallbackSpeedMbps uint `yaml:"fallbackSpeedMbps,omitempty"` // speed assumed when sysfs reports none
 
 	matcher *regexp.Regexp // compiled matcher if the config is valid
 }
@@ -357,12 +874,32 @@ func (nbc *netBWConfig) validate() error {
 		return fmt.Errorf(".matcher: %q must be a valid regexp: %w", nbc.Matcher, err)
 	}
 	nbc.matcher = matcher
+	if nbc.AllocationDir != "" && !filepath.IsAbs(nbc.AllocationDir) {
+		return fmt.Errorf(".allocationDir: %q must be an absolute path", nbc.AllocationDir)
+	}
 	return nil
 }
 
+// options converts the optional settings into netbw options.
+func (nbc *netBWConfig) options() []plugin.NetBWOption {
+	var opts []plugin.NetBWOption
+	if nbc.AllocationDir != "" {
+		opts = append(opts, plugin.WithAllocationDir(nbc.AllocationDir))
+	}
+	if nbc.ReservedMbps > 0 {
+		opts = append(opts, plugin.WithReservedMbps(nbc.ReservedMbps))
+	}
+	if nbc.FallbackSpeedMbps > 0 {
+		opts = append(opts, plugin.WithFallbackSpeedMbps(nbc.FallbackSpeedMbps))
+	}
+	return opts
+}
+
 type netRdmaConfig struct {
 	Matcher       string `yaml:"matcher"` // matcher should be a valid regular expression
 	ResourceCount uint   `yaml:"resourceCount"`
+	IPFamily      string `yaml:"ipFamily,omitempty"`   // family of the RoCE v2 GID passed to pods, default ipv4
+	MountSysfs    bool   `yaml:"mountSysfs,omitempty"` // mount /sys/class/infiniband/<dev> read-only
 
 	matcher *regexp.Regexp // compiled matcher if the config is valid
 }
@@ -374,18 +911,420 @@ func (nrc *netRdmaConfig) validate() error {
 		return fmt.Errorf(".matcher: %q must be a valid regexp: %w", nrc.Matcher, err)
 	}
 	nrc.matcher = matcher
+	switch nrc.IPFamily {
+	case "", plugin.IPFamilyIPv4, plugin.IPFamilyIPv6:
+	default:
+		return fmt.Errorf(".ipFamily: %q must be %q or %q", nrc.IPFamily, plugin.IPFamilyIPv4, plugin.IPFamilyIPv6)
+	}
+	return nil
+}
+
+// options converts the optional settings into netrdma options.
+func (nrc *netRdmaConfig) options() []plugin.NetRdmaOption {
+	var opts []plugin.NetRdmaOption
+	if nrc.IPFamily != "" {
+		opts = append(opts, plugin.WithRdmaIPFamily(nrc.IPFamily))
+	}
+	if nrc.MountSysfs {
+		opts = append(opts, plugin.WithRdmaSysfsMount())
+	}
+	return opts
+}
+
+type sriovConfig struct {
+	Matcher string `yaml:"matcher"` // matcher for the netdev of the PF, should be a valid regular expression
+
+	matcher *regexp.Regexp // compiled matcher if the config is valid
+}
+
+func (sc *sriovConfig) validate() error {
+	// Compile the regular expression
+	matcher, err := regexp.Compile(sc.Matcher)
+	if err != nil {
+		return fmt.Errorf(".matcher: %q must be a valid regexp: %w", sc.Matcher, err)
+	}
+	sc.matcher = matcher
+	return nil
+}
+
+type vfioConfig struct {
+	Name         string `yaml:"name"`                   // resource name within the domain
+	VendorDevice string `yaml:"vendorDevice,omitempty"` // matcher for "vvvv:dddd", e.g. "144d:a80a"
+	Class        string `yaml:"class,omitempty"`        // matcher for the PCI class, e.g. "0x0108.."
+	Address      string `yaml:"address,omitempty"`      // matcher for the PCI address, e.g. "0000:3b:00.0"
+
+	matcher plugin.VfioMatcher // compiled matchers if the config is valid
+}
+
+func (vc *vfioConfig) validate() error {
+	var errs error
+	if !resourceNameRegex.MatchString(vc.Name) {
+		errs = errors.Join(errs, fmt.Errorf(".name: %q must be a valid resource name", vc.Name))
+	}
+	if vc.VendorDevice == "" && vc.Class == "" && vc.Address == "" {
+		errs = errors.Join(errs, fmt.Errorf("one of vendorDevice, class or address must be set"))
+	}
+	compile := func(field, expr string) *regexp.Regexp {
+		if expr == "" {
+			return nil
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".%s: %q must be a valid regexp: %w", field, expr, err))
+		}
+		return re
+	}
+	vc.matcher = plugin.VfioMatcher{
+		VendorDevice: compile("vendorDevice", vc.VendorDevice),
+		Class:        compile("class", vc.Class),
+		Address:      compile("address", vc.Address),
+	}
+	return errs
+}
+
+type nvmeNamespaceConfig struct {
+	Name    string `yaml:"name"`    // resource name within the domain
+	Matcher string `yaml:"matcher"` // matcher for the namespace's kernel name, e.g. "nvme0n1"
+
+	matcher *regexp.Regexp // compiled matcher if the config is valid
+}
+
+func (nc *nvmeNamespaceConfig) validate() error {
+	var errs error
+	if !resourceNameRegex.MatchString(nc.Name) {
+		errs = errors.Join(errs, fmt.Errorf(".name: %q must be a valid resource name", nc.Name))
+	}
+	matcher, err := regexp.Compile(nc.Matcher)
+	if err != nil {
+		errs = errors.Join(errs, fmt.Errorf(".matcher: %q must be a valid regexp: %w", nc.Matcher, err))
+	}
+	nc.matcher = matcher
+	return errs
+}
+
+type volumeConfig struct {
+	Name    string `yaml:"name"`              // resource name within the domain
+	Matcher string `yaml:"matcher,omitempty"` // matcher for DM_NAME or MD_NAME
+	VG      string `yaml:"vg,omitempty"`      // matcher for the volume group of LVM volumes
+	LV      string `yaml:"lv,omitempty"`      // matcher for the logical volume of LVM volumes
+	UUID    string `yaml:"uuid,omitempty"`    // matcher for DM_UUID, e.g. "^CRYPT-"
+	Level   string `yaml:"level,omitempty"`   // matcher for the RAID level of md arrays, e.g. "^raid1$"
+
+	matcher plugin.VolumeMatcher // compiled matchers if the config is valid
+}
+
+func (vc *volumeConfig) validate() error {
+	var errs error
+	if !resourceNameRegex.MatchString(vc.Name) {
+		errs = errors.Join(errs, fmt.Errorf(".name: %q must be a valid resource name", vc.Name))
+	}
+	if vc.Matcher == "" && vc.VG == "" && vc.LV == "" && vc.UUID == "" && vc.Level == "" {
+		errs = errors.Join(errs, fmt.Errorf("one of matcher, vg, lv, uuid or level must be set"))
+	}
+	if vc.Level != "" && (vc.VG != "" || vc.LV != "" || vc.UUID != "") {
+		errs = errors.Join(errs, fmt.Errorf(".level: md arrays have no vg, lv or uuid to match"))
+	}
+	compile := func(field, expr string) *regexp.Regexp {
+		if expr == "" {
+			return nil
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".%s: %q must be a valid regexp: %w", field, expr, err))
+		}
+		return re
+	}
+	vc.matcher = plugin.VolumeMatcher{
+		Name:  compile("matcher", vc.Matcher),
+		VG:    compile("vg", vc.VG),
+		LV:    compile("lv", vc.LV),
+		UUID:  compile("uuid", vc.UUID),
+		Level: compile("level", vc.Level),
+	}
+	return errs
+}
+
+// ioErrorsPath is the HTTP endpoint listing and clearing disks failed on
+// kernel I/O errors.
+const ioErrorsPath = "/disks/io-errors"
+
+// cordonsPath is the HTTP endpoint listing, making and lifting cordons.
+const cordonsPath = "/cordons"
+
+// drivesPath is the HTTP endpoint listing the drives of partition, batch and
+// disk group resources.
+const drivesPath = "/disks/inventory"
+
+type maintenanceConfig struct {
+	StateFile string `yaml:"stateFile,omitempty"` // defaults to /var/lib/udev-manager/cordons.json
+	MarkerDir string `yaml:"markerDir,omitempty"` // optional directory of marker files
+}
+
+// defaultCordonStateFile keeps the cordons made through the admin API.
+const defaultCordonStateFile = "/var/lib/udev-manager/cordons.json"
+
+func (mc *maintenanceConfig) validate() error {
+	var errs error
+	if mc.StateFile == "" {
+		mc.StateFile = defaultCordonStateFile
+	}
+	if !filepath.IsAbs(mc.StateFile) {
+		errs = errors.Join(errs, fmt.Errorf(".stateFile: %q must be an absolute path", mc.StateFile))
+	}
+	if mc.MarkerDir != "" && !filepath.IsAbs(mc.MarkerDir) {
+		errs = errors.Join(errs, fmt.Errorf(".markerDir: %q must be an absolute path", mc.MarkerDir))
+	}
+	return errs
+}
+
+func (mc *maintenanceConfig) maintenanceConfig() plugin.MaintenanceConfig {
+	return plugin.MaintenanceConfig{
+		StateFile: mc.StateFile,
+		MarkerDir: mc.MarkerDir,
+	}
+}
+
+type kmsgConfig struct {
+	Path      string        `yaml:"path,omitempty"`      // kernel log to follow, defaults to /dev/kmsg
+	Threshold int           `yaml:"threshold,omitempty"` // I/O errors within window that fail a disk, default 1
+	Window    time.Duration `yaml:"window,omitempty"`    // e.g. "1h", default 10m
+	StateFile string        `yaml:"stateFile,omitempty"` // defaults to /var/lib/udev-manager/io-errors.json
+}
+
+// defaultKmsgStateFile keeps the disks failed on kernel I/O errors, next to
+// the cordons.
+const defaultKmsgStateFile = "/var/lib/udev-manager/io-errors.json"
+
+func (kc *kmsgConfig) validate() error {
+	var errs error
+	if kc.Path != "" && !filepath.IsAbs(kc.Path) {
+		errs = errors.Join(errs, fmt.Errorf(".path: %q must be an absolute path", kc.Path))
+	}
+	if kc.StateFile == "" {
+		kc.StateFile = defaultKmsgStateFile
+	}
+	if !filepath.IsAbs(kc.StateFile) {
+		errs = errors.Join(errs, fmt.Errorf(".stateFile: %q must be an absolute path", kc.StateFile))
+	}
+	if kc.Threshold < 0 {
+		errs = errors.Join(errs, fmt.Errorf(".threshold: %d must not be negative", kc.Threshold))
+	}
+	if kc.Window < 0 {
+		errs = errors.Join(errs, fmt.Errorf(".window: %s must not be negative", kc.Window))
+	}
+	return errs
+}
+
+func (kc *kmsgConfig) watcherConfig() plugin.KmsgConfig {
+	return plugin.KmsgConfig{
+		Path:      kc.Path,
+		Threshold: kc.Threshold,
+		Window:    kc.Window,
+		StateFile: kc.StateFile,
+	}
+}
+
+type smartConfig struct {
+	Smartctl       string        `yaml:"smartctl,omitempty"`       // smartctl command, default "smartctl"
+	Nvme           string        `yaml:"nvme,omitempty"`           // nvme-cli command, probes NVMe disks if set
+	Period         time.Duration `yaml:"period,omitempty"`         // e.g. "1h", default 10m
+	MediaErrors    uint64        `yaml:"mediaErrors,omitempty"`    // fail at this many media errors
+	PercentageUsed int           `yaml:"percentageUsed,omitempty"` // fail at this share of the endurance used
+	Temperature    int           `yaml:"temperature,omitempty"`    // fail at this temperature in Celsius
+}
+
+func (sc *smartConfig) validate() error {
+	var errs error
+	if sc.Period < 0 {
+		errs = errors.Join(errs, fmt.Errorf(".period: %s must not be negative", sc.Period))
+	}
+	if sc.PercentageUsed < 0 {
+		errs = errors.Join(errs, fmt.Errorf(".percentageUsed: %d must not be negative", sc.PercentageUsed))
+	}
+	if sc.Temperature < 0 {
+		errs = errors.Join(errs, fmt.Errorf(".temperature: %d must not be negative", sc.Temperature))
+	}
+	return errs
+}
+
+func (sc *smartConfig) prober() *plugin.SmartProber {
+	return &plugin.SmartProber{
+		SmartctlPath: sc.Smartctl,
+		NvmePath:     sc.Nvme,
+	}
+}
+
+func (sc *smartConfig) probeConfig() plugin.DiskProbeConfig {
+	return plugin.DiskProbeConfig{
+		Period:         sc.Period,
+		MediaErrors:    sc.MediaErrors,
+		PercentageUsed: sc.PercentageUsed,
+		Temperature:    sc.Temperature,
+	}
+}
+
+type kubernetesConfig struct {
+	Server             string        `yaml:"server,omitempty"`             // API server, defaults to the one of the pod
+	TokenFile          string        `yaml:"tokenFile,omitempty"`          // bearer token, defaults to the service account's without server
+	CAFile             string        `yaml:"caFile,omitempty"`             // CA bundle, defaults to the service account's without server
+	InsecureSkipVerify bool          `yaml:"insecureSkipVerify,omitempty"` // do not verify the server certificate
+	NodeName           string        `yaml:"nodeName,omitempty"`           // defaults to $NODE_NAME
+	EventNamespace     string        `yaml:"eventNamespace,omitempty"`     // default "default"
+	Condition          string        `yaml:"condition,omitempty"`          // node condition type, default UdevManagerDevicesHealthy
+	Resync             time.Duration `yaml:"resync,omitempty"`             // e.g. "1m", default 5m
+}
+
+// nodeNameEnv is where the node name is taken from by default, set from
+// spec.nodeName with the downward API.
+const nodeNameEnv = "NODE_NAME"
+
+var conditionTypeRegex = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+
+func (kc *kubernetesConfig) validate() error {
+	var errs error
+	if kc.Server != "" {
+		if u, err := url.Parse(kc.Server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = errors.Join(errs, fmt.Errorf(".server: %q must be an http or https URL", kc.Server))
+		}
+	}
+	if kc.TokenFile != "" && !filepath.IsAbs(kc.TokenFile) {
+		errs = errors.Join(errs, fmt.Errorf(".tokenFile: %q must be an absolute path", kc.TokenFile))
+	}
+	if kc.CAFile != "" && !filepath.IsAbs(kc.CAFile) {
+		errs = errors.Join(errs, fmt.Errorf(".caFile: %q must be an absolute path", kc.CAFile))
+	}
+	if kc.NodeName == "" {
+		kc.NodeName = os.Getenv(nodeNameEnv)
+	}
+	if kc.NodeName == "" {
+		errs = errors.Join(errs, fmt.Errorf(".nodeName: must be set, or $%s", nodeNameEnv))
+	}
+	if kc.Condition == "" {
+		kc.Condition = plugin.DefaultNodeConditionType
+	}
+	if !conditionTypeRegex.MatchString(kc.Condition) {
+		errs = errors.Join(errs, fmt.Errorf(".condition: %q must be a valid condition type", kc.Condition))
+	}
+	if kc.Resync < 0 {
+		errs = errors.Join(errs, fmt.Errorf(".resync: %s must not be negative", kc.Resync))
+	}
+	return errs
+}
+
+// clientConfig returns the config of the API server, that of the pod unless
+// a server is set.
+func (kc *kubernetesConfig) clientConfig() (kube.Config, error) {
+	config := kube.Config{Server: kc.Server}
+	if kc.Server == "" {
+		var err error
+		config, err = kube.InClusterConfig()
+		if err != nil {
+			return kube.Config{}, fmt.Errorf("kubernetes: server must be set outside of a pod: %w", err)
+		}
+	}
+	if kc.TokenFile != "" {
+		config.TokenFile = kc.TokenFile
+	}
+	if kc.CAFile != "" {
+		config.CAFile = kc.CAFile
+	}
+	config.InsecureSkipVerify = kc.InsecureSkipVerify
+	return config, nil
+}
+
+func (kc *kubernetesConfig) reporterConfig() plugin.NodeReporterConfig {
+	return plugin.NodeReporterConfig{
+		Node:          kc.NodeName,
+		Namespace:     kc.EventNamespace,
+		ConditionType: kc.Condition,
+		Resync:        kc.Resync,
+	}
+}
+
+type nfdConfig struct {
+	Dir   string `yaml:"dir,omitempty"`   // features.d of NFD, defaults to /etc/kubernetes/node-feature-discovery/features.d
+	File  string `yaml:"file,omitempty"`  // default "udev-manager"
+	Label string `yaml:"label,omitempty"` // label template, see plugin.FeatureLabelData
+
+	label *template.Template // parsed label template if the config is valid
+}
+
+func (nc *nfdConfig) validate() error {
+	var errs error
+	if nc.Dir == "" {
+		nc.Dir = plugin.DefaultFeatureDir
+	}
+	if !filepath.IsAbs(nc.Dir) {
+		errs = errors.Join(errs, fmt.Errorf(".dir: %q must be an absolute path", nc.Dir))
+	}
+	if nc.File == "" {
+		nc.File = plugin.DefaultFeatureFile
+	}
+	if strings.ContainsRune(nc.File, '/') || strings.HasPrefix(nc.File, ".") {
+		errs = errors.Join(errs, fmt.Errorf(".file: %q must be a file name not starting with '.'", nc.File))
+	}
+	if nc.Label == "" {
+		nc.Label = plugin.DefaultFeatureLabel
+	}
+	label, err := plugin.ParseFeatureLabel(nc.Label)
+	if err != nil {
+		errs = errors.Join(errs, fmt.Errorf(".label: %w", err))
+	}
+	nc.label = label
+	return errs
+}
+
+func (nc *nfdConfig) featureFileConfig(sysfsRoot string) plugin.FeatureFileConfig {
+	return plugin.FeatureFileConfig{
+		Dir:       nc.Dir,
+		File:      nc.File,
+		Label:     nc.label,
+		SysfsRoot: sysfsRoot,
+	}
+}
+
+type driveInventoryConfig struct {
+	Path string `yaml:"path,omitempty"` // defaults to /var/lib/udev-manager/drives.json
+}
+
+func (dc *driveInventoryConfig) validate() error {
+	if dc.Path == "" {
+		dc.Path = plugin.DefaultDriveInventoryPath
+	}
+	if !filepath.IsAbs(dc.Path) {
+		return fmt.Errorf(".path: %q must be an absolute path", dc.Path)
+	}
 	return nil
 }
 
+func (dc *driveInventoryConfig) inventoryConfig() plugin.DriveInventoryConfig {
+	return plugin.DriveInventoryConfig{
+		Path: dc.Path,
+	}
+}
+
 type appConfig struct {
 	DeviceDomain         string                  `yaml:"domain"`
 	DisableTopologyHints bool                    `yaml:"disable_topology_hints"`
 	HealthCheckPort      uint16                  `yaml:"health_check_port"`
+	SysfsRoot            string                  `yaml:"sysfs_root,omitempty"`     // defaults to /sys
+	AdminSocket          string                  `yaml:"admin_socket,omitempty"`   // defaults to defaultAdminSocket
+	Maintenance          *maintenanceConfig      `yaml:"maintenance,omitempty"`    // cordons if set
+	Kmsg                 *kmsgConfig             `yaml:"kmsg,omitempty"`           // fail disks on kernel I/O errors if set
+	Smart                *smartConfig            `yaml:"smart,omitempty"`          // fail disks on SMART data if set
+	Kubernetes           *kubernetesConfig       `yaml:"kubernetes,omitempty"`     // node Events and condition if set
+	Nfd                  *nfdConfig              `yaml:"nfd,omitempty"`            // NFD feature file if set
+	DriveInventory       *driveInventoryConfig   `yaml:"driveInventory,omitempty"` // YDB drive inventory if set
 	Partitions           []partitionsConfig      `yaml:"partitions"`
 	BatchPartitions      []batchPartitionsConfig `yaml:"batchPartitions"`
+	DiskGroups           []diskGroupsConfig      `yaml:"diskGroups"`
 	HostDevs             []hostDevConfig         `yaml:"hostdevs"`
 	NetworkBandwidth     []netBWConfig           `yaml:"networkBandwidth"`
 	NetworkRdma          []netRdmaConfig         `yaml:"networkRdma"`
+	Sriov                []sriovConfig           `yaml:"sriov"`
+	Vfio                 []vfioConfig            `yaml:"vfio"`
+	NvmeNamespaces       []nvmeNamespaceConfig   `yaml:"nvmeNamespaces"`
+	Volumes              []volumeConfig          `yaml:"volumes"`
 }
 
 func (c *appConfig) validate() error {
@@ -400,6 +1339,61 @@ func (c *appConfig) validate() error {
 	if c.HealthCheckPort == 0 {
 		c.HealthCheckPort = defaultHealthcheckPort
 	}
+	if c.SysfsRoot == "" {
+		c.SysfsRoot = plugin.DefaultSysfsRoot
+	}
+	if !filepath.IsAbs(c.SysfsRoot) {
+		errs = errors.Join(errs, fmt.Errorf(".sysfs_root: %q must be an absolute path", c.SysfsRoot))
+	}
+
+	if c.AdminSocket == "" {
+		c.AdminSocket = defaultAdminSocket
+	}
+	if !filepath.IsAbs(c.AdminSocket) {
+		errs = errors.Join(errs, fmt.Errorf(".admin_socket: %q must be an absolute path", c.AdminSocket))
+	}
+
+	// Validate maintenance
+	if c.Maintenance != nil {
+		if err := c.Maintenance.validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".maintenance: %w", err))
+		}
+	}
+
+	// Validate kernel log watcher
+	if c.Kmsg != nil {
+		if err := c.Kmsg.validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".kmsg: %w", err))
+		}
+	}
+
+	// Validate disk probe
+	if c.Smart != nil {
+		if err := c.Smart.validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".smart: %w", err))
+		}
+	}
+
+	// Validate Kubernetes reporting
+	if c.Kubernetes != nil {
+		if err := c.Kubernetes.validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".kubernetes: %w", err))
+		}
+	}
+
+	// Validate the feature file
+	if c.Nfd != nil {
+		if err := c.Nfd.validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".nfd: %w", err))
+		}
+	}
+
+	// Validate the drive inventory
+	if c.DriveInventory != nil {
+		if err := c.DriveInventory.validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".driveInventory: %w", err))
+		}
+	}
 
 	// Validate partitions
 	for i := range c.Partitions {
@@ -415,6 +1409,13 @@ func (c *appConfig) validate() error {
 		}
 	}
 
+	// Validate disk groups
+	for i := range c.DiskGroups {
+		if err := c.DiskGroups[i].validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".diskGroups[%d]: %w", i, err))
+		}
+	}
+
 	// Validate hostdevs
 	for i := range c.HostDevs {
 		if err := c.HostDevs[i].validate(); err != nil {
@@ -436,6 +1437,73 @@ func (c *appConfig) validate() error {
 		}
 	}
 
+	// Validate sriov
+	for i := range c.Sriov {
+		if err := c.Sriov[i].validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".sriov[%d]: %w", i, err))
+		}
+	}
+
+	// Validate vfio
+	for i := range c.Vfio {
+		if err := c.Vfio[i].validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".vfio[%d]: %w", i, err))
+		}
+	}
+
+	// Validate nvme namespaces
+	for i := range c.NvmeNamespaces {
+		if err := c.NvmeNamespaces[i].validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".nvmeNamespaces[%d]: %w", i, err))
+		}
+	}
+
+	// Validate volumes
+	for i := range c.Volumes {
+		if err := c.Volumes[i].validate(); err != nil {
+			errs = errors.Join(errs, fmt.Errorf(".volumes[%d]: %w", i, err))
+		}
+	}
+
+	// Validate that resource names are unique
+	if err := c.validateResourceNames(); err != nil {
+		errs = errors.Join(errs, err)
+	}
+
+	return errs
+}
+
+// validateResourceNames rejects configs registering the same resource twice:
+// the kubelet keeps a single plugin per resource name, so the later one would
+// silently replace the earlier one.
+func (c *appConfig) validateResourceNames() error {
+	var errs error
+	defined := make(map[string]string) // resource name -> config path defining it
+	define := func(path, domain, name string) {
+		resource := domain + "/" + name
+		if other, ok := defined[resource]; ok {
+			errs = errors.Join(errs, fmt.Errorf("%s: resource %q is also defined by %s", path, resource, other))
+			return
+		}
+		defined[resource] = path
+	}
+
+	for i, bc := range c.BatchPartitions {
+		domain := bc.DomainOverride
+		if domain == "" {
+			domain = c.DeviceDomain
+		}
+		define(fmt.Sprintf(".batchPartitions[%d]", i), domain, "batch-"+bc.Name)
+	}
+	for i, vc := range c.Vfio {
+		define(fmt.Sprintf(".vfio[%d]", i), c.DeviceDomain, "vfio-"+vc.Name)
+	}
+	for i, nc := range c.NvmeNamespaces {
+		define(fmt.Sprintf(".nvmeNamespaces[%d]", i), c.DeviceDomain, "nvme-"+nc.Name)
+	}
+	for i, vc := range c.Volumes {
+		define(fmt.Sprintf(".volumes[%d]", i), c.DeviceDomain, "volume-"+vc.Name)
+	}
 	return errs
 }
 
@@ -0,0 +1,214 @@
+// Package kube is a minimal client of the Kubernetes API for what
+// udev-manager reports about its node: Events and node conditions.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Credentials of the service account of a pod, used when no server is set.
+const (
+	ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	ServiceAccountCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Config locates the API server and the credentials of a [Client].
+type Config struct {
+	Server             string // e.g. "https://10.96.0.1:443"
+	TokenFile          string // bearer token, read on every request so rotated tokens are picked up
+	CAFile             string // CA bundle of the server, the system pool if empty
+	InsecureSkipVerify bool   // do not verify the certificate of the server
+}
+
+// InClusterConfig returns the config of a pod using its service account, or
+// an error outside of a pod.
+func InClusterConfig() (Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return Config{}, fmt.Errorf("not running in a pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+	return Config{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		TokenFile: ServiceAccountTokenFile,
+		CAFile:    ServiceAccountCAFile,
+	}, nil
+}
+
+// Client sends requests to the API server.
+type Client struct {
+	server    *url.URL
+	tokenFile string
+	http      *http.Client
+}
+
+// NewClient creates a client of the API server of config.
+func NewClient(config Config) (*Client, error) {
+	server, err := url.Parse(config.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server %q: %w", config.Server, err)
+	}
+	if server.Scheme != "http" && server.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server %q: scheme must be http or https", config.Server)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA file %q", config.CAFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &Client{
+		server:    server,
+		tokenFile: config.TokenFile,
+		http:      &http.Client{Timeout: 30 * time.Second, Transport: transport},
+	}, nil
+}
+
+// StatusError is returned for requests the API server rejects.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API server returned %d: %s", e.Code, e.Message)
+}
+
+// do sends body as JSON to path.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server.JoinPath(path).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		// Failures come as a Status object; fall back to the raw body.
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(respBody))
+		}
+		return &StatusError{Code: resp.StatusCode, Message: status.Message}
+	}
+	return nil
+}
+
+// ObjectReference names the object an [Event] is about.
+type ObjectReference struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+	UID        string `json:"uid,omitempty"`
+}
+
+// ObjectMeta is the metadata of created objects.
+type ObjectMeta struct {
+	Name         string `json:"name,omitempty"`
+	GenerateName string `json:"generateName,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+}
+
+// EventSource is the component reporting an [Event].
+type EventSource struct {
+	Component string `json:"component,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
+// Event types.
+const (
+	EventNormal  = "Normal"
+	EventWarning = "Warning"
+)
+
+// Event is a core/v1 Event.
+type Event struct {
+	APIVersion         string          `json:"apiVersion"`
+	Kind               string          `json:"kind"`
+	Metadata           ObjectMeta      `json:"metadata"`
+	InvolvedObject     ObjectReference `json:"involvedObject"`
+	Reason             string          `json:"reason"`
+	Message            string          `json:"message"`
+	Type               string          `json:"type"`
+	Source             EventSource     `json:"source"`
+	FirstTimestamp     time.Time       `json:"firstTimestamp"`
+	LastTimestamp      time.Time       `json:"lastTimestamp"`
+	Count              int             `json:"count"`
+	ReportingComponent string          `json:"reportingComponent,omitempty"`
+	ReportingInstance  string          `json:"reportingInstance,omitempty"`
+}
+
+// CreateEvent creates ev in its namespace.
+func (c *Client) CreateEvent(ctx context.Context, ev Event) error {
+	ev.APIVersion, ev.Kind = "v1", "Event"
+	path := "/api/v1/namespaces/" + url.PathEscape(ev.Metadata.Namespace) + "/events"
+	return c.do(ctx, http.MethodPost, path, "application/json", ev)
+}
+
+// Condition statuses.
+const (
+	ConditionTrue  = "True"
+	ConditionFalse = "False"
+)
+
+// NodeCondition is a condition in the status of a node.
+type NodeCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	LastHeartbeatTime  time.Time `json:"lastHeartbeatTime,omitzero"`
+	LastTransitionTime time.Time `json:"lastTransitionTime,omitzero"`
+}
+
+// SetNodeCondition adds condition to the status of node or replaces the
+// condition of the same type, leaving the other conditions alone.
+func (c *Client) SetNodeCondition(ctx context.Context, node string, condition NodeCondition) error {
+	// Conditions are merged by type in strategic merge patches.
+	patch := map[string]any{"status": map[string]any{"conditions": []NodeCondition{condition}}}
+	path := "/api/v1/nodes/" + url.PathEscape(node) + "/status"
+	return c.do(ctx, http.MethodPatch, path, "application/strategic-merge-patch+json", patch)
+}
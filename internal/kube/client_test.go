@@ -0,0 +1,100 @@
+package kube_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/kube"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server *kube.FakeAPIServer
+		client *kube.Client
+		ctx    = context.Background()
+	)
+
+	BeforeEach(func() {
+		server = kube.NewFakeAPIServer()
+		DeferCleanup(server.Close)
+
+		tokenFile := filepath.Join(GinkgoT().TempDir(), "token")
+		Expect(os.WriteFile(tokenFile, []byte("secret\n"), 0o600)).To(Succeed())
+		server.RequireToken("secret")
+
+		var err error
+		client, err = kube.NewClient(kube.Config{Server: server.URL, TokenFile: tokenFile})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("creates events", func() {
+		now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+		Expect(client.CreateEvent(ctx, kube.Event{
+			Metadata:       kube.ObjectMeta{GenerateName: "node-1.", Namespace: "default"},
+			InvolvedObject: kube.ObjectReference{Kind: "Node", Name: "node-1"},
+			Reason:         "DeviceUnhealthy",
+			Message:        "disk01 is Unhealthy",
+			Type:           kube.EventWarning,
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Count:          1,
+		})).To(Succeed())
+
+		events := server.Events()
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Kind).To(Equal("Event"))
+		Expect(events[0].Reason).To(Equal("DeviceUnhealthy"))
+		Expect(events[0].FirstTimestamp).To(BeTemporally("==", now))
+	})
+
+	It("sets node conditions by type", func() {
+		Expect(client.SetNodeCondition(ctx, "node-1", kube.NodeCondition{Type: "A", Status: kube.ConditionTrue})).To(Succeed())
+		Expect(client.SetNodeCondition(ctx, "node-1", kube.NodeCondition{Type: "B", Status: kube.ConditionTrue})).To(Succeed())
+		Expect(client.SetNodeCondition(ctx, "node-1", kube.NodeCondition{Type: "A", Status: kube.ConditionFalse, Reason: "Broken"})).To(Succeed())
+
+		Expect(server.Conditions("node-1")).To(Equal([]kube.NodeCondition{
+			{Type: "A", Status: kube.ConditionFalse, Reason: "Broken"},
+			{Type: "B", Status: kube.ConditionTrue},
+		}))
+	})
+
+	It("returns the message of rejected requests", func() {
+		server.RequireToken("other")
+		err := client.SetNodeCondition(ctx, "node-1", kube.NodeCondition{Type: "A", Status: kube.ConditionTrue})
+		var statusErr *kube.StatusError
+		Expect(errors.As(err, &statusErr)).To(BeTrue())
+		Expect(statusErr.Code).To(Equal(http.StatusUnauthorized))
+		Expect(statusErr.Message).To(Equal("Unauthorized"))
+	})
+
+	It("rejects invalid servers and CA files", func() {
+		_, err := kube.NewClient(kube.Config{Server: "unix:///var/run/api.sock"})
+		Expect(err).To(MatchError(ContainSubstring("scheme must be http or https")))
+
+		caFile := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+		Expect(os.WriteFile(caFile, []byte("not a certificate"), 0o600)).To(Succeed())
+		_, err = kube.NewClient(kube.Config{Server: "https://10.96.0.1", CAFile: caFile})
+		Expect(err).To(MatchError(ContainSubstring("no certificates")))
+	})
+
+	It("configures itself in a pod from the environment", func() {
+		GinkgoT().Setenv("KUBERNETES_SERVICE_HOST", "fd00::1")
+		GinkgoT().Setenv("KUBERNETES_SERVICE_PORT", "443")
+		Expect(kube.InClusterConfig()).To(Equal(kube.Config{
+			Server:    "https://[fd00::1]:443",
+			TokenFile: kube.ServiceAccountTokenFile,
+			CAFile:    kube.ServiceAccountCAFile,
+		}))
+
+		GinkgoT().Setenv("KUBERNETES_SERVICE_HOST", "")
+		_, err := kube.InClusterConfig()
+		Expect(err).To(HaveOccurred())
+	})
+})
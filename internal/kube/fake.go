@@ -0,0 +1,131 @@
+package kube
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// FakeAPIServer is an in-memory API server for use in tests. It accepts the
+// requests of [Client] and records the Events created and the conditions
+// set on nodes. Point a client at it with Config{Server: s.URL}.
+type FakeAPIServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	token      string
+	failure    int
+	events     []Event
+	conditions map[string][]NodeCondition // by node
+}
+
+// NewFakeAPIServer starts a FakeAPIServer; Close it when done.
+func NewFakeAPIServer() *FakeAPIServer {
+	s := &FakeAPIServer{conditions: make(map[string][]NodeCondition)}
+	handler := http.NewServeMux()
+	handler.HandleFunc("POST /api/v1/namespaces/{namespace}/events", s.createEvent)
+	handler.HandleFunc("PATCH /api/v1/nodes/{node}/status", s.patchNodeStatus)
+	s.Server = httptest.NewServer(s.check(handler))
+	return s
+}
+
+// RequireToken rejects requests without token as their bearer token.
+func (s *FakeAPIServer) RequireToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// Fail fails all requests with the status code, or none if it is zero.
+func (s *FakeAPIServer) Fail(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = code
+}
+
+// Events returns the Events created so far.
+func (s *FakeAPIServer) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Conditions returns the conditions of node.
+func (s *FakeAPIServer) Conditions(node string) []NodeCondition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]NodeCondition(nil), s.conditions[node]...)
+}
+
+func (s *FakeAPIServer) check(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		token, failure := s.token, s.failure
+		s.mu.Unlock()
+		switch {
+		case failure != 0:
+			writeStatus(resp, failure, "injected failure")
+		case token != "" && req.Header.Get("Authorization") != "Bearer "+token:
+			writeStatus(resp, http.StatusUnauthorized, "Unauthorized")
+		default:
+			next.ServeHTTP(resp, req)
+		}
+	})
+}
+
+func (s *FakeAPIServer) createEvent(resp http.ResponseWriter, req *http.Request) {
+	var ev Event
+	if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+		writeStatus(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+	if ev.Metadata.Namespace != req.PathValue("namespace") {
+		writeStatus(resp, http.StatusBadRequest, "the namespace of the event does not match the namespace of the request")
+		return
+	}
+	s.mu.Lock()
+	s.events = append(s.events, ev)
+	s.mu.Unlock()
+	resp.WriteHeader(http.StatusCreated)
+}
+
+func (s *FakeAPIServer) patchNodeStatus(resp http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/strategic-merge-patch+json" {
+		writeStatus(resp, http.StatusUnsupportedMediaType, "only strategic merge patches are supported")
+		return
+	}
+	var patch struct {
+		Status struct {
+			Conditions []NodeCondition `json:"conditions"`
+		} `json:"status"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+		writeStatus(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+	node := req.PathValue("node")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, condition := range patch.Status.Conditions {
+		conditions := s.conditions[node]
+		replaced := false
+		for i := range conditions {
+			if conditions[i].Type == condition.Type {
+				conditions[i], replaced = condition, true
+			}
+		}
+		if !replaced {
+			conditions = append(conditions, condition)
+		}
+		s.conditions[node] = conditions
+	}
+	resp.WriteHeader(http.StatusOK)
+}
+
+// writeStatus writes a failure as a Status object.
+func writeStatus(resp http.ResponseWriter, code int, message string) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	_ = json.NewEncoder(resp).Encode(map[string]any{"kind": "Status", "status": "Failure", "message": message, "code": code})
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/ydb-platform/udev-manager/internal/mux"
 	"github.com/ydb-platform/udev-manager/internal/udev"
@@ -20,17 +22,185 @@ type batchPartitionPool struct {
 	parts  map[udev.Id]udev.Device
 	labels map[udev.Id]string // mapped label per device (from capture group 1 or full PARTNAME)
 	domain string
+	name   string
+
+	expected   []string // labels that must all be present for the batch to be healthy
+	minMembers int      // minimum number of present partitions for the batch to be healthy
+
+	numaAligned          bool // seats are healthy only while all members share a NUMA node
+	disableTopologyHints bool
+
+	templates  *PartitionTemplates
+	multipath  *multipathSysfs
+	diskHealth []DiskHealth
+	pruneAfter time.Duration // disk groups only, see WithDiskGroupPruneAfter
+}
+
+// BatchPartitionOption configures a batch partition resource created by
+// [NewBatchPartitionScatter].
+type BatchPartitionOption func(*batchPartitionPool)
+
+// WithExpectedMembers declares the labels that make up a complete batch. The
+// seats are reported Unhealthy while any of them is missing, and the allocate
+// response lists the missing labels.
+func WithExpectedMembers(labels ...string) BatchPartitionOption {
+	return func(p *batchPartitionPool) { p.expected = append(p.expected, labels...) }
+}
+
+// WithMinMembers sets the minimum number of matching partitions required for
+// the seats to be reported Healthy.
+func WithMinMembers(n int) BatchPartitionOption {
+	return func(p *batchPartitionPool) { p.minMembers = n }
+}
+
+// WithNumaAligned advertises the batch only while all of its members share a
+// single NUMA node; otherwise the seats are reported Unhealthy. Members that
+// report no NUMA node are not taken into account.
+func WithNumaAligned() BatchPartitionOption {
+	return func(p *batchPartitionPool) { p.numaAligned = true }
+}
+
+// WithBatchTopologyHints enables or disables NUMA topology hints on the seats.
+// Hints are enabled by default.
+func WithBatchTopologyHints(enabled bool) BatchPartitionOption {
+	return func(p *batchPartitionPool) { p.disableTopologyHints = !enabled }
+}
+
+// WithBatchTemplates renders the container paths and environment of the
+// members with t.
+func WithBatchTemplates(t *PartitionTemplates) BatchPartitionOption {
+	return func(p *batchPartitionPool) { p.templates = t }
 }
 
+// WithBatchSysfsRoot looks for multipath maps in sysfs mounted at root, e.g.
+// [DefaultSysfsRoot]. Without it, multipath maps are not recognised.
+func WithBatchSysfsRoot(root string) BatchPartitionOption {
+	multipath := newMultipathSysfs(root)
+	return func(p *batchPartitionPool) { p.multipath = multipath }
+}
+
+// WithBatchDiskHealth reports the seats Unhealthy while h fails the disk of
+// any member.
+func WithBatchDiskHealth(h DiskHealth) BatchPartitionOption {
+	return func(p *batchPartitionPool) { p.diskHealth = append(p.diskHealth, h) }
+}
+
+// WithDiskGroupPruneAfter removes disk groups that have had no partitions for
+// d, so that disks swapped out of a node do not leave their resources behind.
+// Batch partition resources ignore it.
+func WithDiskGroupPruneAfter(d time.Duration) BatchPartitionOption {
+	return func(p *batchPartitionPool) { p.pruneAfter = d }
+}
+
+// health is the health of the member set, which is reported to the resource
+// whenever it changes.
 func (p *batchPartitionPool) health() Health {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	if len(p.parts) == 0 {
-		return Unhealthy{}
+		return Unhealthy{Reason: fmt.Sprintf("batch %s has no members", p.name)}
+	}
+	if len(p.parts) < p.minMembers {
+		return Unhealthy{Reason: fmt.Sprintf("batch %s: %d of at least %d members present", p.name, len(p.parts), p.minMembers)}
+	}
+	if missing := p.missingLocked(); len(missing) > 0 {
+		return Unhealthy{Reason: fmt.Sprintf("batch %s: expected members %q are missing", p.name, missing)}
+	}
+	if nodes := p.numaNodesLocked(); p.numaAligned && len(nodes) > 1 {
+		return Unhealthy{Reason: fmt.Sprintf("batch %s: members span NUMA nodes %v", p.name, nodes)}
+	}
+	for _, dev := range p.parts {
+		if !isMapPartition(dev) {
+			continue
+		}
+		if health := p.multipath.pathsHealth(dev); !isHealthy(health) {
+			return health
+		}
 	}
 	return Healthy{}
 }
 
+// hasPartitionOn reports whether a member of p is a partition of multipath
+// map dev, whose change events may change the health of p.
+func (p *batchPartitionPool) hasPartitionOn(dev udev.Device) bool {
+	parts := p.multipath.mapPartitions(dev)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, part := range parts {
+		if _, ok := p.parts[part.Id()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// disksHealth is Unhealthy while the disk of any member fails a [DiskHealth]
+// check. Unlike health it is evaluated whenever the seats are listed, so a
+// disk that is cleared makes the seats Healthy again without a member change.
+func (p *batchPartitionPool) disksHealth() Health {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, dev := range p.parts {
+		if err := checkDisks(p.diskHealth, dev); err != nil {
+			return Unhealthy{Reason: fmt.Sprintf("batch %s: %v", p.name, err)}
+		}
+	}
+	return Healthy{}
+}
+
+// numaNodes returns the sorted union of the NUMA nodes of all members.
+func (p *batchPartitionPool) numaNodes() []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.numaNodesLocked()
+}
+
+func (p *batchPartitionPool) numaNodesLocked() []int {
+	nodes := make([]int, 0, len(p.parts))
+	for _, dev := range p.parts {
+		nodes = append(nodes, dev.NumaNode())
+	}
+	return distinctNumaNodes(nodes)
+}
+
+// missing returns the expected labels that currently have no matching
+// partition, in the order they were declared.
+func (p *batchPartitionPool) missing() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.missingLocked()
+}
+
+func (p *batchPartitionPool) missingLocked() []string {
+	if len(p.expected) == 0 {
+		return nil
+	}
+	present := make(map[string]struct{}, len(p.labels))
+	for _, label := range p.labels {
+		present[label] = struct{}{}
+	}
+	var missing []string
+	for _, label := range p.expected {
+		if _, ok := present[label]; !ok {
+			missing = append(missing, label)
+		}
+	}
+	return missing
+}
+
+// size returns the number of partitions currently in the pool.
+func (p *batchPartitionPool) size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.parts)
+}
+
+// missingEnv is the name of the env var listing the expected labels that were
+// absent at allocation time.
+func (p *batchPartitionPool) missingEnv() string {
+	return sanitizeEnv(p.domain) + "_BATCH_" + sanitizeEnv(p.name) + "_MISSING"
+}
+
 func (p *batchPartitionPool) empty() bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -38,6 +208,9 @@ func (p *batchPartitionPool) empty() bool {
 }
 
 func (p *batchPartitionPool) add(dev udev.Device, label string) {
+	if klog.V(2).Enabled() {
+		klog.V(2).Infof("batch %s: partition %s (%s): %s", p.name, label, dev.DevNode(), readPartitionMetadata(dev))
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.parts[dev.Id()] = dev
@@ -61,13 +234,43 @@ func (p *batchPartitionPool) allocate(ctx context.Context) (*pluginapi.Container
 	for id, dev := range p.parts {
 		snapshot = append(snapshot, devLabel{dev: dev, label: p.labels[id]})
 	}
+	missing := p.missingLocked()
 	p.mu.RUnlock()
 
-	responses := make([]*pluginapi.ContainerAllocateResponse, 0, len(snapshot))
+	responses := make([]*pluginapi.ContainerAllocateResponse, 0, len(snapshot)+1)
 	for _, dl := range snapshot {
-		responses = append(responses, allocatePartitionDevice(dl.dev, p.domain, dl.label))
+		response, err := allocatePartitionDevice(dl.dev, p.domain, dl.label, p.templates)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
 	}
-	return mergeResponses(responses...), nil
+	if len(missing) > 0 {
+		for _, label := range missing {
+			klog.Warningf("batch %s: allocating without expected member %q", p.name, label)
+		}
+		responses = append(responses, &pluginapi.ContainerAllocateResponse{
+			Envs: map[string]string{p.missingEnv(): strings.Join(missing, ",")},
+		})
+	}
+	return mergeResponses(nil, responses...), nil
+}
+
+// quorumWarnings describes every expected member that is currently absent
+// and, if configured, the shortfall against the minimum member count and the
+// NUMA nodes the members span.
+func (p *batchPartitionPool) quorumWarnings(resourceName string) []string {
+	var warnings []string
+	for _, label := range p.missing() {
+		warnings = append(warnings, fmt.Sprintf("batch %s: expected member %q is missing", resourceName, label))
+	}
+	if size := p.size(); size < p.minMembers {
+		warnings = append(warnings, fmt.Sprintf("batch %s: %d of at least %d members present", resourceName, size, p.minMembers))
+	}
+	if nodes := p.numaNodes(); p.numaAligned && len(nodes) > 1 {
+		warnings = append(warnings, fmt.Sprintf("batch %s: members span NUMA nodes %v", resourceName, nodes))
+	}
+	return warnings
 }
 
 // batchPartitionSeat is a single allocatable slot in a batch resource.
@@ -79,9 +282,31 @@ type batchPartitionSeat struct {
 
 func (s *batchPartitionSeat) Id() Id { return s.id }
 
-func (s *batchPartitionSeat) Health() Health { return s.pool.health() }
+// udevDevices returns the current members, all of which are allocated together.
+func (s *batchPartitionSeat) udevDevices() []udev.Device {
+	s.pool.mu.RLock()
+	defer s.pool.mu.RUnlock()
+	devs := make([]udev.Device, 0, len(s.pool.parts))
+	for _, dev := range s.pool.parts {
+		devs = append(devs, dev)
+	}
+	return devs
+}
 
-func (s *batchPartitionSeat) TopologyHints() *pluginapi.TopologyInfo { return nil }
+func (s *batchPartitionSeat) Health() Health {
+	if health := s.pool.health(); !isHealthy(health) {
+		return health
+	}
+	return s.pool.disksHealth()
+}
+
+// TopologyHints reports the union of the NUMA nodes of all pool members.
+func (s *batchPartitionSeat) TopologyHints() *pluginapi.TopologyInfo {
+	if s.pool.disableTopologyHints {
+		return nil
+	}
+	return numaTopology(s.pool.numaNodes()...)
+}
 
 func (s *batchPartitionSeat) Allocate(ctx context.Context) (*pluginapi.ContainerAllocateResponse, error) {
 	return s.pool.allocate(ctx)
@@ -89,19 +314,12 @@ func (s *batchPartitionSeat) Allocate(ctx context.Context) (*pluginapi.Container
 
 // matchBatchPartitionDevice checks if a device is a partition matching the given regexp.
 // Returns the device's udev.Id, the mapped label (capture group 1 if present, otherwise
-// the full PARTNAME), and true if it matches, or zero values and false otherwise.
-func matchBatchPartitionDevice(dev udev.Device, matcher *regexp.Regexp) (udev.Id, string, bool) {
-	if dev == nil {
-		return "", "", false
-	}
-	if dev.Subsystem() != udev.BlockSubsystem {
-		return "", "", false
-	}
-	if dev.DevType() != udev.DeviceTypePart {
-		return "", "", false
-	}
-	partlabel, found := dev.Properties()[udev.PropertyPartName]
-	if !found {
+// the full partition label), and true if it matches, or zero values and false otherwise.
+// Partitions of multipath maps match, partitions of their paths never do; a nil
+// multipath only matches regular partitions.
+func matchBatchPartitionDevice(dev udev.Device, matcher *regexp.Regexp, multipath *multipathSysfs) (udev.Id, string, bool) {
+	partlabel, ok := multipath.partitionName(dev)
+	if !ok {
 		return "", "", false
 	}
 	matches := matcher.FindStringSubmatch(partlabel)
@@ -118,6 +336,8 @@ func matchBatchPartitionDevice(dev udev.Device, matcher *regexp.Regexp) (udev.Id
 // NewBatchPartitionScatter creates a batch partition resource that aggregates all partitions
 // matching the given regexp into a single allocatable Kubernetes resource.
 // count controls how many pods can simultaneously hold the resource (each gets all partitions).
+// By default the seats are Healthy while at least one partition matches; see
+// [WithExpectedMembers] and [WithMinMembers] to require a quorum.
 func NewBatchPartitionScatter(
 	d udev.Discovery,
 	registry *Registry,
@@ -125,11 +345,16 @@ func NewBatchPartitionScatter(
 	name string,
 	matcher *regexp.Regexp,
 	count int,
+	opts ...BatchPartitionOption,
 ) mux.CancelFunc {
 	pool := &batchPartitionPool{
 		parts:  make(map[udev.Id]udev.Device),
 		labels: make(map[udev.Id]string),
 		domain: domain,
+		name:   name,
+	}
+	for _, opt := range opts {
+		opt(pool)
 	}
 
 	instanceMap := make(map[Id]Instance, count)
@@ -157,7 +382,67 @@ func NewBatchPartitionScatter(
 	ch := make(chan udev.Event, 1)
 	go runBatchPartitionScatter(ch, pool, matcher, res, seats)
 
-	return d.Subscribe(mux.SinkFromChan(ch))
+	unwatch := d.Watch(pool.hasPartitionOn)
+	return mux.ChainCancelFunc(unwatch, d.Subscribe(mux.SinkFromChan(ch)))
+}
+
+// batchReporter submits the health of the seats of a pool whenever it or the
+// NUMA nodes of the members change, and logs the quorum of the pool whenever
+// that changes.
+type batchReporter struct {
+	pool       *batchPartitionPool
+	res        *resource
+	seats      []Instance
+	last       Health
+	lastNodes  string
+	lastQuorum string
+}
+
+// newBatchReporter returns a batchReporter for seats that start out
+// reflecting the empty pool, so the first transition worth reporting is
+// towards Healthy. Topology hints follow the member set, so a change in the
+// NUMA nodes is reported as well.
+func newBatchReporter(pool *batchPartitionPool, res *resource, seats []Instance) *batchReporter {
+	return &batchReporter{
+		pool:      pool,
+		res:       res,
+		seats:     seats,
+		last:      Unhealthy{},
+		lastNodes: fmt.Sprint(pool.numaNodes()),
+	}
+}
+
+// report submits the health of the pool if it changed on the udev event
+// named by cause.
+func (r *batchReporter) report(cause string) {
+	r.logQuorum()
+	health := r.pool.health()
+	nodes := fmt.Sprint(r.pool.numaNodes())
+	if health.String() == r.last.String() && nodes == r.lastNodes {
+		return
+	}
+	r.last, r.lastNodes = health, nodes
+	if healthy, ok := health.(Healthy); ok && healthy.Reason == "" {
+		health = Healthy{Reason: fmt.Sprintf("batch %s: all members present", r.pool.name)}
+	}
+	if err := r.res.Submit(HealthEvent{Instances: r.seats, Health: health, Cause: cause}); err != nil {
+		klog.Errorf("batch %s: failed to submit health event: %v", r.res.Name(), err)
+	}
+}
+
+// logQuorum logs the quorum warnings of the pool unless they are the same as
+// on the last report, so that events that do not affect the quorum, like
+// change events on the members, do not repeat them.
+func (r *batchReporter) logQuorum() {
+	warnings := r.pool.quorumWarnings(r.res.Name())
+	quorum := strings.Join(warnings, "\n")
+	if quorum == r.lastQuorum {
+		return
+	}
+	r.lastQuorum = quorum
+	for _, warning := range warnings {
+		klog.Warning(warning)
+	}
 }
 
 func runBatchPartitionScatter(
@@ -167,47 +452,43 @@ func runBatchPartitionScatter(
 	res *resource,
 	seats []Instance,
 ) {
+	report := newBatchReporter(pool, res, seats).report
+
 	for ev := range evCh {
 		switch ev := ev.(type) {
 		case udev.Init:
+			matched := false
 			for _, dev := range ev.Devices {
-				if id, label, ok := matchBatchPartitionDevice(dev, matcher); ok {
+				if id, label, ok := matchBatchPartitionDevice(dev, matcher, pool.multipath); ok {
 					pool.add(dev, label)
+					matched = true
 					klog.V(5).Infof("batch %s: init matched partition %s", res.Name(), id)
 				}
 			}
-			if !pool.empty() {
-				if err := res.Submit(HealthEvent{Instances: seats, Health: Healthy{}}); err != nil {
-					klog.Errorf("batch %s: failed to submit health event: %v", res.Name(), err)
-				}
+			if matched {
+				report(reasonUdevInit)
 			}
 
 		case udev.Added:
-			id, label, ok := matchBatchPartitionDevice(ev.Device, matcher)
+			id, label, ok := matchBatchPartitionDevice(ev.Device, matcher, pool.multipath)
 			if !ok {
+				if pool.hasPartitionOn(ev.Device) {
+					report(fmt.Sprintf(reasonUdevAdded, ev.Id()))
+				}
 				continue
 			}
-			wasEmpty := pool.empty()
 			pool.add(ev.Device, label)
 			klog.V(5).Infof("batch %s: added partition %s", res.Name(), id)
-			if wasEmpty {
-				if err := res.Submit(HealthEvent{Instances: seats, Health: Healthy{}}); err != nil {
-					klog.Errorf("batch %s: failed to submit health event: %v", res.Name(), err)
-				}
-			}
+			report(fmt.Sprintf(reasonUdevAdded, id))
 
 		case udev.Removed:
-			id, _, ok := matchBatchPartitionDevice(ev.Device, matcher)
+			id, _, ok := matchBatchPartitionDevice(ev.Device, matcher, pool.multipath)
 			if !ok {
 				continue
 			}
 			pool.remove(id)
 			klog.V(5).Infof("batch %s: removed partition %s", res.Name(), id)
-			if pool.empty() {
-				if err := res.Submit(HealthEvent{Instances: seats, Health: Unhealthy{}}); err != nil {
-					klog.Errorf("batch %s: failed to submit health event: %v", res.Name(), err)
-				}
-			}
+			report(fmt.Sprintf(reasonUdevRemoved, id))
 		}
 	}
 }
@@ -20,7 +20,7 @@ var _ = Describe("matchBatchPartitionDevice", func() {
 
 	It("returns false for a non-block device", func() {
 		dev := netDevice("eth0", "1000", "up")
-		_, _, ok := matchBatchPartitionDevice(dev, matcher)
+		_, _, ok := matchBatchPartitionDevice(dev, matcher, nil)
 		Expect(ok).To(BeFalse())
 	})
 
@@ -31,7 +31,7 @@ var _ = Describe("matchBatchPartitionDevice", func() {
 			devType:    "disk",
 			properties: map[string]string{},
 		}
-		_, _, ok := matchBatchPartitionDevice(dev, matcher)
+		_, _, ok := matchBatchPartitionDevice(dev, matcher, nil)
 		Expect(ok).To(BeFalse())
 	})
 
@@ -42,19 +42,19 @@ var _ = Describe("matchBatchPartitionDevice", func() {
 			devType:    udev.DeviceTypePart,
 			properties: map[string]string{},
 		}
-		_, _, ok := matchBatchPartitionDevice(dev, matcher)
+		_, _, ok := matchBatchPartitionDevice(dev, matcher, nil)
 		Expect(ok).To(BeFalse())
 	})
 
 	It("returns false when the label does not match the regexp", func() {
 		dev := partitionDevice("sda1", "data_01")
-		_, _, ok := matchBatchPartitionDevice(dev, matcher)
+		_, _, ok := matchBatchPartitionDevice(dev, matcher, nil)
 		Expect(ok).To(BeFalse())
 	})
 
 	It("returns the full match as label when there is no capture group", func() {
 		dev := partitionDevice("nvme0n1p1", "nvme_data_01")
-		id, label, ok := matchBatchPartitionDevice(dev, matcher)
+		id, label, ok := matchBatchPartitionDevice(dev, matcher, nil)
 		Expect(ok).To(BeTrue())
 		Expect(id).To(Equal(udev.Id("nvme0n1p1")))
 		Expect(label).To(Equal("nvme_data_01"))
@@ -63,7 +63,7 @@ var _ = Describe("matchBatchPartitionDevice", func() {
 	It("returns capture group 1 as label when a capture group is present", func() {
 		m := regexp.MustCompile(`nvme_(.*)`)
 		dev := partitionDevice("nvme0n1p1", "nvme_data_01")
-		id, label, ok := matchBatchPartitionDevice(dev, m)
+		id, label, ok := matchBatchPartitionDevice(dev, m, nil)
 		Expect(ok).To(BeTrue())
 		Expect(id).To(Equal(udev.Id("nvme0n1p1")))
 		Expect(label).To(Equal("data_01"))
@@ -101,6 +101,41 @@ var _ = Describe("batchPartitionPool", func() {
 			pool.add(dev1, "nvme_data_01")
 			Expect(pool.health()).To(BeAssignableToTypeOf(Healthy{}))
 		})
+
+		It("returns Unhealthy while an expected member is missing", func() {
+			WithExpectedMembers("nvme_data_01", "nvme_data_02")(pool)
+			pool.add(dev1, "nvme_data_01")
+			Expect(pool.health()).To(BeAssignableToTypeOf(Unhealthy{}))
+			Expect(pool.missing()).To(Equal([]string{"nvme_data_02"}))
+
+			pool.add(dev2, "nvme_data_02")
+			Expect(pool.health()).To(BeAssignableToTypeOf(Healthy{}))
+			Expect(pool.missing()).To(BeEmpty())
+		})
+
+		It("returns Unhealthy below the minimum member count", func() {
+			WithMinMembers(2)(pool)
+			pool.add(dev1, "nvme_data_01")
+			Expect(pool.health()).To(BeAssignableToTypeOf(Unhealthy{}))
+
+			pool.add(dev2, "nvme_data_02")
+			Expect(pool.health()).To(BeAssignableToTypeOf(Healthy{}))
+		})
+	})
+
+	Describe("quorumWarnings", func() {
+		It("describes the absent expected members and the shortfall", func() {
+			WithExpectedMembers("nvme_data_01", "nvme_data_02")(pool)
+			WithMinMembers(2)(pool)
+			pool.add(dev1, "nvme_data_01")
+			Expect(pool.quorumWarnings("ydb.tech/batch-data")).To(Equal([]string{
+				`batch ydb.tech/batch-data: expected member "nvme_data_02" is missing`,
+				"batch ydb.tech/batch-data: 1 of at least 2 members present",
+			}))
+
+			pool.add(dev2, "nvme_data_02")
+			Expect(pool.quorumWarnings("ydb.tech/batch-data")).To(BeEmpty())
+		})
 	})
 
 	Describe("empty", func() {
@@ -172,6 +207,24 @@ var _ = Describe("batchPartitionPool", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(resp.Envs).To(HaveKey("YDB_TECH_PART_NVME_DATA_01_PATH"))
 		})
+
+		It("lists the missing expected labels", func() {
+			pool.name = "nvme-set"
+			WithExpectedMembers("nvme_data_01", "nvme_data_02", "nvme_data_03")(pool)
+			pool.add(dev1, "nvme_data_01")
+			resp, err := pool.allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_BATCH_NVME_SET_MISSING", "nvme_data_02,nvme_data_03"))
+		})
+
+		It("omits the missing list when every expected label is present", func() {
+			pool.name = "nvme-set"
+			WithExpectedMembers("nvme_data_01")(pool)
+			pool.add(dev1, "nvme_data_01")
+			resp, err := pool.allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Envs).NotTo(HaveKey("YDB_TECH_BATCH_NVME_SET_MISSING"))
+		})
 	})
 })
 
@@ -201,10 +254,45 @@ var _ = Describe("batchPartitionSeat", func() {
 		Expect(seat.Health()).To(BeAssignableToTypeOf(Healthy{}))
 	})
 
-	It("returns nil TopologyHints", func() {
+	It("returns nil TopologyHints when no member reports a NUMA node", func() {
+		pool.add(partitionDevice("nvme0n1p1", "nvme_data"), "nvme_data")
 		Expect(seat.TopologyHints()).To(BeNil())
 	})
 
+	It("returns the union of member NUMA nodes", func() {
+		dev0 := partitionDevice("nvme0n1p1", "nvme_data_01")
+		dev0.numaNode = 1
+		dev1 := partitionDevice("nvme1n1p1", "nvme_data_02")
+		dev1.numaNode = 0
+		pool.add(dev0, "nvme_data_01")
+		pool.add(dev1, "nvme_data_02")
+		hints := seat.TopologyHints()
+		Expect(hints).NotTo(BeNil())
+		Expect(hints.Nodes).To(HaveLen(2))
+		Expect(hints.Nodes[0].ID).To(BeEquivalentTo(0))
+		Expect(hints.Nodes[1].ID).To(BeEquivalentTo(1))
+	})
+
+	It("returns nil TopologyHints when hints are disabled", func() {
+		WithBatchTopologyHints(false)(pool)
+		dev := partitionDevice("nvme0n1p1", "nvme_data")
+		dev.numaNode = 1
+		pool.add(dev, "nvme_data")
+		Expect(seat.TopologyHints()).To(BeNil())
+	})
+
+	It("is Unhealthy with numaAligned when members span NUMA nodes", func() {
+		WithNumaAligned()(pool)
+		dev0 := partitionDevice("nvme0n1p1", "nvme_data_01")
+		dev0.numaNode = 0
+		dev1 := partitionDevice("nvme1n1p1", "nvme_data_02")
+		dev1.numaNode = 1
+		pool.add(dev0, "nvme_data_01")
+		Expect(seat.Health()).To(BeAssignableToTypeOf(Healthy{}))
+		pool.add(dev1, "nvme_data_02")
+		Expect(seat.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+	})
+
 	It("delegates Allocate to the pool", func() {
 		pool.add(partitionDevice("nvme0n1p1", "nvme_data"), "nvme_data")
 		resp, err := seat.Allocate(context.Background())
@@ -229,6 +317,34 @@ var _ = Describe("newResource Close", func() {
 	})
 })
 
+var _ = Describe("batchReporter", func() {
+	It("remembers the quorum it logged last", func() {
+		pool := &batchPartitionPool{
+			parts:  make(map[udev.Id]udev.Device),
+			labels: make(map[udev.Id]string),
+			domain: "ydb.tech",
+			name:   "data",
+		}
+		WithExpectedMembers("nvme_data_01", "nvme_data_02")(pool)
+		res := newResource(ResourceTemplate{Domain: "ydb.tech", Prefix: "batch-data"}, map[Id]Instance{})
+		DeferCleanup(res.Close)
+		r := newBatchReporter(pool, res, nil)
+
+		r.logQuorum()
+		quorum := r.lastQuorum
+		Expect(quorum).To(ContainSubstring(`"nvme_data_02" is missing`))
+
+		pool.add(partitionDevice("nvme0n1p1", "nvme_data_01"), "nvme_data_01")
+		r.logQuorum()
+		Expect(r.lastQuorum).NotTo(Equal(quorum))
+		Expect(r.lastQuorum).NotTo(ContainSubstring(`"nvme_data_01" is missing`))
+
+		pool.add(partitionDevice("nvme1n1p1", "nvme_data_02"), "nvme_data_02")
+		r.logQuorum()
+		Expect(r.lastQuorum).To(BeEmpty())
+	})
+})
+
 var _ = Describe("runBatchPartitionScatter", func() {
 	var (
 		pool    *batchPartitionPool
@@ -391,4 +507,33 @@ var _ = Describe("runBatchPartitionScatter", func() {
 			Consistently(watchCh, 50*time.Millisecond).ShouldNot(Receive())
 		})
 	})
+
+	Describe("with expected members", func() {
+		BeforeEach(func() {
+			WithExpectedMembers("nvme_data_01", "nvme_data_02")(pool)
+		})
+
+		It("stays Unhealthy until every expected member is present", func() {
+			evCh <- udev.Added{Device: partitionDevice("nvme0n1p1", "nvme_data_01")}
+			Consistently(watchCh, 50*time.Millisecond).ShouldNot(Receive())
+
+			evCh <- udev.Added{Device: partitionDevice("nvme1n1p1", "nvme_data_02")}
+			var snapshot []Instance
+			Eventually(watchCh).Should(Receive(&snapshot))
+			Expect(snapshot[0].Health()).To(BeAssignableToTypeOf(Healthy{}))
+		})
+
+		It("goes Unhealthy as soon as one expected member is removed", func() {
+			evCh <- udev.Init{Devices: []udev.Device{
+				partitionDevice("nvme0n1p1", "nvme_data_01"),
+				partitionDevice("nvme1n1p1", "nvme_data_02"),
+			}}
+			Eventually(watchCh).Should(Receive())
+
+			evCh <- udev.Removed{Device: partitionDevice("nvme1n1p1", "nvme_data_02")}
+			var snapshot []Instance
+			Eventually(watchCh).Should(Receive(&snapshot))
+			Expect(snapshot[0].Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		})
+	})
 })
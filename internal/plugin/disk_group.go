@@ -0,0 +1,384 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ydb-platform/udev-manager/internal/mux"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultDiskGroupName names disk groups after the serial number of the disk,
+// or its WWN or kernel name if it has none.
+const DefaultDiskGroupName = "disk-{{ or .Serial .WWN .Name }}"
+
+// diskGroupNameInvalidRegex matches runs of characters that are not allowed
+// in resource names.
+var diskGroupNameInvalidRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// DiskTemplateData is what disk group names are rendered from, e.g.
+// "disk-{{ .Serial }}" or "{{ .Model | lower }}-{{ .Name }}".
+type DiskTemplateData struct {
+	Name   string // kernel name, e.g. "nvme0n1"
+	Serial string
+	WWN    string
+	Model  string
+
+	dev udev.Device
+}
+
+// Property returns the udev property key of the disk or its parents.
+func (d DiskTemplateData) Property(key string) string {
+	return d.dev.PropertyLookup(key)
+}
+
+// SysAttr returns the sysfs attribute key of the disk or its parents.
+func (d DiskTemplateData) SysAttr(key string) string {
+	return d.dev.SystemAttributeLookup(key)
+}
+
+func newDiskTemplateData(disk udev.Device) DiskTemplateData {
+	// udev properties are preferred as the sysfs attributes are padded and
+	// some of them live on the controller rather than the disk.
+	lookup := func(property, sysattr string) string {
+		if value := disk.PropertyLookup(property); value != "" {
+			return value
+		}
+		return strings.TrimSpace(disk.SystemAttributeLookup(sysattr))
+	}
+	return DiskTemplateData{
+		Name:   udev.Sysname(disk),
+		Serial: lookup(udev.PropertyShortSerial, udev.SysAttrSerial),
+		WWN:    lookup(udev.PropertyWWN, udev.SysAttrWWID),
+		Model:  lookup(udev.PropertyModel, udev.SysAttrModel),
+		dev:    disk,
+	}
+}
+
+// blankDiskTemplateData has none of the data of a disk, so that a name
+// template renders only its static text from it.
+var blankDiskTemplateData = DiskTemplateData{dev: udev.NewFakeDevice("")}
+
+// executeDiskGroupName renders tmpl from data, replacing characters that are
+// not allowed in resource names.
+func executeDiskGroupName(tmpl *template.Template, data DiskTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	name := diskGroupNameInvalidRegex.ReplaceAllString(sb.String(), "-")
+	return strings.Trim(name, "._-"), nil
+}
+
+// renderDiskGroupName renders the resource name of the group of disk. It
+// fails if the name has none of the data of disk, e.g. "disk" for a disk
+// without a serial, as all such disks would share one group.
+func renderDiskGroupName(tmpl *template.Template, disk udev.Device) (string, error) {
+	name, err := executeDiskGroupName(tmpl, newDiskTemplateData(disk))
+	if err != nil {
+		return "", fmt.Errorf("failed to render group name of disk %s: %w", disk.DevNode(), err)
+	}
+	if name == "" {
+		return "", fmt.Errorf("group name of disk %s is empty", disk.DevNode())
+	}
+	if static, err := executeDiskGroupName(tmpl, blankDiskTemplateData); err == nil && name == static {
+		return "", fmt.Errorf("group name %q of disk %s is only the static text of the template", name, disk.DevNode())
+	}
+	return name, nil
+}
+
+// ValidateDiskGroupName renders tmpl against a sample disk, so that mistakes
+// surface when the config is loaded.
+func ValidateDiskGroupName(tmpl *template.Template) error {
+	_, err := renderDiskGroupName(tmpl, sampleDiskDevice)
+	return err
+}
+
+// diskGroup is the resource of a single disk. Its only seat hands out all of
+// the matching partitions of the disk.
+type diskGroup struct {
+	pool     *batchPartitionPool
+	res      *resource
+	reporter *batchReporter
+	disk     udev.Id // the disk whose partitions the group holds
+}
+
+// diskGrouper sorts matching partitions into one diskGroup per parent disk.
+// Groups are created as their disks show up and are kept, Unhealthy, while
+// their disks are gone, so that a disk coming back gets its resource back.
+// With pruneAfter set, a group without partitions for that long is removed.
+type diskGrouper struct {
+	domain     string
+	name       *template.Template
+	matcher    *regexp.Regexp
+	opts       []BatchPartitionOption
+	register   func(Resource) error
+	unregister func(string) error
+	// multipath and pruneAfter are shared by all groups, as opts set them once
+	multipath  *multipathSysfs
+	pruneAfter time.Duration
+
+	groups     map[string]*diskGroup // group name -> group, nil if it failed to register
+	members    map[udev.Id]string    // partition -> group name
+	emptySince map[string]time.Time  // group name -> when it lost its last partition
+}
+
+func newDiskGrouper(
+	domain string,
+	name *template.Template,
+	matcher *regexp.Regexp,
+	register func(Resource) error,
+	unregister func(string) error,
+	opts []BatchPartitionOption,
+) *diskGrouper {
+	probe := &batchPartitionPool{}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	return &diskGrouper{
+		domain:     domain,
+		name:       name,
+		matcher:    matcher,
+		opts:       opts,
+		register:   register,
+		unregister: unregister,
+		multipath:  probe.multipath,
+		pruneAfter: probe.pruneAfter,
+		groups:     make(map[string]*diskGroup),
+		members:    make(map[udev.Id]string),
+		emptySince: make(map[string]time.Time),
+	}
+}
+
+// group returns the group named name, creating and registering it on first
+// use. It returns nil if the group could not be registered.
+func (g *diskGrouper) group(name string, disk udev.Device) *diskGroup {
+	if group, ok := g.groups[name]; ok {
+		return group
+	}
+
+	pool := &batchPartitionPool{
+		parts:     make(map[udev.Id]udev.Device),
+		labels:    make(map[udev.Id]string),
+		domain:    g.domain,
+		name:      name,
+		multipath: g.multipath,
+	}
+	for _, opt := range g.opts {
+		opt(pool)
+	}
+	seat := &batchPartitionSeat{id: "0", pool: pool}
+	seats := []Instance{seat}
+	res := newResource(ResourceTemplate{
+		Domain: g.domain,
+		Prefix: name,
+	}, map[Id]Instance{seat.id: seat})
+
+	if err := g.register(res); err != nil {
+		klog.Errorf("failed to add disk group resource %s: %v", res.Name(), err)
+		res.Close()
+		g.groups[name] = nil
+		return nil
+	}
+	klog.Infof("disk group %s: found disk %s", res.Name(), disk.DevNode())
+
+	group := &diskGroup{
+		pool:     pool,
+		res:      res,
+		reporter: newBatchReporter(pool, res, seats),
+		disk:     disk.Id(),
+	}
+	g.groups[name] = group
+	return group
+}
+
+// add puts partition dev into the group of its disk and returns that group.
+// It returns nil if dev does not match or its disk cannot be named.
+func (g *diskGrouper) add(dev udev.Device) *diskGroup {
+	id, label, ok := matchBatchPartitionDevice(dev, g.matcher, g.multipath)
+	if !ok {
+		return nil
+	}
+	disk := dev.Parent()
+	if disk == nil {
+		klog.Warningf("disk groups: partition %s has no parent disk", id)
+		return nil
+	}
+	name, err := renderDiskGroupName(g.name, disk)
+	if err != nil {
+		klog.Warningf("disk groups: skipping partition %s: %v", id, err)
+		return nil
+	}
+
+	// The name of the group may change with the properties of the disk.
+	if old, ok := g.members[id]; ok && old != name {
+		if group := g.groups[old]; group != nil {
+			group.pool.remove(id)
+			group.reporter.report(fmt.Sprintf(reasonUdevAdded, id))
+		}
+		delete(g.members, id)
+		g.markIfEmpty(old)
+	}
+
+	group := g.group(name, disk)
+	if group == nil {
+		return nil
+	}
+	// Distinct disks rendering the same name, e.g. sharing a serial, are not
+	// merged: the group stays with its disk until that has no partitions left.
+	if group.disk != disk.Id() {
+		if !g.empty(name) {
+			klog.Warningf("disk groups: skipping partition %s: disk %s is named %s like disk %s", id, disk.DevNode(), group.res.Name(), group.disk)
+			return nil
+		}
+		group.disk = disk.Id()
+	}
+	group.pool.add(dev, label)
+	g.members[id] = name
+	delete(g.emptySince, name)
+	klog.V(5).Infof("disk group %s: added partition %s", group.res.Name(), id)
+	return group
+}
+
+// remove takes partition dev out of its group and returns that group, or nil
+// if dev was not grouped.
+func (g *diskGrouper) remove(dev udev.Device) *diskGroup {
+	if dev == nil {
+		return nil
+	}
+	// The parent of a removed partition may be gone already, so the group is
+	// looked up by the partition.
+	name, ok := g.members[dev.Id()]
+	if !ok {
+		return nil
+	}
+	delete(g.members, dev.Id())
+	g.markIfEmpty(name)
+	group := g.groups[name]
+	group.pool.remove(dev.Id())
+	klog.V(5).Infof("disk group %s: removed partition %s", group.res.Name(), dev.Id())
+	return group
+}
+
+// markIfEmpty records when the group named name lost its last partition.
+func (g *diskGrouper) markIfEmpty(name string) {
+	if g.pruneAfter <= 0 || g.groups[name] == nil || !g.empty(name) {
+		return
+	}
+	if _, ok := g.emptySince[name]; !ok {
+		g.emptySince[name] = time.Now()
+	}
+}
+
+// empty reports whether the group named name has no partitions.
+func (g *diskGrouper) empty(name string) bool {
+	for _, member := range g.members {
+		if member == name {
+			return false
+		}
+	}
+	return true
+}
+
+// prune removes the groups that have had no partitions for pruneAfter as of
+// now, unregistering and closing their resources. A disk that comes back
+// later gets a new resource.
+func (g *diskGrouper) prune(now time.Time) {
+	for name, since := range g.emptySince {
+		if now.Sub(since) < g.pruneAfter {
+			continue
+		}
+		group := g.groups[name]
+		delete(g.emptySince, name)
+		delete(g.groups, name)
+		if err := g.unregister(group.res.Name()); err != nil {
+			klog.Errorf("failed to remove disk group resource %s: %v", group.res.Name(), err)
+		}
+		group.res.Close()
+		klog.Infof("disk group %s: removed after %s without partitions", group.res.Name(), now.Sub(since).Round(time.Second))
+	}
+}
+
+// pruneInterval is how often empty groups are looked for: pruneAfter, but at
+// least once a minute.
+func (g *diskGrouper) pruneInterval() time.Duration {
+	return min(g.pruneAfter, time.Minute)
+}
+
+// NewDiskGroupScatter creates a resource <domain>/<name> for every disk with
+// partitions matching matcher, where name is rendered from the disk by the
+// name template (see [DefaultDiskGroupName] and [DiskTemplateData]). The
+// single seat of each resource hands out all matching partitions of its disk,
+// like a batch resource of [NewBatchPartitionScatter] with a count of one,
+// and opts apply to every group. Groups are kept while their disks are gone,
+// unless [WithDiskGroupPruneAfter] is given.
+func NewDiskGroupScatter(
+	d udev.Discovery,
+	registry *Registry,
+	domain string,
+	name *template.Template,
+	matcher *regexp.Regexp,
+	opts ...BatchPartitionOption,
+) mux.CancelFunc {
+	ch := make(chan udev.Event, 1)
+	go runDiskGroupScatter(ch, newDiskGrouper(domain, name, matcher, registry.Add, registry.Remove, opts))
+
+	return d.Subscribe(mux.SinkFromChan(ch))
+}
+
+func runDiskGroupScatter(evCh <-chan udev.Event, g *diskGrouper) {
+	var tick <-chan time.Time
+	if g.pruneAfter > 0 {
+		ticker := time.NewTicker(g.pruneInterval())
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		var ev udev.Event
+		select {
+		case now := <-tick:
+			g.prune(now)
+			continue
+		case e, ok := <-evCh:
+			if !ok {
+				return
+			}
+			ev = e
+		}
+
+		switch ev := ev.(type) {
+		case udev.Init:
+			// Report every group once, after all of its partitions are in.
+			var touched []*diskGroup
+			seen := make(map[*diskGroup]struct{})
+			for _, dev := range ev.Devices {
+				group := g.add(dev)
+				if group == nil {
+					continue
+				}
+				if _, ok := seen[group]; !ok {
+					seen[group] = struct{}{}
+					touched = append(touched, group)
+				}
+			}
+			for _, group := range touched {
+				group.reporter.report(reasonUdevInit)
+			}
+
+		case udev.Added:
+			if group := g.add(ev.Device); group != nil {
+				group.reporter.report(fmt.Sprintf(reasonUdevAdded, ev.Id()))
+			}
+
+		case udev.Removed:
+			if group := g.remove(ev.Device); group != nil {
+				group.reporter.report(fmt.Sprintf(reasonUdevRemoved, ev.Id()))
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"text/template"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// diskDevice returns a mock block disk with the given kernel name and serial.
+func diskDevice(name, serial string) *mockDevice {
+	return &mockDevice{
+		id:        udev.Id("/sys/devices/virtual/block/" + name),
+		subsystem: udev.BlockSubsystem,
+		devType:   udev.DeviceTypeDisk,
+		devNode:   "/dev/" + name,
+		properties: map[string]string{
+			udev.PropertyShortSerial: serial,
+		},
+		sysattrs: map[string]string{
+			udev.SysAttrModel: "SAMSUNG MZQL2960HCJR    ",
+		},
+		numaNode: -1,
+	}
+}
+
+// diskPartition returns partition name of disk with partition label label.
+func diskPartition(disk *mockDevice, name, label string) *mockDevice {
+	dev := partitionDevice(string(disk.id)+"/"+name, label)
+	dev.devNode = "/dev/" + name
+	dev.parent = disk
+	return dev
+}
+
+var _ = Describe("renderDiskGroupName", func() {
+	It("renders the serial with the default template", func() {
+		tmpl := template.Must(ParsePartitionTemplate("name", DefaultDiskGroupName))
+		Expect(renderDiskGroupName(tmpl, diskDevice("nvme0n1", "S64F01"))).To(Equal("disk-S64F01"))
+	})
+
+	It("falls back to the WWN or kernel name with the default template", func() {
+		tmpl := template.Must(ParsePartitionTemplate("name", DefaultDiskGroupName))
+		disk := diskDevice("sda", "")
+		Expect(renderDiskGroupName(tmpl, disk)).To(Equal("disk-sda"))
+
+		disk.properties[udev.PropertyWWN] = "0x5000c500a1b2c3d4"
+		Expect(renderDiskGroupName(tmpl, disk)).To(Equal("disk-0x5000c500a1b2c3d4"))
+	})
+
+	It("fails on a name that is only the static text of the template", func() {
+		tmpl := template.Must(ParsePartitionTemplate("name", `disk-{{ .Serial }}`))
+		_, err := renderDiskGroupName(tmpl, diskDevice("sda", ""))
+		Expect(err).To(MatchError(ContainSubstring("static text")))
+	})
+
+	It("trims the model and replaces characters not allowed in resource names", func() {
+		tmpl := template.Must(ParsePartitionTemplate("name", `{{ .Model | lower }}/{{ .Name }}`))
+		Expect(renderDiskGroupName(tmpl, diskDevice("nvme0n1", "S64F01"))).To(Equal("samsung-mzql2960hcjr-nvme0n1"))
+	})
+
+	It("fails on an empty name", func() {
+		tmpl := template.Must(ParsePartitionTemplate("name", `{{ .WWN }}`))
+		_, err := renderDiskGroupName(tmpl, diskDevice("nvme0n1", "S64F01"))
+		Expect(err).To(MatchError(ContainSubstring("is empty")))
+	})
+
+	It("validates templates against a sample disk", func() {
+		Expect(ValidateDiskGroupName(template.Must(ParsePartitionTemplate("name", `{{ .WWN }}`)))).To(Succeed())
+		Expect(ValidateDiskGroupName(template.Must(ParsePartitionTemplate("name", `{{ .Nope }}`)))).NotTo(Succeed())
+		Expect(ValidateDiskGroupName(template.Must(ParsePartitionTemplate("name", `disk`)))).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("diskGrouper", func() {
+	var (
+		grouper    *diskGrouper
+		registered map[string]Resource
+		unregister func(string) error
+		disk0      *mockDevice
+		disk1      *mockDevice
+	)
+
+	BeforeEach(func() {
+		registered = make(map[string]Resource)
+		register := func(res Resource) error {
+			if _, ok := registered[res.Name()]; ok {
+				return fmt.Errorf("resource %s already registered", res.Name())
+			}
+			registered[res.Name()] = res
+			DeferCleanup(res.Close)
+			return nil
+		}
+		unregister = func(name string) error {
+			if _, ok := registered[name]; !ok {
+				return fmt.Errorf("resource %s not registered", name)
+			}
+			delete(registered, name)
+			return nil
+		}
+		grouper = newDiskGrouper(
+			"ydb.tech",
+			template.Must(ParsePartitionTemplate("name", DefaultDiskGroupName)),
+			regexp.MustCompile(`^ydb_(.*)$`),
+			register,
+			func(name string) error { return unregister(name) },
+			nil,
+		)
+		disk0 = diskDevice("nvme0n1", "S64F00")
+		disk1 = diskDevice("nvme1n1", "S64F01")
+	})
+
+	It("creates one resource per disk", func() {
+		Expect(grouper.add(diskPartition(disk0, "nvme0n1p1", "ydb_data_01"))).NotTo(BeNil())
+		Expect(grouper.add(diskPartition(disk0, "nvme0n1p2", "ydb_data_02"))).NotTo(BeNil())
+		Expect(grouper.add(diskPartition(disk1, "nvme1n1p1", "ydb_data_01"))).NotTo(BeNil())
+
+		Expect(registered).To(HaveLen(2))
+		Expect(registered).To(HaveKey("ydb.tech/disk-S64F00"))
+		Expect(registered).To(HaveKey("ydb.tech/disk-S64F01"))
+		Expect(grouper.groups["disk-S64F00"].pool.size()).To(Equal(2))
+		Expect(grouper.groups["disk-S64F01"].pool.size()).To(Equal(1))
+	})
+
+	It("hands out all partitions of a disk from a single seat", func() {
+		grouper.add(diskPartition(disk0, "nvme0n1p1", "ydb_data_01"))
+		grouper.add(diskPartition(disk0, "nvme0n1p2", "ydb_data_02"))
+
+		instances := registered["ydb.tech/disk-S64F00"].Instances()
+		Expect(instances).To(HaveLen(1))
+		resp, err := instances["0"].Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Devices).To(HaveLen(2))
+		Expect(resp.Envs).To(HaveKey("YDB_TECH_PART_DATA_01_PATH"))
+		Expect(resp.Envs).To(HaveKey("YDB_TECH_PART_DATA_02_PATH"))
+	})
+
+	It("ignores partitions that do not match or have no parent disk", func() {
+		Expect(grouper.add(diskPartition(disk0, "nvme0n1p1", "other"))).To(BeNil())
+		Expect(grouper.add(partitionDevice("nvme9n1p1", "ydb_data_01"))).To(BeNil())
+		Expect(registered).To(BeEmpty())
+	})
+
+	It("keeps the group of a disk that is gone and reuses it when it returns", func() {
+		part := diskPartition(disk0, "nvme0n1p1", "ydb_data_01")
+		group := grouper.add(part)
+		Expect(group.pool.health()).To(BeAssignableToTypeOf(Healthy{}))
+
+		Expect(grouper.remove(part)).To(BeIdenticalTo(group))
+		Expect(group.pool.health()).To(BeAssignableToTypeOf(Unhealthy{}))
+
+		Expect(grouper.add(part)).To(BeIdenticalTo(group))
+		Expect(group.pool.health()).To(BeAssignableToTypeOf(Healthy{}))
+		Expect(registered).To(HaveLen(1))
+	})
+
+	It("removes partitions whose parent is already gone", func() {
+		part := diskPartition(disk0, "nvme0n1p1", "ydb_data_01")
+		group := grouper.add(part)
+
+		Expect(grouper.remove(partitionDevice(string(part.id), ""))).To(BeIdenticalTo(group))
+		Expect(group.pool.empty()).To(BeTrue())
+	})
+
+	It("moves a partition when the name of its disk changes", func() {
+		part := diskPartition(disk0, "nvme0n1p1", "ydb_data_01")
+		old := grouper.add(part)
+
+		renamed := diskPartition(diskDevice("nvme0n1", "S64F99"), "nvme0n1p1", "ydb_data_01")
+		group := grouper.add(renamed)
+		Expect(group).NotTo(BeIdenticalTo(old))
+		Expect(old.pool.empty()).To(BeTrue())
+		Expect(group.pool.size()).To(Equal(1))
+	})
+
+	It("does not merge distinct disks with the same name", func() {
+		twin := diskDevice("nvme2n1", "S64F00")
+		group := grouper.add(diskPartition(disk0, "nvme0n1p1", "ydb_data_01"))
+		Expect(grouper.add(diskPartition(twin, "nvme2n1p1", "ydb_data_01"))).To(BeNil())
+		Expect(group.pool.size()).To(Equal(1))
+		Expect(registered).To(HaveLen(1))
+	})
+
+	It("hands the group over to another disk with the same name once it is empty", func() {
+		part := diskPartition(disk0, "nvme0n1p1", "ydb_data_01")
+		group := grouper.add(part)
+		grouper.remove(part)
+
+		twin := diskDevice("nvme2n1", "S64F00")
+		Expect(grouper.add(diskPartition(twin, "nvme2n1p1", "ydb_data_01"))).To(BeIdenticalTo(group))
+		Expect(grouper.add(part)).To(BeNil())
+		Expect(group.pool.size()).To(Equal(1))
+	})
+
+	It("keeps groups without partitions unless pruning is enabled", func() {
+		part := diskPartition(disk0, "nvme0n1p1", "ydb_data_01")
+		grouper.add(part)
+		grouper.remove(part)
+
+		grouper.prune(time.Now().Add(24 * time.Hour))
+		Expect(registered).To(HaveKey("ydb.tech/disk-S64F00"))
+	})
+
+	Context("with pruning", func() {
+		BeforeEach(func() {
+			grouper.pruneAfter = time.Hour
+		})
+
+		It("removes a group that has had no partitions for pruneAfter", func() {
+			part := diskPartition(disk0, "nvme0n1p1", "ydb_data_01")
+			group := grouper.add(part)
+			grouper.add(diskPartition(disk1, "nvme1n1p1", "ydb_data_01"))
+			grouper.remove(part)
+
+			grouper.prune(time.Now().Add(30 * time.Minute))
+			Expect(registered).To(HaveKey("ydb.tech/disk-S64F00"))
+
+			grouper.prune(time.Now().Add(time.Hour))
+			Expect(registered).NotTo(HaveKey("ydb.tech/disk-S64F00"))
+			Expect(registered).To(HaveKey("ydb.tech/disk-S64F01"))
+			Expect(grouper.groups).NotTo(HaveKey("disk-S64F00"))
+
+			By("creating a new resource when the disk returns")
+			Expect(grouper.add(part)).NotTo(BeIdenticalTo(group))
+			Expect(registered).To(HaveKey("ydb.tech/disk-S64F00"))
+		})
+
+		It("keeps a group whose disk returns in time", func() {
+			part := diskPartition(disk0, "nvme0n1p1", "ydb_data_01")
+			grouper.add(part)
+			grouper.remove(part)
+			grouper.add(part)
+
+			grouper.prune(time.Now().Add(2 * time.Hour))
+			Expect(registered).To(HaveKey("ydb.tech/disk-S64F00"))
+		})
+
+		It("removes the old group of a disk whose name changed", func() {
+			part := diskPartition(disk0, "nvme0n1p1", "ydb_data_01")
+			grouper.add(part)
+			grouper.add(diskPartition(diskDevice("nvme0n1", "S64F99"), "nvme0n1p1", "ydb_data_01"))
+
+			grouper.prune(time.Now().Add(time.Hour))
+			Expect(registered).To(HaveLen(1))
+			Expect(registered).To(HaveKey("ydb.tech/disk-S64F99"))
+		})
+	})
+
+	It("skips disks whose group name clashes with another resource", func() {
+		registered["ydb.tech/disk-S64F00"] = nil
+		Expect(grouper.add(diskPartition(disk0, "nvme0n1p1", "ydb_data_01"))).To(BeNil())
+		Expect(grouper.add(diskPartition(disk0, "nvme0n1p2", "ydb_data_02"))).To(BeNil())
+	})
+})
+
+var _ = Describe("runDiskGroupScatter", func() {
+	It("reports the health of each disk group as its partitions come and go", func() {
+		registered := make(chan Resource, 2)
+		grouper := newDiskGrouper(
+			"ydb.tech",
+			template.Must(ParsePartitionTemplate("name", DefaultDiskGroupName)),
+			regexp.MustCompile(`^ydb_(.*)$`),
+			func(res Resource) error {
+				DeferCleanup(res.Close)
+				registered <- res
+				return nil
+			},
+			func(string) error { return nil },
+			nil,
+		)
+		evCh := make(chan udev.Event, 10)
+		DeferCleanup(func() { close(evCh) })
+		go runDiskGroupScatter(evCh, grouper)
+
+		disk := diskDevice("nvme0n1", "S64F00")
+		part := diskPartition(disk, "nvme0n1p1", "ydb_data_01")
+		evCh <- udev.Init{Devices: []udev.Device{disk, part, diskPartition(disk, "nvme0n1p2", "ydb_data_02")}}
+
+		var res Resource
+		Eventually(registered).Should(Receive(&res))
+		Expect(res.Name()).To(Equal("ydb.tech/disk-S64F00"))
+		watchCh := res.ListAndWatch(context.Background())
+		var instances []Instance
+		Eventually(watchCh).Should(Receive(&instances))
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+		evCh <- udev.Removed{Device: part}
+		evCh <- udev.Removed{Device: diskPartition(disk, "nvme0n1p2", "ydb_data_02")}
+		Eventually(func() Health {
+			select {
+			case instances = <-watchCh:
+			default:
+			}
+			return instances[0].Health()
+		}).Should(BeAssignableToTypeOf(Unhealthy{}))
+		Expect(registered).NotTo(Receive())
+	})
+})
@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// DiskHealth tells whether a disk is fit for new allocations, based on what
+// udev does not see, e.g. the kernel log or SMART data. Partitions and batches
+// consult it through [WithPartitionDiskHealth] and [WithBatchDiskHealth].
+type DiskHealth interface {
+	// Check returns nil if disk is fit for use, or an error saying why not.
+	Check(disk udev.Device) error
+}
+
+// diskOf returns the disk block device dev is on: the parent of a partition,
+// or dev itself.
+func diskOf(dev udev.Device) udev.Device {
+	if dev.DevType() == udev.DeviceTypePart && dev.Parent() != nil {
+		return dev.Parent()
+	}
+	return dev
+}
+
+// diskIdentity names disk across renames, replacements and restarts: by its
+// WWN, or else its serial number, or else its kernel name.
+func diskIdentity(disk udev.Device) string {
+	data := newDiskTemplateData(disk)
+	switch {
+	case data.WWN != "":
+		return data.WWN
+	case data.Serial != "":
+		return data.Serial
+	default:
+		return data.Name
+	}
+}
+
+// checkDisks returns the first error of checks for the disk dev is on.
+func checkDisks(checks []DiskHealth, dev udev.Device) error {
+	if len(checks) == 0 {
+		return nil
+	}
+	disk := diskOf(dev)
+	for _, check := range checks {
+		if err := check.Check(disk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
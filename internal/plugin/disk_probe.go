@@ -0,0 +1,239 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ydb-platform/udev-manager/internal/mux"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+)
+
+// Defaults of [DiskProbeConfig].
+const (
+	DefaultDiskProbePeriod  = 10 * time.Minute
+	DefaultDiskProbeTimeout = 30 * time.Second
+)
+
+// DiskReport is the health data of a disk read by a [DiskProber].
+type DiskReport struct {
+	CriticalWarning int    // NVMe critical warning bits; non-zero for ATA disks failing their SMART assessment
+	MediaErrors     uint64 // unrecovered media errors, or remapped and pending sectors of ATA disks
+	PercentageUsed  int    // estimated share of the endurance used, may exceed 100
+	Temperature     int    // Celsius
+}
+
+// DiskProber reads the health data of a disk, e.g. from SMART.
+type DiskProber interface {
+	Probe(ctx context.Context, disk udev.Device) (*DiskReport, error)
+}
+
+// DiskProbeConfig sets how often disks are probed and the limits that fail
+// them. A disk with a critical warning always fails; the other limits are
+// only checked if set.
+type DiskProbeConfig struct {
+	Period  time.Duration // how long a report is used, defaults to DefaultDiskProbePeriod
+	Timeout time.Duration // of one probe, defaults to DefaultDiskProbeTimeout
+
+	MediaErrors    uint64 // fail at this many media errors
+	PercentageUsed int    // fail at this share of the endurance used
+	Temperature    int    // fail at this temperature in Celsius
+}
+
+// check returns why report fails the limits of c, or nil.
+func (c DiskProbeConfig) check(report *DiskReport) error {
+	switch {
+	case report.CriticalWarning != 0:
+		return fmt.Errorf("critical warning 0x%x", report.CriticalWarning)
+	case c.MediaErrors > 0 && report.MediaErrors >= c.MediaErrors:
+		return fmt.Errorf("%d media errors, limit %d", report.MediaErrors, c.MediaErrors)
+	case c.PercentageUsed > 0 && report.PercentageUsed >= c.PercentageUsed:
+		return fmt.Errorf("%d%% of endurance used, limit %d%%", report.PercentageUsed, c.PercentageUsed)
+	case c.Temperature > 0 && report.Temperature >= c.Temperature:
+		return fmt.Errorf("temperature %d°C, limit %d°C", report.Temperature, c.Temperature)
+	default:
+		return nil
+	}
+}
+
+// probedDisk is the last probe result of a disk.
+type probedDisk struct {
+	dev     udev.Device
+	report  *DiskReport // nil until probed successfully
+	failure error       // why report fails the limits
+}
+
+// ProbedDiskHealth is a [DiskHealth] that fails disks whose last report from
+// a [DiskProber] exceeds the limits. Disks are probed in the background once
+// their health is first checked and then every period; until the first probe
+// succeeds they are taken to be fit. A probe that fails keeps the previous
+// result. Disks are forgotten once they are removed.
+type ProbedDiskHealth struct {
+	prober  DiskProber
+	config  DiskProbeConfig
+	refresh func(cause string) // reports disks that failed or recovered to kubelet
+	wake    chan struct{}
+
+	mu    sync.Mutex
+	disks map[string]*probedDisk // kernel name -> last result
+}
+
+// NewProbedDiskHealth probes disks with prober and refreshes the resources of
+// registry whenever a disk fails or recovers. Disks removed from d are
+// forgotten. The returned CancelFunc stops probing.
+func NewProbedDiskHealth(d udev.Discovery, registry *Registry, prober DiskProber, config DiskProbeConfig) (*ProbedDiskHealth, mux.CancelFunc) {
+	h := newProbedDiskHealth(prober, config, registry.Refresh)
+
+	ch := make(chan udev.Event, 1)
+	forgotten := make(chan struct{})
+	go func() {
+		defer close(forgotten)
+		for ev := range ch {
+			if removed, ok := ev.(udev.Removed); ok && removed.Device != nil {
+				h.forget(removed.Device)
+			}
+		}
+	}()
+	unsubscribe := d.Subscribe(mux.SinkFromChan(ch))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.run(ctx)
+	}()
+	return h, func() {
+		unsubscribe()
+		<-forgotten
+		cancel()
+		<-done
+	}
+}
+
+func newProbedDiskHealth(prober DiskProber, config DiskProbeConfig, refresh func(string)) *ProbedDiskHealth {
+	if config.Period <= 0 {
+		config.Period = DefaultDiskProbePeriod
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultDiskProbeTimeout
+	}
+	return &ProbedDiskHealth{
+		prober:  prober,
+		config:  config,
+		refresh: refresh,
+		wake:    make(chan struct{}, 1),
+		disks:   make(map[string]*probedDisk),
+	}
+}
+
+// run probes new disks as they are checked and all disks every period.
+func (h *ProbedDiskHealth) run(ctx context.Context) {
+	ticker := time.NewTicker(h.config.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.wake:
+			h.probe(ctx, false)
+		case <-ticker.C:
+			h.probe(ctx, true)
+		}
+	}
+}
+
+// probe probes all disks, or only those never probed successfully, and
+// refreshes if any of them failed or recovered.
+func (h *ProbedDiskHealth) probe(ctx context.Context, all bool) {
+	h.mu.Lock()
+	disks := make(map[string]udev.Device, len(h.disks))
+	for name, disk := range h.disks {
+		if all || disk.report == nil {
+			disks[name] = disk.dev
+		}
+	}
+	h.mu.Unlock()
+
+	var changed []string
+	for name, dev := range disks {
+		probeCtx, cancel := context.WithTimeout(ctx, h.config.Timeout)
+		report, err := h.prober.Probe(probeCtx, dev)
+		cancel()
+		if err != nil {
+			klog.Warningf("disk probe: failed to probe %s: %v", name, err)
+			continue
+		}
+		failure := h.config.check(report)
+		klog.V(2).Infof("disk probe: %s: %+v", name, *report)
+
+		h.mu.Lock()
+		disk, ok := h.disks[name]
+		if !ok || disk.dev.Id() != dev.Id() {
+			h.mu.Unlock() // removed while probing
+			continue
+		}
+		if (disk.failure == nil) != (failure == nil) {
+			if failure != nil {
+				klog.Errorf("disk probe: disk %s failed: %v", name, failure)
+				changed = append(changed, name+" failed")
+			} else {
+				klog.Infof("disk probe: disk %s recovered", name)
+				changed = append(changed, name+" recovered")
+			}
+		}
+		disk.report, disk.failure = report, failure
+		h.mu.Unlock()
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		h.refresh("disk probe: " + strings.Join(changed, ", "))
+	}
+}
+
+// Check fails disks whose last report exceeds the limits. Disks seen for the
+// first time are queued for probing.
+func (h *ProbedDiskHealth) Check(disk udev.Device) error {
+	name := udev.Sysname(disk)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	probed, ok := h.disks[name]
+	if !ok {
+		h.disks[name] = &probedDisk{dev: disk}
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	if probed.failure != nil {
+		return fmt.Errorf("disk %s failed its health probe: %w", name, probed.failure)
+	}
+	return nil
+}
+
+// forget drops the result of disk once it is removed, so that a disk added
+// later under the same kernel name is probed afresh.
+func (h *ProbedDiskHealth) forget(disk udev.Device) {
+	name := udev.Sysname(disk)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if probed, ok := h.disks[name]; ok && probed.dev.Id() == disk.Id() {
+		delete(h.disks, name)
+	}
+}
+
+// Report returns the last report of disk, given by its kernel name, or nil
+// if it was not probed successfully yet.
+func (h *ProbedDiskHealth) Report(disk string) *DiskReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if probed, ok := h.disks[disk]; ok {
+		return probed.report
+	}
+	return nil
+}
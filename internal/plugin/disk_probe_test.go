@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// fakeProber returns the reports set per disk and counts its probes.
+type fakeProber struct {
+	mu      sync.Mutex
+	reports map[string]*DiskReport
+	probes  int
+}
+
+func (p *fakeProber) set(disk string, report *DiskReport) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reports[disk] = report
+}
+
+func (p *fakeProber) Probe(_ context.Context, disk udev.Device) (*DiskReport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probes++
+	if report, ok := p.reports[udev.Sysname(disk)]; ok {
+		return report, nil
+	}
+	return nil, errors.New("no such disk")
+}
+
+var _ = Describe("DiskProbeConfig", func() {
+	It("always fails critical warnings and checks the limits that are set", func() {
+		Expect(DiskProbeConfig{}.check(&DiskReport{MediaErrors: 100, PercentageUsed: 200, Temperature: 90})).To(Succeed())
+		Expect(DiskProbeConfig{}.check(&DiskReport{CriticalWarning: 2})).To(MatchError("critical warning 0x2"))
+
+		config := DiskProbeConfig{MediaErrors: 1, PercentageUsed: 90, Temperature: 70}
+		Expect(config.check(&DiskReport{PercentageUsed: 89, Temperature: 69})).To(Succeed())
+		Expect(config.check(&DiskReport{MediaErrors: 1})).To(MatchError(ContainSubstring("media errors")))
+		Expect(config.check(&DiskReport{PercentageUsed: 90})).To(MatchError(ContainSubstring("endurance")))
+		Expect(config.check(&DiskReport{Temperature: 70})).To(MatchError(ContainSubstring("temperature")))
+	})
+})
+
+var _ = Describe("ProbedDiskHealth", func() {
+	var (
+		prober    *fakeProber
+		refreshes atomic.Int32
+		health    *ProbedDiskHealth
+		disk      *mockDevice
+	)
+
+	BeforeEach(func() {
+		prober = &fakeProber{reports: map[string]*DiskReport{"sdb": {}}}
+		refreshes.Store(0)
+		health = newProbedDiskHealth(prober, DiskProbeConfig{MediaErrors: 1}, func(string) { refreshes.Add(1) })
+		disk = diskDevice("sdb", "S64F01")
+	})
+
+	It("queues new disks and fails them once a probe exceeds the limits", func() {
+		Expect(health.Check(disk)).To(Succeed())
+		Expect(health.wake).To(HaveLen(1))
+
+		health.probe(context.Background(), false)
+		Expect(health.Check(disk)).To(Succeed())
+		Expect(health.Report("sdb")).To(Equal(&DiskReport{}))
+		Expect(refreshes.Load()).To(BeZero())
+
+		prober.set("sdb", &DiskReport{MediaErrors: 3})
+		health.probe(context.Background(), false)
+		Expect(health.Check(disk)).To(Succeed()) // the report is cached until the period ends
+
+		health.probe(context.Background(), true)
+		Expect(health.Check(disk)).To(MatchError(ContainSubstring("3 media errors")))
+		Expect(refreshes.Load()).To(Equal(int32(1)))
+
+		prober.set("sdb", &DiskReport{})
+		health.probe(context.Background(), true)
+		Expect(health.Check(disk)).To(Succeed())
+		Expect(refreshes.Load()).To(Equal(int32(2)))
+	})
+
+	It("keeps the last result when a probe fails", func() {
+		prober.set("sdb", &DiskReport{CriticalWarning: 1})
+		health.Check(disk)
+		health.probe(context.Background(), false)
+		Expect(health.Check(disk)).NotTo(Succeed())
+
+		prober.mu.Lock()
+		delete(prober.reports, "sdb")
+		prober.mu.Unlock()
+		health.probe(context.Background(), true)
+		Expect(health.Check(disk)).NotTo(Succeed())
+		Expect(refreshes.Load()).To(Equal(int32(1)))
+	})
+
+	It("makes the partitions of a failed disk unhealthy", func() {
+		prober.set("sdb", &DiskReport{CriticalWarning: 1})
+		part := diskPartition(disk, "sdb1", "ydb_data_01")
+		p := &partition{label: "data_01", dev: part}
+		WithPartitionDiskHealth(health)(p)
+		Expect(p.Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+		health.probe(context.Background(), false)
+		Expect(p.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+	})
+
+	It("forgets a disk once it is removed", func() {
+		prober.set("sdb", &DiskReport{CriticalWarning: 1})
+		health.Check(disk)
+		health.probe(context.Background(), false)
+		Expect(health.Check(disk)).NotTo(Succeed())
+
+		other := diskDevice("sdb", "S64F02")
+		other.id = "/sys/devices/pci0000:00/block/sdb"
+		health.forget(other)
+		Expect(health.Report("sdb")).NotTo(BeNil()) // another device of the same name
+
+		health.forget(disk)
+		Expect(health.Report("sdb")).To(BeNil())
+		Expect(health.Check(disk)).To(Succeed()) // queued again
+	})
+
+	It("probes in the background until cancelled", func() {
+		prober.set("sdb", &DiskReport{CriticalWarning: 1})
+		health.config.Period = 10 * time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			health.run(ctx)
+		}()
+
+		health.Check(disk)
+		Eventually(func() error { return health.Check(disk) }).Should(HaveOccurred())
+		Eventually(func() int {
+			prober.mu.Lock()
+			defer prober.mu.Unlock()
+			return prober.probes
+		}).Should(BeNumerically(">", 2))
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+})
@@ -0,0 +1,246 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	"github.com/ydb-platform/udev-manager/internal/mux"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// DefaultDriveInventoryPath is where a [DriveInventory] is written by default.
+const DefaultDriveInventoryPath = "/var/lib/udev-manager/drives.json"
+
+// Drive types, as in the drive section of the YDB config.
+const (
+	DriveTypeNvme = "NVME"
+	DriveTypeSsd  = "SSD"
+	DriveTypeRot  = "ROT"
+)
+
+const (
+	partLabelLinkDir = "/dev/disk/by-partlabel/"
+	idLinkDir        = "/dev/disk/by-id/"
+)
+
+// Drive is a partition backing an instance of a partition, batch or disk
+// group resource.
+type Drive struct {
+	Resource  string `json:"resource"`
+	Id        Id     `json:"id"`
+	Path      string `json:"path"` // stable by-partlabel or by-id link
+	DevNode   string `json:"devnode"`
+	Label     string `json:"label,omitempty"` // partition label
+	SizeBytes uint64 `json:"sizeBytes"`
+	Type      string `json:"type"` // DriveTypeNvme, DriveTypeSsd or DriveTypeRot
+	Serial    string `json:"serial,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Health    string `json:"health"`
+}
+
+// DriveInventoryConfig sets where a [DriveInventory] is written.
+type DriveInventoryConfig struct {
+	Path string // defaults to DefaultDriveInventoryPath
+}
+
+// DriveInventory lists the partitions of the partition, batch and disk group
+// resources of a registry with what the drive section of the YDB config of
+// the node needs: a stable path, size, type and serial. The list is kept in
+// a JSON file, rewritten atomically whenever devices or the health of
+// instances change, and served on GET as an [http.Handler].
+type DriveInventory struct {
+	config DriveInventoryConfig
+	wake   chan struct{}
+
+	mu     sync.Mutex
+	drives []Drive
+	last   []byte // content last written
+}
+
+// NewDriveInventory creates a DriveInventory; nothing is written until Watch.
+func NewDriveInventory(config DriveInventoryConfig) *DriveInventory {
+	if config.Path == "" {
+		config.Path = DefaultDriveInventoryPath
+	}
+	return &DriveInventory{config: config, wake: make(chan struct{}, 1), drives: []Drive{}}
+}
+
+// Watch writes the inventory of registry and discovery now and whenever
+// devices or the health of instances change. The returned CancelFunc stops
+// watching.
+func (i *DriveInventory) Watch(registry *Registry, discovery udev.Discovery) (mux.CancelFunc, error) {
+	if err := os.MkdirAll(filepath.Dir(i.config.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create drive inventory directory: %w", err)
+	}
+	return watchInventory(discovery, driveDevice, i.wake, func(devices map[udev.Id]udev.Device) error {
+		return i.write(registry, devices)
+	}, "drives")
+}
+
+// driveDevice filters the devices a [DriveInventory] looks at.
+func driveDevice(dev udev.Device) bool {
+	return dev.Subsystem() == udev.BlockSubsystem
+}
+
+// Drives returns the drives as last listed.
+func (i *DriveInventory) Drives() []Drive {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return slices.Clone(i.drives)
+}
+
+// ResourceAdded implements [Observer].
+func (i *DriveInventory) ResourceAdded(ResourceStatus) { i.changed() }
+
+// Registered implements [Observer].
+func (i *DriveInventory) Registered(string, error) {}
+
+// HealthChanged implements [Observer].
+func (i *DriveInventory) HealthChanged(string, Id, HealthTransition) { i.changed() }
+
+// ResourceRemoved implements [Observer].
+func (i *DriveInventory) ResourceRemoved(string) { i.changed() }
+
+func (i *DriveInventory) changed() {
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ServeHTTP lists the drives on GET as a JSON array.
+func (i *DriveInventory) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		resp.Header().Set("Allow", "GET")
+		http.Error(resp, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(i.Drives()); err != nil {
+		klog.Errorf("drives: failed to write drives: %v", err)
+	}
+}
+
+// write lists the drives and writes the file if they changed since the last
+// write.
+func (i *DriveInventory) write(registry *Registry, devices map[udev.Id]udev.Device) error {
+	drives := listDrives(registry.Status(), devices)
+	content, err := json.MarshalIndent(drives, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.drives = drives
+	if i.last != nil && bytes.Equal(content, i.last) {
+		return nil
+	}
+	if err := writeFileAtomic(i.config.Path, content, 0o644); err != nil {
+		return fmt.Errorf("failed to write drive inventory: %w", err)
+	}
+	klog.V(2).Infof("drives: wrote %d drives to %s", len(drives), i.config.Path)
+	i.last = content
+	return nil
+}
+
+// listDrives lists the partitions among devices backing the instances of
+// statuses, sorted by resource and instance. Partitions of multipath maps,
+// which are dm devices of type disk, count as partitions. Partitions shared
+// by the seats of a batch are listed once, with the first seat.
+func listDrives(statuses []ResourceStatus, devices map[udev.Id]udev.Device) []Drive {
+	drives := []Drive{}
+	for _, status := range statuses {
+		seen := make(map[udev.Id]struct{})
+		for _, instance := range status.Instances {
+			for _, id := range instance.Devices {
+				dev := devices[udev.Id(id)]
+				if dev == nil || dev.Subsystem() != udev.BlockSubsystem || (dev.DevType() != udev.DeviceTypePart && !isMapPartition(dev)) {
+					continue
+				}
+				if _, ok := seen[dev.Id()]; ok {
+					continue
+				}
+				seen[dev.Id()] = struct{}{}
+				drive := newDrive(dev)
+				drive.Resource, drive.Id, drive.Health = status.Name, instance.Id, instance.Health
+				drives = append(drives, drive)
+			}
+		}
+	}
+	slices.SortStableFunc(drives, func(a, b Drive) int {
+		if c := strings.Compare(a.Resource, b.Resource); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.Id), string(b.Id))
+	})
+	return drives
+}
+
+// newDrive describes partition dev from the attributes the allocation of
+// partitions passes to containers.
+func newDrive(dev udev.Device) Drive {
+	drive := Drive{
+		Path:    drivePath(dev),
+		DevNode: dev.DevNode(),
+		Label:   partitionName(dev),
+		Type:    driveType(dev),
+		Model:   strings.TrimSpace(dev.SystemAttributeLookup(udev.SysAttrModel)),
+	}
+	if sectors, err := strconv.ParseUint(strings.TrimSpace(dev.SystemAttribute(udev.SysAttrSize)), 10, 64); err == nil {
+		drive.SizeBytes = sectors * sectorSize
+	}
+	drive.Serial = strings.TrimSpace(dev.SystemAttributeLookup(udev.SysAttrSerial))
+	if drive.Serial == "" {
+		drive.Serial = dev.PropertyLookup(udev.PropertyShortSerial)
+	}
+	return drive
+}
+
+// partitionName returns the partition label of dev, which map partitions
+// only carry in ID_PART_ENTRY_NAME.
+func partitionName(dev udev.Device) string {
+	if name := dev.Property(udev.PropertyPartName); name != "" {
+		return name
+	}
+	return dev.Property(udev.PropertyPartEntryName)
+}
+
+// drivePath returns the by-partlabel link of partition dev, or else its
+// first by-id link, or else its device node.
+func drivePath(dev udev.Device) string {
+	links := slices.Clone(dev.DevLinks())
+	slices.Sort(links)
+	for _, dir := range []string{partLabelLinkDir, idLinkDir} {
+		for _, link := range links {
+			if strings.HasPrefix(link, dir) {
+				return link
+			}
+		}
+	}
+	return dev.DevNode()
+}
+
+// driveType returns the type of the disk partition dev is on: NVME for NVMe
+// disks, ROT for rotational disks and SSD for the others.
+func driveType(dev udev.Device) string {
+	switch {
+	case diskTransport(dev) == "nvme":
+		return DriveTypeNvme
+	case strings.TrimSpace(dev.SystemAttributeLookup(udev.SysAttrRotational)) == "1":
+		return DriveTypeRot
+	default:
+		return DriveTypeSsd
+	}
+}
@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+var _ = Describe("DriveInventory", func() {
+	var (
+		discovery *udev.FakeDiscovery
+		nvme      *udev.FakeDevice
+		hdd       *udev.FakeDevice
+	)
+
+	BeforeEach(func() {
+		discovery = udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+
+		nvmeCtrl := udev.NewFakeDevice("/sys/devices/pci0000:00/0000:00:02.0/nvme/nvme0").
+			WithSubsystem(udev.NvmeSubsystem)
+		nvmeDisk := udev.NewFakeDevice("/sys/devices/pci0000:00/0000:00:02.0/nvme/nvme0/nvme0n1").
+			WithParent(nvmeCtrl).
+			WithSubsystem(udev.BlockSubsystem).
+			WithDevType(udev.DeviceTypeDisk).
+			WithSysAttr(udev.SysAttrModel, "SAMSUNG MZQL23T8HCLS-00A07   ").
+			WithSysAttr(udev.SysAttrSerial, "S64HNE0T000001  ").
+			WithSysAttr(udev.SysAttrRotational, "0")
+		nvme = udev.NewFakeDevice("/sys/devices/pci0000:00/0000:00:02.0/nvme/nvme0/nvme0n1/nvme0n1p1").
+			WithParent(nvmeDisk).
+			WithSubsystem(udev.BlockSubsystem).
+			WithDevType(udev.DeviceTypePart).
+			WithDevNode("/dev/nvme0n1p1").
+			WithDevLinks("/dev/disk/by-id/nvme-eui.0001-part1", "/dev/disk/by-partlabel/ydb_disk_01").
+			WithProperty(udev.PropertyPartName, "ydb_disk_01").
+			WithSysAttr(udev.SysAttrSize, "2048")
+
+		hddDisk := udev.NewFakeDevice("/sys/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sda").
+			WithSubsystem(udev.BlockSubsystem).
+			WithDevType(udev.DeviceTypeDisk).
+			WithProperty(udev.PropertyBus, "ata").
+			WithProperty(udev.PropertyShortSerial, "ZL2000001").
+			WithSysAttr(udev.SysAttrRotational, "1")
+		hdd = udev.NewFakeDevice("/sys/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sda/sda1").
+			WithParent(hddDisk).
+			WithSubsystem(udev.BlockSubsystem).
+			WithDevType(udev.DeviceTypePart).
+			WithDevNode("/dev/sda1").
+			WithDevLinks("/dev/disk/by-id/ata-ST16000NM001G_ZL2000001-part1").
+			WithProperty(udev.PropertyPartName, "ydb_hdd_01").
+			WithProperty(udev.PropertyShortSerial, "ZL2000001").
+			WithSysAttr(udev.SysAttrSize, "4096")
+
+		discovery.AddDevice(nvme)
+		discovery.AddDevice(hdd)
+	})
+
+	It("describes partitions with their stable path, size, type and serial", func() {
+		statuses := []ResourceStatus{
+			{Name: "ydb.tech/part-hdd_01", Instances: []InstanceStatus{
+				{Id: "hdd_01", Health: "Healthy", Devices: []string{string(hdd.Id())}},
+			}},
+			{Name: "ydb.tech/netbw-eth0", Instances: []InstanceStatus{
+				{Id: "0", Health: "Healthy", Devices: []string{"/sys/devices/virtual/net/eth0"}},
+			}},
+			{Name: "ydb.tech/batch-disks", Instances: []InstanceStatus{
+				{Id: "0", Health: "Healthy", Devices: []string{string(nvme.Id()), string(hdd.Id())}},
+				{Id: "1", Health: "Healthy", Devices: []string{string(hdd.Id()), string(nvme.Id())}},
+			}},
+		}
+		Expect(listDrives(statuses, discovery.State(driveDevice))).To(Equal([]Drive{
+			{
+				Resource: "ydb.tech/batch-disks", Id: "0",
+				Path: "/dev/disk/by-partlabel/ydb_disk_01", DevNode: "/dev/nvme0n1p1", Label: "ydb_disk_01",
+				SizeBytes: 2048 * 512, Type: DriveTypeNvme, Serial: "S64HNE0T000001", Model: "SAMSUNG MZQL23T8HCLS-00A07",
+				Health: "Healthy",
+			},
+			{
+				Resource: "ydb.tech/batch-disks", Id: "0",
+				Path: "/dev/disk/by-id/ata-ST16000NM001G_ZL2000001-part1", DevNode: "/dev/sda1", Label: "ydb_hdd_01",
+				SizeBytes: 4096 * 512, Type: DriveTypeRot, Serial: "ZL2000001",
+				Health: "Healthy",
+			},
+			{
+				Resource: "ydb.tech/part-hdd_01", Id: "hdd_01",
+				Path: "/dev/disk/by-id/ata-ST16000NM001G_ZL2000001-part1", DevNode: "/dev/sda1", Label: "ydb_hdd_01",
+				SizeBytes: 4096 * 512, Type: DriveTypeRot, Serial: "ZL2000001",
+				Health: "Healthy",
+			},
+		}))
+	})
+
+	It("describes partitions of multipath maps", func() {
+		mpath := udev.NewFakeDevice("/sys/devices/virtual/block/dm-5").
+			WithSubsystem(udev.BlockSubsystem).
+			WithDevType(udev.DeviceTypeDisk).
+			WithDevNode("/dev/dm-5").
+			WithDevLinks("/dev/mapper/mpatha1", "/dev/disk/by-id/dm-uuid-part1-mpath-3600a098038314c6f").
+			WithProperty(udev.PropertyDmUUID, "part1-mpath-3600a098038314c6f").
+			WithProperty(udev.PropertyPartEntryName, "ydb_san_01").
+			WithSysAttr(udev.SysAttrSize, "8192").
+			WithSysAttr(udev.SysAttrRotational, "0")
+		plain := udev.NewFakeDevice("/sys/devices/virtual/block/dm-0").
+			WithSubsystem(udev.BlockSubsystem).
+			WithDevType(udev.DeviceTypeDisk).
+			WithProperty(udev.PropertyDmUUID, "LVM-abcdef")
+		discovery.AddDevice(mpath)
+		discovery.AddDevice(plain)
+
+		statuses := []ResourceStatus{
+			{Name: "ydb.tech/batch-san", Instances: []InstanceStatus{
+				{Id: "0", Health: "Healthy", Devices: []string{string(mpath.Id()), string(plain.Id())}},
+			}},
+		}
+		Expect(listDrives(statuses, discovery.State(driveDevice))).To(Equal([]Drive{{
+			Resource: "ydb.tech/batch-san", Id: "0",
+			Path: "/dev/disk/by-id/dm-uuid-part1-mpath-3600a098038314c6f", DevNode: "/dev/dm-5", Label: "ydb_san_01",
+			SizeBytes: 8192 * 512, Type: DriveTypeSsd,
+			Health: "Healthy",
+		}}))
+	})
+
+	It("types non-rotational disks off NVMe as SSD", func() {
+		disk := udev.NewFakeDevice("/sys/block/sdb").
+			WithSubsystem(udev.BlockSubsystem).
+			WithDevType(udev.DeviceTypeDisk).
+			WithProperty(udev.PropertyBus, "ata").
+			WithSysAttr(udev.SysAttrRotational, "0")
+		part := udev.NewFakeDevice("/sys/block/sdb/sdb1").
+			WithParent(disk).
+			WithSubsystem(udev.BlockSubsystem).
+			WithDevType(udev.DeviceTypePart).
+			WithDevNode("/dev/sdb1")
+		Expect(newDrive(part)).To(Equal(Drive{Path: "/dev/sdb1", DevNode: "/dev/sdb1", Type: DriveTypeSsd}))
+	})
+
+	It("rewrites the file and serves the drives as resources change", func() {
+		dir := GinkgoT().TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+
+		inventory := NewDriveInventory(DriveInventoryConfig{Path: filepath.Join(dir, "state", "drives.json")})
+		registry, err := NewRegistry(ctx, wg,
+			WithPluginDir(dir+"/"),
+			WithKubeletSocket(filepath.Join(dir, "kubelet.sock")),
+			WithObserver(inventory),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		stop, err := inventory.Watch(registry, discovery)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(stop)
+
+		drives := func() []Drive {
+			data, err := os.ReadFile(filepath.Join(dir, "state", "drives.json"))
+			Expect(err).NotTo(HaveOccurred())
+			var drives []Drive
+			Expect(json.Unmarshal(data, &drives)).To(Succeed())
+			return drives
+		}
+		Expect(drives()).To(BeEmpty())
+
+		part := &partition{label: "disk_01", domain: "ydb.tech", dev: nvme}
+		res := newResource(ResourceTemplate{Domain: "ydb.tech", Prefix: "part-disk_01"}, map[Id]Instance{part.Id(): part})
+		DeferCleanup(res.Close)
+		_ = registry.Add(res) // no kubelet
+		Eventually(drives).Should(ConsistOf(And(
+			HaveField("Resource", "ydb.tech/part-disk_01"),
+			HaveField("Path", "/dev/disk/by-partlabel/ydb_disk_01"),
+			HaveField("Health", "Healthy"),
+		)))
+
+		Expect(res.Submit(HealthEvent{Instances: []Instance{part}, Health: Unhealthy{Reason: "udev: removed"}})).To(Succeed())
+		Eventually(drives).Should(ConsistOf(HaveField("Health", "Unhealthy")))
+
+		rec := httptest.NewRecorder()
+		inventory.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/disks/inventory", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var served []Drive
+		Expect(json.Unmarshal(rec.Body.Bytes(), &served)).To(Succeed())
+		Expect(served).To(Equal(drives()))
+
+		rec = httptest.NewRecorder()
+		inventory.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/disks/inventory", nil))
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
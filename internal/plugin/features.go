@@ -0,0 +1,365 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"k8s.io/klog/v2"
+
+	"github.com/ydb-platform/udev-manager/internal/mux"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// Defaults of [FeatureFileConfig].
+const (
+	DefaultFeatureDir   = "/etc/kubernetes/node-feature-discovery/features.d"
+	DefaultFeatureFile  = "udev-manager"
+	DefaultFeatureLabel = "udev-manager-{{ .Kind }}{{ with .Name }}.{{ . }}{{ end }}"
+)
+
+// Kinds of the features of a [FeatureFile].
+const (
+	featureResource  = "resource"   // healthy instances of a resource
+	featureDisks     = "disks"      // physical disks
+	featureDiskModel = "disk-model" // physical disks of a model
+	featureNicSpeed  = "nic-speed"  // link speed of a physical NIC in Mbps
+	featureRdma      = "rdma"       // "true" if there are RDMA devices
+)
+
+var (
+	// labelNameRegex is the name part of a label, after the optional prefix.
+	labelNameRegex = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	// labelPrefixRegex is the DNS subdomain a label may be prefixed with.
+	labelPrefixRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	// invalidLabelChars are replaced in the names of features.
+	invalidLabelChars = regexp.MustCompile(`[^-A-Za-z0-9_.]+`)
+)
+
+// FeatureLabelData is what the label template of a [FeatureFile] is evaluated
+// against, e.g. "ydb.tech/{{ .Kind }}{{ with .Name }}.{{ . }}{{ end }}".
+type FeatureLabelData struct {
+	Kind string // "resource", "disks", "disk-model", "nic-speed" or "rdma"
+	Name string // resource name, disk model or interface, empty for totals; valid in label names
+}
+
+// sampleFeatureLabel is the data label templates are validated with.
+var sampleFeatureLabel = FeatureLabelData{Kind: featureDiskModel, Name: "SAMSUNG_MZQL23T8HCLS-00A07"}
+
+// ParseFeatureLabel parses text as the label template of a [FeatureFile] and
+// renders it against a sample feature, so that mistakes surface when the
+// config is loaded.
+func ParseFeatureLabel(text string) (*template.Template, error) {
+	tmpl, err := template.New("label").Funcs(partitionTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := renderFeatureLabel(tmpl, sampleFeatureLabel); err != nil {
+		return nil, fmt.Errorf("template fails on a sample feature: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderFeatureLabel renders the label of a feature and checks that it is a
+// valid label name.
+func renderFeatureLabel(tmpl *template.Template, data FeatureLabelData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	label := sb.String()
+	prefix, name, ok := strings.Cut(label, "/")
+	if !ok {
+		prefix, name = "", label
+	} else if len(prefix) > 253 || !labelPrefixRegex.MatchString(prefix) {
+		return "", fmt.Errorf("label %q: prefix must be a DNS subdomain", label)
+	}
+	if len(name) > 63 || !labelNameRegex.MatchString(name) {
+		return "", fmt.Errorf("label %q: name must be at most 63 alphanumerics, '-', '_' or '.'", label)
+	}
+	return label, nil
+}
+
+// featureName makes s usable in a label name, e.g. "ydb.tech/part-disk01"
+// becomes "ydb.tech_part-disk01".
+func featureName(s string) string {
+	return strings.Trim(invalidLabelChars.ReplaceAllString(strings.TrimSpace(s), "_"), "-_.")
+}
+
+// FeatureFileConfig sets where a [FeatureFile] is written and how its labels
+// are named.
+type FeatureFileConfig struct {
+	Dir   string             // the features.d directory of NFD, defaults to DefaultFeatureDir
+	File  string             // name of the file in Dir, defaults to DefaultFeatureFile
+	Label *template.Template // label of a feature, see FeatureLabelData; defaults to DefaultFeatureLabel
+
+	SysfsRoot string // where the physfn links of SR-IOV VFs are read, defaults to DefaultSysfsRoot
+}
+
+// feature is a label of a [FeatureFile] before its name is rendered.
+type feature struct {
+	FeatureLabelData
+	Value string
+}
+
+// FeatureFile publishes the device inventory of a node as a local source
+// feature file of Node Feature Discovery, which turns its lines into node
+// labels: the healthy instances of every resource, the number of physical
+// disks in total and by model, the link speed of every physical NIC and
+// whether there are RDMA devices. As an [Observer] of the registry it
+// rewrites the file atomically whenever the inventory changes.
+//
+// The file is left in place on shutdown, so that labels do not flap while
+// udev-manager restarts.
+type FeatureFile struct {
+	config FeatureFileConfig
+	wake   chan struct{}
+
+	mu   sync.Mutex
+	last []byte // content last written
+}
+
+// NewFeatureFile creates a FeatureFile; nothing is written until Watch.
+func NewFeatureFile(config FeatureFileConfig) (*FeatureFile, error) {
+	if config.Dir == "" {
+		config.Dir = DefaultFeatureDir
+	}
+	if config.File == "" {
+		config.File = DefaultFeatureFile
+	}
+	if config.SysfsRoot == "" {
+		config.SysfsRoot = DefaultSysfsRoot
+	}
+	if config.Label == nil {
+		label, err := ParseFeatureLabel(DefaultFeatureLabel)
+		if err != nil {
+			return nil, err
+		}
+		config.Label = label
+	}
+	return &FeatureFile{config: config, wake: make(chan struct{}, 1)}, nil
+}
+
+// Path returns the path of the feature file.
+func (f *FeatureFile) Path() string {
+	return filepath.Join(f.config.Dir, f.config.File)
+}
+
+// Watch writes the inventory of registry and discovery now and whenever
+// devices or the health of instances change. The returned CancelFunc stops
+// watching.
+func (f *FeatureFile) Watch(registry *Registry, discovery udev.Discovery) (mux.CancelFunc, error) {
+	if err := os.MkdirAll(f.config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create feature directory: %w", err)
+	}
+	return watchInventory(discovery, featureDevice, f.wake, func(devices map[udev.Id]udev.Device) error {
+		return f.write(registry, devices)
+	}, "features")
+}
+
+// featureDevice filters the devices a [FeatureFile] looks at.
+func featureDevice(dev udev.Device) bool {
+	switch dev.Subsystem() {
+	case udev.BlockSubsystem, udev.NetSubsystem, udev.InfinibandSubsystem:
+		return true
+	}
+	return false
+}
+
+// watchInventory follows the devices of discovery that match filter and
+// calls write with them once the current devices are known, then on every
+// event and wake-up until the returned CancelFunc is called. The devices are
+// kept from the events rather than asked of discovery: write runs on a
+// subscriber of discovery, and a discovery serving State from the goroutine
+// that delivers events would wait for it. An error of the first write is
+// returned, later ones are logged with name.
+func watchInventory(
+	discovery udev.Discovery,
+	filter mux.FilterFunc[udev.Device],
+	wake <-chan struct{},
+	write func(map[udev.Id]udev.Device) error,
+	name string,
+) (mux.CancelFunc, error) {
+	devices := make(map[udev.Id]udev.Device)
+	apply := func(ev udev.Event) {
+		switch e := ev.(type) {
+		case udev.Init:
+			for _, dev := range e.Devices {
+				if filter(dev) {
+					devices[dev.Id()] = dev
+				}
+			}
+		case udev.Added:
+			if filter(e.Device) {
+				devices[e.Id()] = e.Device
+			}
+		case udev.Removed:
+			delete(devices, e.Id())
+		}
+	}
+
+	events := make(chan udev.Event, 1)
+	cancel := discovery.Subscribe(mux.SinkFromChan(events))
+	// Init is delivered before Subscribe returns.
+	if ev, ok := <-events; ok {
+		apply(ev)
+	}
+	if err := write(devices); err != nil {
+		go func() {
+			for range events {
+			}
+		}()
+		cancel()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				apply(ev)
+			case <-wake:
+			}
+			if err := write(devices); err != nil {
+				klog.Errorf("%s: %v", name, err)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+// ResourceAdded implements [Observer].
+func (f *FeatureFile) ResourceAdded(ResourceStatus) { f.changed() }
+
+// Registered implements [Observer].
+func (f *FeatureFile) Registered(string, error) {}
+
+// HealthChanged implements [Observer].
+func (f *FeatureFile) HealthChanged(string, Id, HealthTransition) { f.changed() }
+
+// ResourceRemoved implements [Observer].
+func (f *FeatureFile) ResourceRemoved(string) { f.changed() }
+
+func (f *FeatureFile) changed() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// write writes the feature file if the inventory changed since the last
+// write.
+func (f *FeatureFile) write(registry *Registry, devices map[udev.Id]udev.Device) error {
+	content := f.render(inventory(registry.Status(), devices, sysfs{root: f.config.SysfsRoot}))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.last != nil && bytes.Equal(content, f.last) {
+		return nil
+	}
+	if err := writeFileAtomic(f.Path(), content, 0o644); err != nil {
+		return fmt.Errorf("failed to write feature file: %w", err)
+	}
+	klog.V(2).Infof("features: wrote %s:\n%s", f.Path(), content)
+	f.last = content
+	return nil
+}
+
+// render formats features as "label=value" lines sorted by label, leaving
+// out features whose labels fail to render.
+func (f *FeatureFile) render(features []feature) []byte {
+	lines := make([]string, 0, len(features))
+	for _, feature := range features {
+		label, err := renderFeatureLabel(f.config.Label, feature.FeatureLabelData)
+		if err != nil {
+			klog.Errorf("features: skipping %s %q: %v", feature.Kind, feature.Name, err)
+			continue
+		}
+		lines = append(lines, label+"="+feature.Value)
+	}
+	slices.Sort(lines)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Written by udev-manager, do not edit.\n")
+	for _, line := range lines {
+		buf.WriteString(line + "\n")
+	}
+	return buf.Bytes()
+}
+
+// inventory lists the features of the resources of statuses and of devices.
+// Disks and NICs count as physical if they have a parent device; loop, dm
+// and md devices, bonds and other virtual interfaces do not, and neither do
+// the netdevs of SR-IOV VFs, whose PCI devices have a physfn link in sys.
+func inventory(statuses []ResourceStatus, devices map[udev.Id]udev.Device, sys sysfs) []feature {
+	var features []feature
+	for _, status := range statuses {
+		healthy := 0
+		for _, instance := range status.Instances {
+			if instance.Health == (Healthy{}).String() {
+				healthy++
+			}
+		}
+		features = append(features, feature{
+			FeatureLabelData: FeatureLabelData{Kind: featureResource, Name: featureName(status.Name)},
+			Value:            strconv.Itoa(healthy),
+		})
+	}
+
+	disks, models, rdma := 0, make(map[string]int), false
+	for _, dev := range devices {
+		switch {
+		case dev.Subsystem() == udev.BlockSubsystem && dev.DevType() == udev.DeviceTypeDisk && dev.Parent() != nil:
+			disks++
+			model := dev.SystemAttributeLookup(udev.SysAttrModel)
+			if strings.TrimSpace(model) == "" {
+				model = dev.PropertyLookup(udev.PropertyModel)
+			}
+			if name := featureName(model); name != "" {
+				models[name]++
+			}
+		case dev.Subsystem() == udev.NetSubsystem && dev.Parent() != nil && !isVirtualFunction(dev.Parent(), sys):
+			speed, err := strconv.Atoi(strings.TrimSpace(dev.SystemAttributeLookup(udev.SysAttrSpeed)))
+			if err != nil || speed <= 0 {
+				continue
+			}
+			features = append(features, feature{
+				FeatureLabelData: FeatureLabelData{Kind: featureNicSpeed, Name: featureName(udev.Sysname(dev))},
+				Value:            strconv.Itoa(speed),
+			})
+		case dev.Subsystem() == udev.InfinibandSubsystem:
+			rdma = true
+		}
+	}
+	features = append(features, feature{FeatureLabelData: FeatureLabelData{Kind: featureDisks}, Value: strconv.Itoa(disks)})
+	for model, count := range models {
+		features = append(features, feature{
+			FeatureLabelData: FeatureLabelData{Kind: featureDiskModel, Name: model},
+			Value:            strconv.Itoa(count),
+		})
+	}
+	if rdma {
+		features = append(features, feature{FeatureLabelData: FeatureLabelData{Kind: featureRdma}, Value: "true"})
+	}
+	return features
+}
+
+// isVirtualFunction reports whether dev is the PCI device of an SR-IOV VF.
+func isVirtualFunction(dev udev.Device, sys sysfs) bool {
+	return dev.Subsystem() == udev.PCISubsystem && sys.exists(filepath.Join("bus/pci/devices", udev.Sysname(dev), "physfn"))
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+var _ = Describe("ParseFeatureLabel", func() {
+	DescribeTable("renders labels",
+		func(text string, data FeatureLabelData, expected string) {
+			tmpl, err := ParseFeatureLabel(text)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(renderFeatureLabel(tmpl, data)).To(Equal(expected))
+		},
+		Entry("default total", DefaultFeatureLabel, FeatureLabelData{Kind: "disks"}, "udev-manager-disks"),
+		Entry("default named", DefaultFeatureLabel, FeatureLabelData{Kind: "nic-speed", Name: "eth0"}, "udev-manager-nic-speed.eth0"),
+		Entry("prefixed", "ydb.tech/{{ .Kind }}{{ with .Name }}-{{ lower . }}{{ end }}", FeatureLabelData{Kind: "disk-model", Name: "MZQL2960HCJR"}, "ydb.tech/disk-model-mzql2960hcjr"),
+	)
+
+	DescribeTable("rejects templates that fail on a sample feature",
+		func(text, message string) {
+			_, err := ParseFeatureLabel(text)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("unknown field", "{{ .Label }}", "can't evaluate field Label"),
+		Entry("spaces", "{{ .Kind }} {{ .Name }}", "name must be"),
+		Entry("uppercase prefix", "YDB.tech/{{ .Kind }}", "prefix must be a DNS subdomain"),
+	)
+
+	It("makes names of features valid in labels", func() {
+		Expect(featureName("ydb.tech/part-disk01")).To(Equal("ydb.tech_part-disk01"))
+		Expect(featureName(" SAMSUNG MZQL2960HCJR    ")).To(Equal("SAMSUNG_MZQL2960HCJR"))
+	})
+})
+
+var _ = Describe("FeatureFile", func() {
+	var (
+		dir       string
+		discovery *udev.FakeDiscovery
+		registry  *Registry
+		features  *FeatureFile
+		part      *partition
+	)
+
+	content := func() string {
+		data, err := os.ReadFile(filepath.Join(dir, "features.d", DefaultFeatureFile))
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		discovery = udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+
+		disk := diskDevice("nvme0n1", "S1")
+		disk.parent = pciDevice("0000:01:00.0", "0")
+		other := diskDevice("nvme1n1", "S2")
+		other.parent = pciDevice("0000:02:00.0", "0")
+		nic := netDevice("eth0", "25000", "up")
+		nic.parent = pciDevice("0000:03:00.0", "0")
+		discovery.AddDevice(disk)
+		discovery.AddDevice(other)
+		discovery.AddDevice(diskDevice("loop0", ""))
+		discovery.AddDevice(nic)
+		discovery.AddDevice(netDevice("bond0", "50000", "up"))
+		discovery.AddDevice(netDevice("eth1", "-1", "down"))
+
+		// eth2 is a VF of the PF of eth0
+		sysfsRoot := GinkgoT().TempDir()
+		vfDir := filepath.Join(sysfsRoot, "bus/pci/devices/0000:03:00.2")
+		Expect(os.MkdirAll(vfDir, 0o755)).To(Succeed())
+		Expect(os.Symlink("../0000:03:00.0", filepath.Join(vfDir, "physfn"))).To(Succeed())
+		vf := netDevice("eth2", "25000", "up")
+		vf.parent = pciDevice("0000:03:00.2", "0")
+		discovery.AddDevice(vf)
+
+		var err error
+		features, err = NewFeatureFile(FeatureFileConfig{Dir: filepath.Join(dir, "features.d"), SysfsRoot: sysfsRoot})
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+		registry, err = NewRegistry(ctx, wg,
+			WithPluginDir(dir+"/"),
+			WithKubeletSocket(filepath.Join(dir, "kubelet.sock")),
+			WithObserver(features),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		part = &partition{label: "disk01", domain: "ydb.tech", dev: diskPartition(disk, "nvme0n1p1", "disk01")}
+		stop, err := features.Watch(registry, discovery)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(stop)
+	})
+
+	It("lists physical disks by model and NICs but not VFs by speed", func() {
+		Expect(content()).To(Equal(`# Written by udev-manager, do not edit.
+udev-manager-disk-model.SAMSUNG_MZQL2960HCJR=2
+udev-manager-disks=2
+udev-manager-nic-speed.eth0=25000
+`))
+	})
+
+	It("follows devices and the health of resources", func() {
+		res := newResource(ResourceTemplate{Domain: "ydb.tech", Prefix: "part-disk01"}, map[Id]Instance{part.Id(): part})
+		DeferCleanup(res.Close)
+		_ = registry.Add(res) // no kubelet
+		Eventually(content).Should(ContainSubstring("udev-manager-resource.ydb.tech_part-disk01=1\n"))
+
+		Expect(res.Submit(HealthEvent{Instances: []Instance{part}, Health: Unhealthy{Reason: "udev: removed"}})).To(Succeed())
+		Eventually(content).Should(ContainSubstring("udev-manager-resource.ydb.tech_part-disk01=0\n"))
+
+		discovery.Emit(udev.Added{Device: infinibandDevice("mlx5_0")})
+		Eventually(content).Should(ContainSubstring("udev-manager-rdma=true\n"))
+		discovery.Emit(udev.Removed{Device: discovery.DeviceById("/sys/devices/virtual/block/nvme1n1")})
+		Eventually(content).Should(ContainSubstring("udev-manager-disks=1\n"))
+	})
+})
+
+var _ = Describe("FeatureFile on a discovery serving State from its event loop", func() {
+	It("does not stall bursts of events", func() {
+		dir := GinkgoT().TempDir()
+		discovery := newRoundTripDiscovery()
+		DeferCleanup(discovery.Close)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+		features, err := NewFeatureFile(FeatureFileConfig{Dir: dir})
+		Expect(err).NotTo(HaveOccurred())
+		registry, err := NewRegistry(ctx, wg,
+			WithPluginDir(dir+"/"),
+			WithKubeletSocket(filepath.Join(dir, "kubelet.sock")),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		stop, err := features.Watch(registry, discovery)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(stop)
+		added, stopCounting := countAdded(discovery)
+		DeferCleanup(stopCounting)
+
+		const burst = 32
+		start := time.Now()
+		for i := range burst {
+			disk := diskDevice(fmt.Sprintf("nvme%dn1", i), strconv.Itoa(i))
+			disk.parent = pciDevice(fmt.Sprintf("0000:%02x:00.0", i+1), "0")
+			discovery.Emit(udev.Added{Device: disk})
+		}
+		Eventually(added).Should(Equal(burst))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Eventually(func() (string, error) {
+			data, err := os.ReadFile(features.Path())
+			return string(data), err
+		}).Should(ContainSubstring(fmt.Sprintf("udev-manager-disks=%d\n", burst)))
+	})
+})
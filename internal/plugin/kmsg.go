@@ -0,0 +1,330 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ydb-platform/udev-manager/internal/mux"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+)
+
+// Defaults of [KmsgConfig].
+const (
+	DefaultKmsgPath      = "/dev/kmsg"
+	DefaultKmsgThreshold = 1
+	DefaultKmsgWindow    = 10 * time.Minute
+)
+
+// kmsgErrorRegex matches the block-layer error lines of the kernel log and
+// captures the kernel name of the device, e.g.
+//
+//	blk_update_request: I/O error, dev nvme0n1, sector 2048 op 0x1:(WRITE) ...
+//	critical medium error, dev sdb, sector 1234 op 0x0:(READ) ...
+//	Buffer I/O error on dev sda1, logical block 0, async page read
+var kmsgErrorRegex = regexp.MustCompile(`\b(?:I/O|critical medium|critical target|critical nexus|timeout) error,? (?:on )?dev ([^\s,]+)`)
+
+// KmsgConfig describes when kernel I/O errors fail a disk.
+type KmsgConfig struct {
+	Path      string        // kernel log to follow, defaults to DefaultKmsgPath
+	Threshold int           // errors within Window that fail a disk, defaults to DefaultKmsgThreshold
+	Window    time.Duration // defaults to DefaultKmsgWindow
+	StateFile string        // failed disks, kept across restarts; kept in memory only if empty
+}
+
+// KmsgFailure is a disk failed on kernel I/O errors.
+type KmsgFailure struct {
+	Disk  string    `json:"disk"` // kernel name when it failed
+	Line  string    `json:"line"` // the error line that failed it
+	Since time.Time `json:"since"`
+}
+
+// kmsgState is the content of the state file.
+type kmsgState struct {
+	Failed map[string]KmsgFailure `json:"failed"`
+}
+
+// KmsgWatcher follows the kernel log and fails disks that report at least
+// Threshold block-layer I/O errors within Window. It is a [DiskHealth]: the
+// partitions and batches on a failed disk are Unhealthy until the disk is
+// cleared with [KmsgWatcher.Clear]. Disks are known by their WWN or serial
+// number (see diskIdentity), so a failed disk stays failed when it is
+// renamed or the node restarts, while a replacement disk is not failed.
+type KmsgWatcher struct {
+	config    KmsgConfig
+	discovery udev.Discovery
+	refresh   func(cause string) // reports a failed or cleared disk to kubelet
+	now       func() time.Time
+
+	mu     sync.Mutex
+	errors map[string][]time.Time // disk identity -> times of its errors within the window
+	failed map[string]KmsgFailure // disk identity -> failure
+}
+
+// NewKmsgWatcher follows the kernel log at config.Path and looks up the
+// devices it names in d. Failing and clearing a disk refreshes the resources
+// of registry. The kernel log device is followed from its end, so errors
+// logged before the start are not counted; regular files and pipes are read
+// from the start. Disks failed before a restart are loaded from
+// config.StateFile; a missing state file holds none. The returned CancelFunc
+// stops following.
+func NewKmsgWatcher(d udev.Discovery, registry *Registry, config KmsgConfig) (*KmsgWatcher, mux.CancelFunc, error) {
+	w := newKmsgWatcher(d, config, registry.Refresh)
+	if err := w.load(); err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(w.config.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open kernel log: %w", err)
+	}
+	if info, err := file.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			klog.Warningf("kmsg: failed to skip the kernel log in %s: %v", w.config.Path, err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.follow(file)
+	}()
+	return w, func() {
+		if err := file.Close(); err != nil {
+			klog.Errorf("kmsg: failed to close %s: %v", w.config.Path, err)
+		}
+		<-done
+	}, nil
+}
+
+func newKmsgWatcher(d udev.Discovery, config KmsgConfig, refresh func(string)) *KmsgWatcher {
+	if config.Path == "" {
+		config.Path = DefaultKmsgPath
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultKmsgThreshold
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultKmsgWindow
+	}
+	return &KmsgWatcher{
+		config:    config,
+		discovery: d,
+		refresh:   refresh,
+		now:       time.Now,
+		errors:    make(map[string][]time.Time),
+		failed:    make(map[string]KmsgFailure),
+	}
+}
+
+// load reads the failed disks of the state file.
+func (w *KmsgWatcher) load() error {
+	if w.config.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(w.config.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read kmsg state: %w", err)
+	}
+	var state kmsgState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse kmsg state %q: %w", w.config.StateFile, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, failure := range state.Failed {
+		w.failed[id] = failure
+		klog.Infof("kmsg: disk %s (%s) is failed since %s: %s", id, failure.Disk, failure.Since.Format(time.RFC3339), failure.Line)
+	}
+	return nil
+}
+
+// saveLocked writes the failed disks to the state file.
+func (w *KmsgWatcher) saveLocked() error {
+	if w.config.StateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(kmsgState{Failed: w.failed}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.config.StateFile), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return writeFileAtomic(w.config.StateFile, data, 0o644)
+}
+
+// follow feeds the lines of r to observe until r ends or fails. Reading
+// /dev/kmsg fails with EPIPE when records were overwritten before they were
+// read, which only loses those records.
+func (w *KmsgWatcher) follow(r io.Reader) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			w.observe(strings.TrimSpace(line))
+		}
+		if errors.Is(err, syscall.EPIPE) {
+			klog.Warningf("kmsg: kernel log records were lost")
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) {
+				klog.Errorf("kmsg: failed to read %s: %v", w.config.Path, err)
+			}
+			return
+		}
+	}
+}
+
+// observe counts line against the disk it names, if it is a block-layer
+// error line.
+func (w *KmsgWatcher) observe(line string) {
+	match := kmsgErrorRegex.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	disk, id := w.disk(match[1])
+	klog.Warningf("kmsg: I/O error on disk %s: %s", disk, line)
+
+	w.mu.Lock()
+	now := w.now()
+	times := append(w.errors[id], now)
+	for len(times) > 0 && now.Sub(times[0]) > w.config.Window {
+		times = times[1:]
+	}
+	w.errors[id] = times
+	_, failed := w.failed[id]
+	fail := !failed && len(times) >= w.config.Threshold
+	var err error
+	if fail {
+		w.failed[id] = KmsgFailure{Disk: disk, Line: line, Since: now}
+		err = w.saveLocked()
+	}
+	w.mu.Unlock()
+
+	if err != nil {
+		klog.Errorf("kmsg: failed to save failed disks: %v", err)
+	}
+	if fail {
+		klog.Errorf("kmsg: disk %s (%s) failed with %d I/O errors within %s", disk, id, len(times), w.config.Window)
+		w.refresh("kmsg: disk " + disk + " failed")
+	}
+}
+
+// disk returns the kernel name and identity of the disk that block device
+// name is on, e.g. "sda" for "sda1". Devices unknown to the discovery are
+// taken to be disks and known by their name.
+func (w *KmsgWatcher) disk(name string) (disk, id string) {
+	if w.discovery == nil {
+		return name, name
+	}
+	for _, dev := range w.discovery.State(func(dev udev.Device) bool {
+		return dev.Subsystem() == udev.BlockSubsystem && udev.Sysname(dev) == name
+	}) {
+		disk := diskOf(dev)
+		return udev.Sysname(disk), diskIdentity(disk)
+	}
+	return name, name
+}
+
+// Check fails for disks that crossed the threshold and were not cleared.
+func (w *KmsgWatcher) Check(disk udev.Device) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if failure, ok := w.failed[diskIdentity(disk)]; ok {
+		return fmt.Errorf("disk %s failed on kernel I/O errors: %s", udev.Sysname(disk), failure.Line)
+	}
+	return nil
+}
+
+// Clear forgets the errors of disk, given by its identity (WWN, serial
+// number) or the kernel name it failed with, and reports whether it was
+// failed.
+func (w *KmsgWatcher) Clear(disk string) bool {
+	w.mu.Lock()
+	var cleared []string
+	for id, failure := range w.failed {
+		if id == disk || failure.Disk == disk {
+			cleared = append(cleared, id)
+			delete(w.failed, id)
+			delete(w.errors, id)
+		}
+	}
+	delete(w.errors, disk)
+	var err error
+	if len(cleared) > 0 {
+		err = w.saveLocked()
+	}
+	w.mu.Unlock()
+
+	if err != nil {
+		klog.Errorf("kmsg: failed to save failed disks: %v", err)
+	}
+	if len(cleared) > 0 {
+		klog.Infof("kmsg: disk %s cleared", disk)
+		w.refresh("kmsg: disk " + disk + " cleared")
+	}
+	return len(cleared) > 0
+}
+
+// Failed returns the kernel names of the failed disks, sorted.
+func (w *KmsgWatcher) Failed() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	disks := make([]string, 0, len(w.failed))
+	for _, failure := range w.failed {
+		disks = append(disks, failure.Disk)
+	}
+	sort.Strings(disks)
+	return disks
+}
+
+// ServeHTTP lists the failed disks on GET as a JSON object of disk
+// identities and their failures, and clears the disk given by the disk query
+// parameter on DELETE.
+func (w *KmsgWatcher) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.mu.Lock()
+		failed := make(map[string]KmsgFailure, len(w.failed))
+		for id, failure := range w.failed {
+			failed[id] = failure
+		}
+		w.mu.Unlock()
+		resp.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(resp).Encode(failed); err != nil {
+			klog.Errorf("kmsg: failed to write failed disks: %v", err)
+		}
+	case http.MethodDelete:
+		disk := req.URL.Query().Get("disk")
+		if disk == "" {
+			http.Error(resp, "disk must be set", http.StatusBadRequest)
+			return
+		}
+		if !w.Clear(disk) {
+			http.Error(resp, fmt.Sprintf("disk %q is not failed", disk), http.StatusNotFound)
+			return
+		}
+		resp.WriteHeader(http.StatusNoContent)
+	default:
+		resp.Header().Set("Allow", "GET, DELETE")
+		http.Error(resp, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
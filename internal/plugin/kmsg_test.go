@@ -0,0 +1,225 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+var _ = Describe("kmsgErrorRegex", func() {
+	DescribeTable("captures the device of block-layer error lines",
+		func(line, dev string) {
+			match := kmsgErrorRegex.FindStringSubmatch(line)
+			if dev == "" {
+				Expect(match).To(BeNil())
+				return
+			}
+			Expect(match).To(HaveLen(2))
+			Expect(match[1]).To(Equal(dev))
+		},
+		Entry("kmsg record", "3,1452,79230433,-;blk_update_request: I/O error, dev nvme0n1, sector 2048 op 0x1:(WRITE) flags 0x800 phys_seg 1 prio class 0", "nvme0n1"),
+		Entry("newer kernels", "I/O error, dev sdb, sector 12345 op 0x0:(READ) flags 0x80700 phys_seg 1 prio class 2", "sdb"),
+		Entry("medium error", "critical medium error, dev sdc, sector 1234 op 0x0:(READ) flags 0x0", "sdc"),
+		Entry("buffer error", "Buffer I/O error on dev sda1, logical block 0, async page read", "sda1"),
+		Entry("other lines", "EXT4-fs (sda1): mounted filesystem with ordered data mode", ""),
+		Entry("record continuation", " DEVICE=b8:0", ""),
+	)
+})
+
+var _ = Describe("KmsgWatcher", func() {
+	var (
+		discovery *udev.FakeDiscovery
+		disk      *mockDevice
+		part      *mockDevice
+		refreshes atomic.Int32
+		now       time.Time
+		watcher   *KmsgWatcher
+	)
+
+	BeforeEach(func() {
+		disk = diskDevice("sdb", "S64F01")
+		part = diskPartition(disk, "sdb1", "ydb_data_01")
+		discovery = udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+		discovery.AddDevice(disk)
+		discovery.AddDevice(part)
+
+		refreshes.Store(0)
+		now = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		watcher = newKmsgWatcher(discovery, KmsgConfig{Threshold: 2, Window: time.Minute}, func(string) { refreshes.Add(1) })
+		watcher.now = func() time.Time { return now }
+	})
+
+	It("fails a disk once the threshold is crossed within the window", func() {
+		watcher.observe("critical medium error, dev sdb, sector 1234 op 0x0:(READ)")
+		Expect(watcher.Check(disk)).To(Succeed())
+
+		now = now.Add(2 * time.Minute)
+		watcher.observe("critical medium error, dev sdb, sector 1234 op 0x0:(READ)")
+		Expect(watcher.Check(disk)).To(Succeed())
+
+		now = now.Add(30 * time.Second)
+		watcher.observe("critical medium error, dev sdb, sector 1234 op 0x0:(READ)")
+		Expect(watcher.Check(disk)).To(MatchError(ContainSubstring("disk sdb failed")))
+		Expect(refreshes.Load()).To(Equal(int32(1)))
+		Expect(watcher.Failed()).To(Equal([]string{"sdb"}))
+	})
+
+	It("counts errors of partitions against their disk", func() {
+		watcher.observe("Buffer I/O error on dev sdb1, logical block 0, async page read")
+		watcher.observe("Buffer I/O error on dev sdb1, logical block 8, async page read")
+		Expect(watcher.Check(disk)).NotTo(Succeed())
+		Expect(watcher.Check(diskDevice("sda", "S64F02"))).To(Succeed())
+	})
+
+	It("keeps a disk failed until it is cleared", func() {
+		watcher.observe("I/O error, dev sdb, sector 1 op 0x1:(WRITE)")
+		watcher.observe("I/O error, dev sdb, sector 2 op 0x1:(WRITE)")
+		now = now.Add(time.Hour)
+		Expect(watcher.Check(disk)).NotTo(Succeed())
+
+		Expect(watcher.Clear("sdb")).To(BeTrue())
+		Expect(watcher.Check(disk)).To(Succeed())
+		Expect(refreshes.Load()).To(Equal(int32(2)))
+		Expect(watcher.Clear("sdb")).To(BeFalse())
+		Expect(refreshes.Load()).To(Equal(int32(2)))
+	})
+
+	It("follows the lines of a pipe", func() {
+		r, w := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			watcher.follow(r)
+		}()
+
+		_, err := io.WriteString(w, "3,1452,79230433,-;blk_update_request: I/O error, dev sdb, sector 2048\n SUBSYSTEM=block\n DEVICE=b8:16\n")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.WriteString(w, "3,1453,79230434,-;blk_update_request: I/O error, dev sdb, sector 2056\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+		Eventually(done).Should(BeClosed())
+
+		Expect(watcher.Failed()).To(Equal([]string{"sdb"}))
+	})
+
+	It("makes partitions and batches on the failed disk unhealthy", func() {
+		instances, err := PartitionLabelMatcherInstances("ydb.tech", regexp.MustCompile(`^ydb_(.*)$`), false,
+			WithPartitionDiskHealth(watcher))(part)
+		Expect(err).NotTo(HaveOccurred())
+		pool := &batchPartitionPool{
+			parts:  map[udev.Id]udev.Device{part.Id(): part},
+			labels: map[udev.Id]string{part.Id(): "data_01"},
+		}
+		WithBatchDiskHealth(watcher)(pool)
+		seat := &batchPartitionSeat{id: "0", pool: pool}
+		Expect(instances[0].Health()).To(BeAssignableToTypeOf(Healthy{}))
+		Expect(seat.Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+		watcher.observe("critical medium error, dev sdb, sector 1234")
+		watcher.observe("critical medium error, dev sdb, sector 1234")
+		Expect(instances[0].Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		Expect(pool.health()).To(BeAssignableToTypeOf(Healthy{}))
+		Expect(seat.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+
+		watcher.Clear("sdb")
+		Expect(instances[0].Health()).To(BeAssignableToTypeOf(Healthy{}))
+		Expect(seat.Health()).To(BeAssignableToTypeOf(Healthy{}))
+	})
+
+	It("lists and clears failed disks over HTTP", func() {
+		watcher.observe("I/O error, dev sdb, sector 1")
+		watcher.observe("I/O error, dev sdb, sector 2")
+
+		rec := httptest.NewRecorder()
+		watcher.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/disks/io-errors", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"S64F01": {"disk": "sdb", "line": "I/O error, dev sdb, sector 2", "since": "2026-10-18T12:00:00Z"}}`))
+
+		rec = httptest.NewRecorder()
+		watcher.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/disks/io-errors?disk=sda", nil))
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+
+		rec = httptest.NewRecorder()
+		watcher.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/disks/io-errors?disk=sdb", nil))
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(watcher.Failed()).To(BeEmpty())
+	})
+
+	It("knows disks by their serial number rather than their kernel name", func() {
+		watcher.observe("I/O error, dev sdb, sector 1")
+		watcher.observe("I/O error, dev sdb, sector 2")
+
+		Expect(watcher.Check(diskDevice("sdc", "S64F01"))).To(MatchError(ContainSubstring("disk sdc failed")))
+		Expect(watcher.Check(diskDevice("sdb", "S64F99"))).To(Succeed())
+
+		Expect(watcher.Clear("S64F01")).To(BeTrue())
+		Expect(watcher.Check(disk)).To(Succeed())
+	})
+
+	It("keeps failed disks in the state file across restarts", func() {
+		stateFile := filepath.Join(GinkgoT().TempDir(), "state", "io-errors.json")
+		watcher.config.StateFile = stateFile
+		watcher.observe("I/O error, dev sdb, sector 1")
+		watcher.observe("I/O error, dev sdb, sector 2")
+		Expect(stateFile).To(BeAnExistingFile())
+
+		restarted := newKmsgWatcher(discovery, KmsgConfig{StateFile: stateFile}, func(string) {})
+		Expect(restarted.load()).To(Succeed())
+		Expect(restarted.Check(disk)).To(MatchError(ContainSubstring("I/O error, dev sdb, sector 2")))
+
+		Expect(restarted.Clear("sdb")).To(BeTrue())
+		restarted = newKmsgWatcher(discovery, KmsgConfig{StateFile: stateFile}, func(string) {})
+		Expect(restarted.load()).To(Succeed())
+		Expect(restarted.Failed()).To(BeEmpty())
+	})
+
+	It("rejects a corrupt state file", func() {
+		stateFile := filepath.Join(GinkgoT().TempDir(), "io-errors.json")
+		Expect(os.WriteFile(stateFile, []byte("{"), 0o644)).To(Succeed())
+		restarted := newKmsgWatcher(discovery, KmsgConfig{StateFile: stateFile}, func(string) {})
+		Expect(restarted.load()).To(MatchError(ContainSubstring("failed to parse kmsg state")))
+	})
+})
+
+var _ = Describe("NewKmsgWatcher", func() {
+	It("reads a kernel log file from the start", func() {
+		dir := GinkgoT().TempDir()
+		path := filepath.Join(dir, "kmsg")
+		Expect(os.WriteFile(path, []byte("3,1,1,-;critical target error, dev nvme0n1, sector 8\n"), 0o644)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+		registry, err := NewRegistry(ctx, wg,
+			WithPluginDir(dir+"/"),
+			WithKubeletSocket(filepath.Join(dir, "kubelet.sock")),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		watcher, stop, err := NewKmsgWatcher(nil, registry, KmsgConfig{Path: path})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(stop)
+		Eventually(watcher.Failed).Should(Equal([]string{"nvme0n1"}))
+	})
+
+	It("fails for a missing kernel log", func() {
+		_, _, err := NewKmsgWatcher(nil, nil, KmsgConfig{Path: filepath.Join(GinkgoT().TempDir(), "kmsg")})
+		Expect(err).To(MatchError(ContainSubstring("failed to open kernel log")))
+	})
+})
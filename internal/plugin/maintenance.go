@@ -0,0 +1,376 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ydb-platform/udev-manager/internal/mux"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+)
+
+// Cordon takes the instances of a device out of scheduling for maintenance.
+type Cordon struct {
+	Target string    `json:"target"` // serial, WWID, device node, kernel name or instance ID
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+	Marker bool      `json:"marker,omitempty"` // set by a file in the marker directory
+}
+
+// MaintenanceConfig describes where cordons are kept.
+type MaintenanceConfig struct {
+	StateFile string // cordons made through the API, kept across restarts
+	MarkerDir string // optional directory of marker files, one per cordoned target
+}
+
+// maintenanceState is the content of the state file.
+type maintenanceState struct {
+	Cordons []Cordon `json:"cordons"`
+}
+
+// Maintenance keeps the cordoned devices and reports the instances backed by
+// them Unhealthy through [WithInstanceCheck]. A target matches an instance by
+// its ID, "<resource>/<ID>", or the kernel name, device node, serial or WWID
+// of a device backing it or of that device's disk, so cordoning a disk
+// cordons all partitions on it.
+//
+// Cordons are made through the API and kept in the state file, or by
+// creating a file named after the target in the marker directory, whose
+// content is the reason. Since file names cannot contain slashes, marker
+// files name devices by kernel name rather than device node.
+type Maintenance struct {
+	config  MaintenanceConfig
+	refresh func(cause string) // reports changed cordons to kubelet
+	now     func() time.Time
+
+	mu      sync.Mutex
+	cordons map[string]Cordon // made through the API, by target
+	markers map[string]Cordon // from marker files, by target
+}
+
+// NewMaintenance loads the cordons of the state file and the marker
+// directory. A missing state file holds no cordons.
+func NewMaintenance(config MaintenanceConfig) (*Maintenance, error) {
+	m := &Maintenance{
+		config:  config,
+		refresh: func(string) {},
+		now:     time.Now,
+		cordons: make(map[string]Cordon),
+		markers: make(map[string]Cordon),
+	}
+
+	data, err := os.ReadFile(config.StateFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read maintenance state: %w", err)
+	}
+	if err == nil {
+		var state maintenanceState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("failed to parse maintenance state %q: %w", config.StateFile, err)
+		}
+		for _, cordon := range state.Cordons {
+			m.cordons[cordon.Target] = cordon
+		}
+	}
+	m.scanMarkers()
+	for _, cordon := range m.Cordons() {
+		klog.Infof("maintenance: %s is cordoned since %s: %s", cordon.Target, cordon.Since.Format(time.RFC3339), cordon.Reason)
+	}
+	return m, nil
+}
+
+// Watch refreshes the resources of registry whenever cordons change, and
+// follows the marker directory. The returned CancelFunc stops following.
+func (m *Maintenance) Watch(registry *Registry) (mux.CancelFunc, error) {
+	m.mu.Lock()
+	m.refresh = registry.Refresh
+	m.mu.Unlock()
+	if m.config.MarkerDir == "" {
+		return func() {}, nil
+	}
+
+	if err := os.MkdirAll(m.config.MarkerDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create marker directory: %w", err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fsnotify watcher: %w", err)
+	}
+	if err := watcher.Add(m.config.MarkerDir); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("failed to watch marker directory %q: %w", m.config.MarkerDir, err)
+	}
+	// Markers may have changed since NewMaintenance.
+	m.rescan()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				m.rescan()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				klog.Errorf("maintenance: failed to watch %s: %v", m.config.MarkerDir, err)
+			}
+		}
+	}()
+	return func() {
+		if err := watcher.Close(); err != nil {
+			klog.Errorf("maintenance: failed to close watcher: %v", err)
+		}
+		<-done
+	}, nil
+}
+
+// scanMarkers reads the marker directory and reports whether the cordons it
+// sets changed.
+func (m *Maintenance) scanMarkers() bool {
+	if m.config.MarkerDir == "" {
+		return false
+	}
+	entries, err := os.ReadDir(m.config.MarkerDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		klog.Errorf("maintenance: failed to read marker directory: %v", err)
+		return false
+	}
+
+	markers := make(map[string]Cordon, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		reason, _ := os.ReadFile(filepath.Join(m.config.MarkerDir, entry.Name()))
+		markers[entry.Name()] = Cordon{
+			Target: entry.Name(),
+			Reason: strings.TrimSpace(string(reason)),
+			Since:  info.ModTime(),
+			Marker: true,
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := len(markers) != len(m.markers)
+	for target, cordon := range markers {
+		if old, ok := m.markers[target]; !ok || old.Reason != cordon.Reason {
+			changed = true
+		}
+	}
+	m.markers = markers
+	return changed
+}
+
+// rescan reads the marker directory and refreshes if cordons changed.
+func (m *Maintenance) rescan() {
+	if m.scanMarkers() {
+		klog.Infof("maintenance: marker files changed")
+		m.doRefresh("maintenance: marker files changed")
+	}
+}
+
+func (m *Maintenance) doRefresh(cause string) {
+	m.mu.Lock()
+	refresh := m.refresh
+	m.mu.Unlock()
+	refresh(cause)
+}
+
+// saveLocked writes the cordons made through the API to the state file.
+func (m *Maintenance) saveLocked() error {
+	state := maintenanceState{Cordons: make([]Cordon, 0, len(m.cordons))}
+	for _, cordon := range m.cordons {
+		state.Cordons = append(state.Cordons, cordon)
+	}
+	sort.Slice(state.Cordons, func(i, j int) bool { return state.Cordons[i].Target < state.Cordons[j].Target })
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.config.StateFile), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return writeFileAtomic(m.config.StateFile, data, 0o644)
+}
+
+// Cordon cordons target for reason and persists it.
+func (m *Maintenance) Cordon(target, reason string) error {
+	if target == "" {
+		return fmt.Errorf("target must be set")
+	}
+	m.mu.Lock()
+	old, existed := m.cordons[target]
+	m.cordons[target] = Cordon{Target: target, Reason: reason, Since: m.now()}
+	if err := m.saveLocked(); err != nil {
+		if existed {
+			m.cordons[target] = old
+		} else {
+			delete(m.cordons, target)
+		}
+		m.mu.Unlock()
+		return fmt.Errorf("failed to save cordon of %s: %w", target, err)
+	}
+	m.mu.Unlock()
+
+	klog.Infof("maintenance: cordoned %s: %s", target, reason)
+	m.doRefresh("maintenance: cordoned " + target)
+	return nil
+}
+
+// Uncordon lifts the cordon of target, removing its marker file if it has
+// one, and reports whether target was cordoned.
+func (m *Maintenance) Uncordon(target string) (bool, error) {
+	m.mu.Lock()
+	old, cordoned := m.cordons[target]
+	_, marked := m.markers[target]
+	if cordoned {
+		delete(m.cordons, target)
+		if err := m.saveLocked(); err != nil {
+			m.cordons[target] = old
+			m.mu.Unlock()
+			return false, fmt.Errorf("failed to save uncordon of %s: %w", target, err)
+		}
+	}
+	if marked {
+		if err := os.Remove(filepath.Join(m.config.MarkerDir, target)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			m.mu.Unlock()
+			return cordoned, fmt.Errorf("failed to remove marker file of %s: %w", target, err)
+		}
+		delete(m.markers, target)
+	}
+	m.mu.Unlock()
+
+	if !cordoned && !marked {
+		return false, nil
+	}
+	klog.Infof("maintenance: uncordoned %s", target)
+	m.doRefresh("maintenance: uncordoned " + target)
+	return true, nil
+}
+
+// Cordons returns all cordons, sorted by target.
+func (m *Maintenance) Cordons() []Cordon {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cordons := make([]Cordon, 0, len(m.cordons)+len(m.markers))
+	for _, cordon := range m.cordons {
+		cordons = append(cordons, cordon)
+	}
+	for target, cordon := range m.markers {
+		if _, ok := m.cordons[target]; !ok {
+			cordons = append(cordons, cordon)
+		}
+	}
+	sort.Slice(cordons, func(i, j int) bool { return cordons[i].Target < cordons[j].Target })
+	return cordons
+}
+
+// deviceTargets returns the targets that name dev: its kernel name, device
+// node, serial and WWID, and the kernel name and device node of its disk.
+func deviceTargets(dev udev.Device) []string {
+	targets := []string{
+		udev.Sysname(dev),
+		dev.DevNode(),
+		dev.PropertyLookup(udev.PropertyShortSerial),
+		dev.SystemAttributeLookup(udev.SysAttrSerial),
+		dev.PropertyLookup(udev.PropertyWWN),
+		dev.SystemAttributeLookup(udev.SysAttrWWID),
+	}
+	if disk := diskOf(dev); disk != dev {
+		targets = append(targets, udev.Sysname(disk), disk.DevNode())
+	}
+	return targets
+}
+
+// CheckInstance fails instances matched by a cordon, naming its reason.
+func (m *Maintenance) CheckInstance(resource string, instance Instance) error {
+	targets := []string{string(instance.Id()), resource + "/" + string(instance.Id())}
+	for _, dev := range instanceDevices(instance) {
+		targets = append(targets, deviceTargets(dev)...)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, target := range targets {
+		if target == "" {
+			continue
+		}
+		cordon, ok := m.cordons[target]
+		if !ok {
+			cordon, ok = m.markers[target]
+		}
+		if ok {
+			return fmt.Errorf("cordoned as %s since %s: %s", target, cordon.Since.Format(time.RFC3339), cordon.Reason)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP lists the cordons on GET, cordons the target of a JSON
+// {"target": ..., "reason": ...} body on POST, and uncordons the target
+// query parameter on DELETE.
+func (m *Maintenance) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		resp.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(resp).Encode(m.Cordons()); err != nil {
+			klog.Errorf("maintenance: failed to write cordons: %v", err)
+		}
+	case http.MethodPost:
+		var cordon Cordon
+		if err := json.NewDecoder(req.Body).Decode(&cordon); err != nil {
+			http.Error(resp, fmt.Sprintf("invalid cordon: %v", err), http.StatusBadRequest)
+			return
+		}
+		if cordon.Target == "" {
+			http.Error(resp, "target must be set", http.StatusBadRequest)
+			return
+		}
+		if err := m.Cordon(cordon.Target, cordon.Reason); err != nil {
+			http.Error(resp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		target := req.URL.Query().Get("target")
+		if target == "" {
+			http.Error(resp, "target must be set", http.StatusBadRequest)
+			return
+		}
+		cordoned, err := m.Uncordon(target)
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !cordoned {
+			http.Error(resp, fmt.Sprintf("%q is not cordoned", target), http.StatusNotFound)
+			return
+		}
+		resp.WriteHeader(http.StatusNoContent)
+	default:
+		resp.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(resp, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+var _ = Describe("Maintenance", func() {
+	var (
+		dir         string
+		config      MaintenanceConfig
+		maintenance *Maintenance
+		refreshes   int
+		part        *partition
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		config = MaintenanceConfig{
+			StateFile: filepath.Join(dir, "state", "cordons.json"),
+			MarkerDir: filepath.Join(dir, "cordon"),
+		}
+		var err error
+		maintenance, err = NewMaintenance(config)
+		Expect(err).NotTo(HaveOccurred())
+		refreshes = 0
+		maintenance.refresh = func(string) { refreshes++ }
+
+		disk := diskDevice("nvme0n1", "S64F01")
+		disk.sysattrs[udev.SysAttrWWID] = "eui.0025388b01234567"
+		part = &partition{label: "data_01", domain: "ydb.tech", dev: diskPartition(disk, "nvme0n1p1", "ydb_data_01")}
+	})
+
+	check := func() error {
+		return maintenance.CheckInstance("ydb.tech/part-data_01", part)
+	}
+
+	DescribeTable("matches instances by",
+		func(target string) {
+			Expect(check()).To(Succeed())
+			Expect(maintenance.Cordon(target, "disk swap")).To(Succeed())
+			Expect(check()).To(MatchError(ContainSubstring("disk swap")))
+			Expect(maintenance.CheckInstance("ydb.tech/part-data_02", &partition{label: "data_02", dev: partitionDevice("sda1", "ydb_data_02")})).To(Succeed())
+		},
+		Entry("serial", "S64F01"),
+		Entry("WWID", "eui.0025388b01234567"),
+		Entry("device node", "/dev/nvme0n1p1"),
+		Entry("device node of the disk", "/dev/nvme0n1"),
+		Entry("kernel name of the disk", "nvme0n1"),
+		Entry("instance ID", "data_01"),
+		Entry("resource and instance ID", "ydb.tech/part-data_01/data_01"),
+	)
+
+	It("matches batch seats by any member", func() {
+		pool := &batchPartitionPool{parts: map[udev.Id]udev.Device{
+			part.dev.Id(): part.dev,
+			"sda1":        partitionDevice("sda1", "ydb_data_02"),
+		}}
+		Expect(maintenance.Cordon("S64F01", "")).To(Succeed())
+		Expect(maintenance.CheckInstance("ydb.tech/batch-set", &healthOverride{Instance: &batchPartitionSeat{id: "0", pool: pool}, health: Healthy{}})).NotTo(Succeed())
+	})
+
+	It("keeps cordons across restarts until they are lifted", func() {
+		Expect(maintenance.Cordon("S64F01", "disk swap")).To(Succeed())
+		Expect(refreshes).To(Equal(1))
+
+		restarted, err := NewMaintenance(config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restarted.Cordons()).To(HaveLen(1))
+		Expect(restarted.Cordons()[0].Reason).To(Equal("disk swap"))
+
+		restarted.refresh = func(string) {}
+		Expect(restarted.Uncordon("S64F01")).To(BeTrue())
+		restarted, err = NewMaintenance(config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restarted.Cordons()).To(BeEmpty())
+	})
+
+	It("cordons targets of marker files until they are removed", func() {
+		Expect(os.MkdirAll(config.MarkerDir, 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(config.MarkerDir, "nvme0n1"), []byte("replace on Monday\n"), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(config.MarkerDir, ".nvme1n1.swp"), nil, 0o644)).To(Succeed())
+		maintenance.rescan()
+		Expect(refreshes).To(Equal(1))
+		Expect(check()).To(MatchError(ContainSubstring("replace on Monday")))
+		Expect(maintenance.Cordons()).To(HaveLen(1))
+		Expect(maintenance.Cordons()[0].Marker).To(BeTrue())
+
+		Expect(maintenance.Uncordon("nvme0n1")).To(BeTrue())
+		Expect(filepath.Join(config.MarkerDir, "nvme0n1")).NotTo(BeAnExistingFile())
+		Expect(check()).To(Succeed())
+	})
+
+	It("follows the marker directory", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+		registry, err := NewRegistry(ctx, wg,
+			WithPluginDir(dir+"/"),
+			WithKubeletSocket(filepath.Join(dir, "kubelet.sock")),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		stop, err := maintenance.Watch(registry)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(stop)
+
+		Expect(os.WriteFile(filepath.Join(config.MarkerDir, "S64F01"), nil, 0o644)).To(Succeed())
+		Eventually(check).Should(HaveOccurred())
+		Expect(os.Remove(filepath.Join(config.MarkerDir, "S64F01"))).To(Succeed())
+		Eventually(check).Should(Succeed())
+	})
+
+	It("makes, lists and lifts cordons over HTTP", func() {
+		rec := httptest.NewRecorder()
+		maintenance.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cordons", strings.NewReader(`{"target": "/dev/nvme0n1", "reason": "disk swap"}`)))
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(check()).NotTo(Succeed())
+
+		rec = httptest.NewRecorder()
+		maintenance.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cordons", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(ContainSubstring(`"target":"/dev/nvme0n1"`))
+
+		rec = httptest.NewRecorder()
+		maintenance.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cordons", strings.NewReader(`{"reason": "no target"}`)))
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+		rec = httptest.NewRecorder()
+		maintenance.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/cordons?target=/dev/nvme0n1", nil))
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(check()).To(Succeed())
+
+		rec = httptest.NewRecorder()
+		maintenance.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/cordons?target=/dev/nvme0n1", nil))
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("fails on a corrupt state file", func() {
+		Expect(os.MkdirAll(filepath.Dir(config.StateFile), 0o755)).To(Succeed())
+		Expect(os.WriteFile(config.StateFile, []byte("{"), 0o644)).To(Succeed())
+		_, err := NewMaintenance(config)
+		Expect(err).To(MatchError(ContainSubstring("failed to parse maintenance state")))
+	})
+
+	It("reports cordoned instances unhealthy to kubelet", func() {
+		res := newResource(ResourceTemplate{Domain: "ydb.tech", Prefix: "part-data_01"}, map[Id]Instance{part.Id(): part})
+		DeferCleanup(res.Close)
+		p := &plugin{resource: res, checks: []InstanceCheck{maintenance}}
+		Expect(p.health(part)).To(BeAssignableToTypeOf(Healthy{}))
+
+		maintenance.now = func() time.Time { return time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC) }
+		Expect(maintenance.Cordon("data_01", "disk swap")).To(Succeed())
+		Expect(p.health(part)).To(BeAssignableToTypeOf(Unhealthy{}))
+	})
+})
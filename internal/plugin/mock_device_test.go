@@ -2,7 +2,9 @@ package plugin
 
 import (
 	"fmt"
+	"sync/atomic"
 
+	"github.com/ydb-platform/udev-manager/internal/mux"
 	"github.com/ydb-platform/udev-manager/internal/udev"
 )
 
@@ -12,6 +14,7 @@ type mockDevice struct {
 	subsystem  string
 	devType    string
 	devNode    string
+	devLinks   []string
 	properties map[string]string
 	sysattrs   map[string]string
 	numaNode   int
@@ -23,7 +26,7 @@ func (m *mockDevice) Parent() udev.Device { return m.parent }
 func (m *mockDevice) Subsystem() string   { return m.subsystem }
 func (m *mockDevice) DevType() string     { return m.devType }
 func (m *mockDevice) DevNode() string     { return m.devNode }
-func (m *mockDevice) DevLinks() []string  { return nil }
+func (m *mockDevice) DevLinks() []string  { return m.devLinks }
 
 func (m *mockDevice) Properties() map[string]string {
 	if m.properties == nil {
@@ -94,6 +97,42 @@ func partitionDevice(id, label string) *mockDevice {
 	}
 }
 
+// infinibandDevice returns a mock infiniband-subsystem device for RDMA device name.
+func infinibandDevice(name string) *mockDevice {
+	return &mockDevice{
+		id:         udev.Id("/sys/devices/pci0000:00/0000:00:01.0/infiniband/" + name),
+		subsystem:  udev.InfinibandSubsystem,
+		properties: map[string]string{},
+		sysattrs:   map[string]string{},
+		numaNode:   -1,
+	}
+}
+
+// infinibandVerbsDevice returns a mock infiniband_verbs device name (e.g.
+// "uverbs0") of RDMA device ibdev.
+func infinibandVerbsDevice(name, ibdev string) *mockDevice {
+	return &mockDevice{
+		id:         udev.Id("/sys/devices/pci0000:00/0000:00:01.0/infiniband_verbs/" + name),
+		subsystem:  udev.InfinibandVerbsSubsystem,
+		properties: map[string]string{},
+		sysattrs:   map[string]string{udev.SysAttrIbdev: ibdev},
+		numaNode:   -1,
+	}
+}
+
+// nvmeDevice returns a mock NVMe namespace block device (e.g. "nvme0n1") or
+// generic char device (e.g. "ng0n1") on NUMA node numaNode.
+func nvmeDevice(subsystem, name string, numaNode int) *mockDevice {
+	return &mockDevice{
+		id:         udev.Id("/sys/devices/pci0000:00/0000:00:02.0/nvme/nvme0/" + name),
+		subsystem:  subsystem,
+		devNode:    "/dev/" + name,
+		properties: map[string]string{},
+		sysattrs:   map[string]string{},
+		numaNode:   numaNode,
+	}
+}
+
 // netDevice constructs a mock network device with the given interface name, speed (Mbps), and operstate.
 func netDevice(ifname, speed, operstate string) *mockDevice {
 	return &mockDevice{
@@ -110,3 +149,85 @@ func netDevice(ifname, speed, operstate string) *mockDevice {
 		numaNode: -1,
 	}
 }
+
+// pciDevice constructs a mock PCI device with the given address and NUMA node
+// ("" leaves numa_node unset).
+func pciDevice(addr, numaNode string) *mockDevice {
+	dev := &mockDevice{
+		id:         udev.Id("/sys/bus/pci/devices/" + addr),
+		subsystem:  udev.PCISubsystem,
+		properties: map[string]string{},
+		sysattrs:   map[string]string{},
+		numaNode:   -1,
+	}
+	if numaNode != "" {
+		dev.sysattrs[udev.SysAttrNumaNode] = numaNode
+	}
+	return dev
+}
+
+// roundTripDiscovery is a FakeDiscovery that, like the udev one, emits events
+// and serves State from a single goroutine, so that a subscriber calling State
+// while an event waits on it stalls the events.
+type roundTripDiscovery struct {
+	*udev.FakeDiscovery
+	emit   chan udev.Event
+	states chan stateCall
+	done   chan struct{}
+}
+
+type stateCall struct {
+	filter mux.FilterFunc[udev.Device]
+	reply  chan map[udev.Id]udev.Device
+}
+
+func newRoundTripDiscovery() *roundTripDiscovery {
+	d := &roundTripDiscovery{
+		FakeDiscovery: udev.NewFakeDiscovery(),
+		emit:          make(chan udev.Event),
+		states:        make(chan stateCall),
+		done:          make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case ev := <-d.emit:
+				d.FakeDiscovery.Emit(ev)
+			case call := <-d.states:
+				call.reply <- d.FakeDiscovery.State(call.filter)
+			case <-d.done:
+				return
+			}
+		}
+	}()
+	return d
+}
+
+func (d *roundTripDiscovery) Emit(ev udev.Event) { d.emit <- ev }
+
+func (d *roundTripDiscovery) State(filter mux.FilterFunc[udev.Device]) map[udev.Id]udev.Device {
+	call := stateCall{filter: filter, reply: make(chan map[udev.Id]udev.Device)}
+	d.states <- call
+	return <-call.reply
+}
+
+func (d *roundTripDiscovery) Close() {
+	close(d.done)
+	d.FakeDiscovery.Close()
+}
+
+// countAdded counts the Added events of discovery until the returned
+// CancelFunc is called.
+func countAdded(discovery udev.Discovery) (func() int, mux.CancelFunc) {
+	var added atomic.Int64
+	events := make(chan udev.Event, 1024)
+	cancel := discovery.Subscribe(mux.SinkFromChan(events))
+	go func() {
+		for ev := range events {
+			if _, ok := ev.(udev.Added); ok {
+				added.Add(1)
+			}
+		}
+	}()
+	return func() int { return int(added.Load()) }, cancel
+}
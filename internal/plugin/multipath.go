@@ -0,0 +1,220 @@
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// mpathUUIDPrefix prefixes the DM_UUID of dm-multipath maps.
+	mpathUUIDPrefix = "mpath-"
+
+	// pathStateRunning is the SCSI device state of a usable path.
+	pathStateRunning = "running"
+)
+
+// mpathPartUUIDRegex matches the DM_UUID of partitions of multipath maps as
+// created by kpartx, e.g. "part1-mpath-3600a0980...".
+var mpathPartUUIDRegex = regexp.MustCompile(`^part(\d+)-` + mpathUUIDPrefix)
+
+// multipathSysfs recognises dm-multipath maps and their paths through the
+// holders and slaves of block devices in sysfs. On SAN-attached nodes one LUN
+// shows up as a disk per path plus the map, so the partitions of the paths
+// are suppressed in favour of the partitions of the map.
+//
+// It remembers which disks are paths and which map partitions were seen, so
+// that events of paths can be mapped after their sysfs entries are gone.
+type multipathSysfs struct {
+	sysfs
+
+	mu        sync.Mutex
+	paths     map[string]string              // path disk, e.g. "sdb" -> DM_UUID of its map
+	parts     map[string]udev.Device         // DM_UUID of a map partition -> its device
+	seenPaths map[string]map[string]struct{} // kernel name of a map -> paths seen
+}
+
+func newMultipathSysfs(root string) *multipathSysfs {
+	return &multipathSysfs{
+		sysfs:     sysfs{root: root},
+		paths:     make(map[string]string),
+		parts:     make(map[string]udev.Device),
+		seenPaths: make(map[string]map[string]struct{}),
+	}
+}
+
+// blockDir returns the sysfs directory of block device name.
+func blockDir(name string) string {
+	return filepath.Join("class", "block", name)
+}
+
+// mapOf returns the DM_UUID of the multipath map disk is a path of, or "" if
+// it is none. Disks gone from sysfs are answered from the cache.
+func (s *multipathSysfs) mapOf(disk string) string {
+	if !s.exists(blockDir(disk)) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.paths[disk]
+	}
+
+	var uuid string
+	holders, _ := s.list(filepath.Join(blockDir(disk), "holders"))
+	for _, holder := range holders {
+		if holderUUID := s.read(filepath.Join(blockDir(holder), "dm", "uuid")); strings.HasPrefix(holderUUID, mpathUUIDPrefix) {
+			uuid = holderUUID
+			break
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if uuid == "" {
+		delete(s.paths, disk)
+	} else {
+		s.paths[disk] = uuid
+	}
+	return uuid
+}
+
+// partitionName returns the partition label of dev. ok is true if dev is a
+// partition to expose: a regular partition whose disk is not a path of a
+// multipath map, or a partition of a multipath map. A nil s does not look
+// for multipath maps.
+func (s *multipathSysfs) partitionName(dev udev.Device) (name string, ok bool) {
+	if dev == nil || dev.Subsystem() != udev.BlockSubsystem {
+		return "", false
+	}
+	if s != nil && mpathPartUUIDRegex.MatchString(dev.Property(udev.PropertyDmUUID)) {
+		name = dev.Property(udev.PropertyPartEntryName)
+		if name == "" {
+			return "", false
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.parts[dev.Property(udev.PropertyDmUUID)] = dev
+		return name, true
+	}
+
+	if dev.DevType() != udev.DeviceTypePart {
+		return "", false
+	}
+	name, found := dev.Properties()[udev.PropertyPartName]
+	if !found {
+		return "", false
+	}
+	if s != nil && dev.Parent() != nil {
+		if uuid := s.mapOf(udev.Sysname(dev.Parent())); uuid != "" {
+			klog.V(5).Infof("multipath: suppressing partition %s of path %s", dev.DevNode(), udev.Sysname(dev.Parent()))
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// mapPartition returns the partition of the multipath map that partition dev
+// of a path corresponds to, or nil if dev is not on a path or the map
+// partition was not seen yet.
+func (s *multipathSysfs) mapPartition(dev udev.Device) udev.Device {
+	if s == nil || dev == nil || dev.DevType() != udev.DeviceTypePart || dev.Parent() == nil {
+		return nil
+	}
+	uuid := s.mapOf(udev.Sysname(dev.Parent()))
+	number := dev.Property(udev.PropertyPartNumber)
+	if uuid == "" || number == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.parts["part"+number+"-"+uuid]
+}
+
+// mapPartitions returns the partitions of multipath map dev seen so far that
+// are still in sysfs, sorted by id, or nil if dev is no multipath map. The
+// kernel raises a change event on the map, not on its partitions, when a path
+// fails or comes back, so the partitions are re-evaluated on events of the
+// map.
+func (s *multipathSysfs) mapPartitions(dev udev.Device) []udev.Device {
+	if s == nil || dev == nil || dev.Subsystem() != udev.BlockSubsystem {
+		return nil
+	}
+	uuid := dev.Property(udev.PropertyDmUUID)
+	if !strings.HasPrefix(uuid, mpathUUIDPrefix) {
+		return nil
+	}
+
+	s.mu.Lock()
+	var parts []udev.Device
+	for partUUID, part := range s.parts {
+		if strings.HasSuffix(partUUID, "-"+uuid) {
+			parts = append(parts, part)
+		}
+	}
+	s.mu.Unlock()
+
+	parts = slices.DeleteFunc(parts, func(part udev.Device) bool {
+		return !s.exists(blockDir(udev.Sysname(part)))
+	})
+	slices.SortFunc(parts, func(a, b udev.Device) int {
+		return strings.Compare(string(a.Id()), string(b.Id()))
+	})
+	return parts
+}
+
+// isMapPartition reports whether dev is a partition of a multipath map.
+func isMapPartition(dev udev.Device) bool {
+	return mpathPartUUIDRegex.MatchString(dev.Property(udev.PropertyDmUUID))
+}
+
+// activePaths returns the number of running paths of the map partition dev
+// sits on, and the number of paths the map was seen with. A path that is
+// neither a slave of the map nor in sysfs any more, such as an unmapped LUN
+// or the disk behind a replaced HBA, is removed for good and forgotten.
+func (s *multipathSysfs) activePaths(dev udev.Device) (active, seen int) {
+	maps, _ := s.list(filepath.Join(blockDir(udev.Sysname(dev)), "slaves"))
+	if len(maps) == 0 {
+		return 0, 0
+	}
+	mapName := maps[0]
+	paths, _ := s.list(filepath.Join(blockDir(mapName), "slaves"))
+	for _, path := range paths {
+		if s.read(filepath.Join(blockDir(path), "device", "state")) == pathStateRunning {
+			active++
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seenPaths := s.seenPaths[mapName]
+	if seenPaths == nil {
+		seenPaths = make(map[string]struct{})
+		s.seenPaths[mapName] = seenPaths
+	}
+	for path := range seenPaths {
+		if !slices.Contains(paths, path) && !s.exists(blockDir(path)) {
+			klog.V(2).Infof("multipath: forgetting path %s of %s, it is gone", path, mapName)
+			delete(seenPaths, path)
+		}
+	}
+	for _, path := range paths {
+		seenPaths[path] = struct{}{}
+	}
+	return active, len(seenPaths)
+}
+
+// pathsHealth is Unhealthy while the map partition dev has fewer running
+// paths than its map was seen with.
+func (s *multipathSysfs) pathsHealth(dev udev.Device) Health {
+	active, seen := s.activePaths(dev)
+	if active == 0 || active < seen {
+		return Unhealthy{Reason: fmt.Sprintf("multipath: %s has %d of %d paths running", dev.DevNode(), active, seen)}
+	}
+	return Healthy{}
+}
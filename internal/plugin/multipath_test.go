@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+const mpathUUID = "mpath-3600a098038303053453f463045727a41"
+
+// fakeMultipathSysfs lays out the multipath map dm-2 over the paths sdb and
+// sdc, with its partition dm-5, under root.
+func fakeMultipathSysfs(root string) {
+	for _, path := range []string{"sdb", "sdc"} {
+		writeSysfsFile(root, filepath.Join("class", "block", path, "holders", "dm-2"), "")
+		writeSysfsFile(root, filepath.Join("class", "block", path, "device", "state"), pathStateRunning)
+		writeSysfsFile(root, filepath.Join("class", "block", "dm-2", "slaves", path), "")
+	}
+	writeSysfsFile(root, filepath.Join("class", "block", "dm-2", "dm", "uuid"), mpathUUID)
+	writeSysfsFile(root, filepath.Join("class", "block", "dm-5", "slaves", "dm-2"), "")
+	writeSysfsFile(root, filepath.Join("class", "block", "dm-5", "dm", "uuid"), "part1-"+mpathUUID)
+}
+
+// mpathMap returns the multipath map dm-2.
+func mpathMap() *mockDevice {
+	return volumeDevice("dm-2", map[string]string{
+		udev.PropertyDmName: "mpatha",
+		udev.PropertyDmUUID: mpathUUID,
+	})
+}
+
+// pathPartition returns partition 1 of path disk with partition label label.
+func pathPartition(disk, label string) *mockDevice {
+	dev := diskPartition(diskDevice(disk, "LUN01"), disk+"1", label)
+	dev.properties[udev.PropertyPartNumber] = "1"
+	return dev
+}
+
+// mpathPartition returns partition 1 of the multipath map dm-2 with partition
+// label label.
+func mpathPartition(label string) *mockDevice {
+	return volumeDevice("dm-5", map[string]string{
+		udev.PropertyDmName:        "mpatha1",
+		udev.PropertyDmUUID:        "part1-" + mpathUUID,
+		udev.PropertyPartEntryName: label,
+	})
+}
+
+var _ = Describe("multipathSysfs", func() {
+	var (
+		root      string
+		multipath *multipathSysfs
+	)
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		fakeMultipathSysfs(root)
+		multipath = newMultipathSysfs(root)
+	})
+
+	Describe("partitionName", func() {
+		It("suppresses partitions of paths", func() {
+			_, ok := multipath.partitionName(pathPartition("sdb", "ydb_data_01"))
+			Expect(ok).To(BeFalse())
+		})
+
+		It("exposes partitions of maps by their partition label", func() {
+			name, ok := multipath.partitionName(mpathPartition("ydb_data_01"))
+			Expect(ok).To(BeTrue())
+			Expect(name).To(Equal("ydb_data_01"))
+		})
+
+		It("exposes partitions of other disks", func() {
+			name, ok := multipath.partitionName(pathPartition("sda", "ydb_data_02"))
+			Expect(ok).To(BeTrue())
+			Expect(name).To(Equal("ydb_data_02"))
+		})
+
+		It("only matches regular partitions when nil", func() {
+			var none *multipathSysfs
+			_, ok := none.partitionName(mpathPartition("ydb_data_01"))
+			Expect(ok).To(BeFalse())
+			_, ok = none.partitionName(pathPartition("sdb", "ydb_data_01"))
+			Expect(ok).To(BeTrue())
+		})
+
+		It("keeps suppressing partitions of paths that are gone", func() {
+			part := pathPartition("sdb", "ydb_data_01")
+			_, ok := multipath.partitionName(part)
+			Expect(ok).To(BeFalse())
+
+			Expect(os.RemoveAll(filepath.Join(root, "class", "block", "sdb"))).To(Succeed())
+			_, ok = multipath.partitionName(part)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("mapPartition", func() {
+		It("returns the map partition a partition of a path stands for", func() {
+			Expect(multipath.mapPartition(pathPartition("sdb", "ydb_data_01"))).To(BeNil())
+
+			part := mpathPartition("ydb_data_01")
+			multipath.partitionName(part)
+			Expect(multipath.mapPartition(pathPartition("sdb", "ydb_data_01"))).To(BeIdenticalTo(part))
+			Expect(multipath.mapPartition(pathPartition("sda", "ydb_data_01"))).To(BeNil())
+		})
+	})
+
+	Describe("pathsHealth", func() {
+		It("is unhealthy once a path stops running or is lost", func() {
+			part := mpathPartition("ydb_data_01")
+			Expect(multipath.pathsHealth(part)).To(BeAssignableToTypeOf(Healthy{}))
+
+			writeSysfsFile(root, filepath.Join("class", "block", "sdc", "device", "state"), "offline")
+			Expect(multipath.pathsHealth(part)).To(BeAssignableToTypeOf(Unhealthy{}))
+
+			writeSysfsFile(root, filepath.Join("class", "block", "sdc", "device", "state"), pathStateRunning)
+			Expect(multipath.pathsHealth(part)).To(BeAssignableToTypeOf(Healthy{}))
+
+			Expect(os.Remove(filepath.Join(root, "class", "block", "dm-2", "slaves", "sdc"))).To(Succeed())
+			Expect(multipath.pathsHealth(part)).To(BeAssignableToTypeOf(Unhealthy{}))
+		})
+
+		It("forgets a path once it is gone from sysfs for good", func() {
+			part := mpathPartition("ydb_data_01")
+			Expect(multipath.pathsHealth(part)).To(BeAssignableToTypeOf(Healthy{}))
+
+			Expect(os.Remove(filepath.Join(root, "class", "block", "dm-2", "slaves", "sdc"))).To(Succeed())
+			Expect(multipath.pathsHealth(part)).To(BeAssignableToTypeOf(Unhealthy{}))
+
+			Expect(os.RemoveAll(filepath.Join(root, "class", "block", "sdc"))).To(Succeed())
+			Expect(multipath.pathsHealth(part)).To(BeAssignableToTypeOf(Healthy{}))
+		})
+	})
+})
+
+var _ = Describe("partitions on multipath maps", func() {
+	var (
+		root    string
+		opts    []PartitionOption
+		matcher *regexp.Regexp
+	)
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		fakeMultipathSysfs(root)
+		opts = []PartitionOption{WithPartitionSysfsRoot(root)}
+		matcher = regexp.MustCompile(`^ydb_(.*)$`)
+	})
+
+	It("exposes the map partition and maps events of paths to it", func() {
+		templater := PartitionLabelMatcherTemplater("ydb.tech", matcher, opts...)
+		mapper := PartitionLabelMatcherInstances("ydb.tech", matcher, false, opts...)
+
+		tmpl, err := templater(pathPartition("sdb", "ydb_data_01"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tmpl).To(BeNil())
+
+		part := mpathPartition("ydb_data_01")
+		instances, err := mapper(part)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].dev).To(BeIdenticalTo(part))
+
+		tmpl, err = templater(pathPartition("sdc", "ydb_data_01"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tmpl).To(Equal(&ResourceTemplate{Domain: "ydb.tech", Prefix: "part-data_01"}))
+		instances, err = mapper(pathPartition("sdc", "ydb_data_01"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].Id()).To(Equal(Id("data_01")))
+		Expect(instances[0].dev).To(BeIdenticalTo(part))
+	})
+
+	It("is unhealthy while the map lost paths", func() {
+		instances, err := PartitionLabelMatcherInstances("ydb.tech", matcher, false, opts...)(mpathPartition("ydb_data_01"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances[0].Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+		writeSysfsFile(root, filepath.Join("class", "block", "sdb", "device", "state"), "transport-offline")
+		Expect(instances[0].Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+	})
+
+	It("turns unhealthy on a change event of a map that lost a path", func() {
+		discovery := udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+		discovery.AddDevice(mpathPartition("ydb_data_01"))
+		tmpl := ResourceTemplate{Domain: "ydb.tech", Prefix: "part-data_01"}
+		res := newResource(tmpl, make(map[Id]Instance))
+		DeferCleanup(res.Close)
+		watchCh := res.ListAndWatch(context.Background())
+		Eventually(watchCh).Should(Receive())
+
+		scatter := &Scatter[*partition]{
+			templater: PartitionLabelMatcherTemplater("ydb.tech", matcher, opts...),
+			mapper:    PartitionLabelMatcherInstances("ydb.tech", matcher, false, opts...),
+			related:   PartitionMultipathRelated(opts...),
+			routes:    map[ResourceTemplate]Resource{tmpl: res},
+			produced:  make(map[udev.Id]map[Id]Instance),
+		}
+		DeferCleanup(scatter.subscribe(discovery))
+		Eventually(watchCh).Should(Receive(WithTransform(firstHealth, BeAssignableToTypeOf(Healthy{}))))
+
+		writeSysfsFile(root, filepath.Join("class", "block", "sdb", "device", "state"), "transport-offline")
+		Expect(discovery.Change(mpathMap())).To(BeTrue())
+		Eventually(watchCh).Should(Receive(WithTransform(firstHealth, BeAssignableToTypeOf(Unhealthy{}))))
+	})
+
+	It("turns batches unhealthy on a change event of a map that lost a path", func() {
+		pool := &batchPartitionPool{
+			parts:     make(map[udev.Id]udev.Device),
+			labels:    make(map[udev.Id]string),
+			domain:    "ydb.tech",
+			multipath: newMultipathSysfs(root),
+		}
+		seat := &batchPartitionSeat{id: "0", pool: pool}
+		res := newResource(ResourceTemplate{Domain: "ydb.tech", Prefix: "batch-data"}, map[Id]Instance{seat.Id(): seat})
+		DeferCleanup(res.Close)
+		watchCh := res.ListAndWatch(context.Background())
+		Eventually(watchCh).Should(Receive())
+
+		evCh := make(chan udev.Event, 1)
+		DeferCleanup(func() { close(evCh) })
+		go runBatchPartitionScatter(evCh, pool, matcher, res, []Instance{seat})
+
+		Expect(pool.hasPartitionOn(mpathMap())).To(BeFalse())
+		evCh <- udev.Init{Devices: []udev.Device{mpathPartition("ydb_data_01")}}
+		Eventually(watchCh).Should(Receive(WithTransform(firstHealth, BeAssignableToTypeOf(Healthy{}))))
+
+		writeSysfsFile(root, filepath.Join("class", "block", "sdb", "device", "state"), "transport-offline")
+		Expect(pool.hasPartitionOn(mpathMap())).To(BeTrue())
+		evCh <- udev.Added{Device: mpathMap()}
+		Eventually(watchCh).Should(Receive(WithTransform(firstHealth, BeAssignableToTypeOf(Unhealthy{}))))
+	})
+
+	It("leaves partitions of paths out of batches", func() {
+		multipath := newMultipathSysfs(root)
+		_, _, ok := matchBatchPartitionDevice(pathPartition("sdb", "ydb_data_01"), matcher, multipath)
+		Expect(ok).To(BeFalse())
+		_, label, ok := matchBatchPartitionDevice(mpathPartition("ydb_data_01"), matcher, multipath)
+		Expect(ok).To(BeTrue())
+		Expect(label).To(Equal("data_01"))
+	})
+})
@@ -2,99 +2,467 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ydb-platform/udev-manager/internal/udev"
 
+	"k8s.io/klog/v2"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
+// Keys of the bandwidth metadata attached to netbw allocations. The
+// interfaces keys list the interfaces of all shares of one resource in the
+// container, and the Mbps keys, which carry the interface as well, are summed
+// by mergeResponses over the shares of that interface.
+const (
+	netBWMbpsEnvSuffix       = "_MBPS"
+	netBWInterfacesEnvSuffix = "_INTERFACES"
+	netBWAllocationEnvSuffix = "_ALLOCATION"
+
+	netBWMbpsAnnotationSuffix       = ".mbps"
+	netBWInterfacesAnnotationSuffix = ".interfaces"
+)
+
+// invalidAnnotationChars matches characters not allowed in the name part of
+// an annotation key.
+var invalidAnnotationChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// netBWSettings holds the optional behaviour of netbw instances.
+type netBWSettings struct {
+	allocationDir     string      // if set, a JSON file is written here on every allocation
+	reservedMbps      uint        // subtracted from the link speed before computing shares
+	fallbackSpeedMbps uint        // used when sysfs reports no usable speed
+	bonds             *NetBWBonds // where the speeds of bond slaves are read; nil disables bonds
+}
+
+// NetBWOption configures the instances produced by [NetBWMatcherInstances].
+type NetBWOption func(*netBWSettings)
+
+// WithAllocationDir makes every netbw allocation write a JSON description of
+// the shares allocated to the container into dir, for a node agent to enforce.
+func WithAllocationDir(dir string) NetBWOption {
+	return func(s *netBWSettings) { s.allocationDir = dir }
+}
+
+// WithReservedMbps keeps mbps of every interface out of the shares, e.g. as
+// headroom for host traffic.
+func WithReservedMbps(mbps uint) NetBWOption {
+	return func(s *netBWSettings) { s.reservedMbps = mbps }
+}
+
+// WithFallbackSpeedMbps sets the speed assumed for interfaces that report no
+// speed in sysfs (virtio, VLANs, bonds without known slaves).
+func WithFallbackSpeedMbps(mbps uint) NetBWOption {
+	return func(s *netBWSettings) { s.fallbackSpeedMbps = mbps }
+}
+
+// WithBondSlaves makes the speed of a bond the sum of its slaves' speeds, as
+// read from bonds.
+func WithBondSlaves(bonds *NetBWBonds) NetBWOption {
+	return func(s *netBWSettings) { s.bonds = bonds }
+}
+
+// NetBWBonds reads the speeds of bond slaves from sysfs and remembers the
+// bonds it measured, so that the events of their slaves can be applied to the
+// bonds with [WithScatterRelated] and [NetBWBonds.Related].
+type NetBWBonds struct {
+	sysfs sysfs
+
+	mu     sync.Mutex
+	bonds  map[string]udev.Device // interface name -> bond
+	slaves map[string][]string    // bond interface name -> slaves it was measured with
+}
+
+// NewNetBWBonds returns a NetBWBonds that reads the speeds of the slaves
+// listed in the bonding/slaves sysattr from class/net/<slave>/speed of the
+// sysfs mounted at root, e.g. [DefaultSysfsRoot].
+func NewNetBWBonds(root string) *NetBWBonds {
+	return &NetBWBonds{
+		sysfs:  sysfs{root: root},
+		bonds:  make(map[string]udev.Device),
+		slaves: make(map[string][]string),
+	}
+}
+
+// Related returns the bonds netdev dev is a slave of or was a slave of when
+// they were last measured, while they are still in sysfs. The kernel raises
+// the change events of a link on the slave rather than on its bond, so the
+// speed of the bond is measured again on the events of its slaves.
+func (b *NetBWBonds) Related(dev udev.Device) []udev.Device {
+	if b == nil || dev == nil || dev.Subsystem() != udev.NetSubsystem {
+		return nil
+	}
+	slave := dev.Property(udev.PropertyInterface)
+	master := b.sysfs.linkName(filepath.Join("class/net", slave, "master"))
+
+	b.mu.Lock()
+	var related []udev.Device
+	for name, bond := range b.bonds {
+		if name == master || slices.Contains(b.slaves[name], slave) {
+			related = append(related, bond)
+		}
+	}
+	b.mu.Unlock()
+
+	related = slices.DeleteFunc(related, func(bond udev.Device) bool {
+		return !b.sysfs.exists(filepath.Join("class/net", bond.Property(udev.PropertyInterface)))
+	})
+	slices.SortFunc(related, func(a, b udev.Device) int {
+		return strings.Compare(string(a.Id()), string(b.Id()))
+	})
+	return related
+}
+
+// speedMbps returns the speed of dev in Mbps, or 0 if it is unknown. Bonds
+// are measured by their slaves when a sysfs is configured; otherwise the
+// speed sysattr is used, falling back to fallbackSpeedMbps.
+func (s *netBWSettings) speedMbps(dev udev.Device) uint {
+	if speed := s.bondSpeedMbps(dev); speed > 0 {
+		return speed
+	}
+	if speed := sysfsSpeedMbps(dev); speed > 0 {
+		return speed
+	}
+	return s.fallbackSpeedMbps
+}
+
+// bondSpeedMbps sums the speeds of the slaves of dev. It returns 0 if dev is
+// not a bond, has no slaves or none of them reports a speed. The slaves are
+// read from sysfs rather than looked up in discovery, whose subscriber this
+// runs on.
+func (s *netBWSettings) bondSpeedMbps(dev udev.Device) uint {
+	if s.bonds == nil {
+		return 0
+	}
+	slaves := strings.Fields(dev.SystemAttribute(udev.SysAttrBondingSlaves))
+	if len(slaves) == 0 {
+		return 0
+	}
+	s.bonds.remember(dev, slaves)
+	var total uint
+	found := 0
+	for _, slave := range slaves {
+		speed := s.bonds.sysfs.read(filepath.Join("class/net", slave, udev.SysAttrSpeed))
+		if speed == "" {
+			continue
+		}
+		found++
+		total += parseSpeedMbps(speed)
+	}
+	if found < len(slaves) {
+		klog.Warningf("bond %q: found %d of slaves %v", dev.Property(udev.PropertyInterface), found, slaves)
+	}
+	return total
+}
+
+// remember records that bond was measured with slaves.
+func (b *NetBWBonds) remember(bond udev.Device, slaves []string) {
+	name := bond.Property(udev.PropertyInterface)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bonds[name] = bond
+	b.slaves[name] = slaves
+}
+
+// sysfsSpeedMbps parses the speed sysattr of dev. Drivers that do not know the
+// speed report -1 or nothing; both yield 0.
+func sysfsSpeedMbps(dev udev.Device) uint {
+	return parseSpeedMbps(dev.SystemAttribute(udev.SysAttrSpeed))
+}
+
+// parseSpeedMbps parses the content of a speed attribute; unknown speeds
+// yield 0.
+func parseSpeedMbps(s string) uint {
+	speed, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || speed <= 0 {
+		return 0
+	}
+	return uint(speed)
+}
+
+// netBWAllocation is the content of a per-allocation file.
+type netBWAllocation struct {
+	Id          string          `json:"id"` // also passed to the container
+	Domain      string          `json:"domain"`
+	Name        string          `json:"name"`
+	Instances   []string        `json:"instances"`
+	Interfaces  map[string]uint `json:"interfaces"` // Mbps per interface
+	Mbps        uint            `json:"mbps"`       // total
+	AllocatedAt time.Time       `json:"allocatedAt"`
+}
+
 type networkBandwidth struct {
-	ifname string
-	idx    int
-	dev    udev.Device
+	domain       string
+	ifname       string
+	name         string // resource name part derived from the matcher's capture groups
+	idx          int
+	mbpsPerShare uint
+	dev          udev.Device
+	settings     *netBWSettings
+
+	disableTopologyHints bool
 }
 
 func (n *networkBandwidth) Id() Id {
 	return Id(fmt.Sprintf("%s_%d", n.ifname, n.idx))
 }
 
+func (n *networkBandwidth) udevDevices() []udev.Device { return []udev.Device{n.dev} }
+
 func (n *networkBandwidth) Health() Health {
-	if n.dev.SystemAttribute(udev.SysAttrOperstate) == "up" {
+	operstate := n.dev.SystemAttribute(udev.SysAttrOperstate)
+	if operstate == "up" {
 		return Healthy{}
 	}
-	return Unhealthy{}
+	return Unhealthy{Reason: fmt.Sprintf("%s is %s", n.ifname, operstate)}
+}
+
+// resourceName returns the name the instance's resource was derived from,
+// which keys its env vars and annotations.
+func (n *networkBandwidth) resourceName() string {
+	if n.name == "" {
+		return n.ifname
+	}
+	return n.name
+}
+
+func (n *networkBandwidth) envPrefix() string {
+	return sanitizeEnv(n.domain) + "_NETBW_" + sanitizeEnv(n.resourceName())
+}
+
+func (n *networkBandwidth) annotationPrefix() string {
+	return n.domain + "/netbw-" + invalidAnnotationChars.ReplaceAllString(n.resourceName(), "-")
+}
+
+// ifEnvPrefix and ifAnnotationPrefix key the values of the interface of the
+// share, so that shares of several interfaces of one resource stay apart.
+func (n *networkBandwidth) ifEnvPrefix() string {
+	return n.envPrefix() + "_" + sanitizeEnv(n.ifname)
+}
+
+func (n *networkBandwidth) ifAnnotationPrefix() string {
+	return n.annotationPrefix() + "." + invalidAnnotationChars.ReplaceAllString(n.ifname, "-")
+}
+
+// mergeKind implements [keyMerger]: the interfaces of all shares of the
+// container are listed, and the bandwidth of the shares of each interface is
+// summed. It is asked for the policy of all shares of the resource, so it
+// recognises the Mbps keys of every interface.
+func (n *networkBandwidth) mergeKind(key string) mergeKind {
+	switch {
+	case key == n.envPrefix()+netBWInterfacesEnvSuffix,
+		key == n.annotationPrefix()+netBWInterfacesAnnotationSuffix:
+		return mergeList
+	case strings.HasPrefix(key, n.envPrefix()+"_") && strings.HasSuffix(key, netBWMbpsEnvSuffix),
+		strings.HasPrefix(key, n.annotationPrefix()+".") && strings.HasSuffix(key, netBWMbpsAnnotationSuffix):
+		return mergeSum
+	}
+	return mergeOverwrite
 }
 
+// TopologyHints reports the NUMA node of the interface's PCI parent.
 func (n *networkBandwidth) TopologyHints() *pluginapi.TopologyInfo {
-	return nil
+	if n.disableTopologyHints {
+		return nil
+	}
+	return numaTopology(pciNumaNode(n.dev))
 }
 
+// Allocate hands out the share's bandwidth as env vars and annotations that
+// a tc-based helper can consume. The allocation file, if any, is written by
+// recordAllocation once all shares of the container are known.
 func (n *networkBandwidth) Allocate(context.Context) (*pluginapi.ContainerAllocateResponse, error) {
-	response := &pluginapi.ContainerAllocateResponse{}
+	mbps := strconv.FormatUint(uint64(n.mbpsPerShare), 10)
+
+	response := &pluginapi.ContainerAllocateResponse{
+		Envs: map[string]string{
+			n.envPrefix() + netBWInterfacesEnvSuffix: n.ifname,
+			n.ifEnvPrefix() + netBWMbpsEnvSuffix:     mbps,
+		},
+		Annotations: map[string]string{
+			n.annotationPrefix() + netBWInterfacesAnnotationSuffix: n.ifname,
+			n.ifAnnotationPrefix() + netBWMbpsAnnotationSuffix:     mbps,
+		},
+	}
 
+	klog.V(2).Infof("%+v", response)
 	return response, nil
 }
 
-// NetBWMatcherTemplater returns a FromDevice function that produces a
-// ResourceTemplate for net devices whose INTERFACE property matches matcher.
-func NetBWMatcherTemplater(domain string, matcher *regexp.Regexp) FromDevice[*ResourceTemplate] {
-	return func(dev udev.Device) (*ResourceTemplate, error) {
-		if dev.Subsystem() != udev.NetSubsystem {
-			return nil, nil
+// recordAllocation implements [allocationRecorder]. It writes the shares
+// allocated to a container into <allocationDir>/netbw-<id>.json, where id is
+// the lowest of their instance IDs, and passes id to the container in
+// {DOMAIN}_NETBW_{NAME}_ALLOCATION. Kubelet hands a share to a new container
+// only once the previous one is gone, so the files of earlier allocations
+// sharing a share with this one are stale and removed.
+//
+// Files are not removed when their container goes away, as the device plugin
+// API has no deallocate call; until its shares are allocated again, a file
+// outlives its container. Consumers tell live files from stale ones by
+// checking that the listed instances are still assigned to a container, e.g.
+// through the kubelet PodResources API.
+func (n *networkBandwidth) recordAllocation(instances []Instance, response *pluginapi.ContainerAllocateResponse) error {
+	if n.settings == nil || n.settings.allocationDir == "" {
+		return nil
+	}
+
+	allocation := netBWAllocation{
+		Domain:      n.domain,
+		Name:        n.resourceName(),
+		Interfaces:  make(map[string]uint),
+		AllocatedAt: time.Now().UTC(),
+	}
+	for _, instance := range instances {
+		share, ok := unwrapInstance(instance).(*networkBandwidth)
+		if !ok {
+			continue
 		}
+		allocation.Instances = append(allocation.Instances, string(share.Id()))
+		allocation.Interfaces[share.ifname] += share.mbpsPerShare
+		allocation.Mbps += share.mbpsPerShare
+	}
+	if len(allocation.Instances) == 0 {
+		return nil
+	}
+	slices.Sort(allocation.Instances)
+	allocation.Id = allocation.Instances[0]
 
-		ifname := dev.Property(udev.PropertyInterface)
-		if ifname == "" {
-			return nil, nil
+	data, err := json.Marshal(allocation)
+	if err != nil {
+		return fmt.Errorf("failed to encode allocation %q: %w", allocation.Id, err)
+	}
+	dir := n.settings.allocationDir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create allocation dir %q: %w", dir, err)
+	}
+	name := netBWAllocationFile(allocation.Id)
+	removeStaleAllocations(dir, name, allocation)
+	if err := writeFileAtomic(filepath.Join(dir, name), data, 0o644); err != nil {
+		return err
+	}
+
+	if response.Envs == nil {
+		response.Envs = make(map[string]string)
+	}
+	response.Envs[n.envPrefix()+netBWAllocationEnvSuffix] = allocation.Id
+	return nil
+}
+
+// netBWAllocationFile returns the name of the file of allocation id.
+func netBWAllocationFile(id string) string {
+	return "netbw-" + invalidAnnotationChars.ReplaceAllString(id, "-") + ".json"
+}
+
+// removeStaleAllocations removes the allocation files in dir, other than
+// keep, of the same resource as allocation that list any of its instances.
+func removeStaleAllocations(dir, keep string, allocation netBWAllocation) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		klog.Warningf("netbw: failed to list allocation dir %q: %v", dir, err)
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == keep || !strings.HasPrefix(name, "netbw-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.Warningf("netbw: failed to read allocation file %q: %v", path, err)
+			continue
 		}
+		var old netBWAllocation
+		if err := json.Unmarshal(data, &old); err != nil {
+			klog.Warningf("netbw: failed to parse allocation file %q: %v", path, err)
+			continue
+		}
+		if old.Domain != allocation.Domain || old.Name != allocation.Name ||
+			!slices.ContainsFunc(old.Instances, func(id string) bool { return slices.Contains(allocation.Instances, id) }) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			klog.Warningf("netbw: failed to remove stale allocation file %q: %v", path, err)
+			continue
+		}
+		klog.V(2).Infof("netbw: removed allocation %q, superseded by %q", old.Id, allocation.Id)
+	}
+}
+
+// matchNetInterface returns the interface name of dev and the name of its
+// resource (see [matchInterfaceName]). ok is false for devices that are not
+// matching net interfaces.
+func matchNetInterface(dev udev.Device, matcher *regexp.Regexp) (ifname, name string, ok bool) {
+	if dev.Subsystem() != udev.NetSubsystem {
+		return "", "", false
+	}
 
-		matches := matcher.FindStringSubmatch(ifname)
-		if len(matches) == 0 {
+	ifname = dev.Property(udev.PropertyInterface)
+	if ifname == "" {
+		return "", "", false
+	}
+
+	name, ok = matchInterfaceName(ifname, matcher)
+	return ifname, name, ok
+}
+
+// matchInterfaceName returns the resource name for interface ifname: the
+// matcher's capture groups joined by "_". ok is false if ifname does not
+// match.
+func matchInterfaceName(ifname string, matcher *regexp.Regexp) (name string, ok bool) {
+	matches := matcher.FindStringSubmatch(ifname)
+	if len(matches) == 0 {
+		return "", false
+	}
+	return strings.Join(matches[1:], "_"), true
+}
+
+// NetBWMatcherTemplater returns a FromDevice function that produces a
+// ResourceTemplate for net devices whose INTERFACE property matches matcher.
+func NetBWMatcherTemplater(domain string, matcher *regexp.Regexp) FromDevice[*ResourceTemplate] {
+	return func(dev udev.Device) (*ResourceTemplate, error) {
+		_, name, ok := matchNetInterface(dev, matcher)
+		if !ok {
 			return nil, nil
 		}
 
-		ifname = strings.Join(matches[1:], "_")
-
 		return &ResourceTemplate{
 			Domain: domain,
-			Prefix: fmt.Sprintf("netbw-%s", ifname),
+			Prefix: fmt.Sprintf("netbw-%s", name),
 		}, nil
 	}
 }
 
 // NetBWMatcherInstances returns a FromDevice function that produces one
-// networkBandwidth instance per share (speed ÷ mbpsPerShare) for matching
-// net devices.
-func NetBWMatcherInstances(domain string, matcher *regexp.Regexp, mbpsPerShare uint) FromDevice[[]*networkBandwidth] {
+// networkBandwidth instance per share ((speed - reserved) ÷ mbpsPerShare) for
+// matching net devices.
+func NetBWMatcherInstances(domain string, matcher *regexp.Regexp, mbpsPerShare uint, disableTopologyHints bool, opts ...NetBWOption) FromDevice[[]*networkBandwidth] {
+	settings := &netBWSettings{}
+	for _, opt := range opts {
+		opt(settings)
+	}
 	return func(dev udev.Device) ([]*networkBandwidth, error) {
-		if dev.Subsystem() != udev.NetSubsystem {
-			return nil, nil
-		}
-
-		ifname := dev.Property(udev.PropertyInterface)
-		if ifname == "" {
+		ifname, name, ok := matchNetInterface(dev, matcher)
+		if !ok || mbpsPerShare == 0 {
 			return nil, nil
 		}
 
-		if !matcher.MatchString(ifname) {
-			return nil, nil
-		}
-
-		speedString := dev.SystemAttribute(udev.SysAttrSpeed)
-		if speedString == "" {
-			return nil, nil
-		}
-
-		speedMbps, err := strconv.Atoi(speedString)
-		if err != nil {
+		speedMbps := settings.speedMbps(dev)
+		if speedMbps <= settings.reservedMbps {
+			klog.V(2).Infof("interface %q: speed %d Mbps leaves nothing over the reserved %d Mbps", ifname, speedMbps, settings.reservedMbps)
 			return nil, nil
 		}
 
-		shares := speedMbps / int(mbpsPerShare)
+		shares := int((speedMbps - settings.reservedMbps) / mbpsPerShare)
 		if shares == 0 {
 			return nil, nil
 		}
@@ -102,9 +470,14 @@ func NetBWMatcherInstances(domain string, matcher *regexp.Regexp, mbpsPerShare u
 		instances := make([]*networkBandwidth, 0, shares)
 		for i := 0; i < shares; i++ {
 			instances = append(instances, &networkBandwidth{
-				ifname: ifname,
-				idx:    i,
-				dev:    dev,
+				domain:               domain,
+				ifname:               ifname,
+				name:                 name,
+				idx:                  i,
+				mbpsPerShare:         mbpsPerShare,
+				dev:                  dev,
+				settings:             settings,
+				disableTopologyHints: disableTopologyHints,
 			})
 		}
 
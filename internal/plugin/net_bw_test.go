@@ -2,11 +2,17 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
 var _ = Describe("NetBWMatcherTemplater", func() {
@@ -30,6 +36,14 @@ var _ = Describe("NetBWMatcherTemplater", func() {
 		Expect(tmpl).To(BeNil())
 	})
 
+	It("names the resource of a matcher without capture groups netbw-", func() {
+		for _, ifname := range []string{"eth0", "eth1"} {
+			tmpl, err := NetBWMatcherTemplater("ydb.tech", regexp.MustCompile(`eth\d`))(netDevice(ifname, "1000", "up"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl.Prefix).To(Equal("netbw-"))
+		}
+	})
+
 	It("returns nil when the interface name does not match", func() {
 		dev := netDevice("wlan0", "100", "up")
 		tmpl, err := NetBWMatcherTemplater("ydb.tech", matcher)(dev)
@@ -51,7 +65,7 @@ var _ = Describe("NetBWMatcherInstances", func() {
 	It("returns nil for a non-net device", func() {
 		matcher := regexp.MustCompile(`eth.*`)
 		dev := partitionDevice("sda1", "data_01")
-		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100)(dev)
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false)(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(instances).To(BeNil())
 	})
@@ -59,7 +73,7 @@ var _ = Describe("NetBWMatcherInstances", func() {
 	It("returns nil when the INTERFACE property is missing", func() {
 		matcher := regexp.MustCompile(`eth.*`)
 		dev := &mockDevice{subsystem: "net", properties: map[string]string{}, sysattrs: map[string]string{}}
-		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100)(dev)
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false)(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(instances).To(BeNil())
 	})
@@ -67,7 +81,7 @@ var _ = Describe("NetBWMatcherInstances", func() {
 	It("returns nil when the speed attribute is empty", func() {
 		matcher := regexp.MustCompile(`eth.*`)
 		dev := netDevice("eth0", "", "up")
-		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100)(dev)
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false)(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(instances).To(BeNil())
 	})
@@ -75,7 +89,7 @@ var _ = Describe("NetBWMatcherInstances", func() {
 	It("returns nil when shares would be zero (mbpsPerShare exceeds speed)", func() {
 		matcher := regexp.MustCompile(`eth.*`)
 		dev := netDevice("eth0", "100", "up")
-		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 1000)(dev)
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 1000, false)(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(instances).To(BeNil())
 	})
@@ -83,15 +97,158 @@ var _ = Describe("NetBWMatcherInstances", func() {
 	It("returns the correct number of shares", func() {
 		matcher := regexp.MustCompile(`eth.*`)
 		dev := netDevice("eth0", "1000", "up")
-		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100)(dev)
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false)(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(instances).To(HaveLen(10)) // 1000 Mbps / 100 MbpsPerShare = 10
 	})
 
+	It("returns nil when the driver reports an unknown speed", func() {
+		matcher := regexp.MustCompile(`eth.*`)
+		dev := netDevice("eth0", "-1", "up")
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false)(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(BeNil())
+	})
+
+	It("uses the fallback speed when sysfs reports none", func() {
+		matcher := regexp.MustCompile(`eth.*`)
+		dev := netDevice("eth0", "-1", "up")
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false, WithFallbackSpeedMbps(500))(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(5))
+	})
+
+	It("prefers the reported speed over the fallback", func() {
+		matcher := regexp.MustCompile(`eth.*`)
+		dev := netDevice("eth0", "1000", "up")
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false, WithFallbackSpeedMbps(500))(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(10))
+	})
+
+	It("subtracts the reserved bandwidth before computing shares", func() {
+		matcher := regexp.MustCompile(`eth.*`)
+		dev := netDevice("eth0", "1000", "up")
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false, WithReservedMbps(250))(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(7)) // (1000 - 250) / 100
+	})
+
+	It("returns nil when the reserved bandwidth covers the whole link", func() {
+		matcher := regexp.MustCompile(`eth.*`)
+		dev := netDevice("eth0", "1000", "up")
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false, WithReservedMbps(1000))(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(BeNil())
+	})
+
+	It("returns nil when mbpsPerShare is zero", func() {
+		matcher := regexp.MustCompile(`eth.*`)
+		dev := netDevice("eth0", "1000", "up")
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 0, false)(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(BeNil())
+	})
+
+	It("names env vars after the capture groups like the templater does", func() {
+		matcher := regexp.MustCompile(`^(eth)(\d+)$`)
+		dev := netDevice("eth0", "1000", "up")
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 1000, false)(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(1))
+		Expect(string(instances[0].Id())).To(Equal("eth0_0"))
+
+		resp, err := instances[0].Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_NETBW_ETH_0_ETH0_MBPS", "1000"))
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_NETBW_ETH_0_INTERFACES", "eth0"))
+	})
+
+	Context("with bond slaves", func() {
+		var (
+			sysfsRoot string
+			bond      *mockDevice
+		)
+
+		slave := func(name, speed string) {
+			dir := filepath.Join(sysfsRoot, "class/net", name)
+			Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "speed"), []byte(speed+"\n"), 0o644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			sysfsRoot = GinkgoT().TempDir()
+			slave("eth0", "10000")
+			slave("eth1", "10000")
+			slave("eth2", "25000") // not a slave
+			bond = netDevice("bond0", "", "up")
+			bond.sysattrs[udev.SysAttrBondingSlaves] = "eth0 eth1"
+		})
+
+		It("sums the speeds of the slaves", func() {
+			matcher := regexp.MustCompile(`bond.*`)
+			instances, err := NetBWMatcherInstances("ydb.tech", matcher, 1000, false, WithBondSlaves(NewNetBWBonds(sysfsRoot)))(bond)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(20))
+		})
+
+		It("ignores slaves that report no speed", func() {
+			slave("eth1", "-1")
+			matcher := regexp.MustCompile(`bond.*`)
+			instances, err := NetBWMatcherInstances("ydb.tech", matcher, 1000, false, WithBondSlaves(NewNetBWBonds(sysfsRoot)))(bond)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(10))
+		})
+
+		It("falls back when no slave is known", func() {
+			bond.sysattrs[udev.SysAttrBondingSlaves] = "eth7"
+			matcher := regexp.MustCompile(`bond.*`)
+			instances, err := NetBWMatcherInstances("ydb.tech", matcher, 1000, false,
+				WithBondSlaves(NewNetBWBonds(sysfsRoot)), WithFallbackSpeedMbps(3000))(bond)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(3))
+		})
+
+		It("uses the bond's own speed without a sysfs", func() {
+			bond.sysattrs[udev.SysAttrSpeed] = "5000"
+			matcher := regexp.MustCompile(`bond.*`)
+			instances, err := NetBWMatcherInstances("ydb.tech", matcher, 1000, false)(bond)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(5))
+		})
+
+		Describe("Related", func() {
+			var bonds *NetBWBonds
+
+			BeforeEach(func() {
+				slave("bond0", "20000") // puts the bond in sysfs
+				bonds = NewNetBWBonds(sysfsRoot)
+				_, err := NetBWMatcherInstances("ydb.tech", regexp.MustCompile(`bond.*`), 1000, false, WithBondSlaves(bonds))(bond)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns the bond for the events of its slaves", func() {
+				Expect(bonds.Related(netDevice("eth1", "10000", "down"))).To(Equal([]udev.Device{bond}))
+				Expect(bonds.Related(netDevice("eth2", "25000", "up"))).To(BeEmpty())
+				Expect(bonds.Related(bond)).To(BeEmpty())
+			})
+
+			It("returns the bond for a slave enslaved since it was measured", func() {
+				Expect(os.Symlink("../bond0", filepath.Join(sysfsRoot, "class/net/eth2/master"))).To(Succeed())
+				Expect(bonds.Related(netDevice("eth2", "25000", "up"))).To(Equal([]udev.Device{bond}))
+			})
+
+			It("ignores the bond once it is gone from sysfs", func() {
+				Expect(os.RemoveAll(filepath.Join(sysfsRoot, "class/net/bond0"))).To(Succeed())
+				Expect(bonds.Related(netDevice("eth1", "10000", "down"))).To(BeEmpty())
+			})
+		})
+	})
+
 	It("assigns sequential IDs in ifname_N format", func() {
 		matcher := regexp.MustCompile(`eth.*`)
 		dev := netDevice("eth0", "1000", "up")
-		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100)(dev)
+		instances, err := NetBWMatcherInstances("ydb.tech", matcher, 100, false)(dev)
 		Expect(err).NotTo(HaveOccurred())
 		for i, inst := range instances {
 			Expect(string(inst.Id())).To(Equal(fmt.Sprintf("eth0_%d", i)))
@@ -128,22 +285,207 @@ var _ = Describe("networkBandwidth", func() {
 	})
 
 	Describe("TopologyHints", func() {
-		It("always returns nil", func() {
+		It("returns nil without a PCI parent", func() {
 			dev := netDevice("eth0", "1000", "up")
 			n := &networkBandwidth{ifname: "eth0", idx: 0, dev: dev}
 			Expect(n.TopologyHints()).To(BeNil())
 		})
-	})
 
-	Describe("Allocate", func() {
-		It("returns an empty response (soft resource, no devices passed through)", func() {
+		It("returns the NUMA node of the PCI parent", func() {
 			dev := netDevice("eth0", "1000", "up")
+			dev.parent = pciDevice("0000:3b:00.0", "1")
 			n := &networkBandwidth{ifname: "eth0", idx: 0, dev: dev}
+			hints := n.TopologyHints()
+			Expect(hints).NotTo(BeNil())
+			Expect(hints.Nodes).To(HaveLen(1))
+			Expect(hints.Nodes[0].ID).To(BeEquivalentTo(1))
+		})
+
+		It("returns nil when topology hints are disabled", func() {
+			dev := netDevice("eth0", "1000", "up")
+			dev.parent = pciDevice("0000:3b:00.0", "1")
+			n := &networkBandwidth{ifname: "eth0", idx: 0, dev: dev, disableTopologyHints: true}
+			Expect(n.TopologyHints()).To(BeNil())
+		})
+	})
+
+	Describe("Allocate", func() {
+		var n *networkBandwidth
+
+		BeforeEach(func() {
+			n = &networkBandwidth{
+				domain:       "ydb.tech",
+				ifname:       "eth0",
+				idx:          0,
+				mbpsPerShare: 1000,
+				dev:          netDevice("eth0", "10000", "up"),
+			}
+		})
+
+		It("passes no devices or mounts through", func() {
 			resp, err := n.Allocate(context.Background())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(resp.Devices).To(BeEmpty())
 			Expect(resp.Mounts).To(BeEmpty())
-			Expect(resp.Envs).To(BeEmpty())
 		})
+
+		It("carries the share bandwidth and interface as env vars", func() {
+			resp, err := n.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Envs).To(Equal(map[string]string{
+				"YDB_TECH_NETBW_ETH0_INTERFACES": "eth0",
+				"YDB_TECH_NETBW_ETH0_ETH0_MBPS":  "1000",
+			}))
+		})
+
+		It("carries the share bandwidth and interface as annotations", func() {
+			resp, err := n.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Annotations).To(Equal(map[string]string{
+				"ydb.tech/netbw-eth0.interfaces": "eth0",
+				"ydb.tech/netbw-eth0.eth0.mbps":  "1000",
+			}))
+		})
+
+		It("sums the bandwidth of several shares merged into one container", func() {
+			other := *n
+			other.idx = 1
+			first, err := n.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			second, err := other.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			merged := mergeResponses(mergePolicy(n), first, second)
+			Expect(merged.Envs).To(HaveKeyWithValue("YDB_TECH_NETBW_ETH0_ETH0_MBPS", "2000"))
+			Expect(merged.Envs).To(HaveKeyWithValue("YDB_TECH_NETBW_ETH0_INTERFACES", "eth0"))
+			Expect(merged.Annotations).To(HaveKeyWithValue("ydb.tech/netbw-eth0.eth0.mbps", "2000"))
+		})
+
+		It("keeps the bandwidth of the interfaces of one resource apart", func() {
+			n.name = "data"
+			other := *n
+			other.ifname = "eth1"
+			other.dev = netDevice("eth1", "10000", "up")
+			first, err := n.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			second, err := other.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			third, err := other.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			merged := mergeResponses(mergePolicy(n), first, second, third)
+			Expect(merged.Envs).To(Equal(map[string]string{
+				"YDB_TECH_NETBW_DATA_INTERFACES": "eth0,eth1",
+				"YDB_TECH_NETBW_DATA_ETH0_MBPS":  "1000",
+				"YDB_TECH_NETBW_DATA_ETH1_MBPS":  "2000",
+			}))
+			Expect(merged.Annotations).To(Equal(map[string]string{
+				"ydb.tech/netbw-data.interfaces": "eth0,eth1",
+				"ydb.tech/netbw-data.eth0.mbps":  "1000",
+				"ydb.tech/netbw-data.eth1.mbps":  "2000",
+			}))
+		})
+
+		It("sums only the keys it passes", func() {
+			Expect(n.mergeKind("YDB_TECH_NETBW_ETH0_ETH0_MBPS")).To(Equal(mergeSum))
+			Expect(n.mergeKind("YDB_TECH_NETBW_ETH1_ETH1_MBPS")).To(Equal(mergeOverwrite))
+			Expect(n.mergeKind("APP_LIMIT_MBPS")).To(Equal(mergeOverwrite))
+			Expect(n.mergeKind("example.com/limit.mbps")).To(Equal(mergeOverwrite))
+		})
+
+		Describe("with an allocation dir", func() {
+			var (
+				dir    string
+				shares []Instance
+			)
+
+			BeforeEach(func() {
+				dir = GinkgoT().TempDir()
+				n.settings = &netBWSettings{allocationDir: dir}
+				shares = nil
+				for idx := range 3 {
+					share := *n
+					share.idx = idx
+					shares = append(shares, &healthOverride{Instance: &share, health: Healthy{}})
+				}
+			})
+
+			readAllocation := func(name string) netBWAllocation {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				Expect(err).NotTo(HaveOccurred())
+				var allocation netBWAllocation
+				Expect(json.Unmarshal(data, &allocation)).To(Succeed())
+				return allocation
+			}
+
+			It("writes no file on Allocate", func() {
+				_, err := n.Allocate(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.ReadDir(dir)).To(BeEmpty())
+			})
+
+			It("writes one file per container allocation with the summed bandwidth", func() {
+				resp := &pluginapi.ContainerAllocateResponse{}
+				Expect(recordAllocation([]Instance{shares[1], shares[0]}, resp)).To(Succeed())
+
+				Expect(os.ReadDir(dir)).To(HaveLen(1))
+				allocation := readAllocation("netbw-eth0_0.json")
+				Expect(allocation.Id).To(Equal("eth0_0"))
+				Expect(allocation.Domain).To(Equal("ydb.tech"))
+				Expect(allocation.Name).To(Equal("eth0"))
+				Expect(allocation.Instances).To(Equal([]string{"eth0_0", "eth0_1"}))
+				Expect(allocation.Interfaces).To(Equal(map[string]uint{"eth0": 2000}))
+				Expect(allocation.Mbps).To(BeEquivalentTo(2000))
+				Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_NETBW_ETH0_ALLOCATION", "eth0_0"))
+			})
+
+			It("removes the files of earlier allocations of the same shares", func() {
+				Expect(recordAllocation([]Instance{shares[0], shares[1]}, &pluginapi.ContainerAllocateResponse{})).To(Succeed())
+				Expect(recordAllocation([]Instance{shares[2]}, &pluginapi.ContainerAllocateResponse{})).To(Succeed())
+				Expect(recordAllocation([]Instance{shares[1]}, &pluginapi.ContainerAllocateResponse{})).To(Succeed())
+
+				entries, err := os.ReadDir(dir)
+				Expect(err).NotTo(HaveOccurred())
+				var names []string
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				Expect(names).To(ConsistOf("netbw-eth0_1.json", "netbw-eth0_2.json"))
+				Expect(readAllocation("netbw-eth0_1.json").Mbps).To(BeEquivalentTo(1000))
+			})
+
+			It("fails when the allocation file cannot be written", func() {
+				file := filepath.Join(GinkgoT().TempDir(), "not-a-dir")
+				Expect(os.WriteFile(file, nil, 0o644)).To(Succeed())
+				n.settings.allocationDir = file
+				Expect(recordAllocation(shares[:1], &pluginapi.ContainerAllocateResponse{})).NotTo(Succeed())
+			})
+		})
+	})
+})
+
+var _ = Describe("mergeValue", func() {
+	DescribeTable("combines values of the same key",
+		func(kind mergeKind, old, value, expected string) {
+			Expect(mergeValue(kind, old, value)).To(Equal(expected))
+		},
+		Entry("sums quantities", mergeSum, "1000", "500", "1500"),
+		Entry("sums quantities with a unit", mergeSum, "1000M", "500M", "1500M"),
+		Entry("overwrites on mismatched units", mergeSum, "1G", "500M", "500M"),
+		Entry("overwrites non-numeric values", mergeSum, "n/a", "500", "500"),
+		Entry("overwrites by default", mergeOverwrite, "/a", "/b", "/b"),
+		Entry("joins lists", mergeList, "mlx5_0", "mlx5_1", "mlx5_0,mlx5_1"),
+		Entry("drops duplicates from lists", mergeList, "mlx5_0,mlx5_1", "mlx5_1", "mlx5_0,mlx5_1"),
+	)
+})
+
+var _ = Describe("mergeResponses", func() {
+	It("overwrites every key without a policy", func() {
+		merged := mergeResponses(nil,
+			&pluginapi.ContainerAllocateResponse{Envs: map[string]string{"X_MBPS": "1000"}},
+			&pluginapi.ContainerAllocateResponse{Envs: map[string]string{"X_MBPS": "500"}},
+		)
+		Expect(merged.Envs).To(HaveKeyWithValue("X_MBPS", "500"))
 	})
 })
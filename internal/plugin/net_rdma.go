@@ -3,39 +3,113 @@ package plugin
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"regexp"
-	"strings"
+	"strconv"
 
-	"github.com/Mellanox/rdmamap"
 	"github.com/ydb-platform/udev-manager/internal/udev"
 	"k8s.io/klog/v2"
 
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
+// NetRdmaSettings is what the templater and the instances of netrdma
+// resources share: the topology RDMA devices are resolved through, which
+// remembers their netdevs, and the optional behaviour set by
+// [NetRdmaOption]s.
+type NetRdmaSettings struct {
+	topology   RdmaTopology
+	ipFamily   string // family of the RoCE v2 GID passed to containers
+	mountSysfs bool   // mount /sys/class/infiniband/<dev> into containers
+}
+
+// NetRdmaOption configures [NetRdmaSettings].
+type NetRdmaOption func(*NetRdmaSettings)
+
+// WithRdmaIPFamily selects the IP family (IPFamilyIPv4 or IPFamilyIPv6) of
+// the RoCE v2 GID passed to containers. The default is IPFamilyIPv4.
+func WithRdmaIPFamily(family string) NetRdmaOption {
+	return func(s *NetRdmaSettings) { s.ipFamily = family }
+}
+
+// WithRdmaSysfsMount mounts the sysfs subtree of the RDMA device read-only
+// into containers, for tools that inspect ports and counters.
+func WithRdmaSysfsMount() NetRdmaOption {
+	return func(s *NetRdmaSettings) { s.mountSysfs = true }
+}
+
+// NewNetRdmaSettings resolves RDMA devices through topology, e.g.
+// [NewSysfsRdmaTopology].
+func NewNetRdmaSettings(topology RdmaTopology, opts ...NetRdmaOption) *NetRdmaSettings {
+	settings := &NetRdmaSettings{topology: topology, ipFamily: IPFamilyIPv4}
+	for _, opt := range opts {
+		opt(settings)
+	}
+	return settings
+}
+
+// Keys of the environment passed to containers with netrdma instances. The
+// value of <DOMAIN>_RDMA_<NAME>_DEVICES lists the RDMA devices of all
+// instances of the resource in the container, and the others, prefixed by
+// <DOMAIN>_RDMA_<NAME>_<DEVICE>, describe one of them, so that the instances
+// of several netdevs of one resource do not overwrite each other.
+const (
+	rdmaPortEnvSuffix      = "_PORT"
+	rdmaLinkLayerEnvSuffix = "_LINK_LAYER"
+	rdmaGidIndexEnvSuffix  = "_GID_INDEX"
+	rdmaGidEnvSuffix       = "_GID"
+
+	rdmaDevicesEnvSuffix = "_DEVICES"
+
+	rdmaLinkLayerEthernet = "Ethernet"
+)
+
 type netRdma struct {
 	domain            string
 	ifname            string
+	name              string // resource name part derived from the matcher's capture groups
 	idx               int
 	dev               udev.Device
+	rdmaDevice        string // e.g. "mlx5_0"; empty if unknown
 	associatedDevices []string
+	topology          RdmaTopology
+	ipFamily          string
+	mountSysfs        bool
+
+	disableTopologyHints bool
 }
 
 func (n *netRdma) Id() Id {
 	return Id(fmt.Sprintf("%s_%d", n.ifname, n.idx))
 }
 
+func (n *netRdma) udevDevices() []udev.Device { return []udev.Device{n.dev} }
+
+// Health is evaluated from the ports of the RDMA device: the netdev can be up
+// while the port is DOWN or has no GID. Without a known RDMA device it falls
+// back to the netdev's operstate.
 func (n *netRdma) Health() Health {
-	if n.dev.SystemAttribute(udev.SysAttrOperstate) == "up" {
+	if n.rdmaDevice != "" && n.topology != nil {
+		return rdmaHealth(n.topology, n.rdmaDevice)
+	}
+	operstate := n.dev.SystemAttribute(udev.SysAttrOperstate)
+	if operstate == "up" {
 		return Healthy{}
 	}
-	return Unhealthy{}
+	return Unhealthy{Reason: fmt.Sprintf("%s is %s", udev.Sysname(n.dev), operstate)}
 }
 
+// TopologyHints reports the NUMA node of the interface's PCI parent.
 func (n *netRdma) TopologyHints() *pluginapi.TopologyInfo {
-	return nil
+	if n.disableTopologyHints {
+		return nil
+	}
+	return numaTopology(pciNumaNode(n.dev))
 }
 
+// Allocate passes the RDMA character devices and describes the RDMA device
+// in the environment: its name, the port to use, the port's link layer and,
+// for RoCE, the RoCE v2 GID of the configured IP family.
 func (n *netRdma) Allocate(context.Context) (*pluginapi.ContainerAllocateResponse, error) {
 	response := &pluginapi.ContainerAllocateResponse{}
 
@@ -46,73 +120,173 @@ func (n *netRdma) Allocate(context.Context) (*pluginapi.ContainerAllocateRespons
 			Permissions:   "rw",
 		})
 	}
+
+	if n.rdmaDevice != "" {
+		response.Envs = n.envs()
+		if n.mountSysfs {
+			path := filepath.Join(DefaultSysfsRoot, "class", "infiniband", n.rdmaDevice)
+			response.Mounts = append(response.Mounts, &pluginapi.Mount{
+				ContainerPath: path,
+				HostPath:      path,
+				ReadOnly:      true,
+			})
+		}
+	}
 	klog.V(2).Infof("%+v", response)
 
 	return response, nil
 
 }
 
-// NetRdmaMatcherTemplater returns a FromDevice function that produces a
-// ResourceTemplate for RDMA-capable net devices whose INTERFACE matches
-// matcher.
-func NetRdmaMatcherTemplater(domain string, matcher *regexp.Regexp) FromDevice[*ResourceTemplate] {
-	return func(dev udev.Device) (*ResourceTemplate, error) {
-		if dev.Subsystem() != udev.NetSubsystem {
-			return nil, nil
+// envs lists the RDMA device of the instance and describes it under keys of
+// its own. The port is the first usable one, or the first one if none is
+// usable.
+func (n *netRdma) envs() map[string]string {
+	envs := map[string]string{n.envPrefix() + rdmaDevicesEnvSuffix: n.rdmaDevice}
+	if n.topology == nil {
+		return envs
+	}
+
+	ports := n.topology.Ports(n.rdmaDevice)
+	if len(ports) == 0 {
+		return envs
+	}
+	prefix := n.envPrefix() + "_" + sanitizeEnv(n.rdmaDevice)
+	port := ports[0]
+	for _, p := range ports {
+		if p.Usable() {
+			port = p
+			break
 		}
+	}
+	envs[prefix+rdmaPortEnvSuffix] = port.Num
+	envs[prefix+rdmaLinkLayerEnvSuffix] = port.LinkLayer
 
-		ifname := dev.Property(udev.PropertyInterface)
-		if ifname == "" {
-			return nil, nil
+	if port.LinkLayer != rdmaLinkLayerEthernet {
+		envs[prefix+rdmaGidIndexEnvSuffix] = "0"
+		envs[prefix+rdmaGidEnvSuffix] = port.Gid
+		return envs
+	}
+	if gid, ok := roceV2Gid(n.topology.Gids(n.rdmaDevice, port.Num), n.ipFamily); ok {
+		envs[prefix+rdmaGidIndexEnvSuffix] = strconv.Itoa(gid.Index)
+		envs[prefix+rdmaGidEnvSuffix] = gid.Gid
+	} else {
+		klog.Warningf("rdma %s port %s has no RoCE v2 %s GID", n.rdmaDevice, port.Num, n.ipFamily)
+	}
+	return envs
+}
+
+func (n *netRdma) envPrefix() string {
+	return sanitizeEnv(n.domain) + "_RDMA_" + sanitizeEnv(n.resourceName())
+}
+
+// mergeKind implements [keyMerger]: the RDMA devices of all instances of the
+// resource in the container are listed.
+func (n *netRdma) mergeKind(key string) mergeKind {
+	if key == n.envPrefix()+rdmaDevicesEnvSuffix {
+		return mergeList
+	}
+	return mergeOverwrite
+}
+
+// resourceName returns the name the instance's resource was derived from,
+// which keys its env vars.
+func (n *netRdma) resourceName() string {
+	if n.name == "" {
+		return n.ifname
+	}
+	return n.name
+}
+
+// matchRdmaDevice returns the interface name, resource name and RDMA device
+// for dev, which is a net, infiniband or infiniband_verbs device. RDMA devices
+// are mapped to the first of their netdevs that matches matcher. ok is false
+// for devices that do not match.
+func matchRdmaDevice(dev udev.Device, matcher *regexp.Regexp, topology RdmaTopology) (ifname, name, rdmaDev string, ok bool, err error) {
+	switch dev.Subsystem() {
+	case udev.NetSubsystem:
+		ifname, name, ok = matchNetInterface(dev, matcher)
+		if !ok {
+			return "", "", "", false, nil
 		}
+		rdmaDev, err = topology.DeviceForNetdev(ifname)
+		return ifname, name, rdmaDev, true, err
+	case udev.InfinibandSubsystem:
+		rdmaDev = udev.Sysname(dev)
+	case udev.InfinibandVerbsSubsystem:
+		rdmaDev = dev.SystemAttribute(udev.SysAttrIbdev)
+	}
+	if rdmaDev == "" {
+		return "", "", "", false, nil
+	}
+	for _, ifname := range topology.NetdevsForDevice(rdmaDev) {
+		if name, ok := matchInterfaceName(ifname, matcher); ok {
+			return ifname, name, rdmaDev, true, nil
+		}
+	}
+	return "", "", "", false, nil
+}
 
-		matches := matcher.FindStringSubmatch(ifname)
-		if len(matches) == 0 {
+// NetRdmaMatcherTemplater returns a FromDevice function that produces a
+// ResourceTemplate for RDMA-capable net devices whose INTERFACE matches
+// matcher, and for the infiniband and infiniband_verbs devices backing them.
+func NetRdmaMatcherTemplater(domain string, matcher *regexp.Regexp, settings *NetRdmaSettings) FromDevice[*ResourceTemplate] {
+	return func(dev udev.Device) (*ResourceTemplate, error) {
+		_, name, rdmaDev, ok, err := matchRdmaDevice(dev, matcher, settings.topology)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || rdmaDev == "" {
 			return nil, nil
 		}
 
-		ifname = strings.Join(matches[1:], "_")
-
 		return &ResourceTemplate{
 			Domain: domain,
-			Prefix: fmt.Sprintf("netrdma-%s", ifname),
+			Prefix: fmt.Sprintf("netrdma-%s", name),
 		}, nil
 	}
 }
 
 // NetRdmaMatcherInstances returns a FromDevice function that produces
 // resourcesCount netRdma instances for each matching RDMA-capable net device.
-func NetRdmaMatcherInstances(domain string, matcher *regexp.Regexp, resourcesCount int) FromDevice[[]*netRdma] {
+// A netdev whose RDMA device is not there yet yields no instances; they are
+// created once the infiniband or infiniband_verbs device appears. Events of
+// these devices map to the same instances, so port state changes are
+// re-evaluated.
+func NetRdmaMatcherInstances(domain string, matcher *regexp.Regexp, resourcesCount int, disableTopologyHints bool, settings *NetRdmaSettings) FromDevice[[]*netRdma] {
 	return func(dev udev.Device) ([]*netRdma, error) {
-		if dev.Subsystem() != udev.NetSubsystem {
-			return nil, nil
+		ifname, name, rdmaDevice, ok, err := matchRdmaDevice(dev, matcher, settings.topology)
+		if err != nil {
+			return nil, err
 		}
-
-		ifname := dev.Property(udev.PropertyInterface)
-		if ifname == "" {
+		if !ok {
 			return nil, nil
 		}
-
-		if !matcher.MatchString(ifname) {
+		if rdmaDevice == "" {
+			klog.V(2).Infof("no rdma device for network device %s yet", ifname)
 			return nil, nil
 		}
 
-		rdmaDevice, err := rdmamap.GetRdmaDeviceForNetdevice(ifname)
+		rdmaCharDevices, err := settings.topology.CharDevices(rdmaDevice)
 		if err != nil {
-			klog.Errorf("fail to get rdma devices for network device: %s %v", ifname, err)
-			return nil, nil
+			return nil, err
 		}
-		rdmaCharDevices := rdmamap.GetRdmaCharDevices(rdmaDevice)
 		klog.Infof("found rdma character devices for ifname: %s devices: %v", ifname, rdmaCharDevices)
 
 		instances := make([]*netRdma, 0, resourcesCount)
 		for i := 0; i < resourcesCount; i++ {
 			instances = append(instances, &netRdma{
-				domain:            domain,
-				ifname:            ifname,
-				idx:               i,
-				dev:               dev,
-				associatedDevices: rdmaCharDevices,
+				domain:               domain,
+				ifname:               ifname,
+				name:                 name,
+				idx:                  i,
+				dev:                  dev,
+				rdmaDevice:           rdmaDevice,
+				associatedDevices:    rdmaCharDevices,
+				topology:             settings.topology,
+				ipFamily:             settings.ipFamily,
+				mountSysfs:           settings.mountSysfs,
+				disableTopologyHints: disableTopologyHints,
 			})
 		}
 
@@ -2,10 +2,13 @@ package plugin
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"regexp"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 
 	"github.com/ydb-platform/udev-manager/internal/udev"
 )
@@ -19,33 +22,146 @@ var _ = Describe("NetRdmaMatcherTemplater", func() {
 
 	It("returns nil for a non-net device", func() {
 		dev := partitionDevice("sda1", "data_01")
-		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher)(dev)
+		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher, NewNetRdmaSettings(NewFakeRdmaTopology()))(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(tmpl).To(BeNil())
 	})
 
 	It("returns nil when the INTERFACE property is missing", func() {
 		dev := &mockDevice{subsystem: udev.NetSubsystem, properties: map[string]string{}}
-		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher)(dev)
+		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher, NewNetRdmaSettings(NewFakeRdmaTopology()))(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(tmpl).To(BeNil())
 	})
 
 	It("returns nil when the interface name does not match", func() {
 		dev := netDevice("eth0", "1000", "up")
-		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher)(dev)
+		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher, NewNetRdmaSettings(NewFakeRdmaTopology()))(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tmpl).To(BeNil())
+	})
+
+	It("returns nil for a netdev without an RDMA device", func() {
+		dev := netDevice("ib0", "100000", "up")
+		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher, NewNetRdmaSettings(NewFakeRdmaTopology()))(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(tmpl).To(BeNil())
 	})
 
 	It("returns a template using the capture group as suffix", func() {
 		dev := netDevice("ib0", "100000", "up")
-		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher)(dev)
+		topology := NewFakeRdmaTopology().AddDevice("mlx5_0", []string{"ib0"})
+		tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher, NewNetRdmaSettings(topology))(dev)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(tmpl).NotTo(BeNil())
 		Expect(tmpl.Domain).To(Equal("ydb.tech"))
 		Expect(tmpl.Prefix).To(Equal("netrdma-0"))
 	})
+
+	Context("with an infiniband device", func() {
+		var (
+			root     string
+			settings *NetRdmaSettings
+		)
+
+		BeforeEach(func() {
+			root = GinkgoT().TempDir()
+			settings = NewNetRdmaSettings(NewSysfsRdmaTopology(root))
+		})
+
+		It("maps it to the template of its netdev", func() {
+			fakeRdmaSysfs(root, "mlx5_0", "ib0", "4: ACTIVE", "5: LinkUp", testGid)
+			tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher, settings)(infinibandDevice("mlx5_0"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).NotTo(BeNil())
+			Expect(tmpl.Prefix).To(Equal("netrdma-0"))
+		})
+
+		It("returns nil when its netdev does not match", func() {
+			fakeRdmaSysfs(root, "mlx5_0", "eth0", "4: ACTIVE", "5: LinkUp", testGid)
+			tmpl, err := NetRdmaMatcherTemplater("ydb.tech", matcher, settings)(infinibandDevice("mlx5_0"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+		})
+
+		It("maps its removal after sysfs is gone", func() {
+			fakeRdmaSysfs(root, "mlx5_0", "ib0", "4: ACTIVE", "5: LinkUp", testGid)
+			templater := NetRdmaMatcherTemplater("ydb.tech", matcher, settings)
+			_, err := templater(netDevice("ib0", "100000", "up"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.RemoveAll(filepath.Join(root, "class"))).To(Succeed())
+			tmpl, err := templater(infinibandDevice("mlx5_0"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).NotTo(BeNil())
+			Expect(tmpl.Prefix).To(Equal("netrdma-0"))
+		})
+	})
+})
+
+var _ = Describe("NetRdmaMatcherInstances", func() {
+	var (
+		topology *FakeRdmaTopology
+		matcher  *regexp.Regexp
+		settings *NetRdmaSettings
+	)
+
+	BeforeEach(func() {
+		topology = NewFakeRdmaTopology()
+		matcher = regexp.MustCompile(`ib(.*)`)
+		settings = NewNetRdmaSettings(topology)
+	})
+
+	It("returns nil for a netdev without an RDMA device", func() {
+		instances, err := NetRdmaMatcherInstances("ydb.tech", matcher, 2, false, settings)(netDevice("ib0", "100000", "up"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(BeNil())
+	})
+
+	It("passes the character devices of the RDMA device", func() {
+		topology.AddDevice("mlx5_0", []string{"ib0"}, "/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm")
+		instances, err := NetRdmaMatcherInstances("ydb.tech", matcher, 2, false, settings)(netDevice("ib0", "100000", "up"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(2))
+
+		resp, err := instances[0].Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Devices).To(HaveLen(2))
+		Expect(resp.Devices[0].HostPath).To(Equal("/dev/infiniband/uverbs0"))
+		Expect(resp.Devices[1].HostPath).To(Equal("/dev/infiniband/rdma_cm"))
+	})
+
+	It("produces the same instances for the netdev, infiniband and verbs devices", func() {
+		topology.AddDevice("mlx5_0", []string{"ib0"}, "/dev/infiniband/uverbs0")
+		mapper := NetRdmaMatcherInstances("ydb.tech", matcher, 2, false, settings)
+
+		fromNetdev, err := mapper(netDevice("ib0", "100000", "up"))
+		Expect(err).NotTo(HaveOccurred())
+		fromRdma, err := mapper(infinibandDevice("mlx5_0"))
+		Expect(err).NotTo(HaveOccurred())
+		fromVerbs, err := mapper(infinibandVerbsDevice("uverbs0", "mlx5_0"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fromNetdev).To(HaveLen(2))
+		Expect(fromRdma).To(HaveLen(2))
+		Expect(fromVerbs).To(HaveLen(2))
+		for i := range fromNetdev {
+			Expect(fromRdma[i].Id()).To(Equal(fromNetdev[i].Id()))
+			Expect(fromVerbs[i].Id()).To(Equal(fromNetdev[i].Id()))
+			Expect(fromVerbs[i].rdmaDevice).To(Equal("mlx5_0"))
+		}
+	})
+
+	It("evaluates health from the topology's ports", func() {
+		topology.AddDevice("mlx5_0", []string{"ib0"})
+		instances, err := NetRdmaMatcherInstances("ydb.tech", matcher, 1, false, settings)(netDevice("ib0", "100000", "up"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+
+		topology.SetPorts("mlx5_0", RdmaPort{Num: "1", State: "ACTIVE", PhysState: "LinkUp", Gid: testGid})
+		Expect(instances[0].Health()).To(BeAssignableToTypeOf(Healthy{}))
+	})
 })
 
 var _ = Describe("netRdma", func() {
@@ -68,12 +184,57 @@ var _ = Describe("netRdma", func() {
 			n := &netRdma{domain: "ydb.tech", ifname: "ib0", idx: 0, dev: dev}
 			Expect(n.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
 		})
+
+		Context("with a known RDMA device", func() {
+			var root string
+
+			BeforeEach(func() {
+				root = GinkgoT().TempDir()
+			})
+
+			It("is Unhealthy when the port is down although operstate is up", func() {
+				fakeRdmaSysfs(root, "mlx5_0", "ib0", "1: DOWN", "3: Disabled", testGid)
+				n := &netRdma{ifname: "ib0", dev: netDevice("ib0", "100000", "up"), rdmaDevice: "mlx5_0", topology: NewSysfsRdmaTopology(root)}
+				Expect(n.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+			})
+
+			It("is Healthy when a port is active with a GID", func() {
+				fakeRdmaSysfs(root, "mlx5_0", "ib0", "4: ACTIVE", "5: LinkUp", testGid)
+				n := &netRdma{ifname: "ib0", dev: netDevice("ib0", "100000", "up"), rdmaDevice: "mlx5_0", topology: NewSysfsRdmaTopology(root)}
+				Expect(n.Health()).To(BeAssignableToTypeOf(Healthy{}))
+			})
+
+			It("follows port state changes", func() {
+				fakeRdmaSysfs(root, "mlx5_0", "ib0", "2: INIT", "5: LinkUp", testGid)
+				n := &netRdma{ifname: "ib0", dev: netDevice("ib0", "100000", "up"), rdmaDevice: "mlx5_0", topology: NewSysfsRdmaTopology(root)}
+				Expect(n.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+
+				writeSysfsFile(root, "class/infiniband/mlx5_0/ports/1/state", "4: ACTIVE")
+				Expect(n.Health()).To(BeAssignableToTypeOf(Healthy{}))
+			})
+		})
 	})
 
 	Describe("TopologyHints", func() {
-		It("always returns nil", func() {
+		It("returns nil without a PCI parent", func() {
+			dev := netDevice("ib0", "100000", "up")
+			n := &netRdma{ifname: "ib0", idx: 0, dev: dev}
+			Expect(n.TopologyHints()).To(BeNil())
+		})
+
+		It("returns the NUMA node of the PCI parent", func() {
 			dev := netDevice("ib0", "100000", "up")
+			dev.parent = pciDevice("0000:af:00.0", "0")
 			n := &netRdma{ifname: "ib0", idx: 0, dev: dev}
+			hints := n.TopologyHints()
+			Expect(hints).NotTo(BeNil())
+			Expect(hints.Nodes[0].ID).To(BeEquivalentTo(0))
+		})
+
+		It("returns nil when topology hints are disabled", func() {
+			dev := netDevice("ib0", "100000", "up")
+			dev.parent = pciDevice("0000:af:00.0", "0")
+			n := &netRdma{ifname: "ib0", idx: 0, dev: dev, disableTopologyHints: true}
 			Expect(n.TopologyHints()).To(BeNil())
 		})
 	})
@@ -107,5 +268,167 @@ var _ = Describe("netRdma", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(resp.Devices).To(BeEmpty())
 		})
+
+		Context("with a known RDMA device", func() {
+			const (
+				ipv4Gid = "0000:0000:0000:0000:0000:ffff:0a00:0001"
+				ipv6Gid = "2001:0db8:0000:0000:0000:0000:0000:0001"
+			)
+
+			var (
+				topology *FakeRdmaTopology
+				n        *netRdma
+			)
+
+			BeforeEach(func() {
+				topology = NewFakeRdmaTopology().
+					AddDevice("mlx5_0", []string{"eth0"}).
+					SetPorts("mlx5_0", RdmaPort{Num: "1", State: "ACTIVE", PhysState: "LinkUp", LinkLayer: "Ethernet", Gid: testGid}).
+					SetGids("mlx5_0", "1",
+						RdmaGid{Index: 0, Gid: testGid, Type: "IB/RoCE v1"},
+						RdmaGid{Index: 1, Gid: testGid, Type: "RoCE v2"},
+						RdmaGid{Index: 2, Gid: ipv4Gid, Type: "IB/RoCE v1"},
+						RdmaGid{Index: 3, Gid: ipv4Gid, Type: "RoCE v2"},
+						RdmaGid{Index: 5, Gid: ipv6Gid, Type: "RoCE v2"},
+					)
+				n = &netRdma{
+					domain:     "ydb.tech",
+					ifname:     "eth0",
+					name:       "0",
+					dev:        netDevice("eth0", "100000", "up"),
+					rdmaDevice: "mlx5_0",
+					topology:   topology,
+					ipFamily:   IPFamilyIPv4,
+				}
+			})
+
+			It("describes the device, port and RoCE v2 GID of the IP family", func() {
+				resp, err := n.Allocate(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Envs).To(Equal(map[string]string{
+					"YDB_TECH_RDMA_0_DEVICES":           "mlx5_0",
+					"YDB_TECH_RDMA_0_MLX5_0_PORT":       "1",
+					"YDB_TECH_RDMA_0_MLX5_0_LINK_LAYER": "Ethernet",
+					"YDB_TECH_RDMA_0_MLX5_0_GID_INDEX":  "3",
+					"YDB_TECH_RDMA_0_MLX5_0_GID":        ipv4Gid,
+				}))
+			})
+
+			It("selects an IPv6 GID for the ipv6 family", func() {
+				n.ipFamily = IPFamilyIPv6
+				resp, err := n.Allocate(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_MLX5_0_GID_INDEX", "5"))
+				Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_MLX5_0_GID", ipv6Gid))
+			})
+
+			It("omits the GID when the family has no RoCE v2 GID", func() {
+				topology.SetGids("mlx5_0", "1", RdmaGid{Index: 0, Gid: testGid, Type: "RoCE v2"})
+				resp, err := n.Allocate(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_MLX5_0_PORT", "1"))
+				Expect(resp.Envs).NotTo(HaveKey("YDB_TECH_RDMA_0_MLX5_0_GID"))
+			})
+
+			It("uses GID index 0 on InfiniBand", func() {
+				topology.SetPorts("mlx5_0", RdmaPort{Num: "1", State: "ACTIVE", PhysState: "LinkUp", LinkLayer: "InfiniBand", Gid: testGid})
+				resp, err := n.Allocate(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_MLX5_0_LINK_LAYER", "InfiniBand"))
+				Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_MLX5_0_GID_INDEX", "0"))
+				Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_MLX5_0_GID", testGid))
+			})
+
+			It("prefers a usable port", func() {
+				topology.SetPorts("mlx5_0",
+					RdmaPort{Num: "1", State: "DOWN", PhysState: "Disabled", LinkLayer: "Ethernet"},
+					RdmaPort{Num: "2", State: "ACTIVE", PhysState: "LinkUp", LinkLayer: "Ethernet", Gid: testGid},
+				)
+				resp, err := n.Allocate(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_MLX5_0_PORT", "2"))
+			})
+
+			It("mounts the sysfs subtree of the device when configured", func() {
+				resp, err := n.Allocate(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Mounts).To(BeEmpty())
+
+				n.mountSysfs = true
+				resp, err = n.Allocate(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Mounts).To(HaveLen(1))
+				Expect(resp.Mounts[0].HostPath).To(Equal("/sys/class/infiniband/mlx5_0"))
+				Expect(resp.Mounts[0].ContainerPath).To(Equal("/sys/class/infiniband/mlx5_0"))
+				Expect(resp.Mounts[0].ReadOnly).To(BeTrue())
+			})
+
+			It("keeps the envs of several RDMA resources apart when merged", func() {
+				topology.AddDevice("mlx5_1", []string{"eth1"})
+				other := &netRdma{domain: "ydb.tech", ifname: "eth1", name: "1", rdmaDevice: "mlx5_1", topology: topology}
+				n.associatedDevices = []string{"/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm"}
+				other.associatedDevices = []string{"/dev/infiniband/uverbs1", "/dev/infiniband/rdma_cm"}
+				twin := *n
+				twin.idx = 1
+
+				var responses []*pluginapi.ContainerAllocateResponse
+				for _, instance := range []*netRdma{n, &twin, other} {
+					resp, err := instance.Allocate(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					responses = append(responses, resp)
+				}
+				merged := mergeResponses(mergePolicy(n), responses...)
+
+				Expect(merged.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_DEVICES", "mlx5_0"))
+				Expect(merged.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_1_DEVICES", "mlx5_1"))
+				Expect(merged.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_MLX5_0_PORT", "1"))
+				paths := make([]string, 0, len(merged.Devices))
+				for _, dev := range merged.Devices {
+					paths = append(paths, dev.HostPath)
+				}
+				Expect(paths).To(Equal([]string{"/dev/infiniband/uverbs0", "/dev/infiniband/rdma_cm", "/dev/infiniband/uverbs1"}))
+			})
+
+			It("lists the RDMA devices of all instances of the resource", func() {
+				topology.AddDevice("mlx5_1", []string{"eth1"})
+				sibling := &netRdma{domain: "ydb.tech", ifname: "eth1", name: "0", rdmaDevice: "mlx5_1", topology: topology}
+
+				var responses []*pluginapi.ContainerAllocateResponse
+				for _, instance := range []*netRdma{n, sibling} {
+					resp, err := instance.Allocate(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					responses = append(responses, resp)
+				}
+				merged := mergeResponses(mergePolicy(n), responses...)
+
+				Expect(merged.Envs).To(HaveKeyWithValue("YDB_TECH_RDMA_0_DEVICES", "mlx5_0,mlx5_1"))
+			})
+
+			It("keeps the envs of two netdevs of one resource apart when merged", func() {
+				topology.AddDevice("mlx5_1", []string{"eth1"}).
+					SetPorts("mlx5_1", RdmaPort{Num: "2", State: "ACTIVE", PhysState: "LinkUp", LinkLayer: "InfiniBand", Gid: ipv6Gid})
+				sibling := &netRdma{domain: "ydb.tech", ifname: "eth1", name: "0", rdmaDevice: "mlx5_1", topology: topology, ipFamily: IPFamilyIPv4}
+
+				var responses []*pluginapi.ContainerAllocateResponse
+				for _, instance := range []*netRdma{n, sibling} {
+					resp, err := instance.Allocate(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					responses = append(responses, resp)
+				}
+				merged := mergeResponses(mergePolicy(n), responses...)
+
+				Expect(merged.Envs).To(Equal(map[string]string{
+					"YDB_TECH_RDMA_0_DEVICES":           "mlx5_0,mlx5_1",
+					"YDB_TECH_RDMA_0_MLX5_0_PORT":       "1",
+					"YDB_TECH_RDMA_0_MLX5_0_LINK_LAYER": "Ethernet",
+					"YDB_TECH_RDMA_0_MLX5_0_GID_INDEX":  "3",
+					"YDB_TECH_RDMA_0_MLX5_0_GID":        ipv4Gid,
+					"YDB_TECH_RDMA_0_MLX5_1_PORT":       "2",
+					"YDB_TECH_RDMA_0_MLX5_1_LINK_LAYER": "InfiniBand",
+					"YDB_TECH_RDMA_0_MLX5_1_GID_INDEX":  "0",
+					"YDB_TECH_RDMA_0_MLX5_1_GID":        ipv6Gid,
+				}))
+			})
+		})
 	})
 })
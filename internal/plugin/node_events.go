@@ -0,0 +1,325 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/ydb-platform/udev-manager/internal/kube"
+	"github.com/ydb-platform/udev-manager/internal/mux"
+)
+
+// Defaults of [NodeReporterConfig].
+const (
+	DefaultNodeConditionType = "UdevManagerDevicesHealthy"
+	DefaultEventNamespace    = "default"
+	DefaultConditionResync   = 5 * time.Minute
+)
+
+// Reasons of the Events and the node condition of a [NodeReporter].
+const (
+	reasonResourceAppeared    = "DeviceResourceAppeared"
+	reasonResourceDisappeared = "DeviceResourceDisappeared"
+	reasonDeviceHealthy       = "DeviceHealthy"
+	reasonDeviceUnhealthy     = "DeviceUnhealthy"
+	reasonRegistrationFailed  = "DevicePluginRegistrationFailed"
+	reasonDevicesHealthy      = "DevicesHealthy"
+	reasonDevicesUnhealthy    = "DevicesUnhealthy"
+)
+
+const (
+	// nodeEventQueueSize bounds the Events waiting to be created; more are
+	// dropped while the API server is unreachable.
+	nodeEventQueueSize = 128
+	// conditionMessageItems bounds the problems listed in the condition.
+	conditionMessageItems = 10
+)
+
+// NodeReporterConfig names the node a [NodeReporter] reports on.
+type NodeReporterConfig struct {
+	Node          string        // name of the Node object
+	Namespace     string        // of the Events, defaults to DefaultEventNamespace as for kubelet
+	ConditionType string        // defaults to DefaultNodeConditionType
+	Resync        time.Duration // how often the condition is set unchanged, defaults to DefaultConditionResync
+}
+
+// nodeResource is the state of a resource as a [NodeReporter] knows it.
+type nodeResource struct {
+	unhealthy   map[Id]string // reasons of unhealthy instances
+	instances   map[Id]struct{}
+	registerErr string
+}
+
+func (r *nodeResource) available() bool {
+	return len(r.unhealthy) < len(r.instances)
+}
+
+// NodeReporter is an [Observer] that tells on the Node object what happens
+// to devices, for those who watch "kubectl describe node" rather than logs.
+// It creates Events when resources appear or lose all healthy instances,
+// when instances become unhealthy or recover, and when registration with
+// kubelet fails, and keeps a node condition that is True while all
+// instances are healthy and all resources registered.
+//
+// Events and condition updates are sent in the background; failures to send
+// them are logged and do not affect the device plugins.
+type NodeReporter struct {
+	client *kube.Client
+	config NodeReporterConfig
+	now    func() time.Time
+	events chan kube.Event
+	dirty  chan struct{} // the condition needs to be set
+
+	mu        sync.Mutex
+	resources map[string]*nodeResource
+	condition kube.NodeCondition // last set successfully
+}
+
+// NewNodeReporter reports on the node of config through client. The returned
+// CancelFunc stops sending Events and condition updates.
+func NewNodeReporter(client *kube.Client, config NodeReporterConfig) (*NodeReporter, mux.CancelFunc) {
+	r := newNodeReporter(client, config)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.run(ctx)
+	}()
+	return r, func() {
+		cancel()
+		<-done
+	}
+}
+
+func newNodeReporter(client *kube.Client, config NodeReporterConfig) *NodeReporter {
+	if config.Namespace == "" {
+		config.Namespace = DefaultEventNamespace
+	}
+	if config.ConditionType == "" {
+		config.ConditionType = DefaultNodeConditionType
+	}
+	if config.Resync <= 0 {
+		config.Resync = DefaultConditionResync
+	}
+	return &NodeReporter{
+		client:    client,
+		config:    config,
+		now:       time.Now,
+		events:    make(chan kube.Event, nodeEventQueueSize),
+		dirty:     make(chan struct{}, 1),
+		resources: make(map[string]*nodeResource),
+	}
+}
+
+// run sends queued Events and sets the condition when it changes and every
+// resync period, once a resource is known.
+func (r *NodeReporter) run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-r.events:
+			if err := r.client.CreateEvent(ctx, ev); err != nil {
+				klog.Errorf("node %s: failed to create event %s: %v", r.config.Node, ev.Reason, err)
+			}
+		case <-r.dirty:
+			r.setCondition(ctx, false)
+		case <-ticker.C:
+			r.setCondition(ctx, true)
+		}
+	}
+}
+
+// ResourceAdded implements [Observer].
+func (r *NodeReporter) ResourceAdded(status ResourceStatus) {
+	res := &nodeResource{
+		unhealthy: make(map[Id]string),
+		instances: make(map[Id]struct{}, len(status.Instances)),
+	}
+	for _, instance := range status.Instances {
+		res.instances[instance.Id] = struct{}{}
+		if instance.Health != (Healthy{}).String() {
+			res.unhealthy[instance.Id] = instance.Reason
+		}
+	}
+	r.mu.Lock()
+	r.resources[status.Name] = res
+	r.mu.Unlock()
+
+	r.event(kube.EventNormal, reasonResourceAppeared, "resource %s added with %d of %d instances healthy",
+		status.Name, len(res.instances)-len(res.unhealthy), len(res.instances))
+	r.changed()
+}
+
+// Registered implements [Observer].
+func (r *NodeReporter) Registered(resource string, err error) {
+	r.mu.Lock()
+	if res, ok := r.resources[resource]; ok {
+		res.registerErr = ""
+		if err != nil {
+			res.registerErr = err.Error()
+		}
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		r.event(kube.EventWarning, reasonRegistrationFailed, "resource %s: %v", resource, err)
+	}
+	r.changed()
+}
+
+// HealthChanged implements [Observer].
+func (r *NodeReporter) HealthChanged(resource string, id Id, transition HealthTransition) {
+	healthy := transition.Health == (Healthy{}).String()
+
+	r.mu.Lock()
+	res, ok := r.resources[resource]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	res.instances[id] = struct{}{}
+	wasAvailable := res.available()
+	_, wasUnhealthy := res.unhealthy[id]
+	if healthy {
+		delete(res.unhealthy, id)
+	} else {
+		res.unhealthy[id] = transition.Reason
+	}
+	available := res.available()
+	r.mu.Unlock()
+
+	switch {
+	case healthy && wasUnhealthy:
+		r.event(kube.EventNormal, reasonDeviceHealthy, "%s instance %s is Healthy: %s (%s)",
+			resource, id, transition.Reason, transition.Cause)
+	case !healthy && !wasUnhealthy:
+		r.event(kube.EventWarning, reasonDeviceUnhealthy, "%s instance %s is Unhealthy: %s (%s)",
+			resource, id, transition.Reason, transition.Cause)
+	}
+	switch {
+	case available && !wasAvailable:
+		r.event(kube.EventNormal, reasonResourceAppeared, "resource %s has healthy instances again", resource)
+	case !available && wasAvailable:
+		r.event(kube.EventWarning, reasonResourceDisappeared, "resource %s has no healthy instances left", resource)
+	}
+	r.changed()
+}
+
+// ResourceRemoved implements [Observer].
+func (r *NodeReporter) ResourceRemoved(resource string) {
+	r.mu.Lock()
+	_, ok := r.resources[resource]
+	delete(r.resources, resource)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	r.event(kube.EventNormal, reasonResourceDisappeared, "resource %s removed", resource)
+	r.changed()
+}
+
+// event queues an Event on the node, dropping it if the queue is full.
+func (r *NodeReporter) event(eventType, reason, format string, args ...any) {
+	now := r.now()
+	ev := kube.Event{
+		Metadata: kube.ObjectMeta{GenerateName: r.config.Node + ".", Namespace: r.config.Namespace},
+		// Nodes are referred to by name as UID, as kubelet does.
+		InvolvedObject:     kube.ObjectReference{Kind: "Node", Name: r.config.Node, UID: r.config.Node},
+		Reason:             reason,
+		Message:            fmt.Sprintf(format, args...),
+		Type:               eventType,
+		Source:             kube.EventSource{Component: "udev-manager", Host: r.config.Node},
+		FirstTimestamp:     now,
+		LastTimestamp:      now,
+		Count:              1,
+		ReportingComponent: "udev-manager",
+		ReportingInstance:  r.config.Node,
+	}
+	select {
+	case r.events <- ev:
+	default:
+		klog.Errorf("node %s: dropped event %s: %s", r.config.Node, reason, ev.Message)
+	}
+}
+
+// changed wakes up run to set the condition.
+func (r *NodeReporter) changed() {
+	select {
+	case r.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// desiredCondition returns the condition for the known resources.
+func (r *NodeReporter) desiredCondition() kube.NodeCondition {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.resources))
+	for name := range r.resources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var problems []string
+	instances := 0
+	for _, name := range names {
+		res := r.resources[name]
+		instances += len(res.instances)
+		if res.registerErr != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, res.registerErr))
+		}
+		ids := make([]Id, 0, len(res.unhealthy))
+		for id := range res.unhealthy {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		for _, id := range ids {
+			problems = append(problems, fmt.Sprintf("%s/%s: %s", name, id, res.unhealthy[id]))
+		}
+	}
+
+	condition := kube.NodeCondition{Type: r.config.ConditionType}
+	if len(problems) == 0 {
+		condition.Status = kube.ConditionTrue
+		condition.Reason = reasonDevicesHealthy
+		condition.Message = fmt.Sprintf("all %d instances of %d resources are healthy", instances, len(names))
+		return condition
+	}
+	condition.Status = kube.ConditionFalse
+	condition.Reason = reasonDevicesUnhealthy
+	if len(problems) > conditionMessageItems {
+		problems = append(problems[:conditionMessageItems], fmt.Sprintf("and %d more", len(problems)-conditionMessageItems))
+	}
+	condition.Message = strings.Join(problems, "; ")
+	return condition
+}
+
+// setCondition sets the condition on the node if it changed, or always on
+// resync.
+func (r *NodeReporter) setCondition(ctx context.Context, resync bool) {
+	condition := r.desiredCondition()
+	last := r.condition
+	if !resync && condition.Status == last.Status && condition.Reason == last.Reason && condition.Message == last.Message {
+		return
+	}
+	now := r.now()
+	condition.LastHeartbeatTime = now
+	condition.LastTransitionTime = now
+	if condition.Status == last.Status {
+		condition.LastTransitionTime = last.LastTransitionTime
+	}
+	if err := r.client.SetNodeCondition(ctx, r.config.Node, condition); err != nil {
+		klog.Errorf("node %s: failed to set condition %s: %v", r.config.Node, condition.Type, err)
+		return
+	}
+	r.condition = condition
+}
@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	"github.com/ydb-platform/udev-manager/internal/kube"
+)
+
+var _ = Describe("NodeReporter", func() {
+	var (
+		server   *kube.FakeAPIServer
+		reporter *NodeReporter
+		part     *partition
+	)
+
+	reasons := func() []string {
+		var reasons []string
+		for _, ev := range server.Events() {
+			reasons = append(reasons, ev.Reason)
+		}
+		return reasons
+	}
+	condition := func() kube.NodeCondition {
+		conditions := server.Conditions("node-1")
+		if len(conditions) == 0 {
+			return kube.NodeCondition{}
+		}
+		return conditions[0]
+	}
+
+	BeforeEach(func() {
+		server = kube.NewFakeAPIServer()
+		DeferCleanup(server.Close)
+		client, err := kube.NewClient(kube.Config{Server: server.URL})
+		Expect(err).NotTo(HaveOccurred())
+		var stop func()
+		reporter, stop = NewNodeReporter(client, NodeReporterConfig{Node: "node-1"})
+		DeferCleanup(stop)
+		part = &partition{label: "disk01", domain: "ydb.tech", dev: partitionDevice("nvme0n1p1", "disk01")}
+	})
+
+	It("reports resources and the health of their instances on the node", func() {
+		res := newResource(ResourceTemplate{Domain: "ydb.tech", Prefix: "part-disk01"}, map[Id]Instance{part.Id(): part})
+		DeferCleanup(res.Close)
+		res.attach(nil, []Observer{reporter})
+		reporter.ResourceAdded(ResourceStatus{Name: res.Name(), Instances: []InstanceStatus{{Id: part.Id(), Health: "Healthy"}}})
+		reporter.Registered(res.Name(), nil)
+		Eventually(condition).Should(MatchFields(IgnoreExtras, Fields{
+			"Type":    Equal(DefaultNodeConditionType),
+			"Status":  Equal(kube.ConditionTrue),
+			"Message": Equal("all 1 instances of 1 resources are healthy"),
+		}))
+		since := condition().LastTransitionTime
+
+		Expect(res.Submit(HealthEvent{Instances: []Instance{part}, Health: Unhealthy{Reason: "udev: removed nvme0n1p1"}})).To(Succeed())
+		Eventually(condition).Should(MatchFields(IgnoreExtras, Fields{
+			"Status":  Equal(kube.ConditionFalse),
+			"Reason":  Equal("DevicesUnhealthy"),
+			"Message": Equal("ydb.tech/part-disk01/disk01: udev: removed nvme0n1p1"),
+		}))
+		Expect(condition().LastTransitionTime).To(BeTemporally(">=", since))
+
+		Expect(res.Submit(HealthEvent{Instances: []Instance{part}, Health: Healthy{Reason: "udev: added or changed nvme0n1p1"}})).To(Succeed())
+		Eventually(condition).Should(HaveField("Status", kube.ConditionTrue))
+		Eventually(reasons).Should(Equal([]string{
+			"DeviceResourceAppeared",
+			"DeviceUnhealthy",
+			"DeviceResourceDisappeared",
+			"DeviceHealthy",
+			"DeviceResourceAppeared",
+		}))
+
+		events := server.Events()
+		Expect(events[1].Type).To(Equal(kube.EventWarning))
+		Expect(events[1].Message).To(Equal("ydb.tech/part-disk01 instance disk01 is Unhealthy: udev: removed nvme0n1p1 (udev: removed nvme0n1p1)"))
+		Expect(events[1].InvolvedObject).To(Equal(kube.ObjectReference{Kind: "Node", Name: "node-1", UID: "node-1"}))
+		Expect(events[1].Metadata.Namespace).To(Equal(DefaultEventNamespace))
+	})
+
+	It("reports failed registrations of a registry", func() {
+		dir := GinkgoT().TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+		registry, err := NewRegistry(ctx, wg,
+			WithPluginDir(dir+"/"),
+			WithKubeletSocket(filepath.Join(dir, "kubelet.sock")),
+			WithObserver(reporter),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		res := newResource(ResourceTemplate{Domain: "ydb.tech", Prefix: "part-disk01"}, map[Id]Instance{part.Id(): part})
+		DeferCleanup(res.Close)
+		Expect(registry.Add(res)).NotTo(Succeed())
+		Eventually(reasons).Should(Equal([]string{"DeviceResourceAppeared", "DevicePluginRegistrationFailed"}))
+		Eventually(condition).Should(MatchFields(IgnoreExtras, Fields{
+			"Status":  Equal(kube.ConditionFalse),
+			"Message": HavePrefix("ydb.tech/part-disk01: failed to register with kubelet"),
+		}))
+	})
+
+	It("forgets resources removed from a registry", func() {
+		dir := GinkgoT().TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+		registry, err := NewRegistry(ctx, wg,
+			WithPluginDir(dir+"/"),
+			WithKubeletSocket(filepath.Join(dir, "kubelet.sock")),
+			WithObserver(reporter),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		res := newResource(ResourceTemplate{Domain: "ydb.tech", Prefix: "part-disk01"}, map[Id]Instance{part.Id(): part})
+		DeferCleanup(res.Close)
+		Expect(registry.Add(res)).NotTo(Succeed())
+		Expect(filepath.Join(dir, "ydb-tech-part-disk01.sock")).To(BeAnExistingFile())
+
+		Expect(registry.Remove(res.Name())).To(Succeed())
+		Expect(filepath.Join(dir, "ydb-tech-part-disk01.sock")).NotTo(BeAnExistingFile())
+		Expect(registry.Status()).To(BeEmpty())
+		Expect(registry.Remove(res.Name())).NotTo(Succeed())
+		Eventually(reasons).Should(Equal([]string{"DeviceResourceAppeared", "DevicePluginRegistrationFailed", "DeviceResourceDisappeared"}))
+		Eventually(condition).Should(HaveField("Status", kube.ConditionTrue))
+	})
+
+	It("keeps going while the API server fails", func() {
+		server.Fail(http.StatusInternalServerError)
+		reporter.ResourceAdded(ResourceStatus{Name: "ydb.tech/part-disk01", Instances: []InstanceStatus{{Id: "disk01", Health: "Healthy"}}})
+		Consistently(server.Events, 200*time.Millisecond).Should(BeEmpty())
+
+		server.Fail(0)
+		reporter.HealthChanged("ydb.tech/part-disk01", "disk01", HealthTransition{Health: "Unhealthy", Reason: "cordoned"})
+		Eventually(condition).Should(HaveField("Message", "ydb.tech/part-disk01/disk01: cordoned"))
+	})
+})
@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// Kernel name prefixes of the block and generic char devices of an NVMe
+// namespace: nvme<ctrl>n<ns> and ng<ctrl>n<ns>.
+const (
+	nvmeBlockPrefix   = "nvme"
+	nvmeGenericPrefix = "ng"
+)
+
+// nvmeNamespaceRegex matches the kernel names of NVMe namespaces, leaving out
+// their partitions (nvme0n1p1).
+var nvmeNamespaceRegex = regexp.MustCompile(`^nvme\d+n\d+$`)
+
+// nvmeSysfs checks which nodes of NVMe namespaces exist. It remembers the
+// namespaces that were seen with a generic char device, so that its absence
+// can be told apart from a kernel that does not create one.
+type nvmeSysfs struct {
+	sysfs
+
+	mu          sync.Mutex
+	withGeneric map[string]struct{} // namespaces seen with a generic device
+}
+
+func newNvmeSysfs(root string) *nvmeSysfs {
+	return &nvmeSysfs{
+		sysfs:       sysfs{root: root},
+		withGeneric: make(map[string]struct{}),
+	}
+}
+
+// block reports whether the block device of namespace ns exists.
+func (s *nvmeSysfs) block(ns string) bool {
+	return s.exists(filepath.Join("class", "block", ns))
+}
+
+// generic reports whether the generic char device of namespace ns exists.
+func (s *nvmeSysfs) generic(ns string) bool {
+	if !s.exists(filepath.Join("class", udev.NvmeGenericSubsystem, nvmeGenericName(ns))) {
+		return false
+	}
+	s.sawGeneric(ns)
+	return true
+}
+
+func (s *nvmeSysfs) sawGeneric(ns string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.withGeneric[ns] = struct{}{}
+}
+
+// expectsGeneric reports whether namespace ns was seen with a generic device.
+func (s *nvmeSysfs) expectsGeneric(ns string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.withGeneric[ns]
+	return ok
+}
+
+// nvmeGenericName returns the name of the generic char device of namespace
+// ns, e.g. "ng0n1" for "nvme0n1".
+func nvmeGenericName(ns string) string {
+	return nvmeGenericPrefix + strings.TrimPrefix(ns, nvmeBlockPrefix)
+}
+
+// NvmeSettings is what the templater and the instances of an NVMe namespace
+// resource share: the view of sysfs that remembers which namespaces had a
+// generic device.
+type NvmeSettings struct {
+	sysfs *nvmeSysfs
+}
+
+// NewNvmeSettings checks the nodes of namespaces in sysfs mounted at root,
+// e.g. [DefaultSysfsRoot].
+func NewNvmeSettings(root string) *NvmeSettings {
+	return &NvmeSettings{sysfs: newNvmeSysfs(root)}
+}
+
+// nvmeNamespace is an NVMe namespace handed out to one pod with its block
+// device and, where the kernel provides one, its generic char device for
+// io_uring passthrough.
+type nvmeNamespace struct {
+	domain   string
+	ns       string // kernel name of the block device, e.g. "nvme0n1"
+	dev      udev.Device
+	settings *NvmeSettings
+
+	disableTopologyHints bool
+}
+
+func (n *nvmeNamespace) Id() Id {
+	return Id(n.ns)
+}
+
+func (n *nvmeNamespace) udevDevices() []udev.Device { return []udev.Device{n.dev} }
+
+// Health is Healthy while the block device exists and so does the generic
+// device, unless the namespace never had one.
+func (n *nvmeNamespace) Health() Health {
+	if !n.settings.sysfs.block(n.ns) {
+		return Unhealthy{Reason: fmt.Sprintf("nvme namespace %s has no block device", n.ns)}
+	}
+	if !n.settings.sysfs.generic(n.ns) && n.settings.sysfs.expectsGeneric(n.ns) {
+		return Unhealthy{Reason: fmt.Sprintf("nvme namespace %s lost its generic device %s", n.ns, nvmeGenericName(n.ns))}
+	}
+	return Healthy{}
+}
+
+func (n *nvmeNamespace) TopologyHints() *pluginapi.TopologyInfo {
+	if n.disableTopologyHints {
+		return nil
+	}
+	return numaTopology(n.dev.NumaNode())
+}
+
+// Allocate passes the block device and, if it exists, the generic char
+// device of the namespace under /dev/allocated/<domain>/nvme.
+func (n *nvmeNamespace) Allocate(context.Context) (*pluginapi.ContainerAllocateResponse, error) {
+	response := &pluginapi.ContainerAllocateResponse{Envs: make(map[string]string)}
+	envName := func(env string) string {
+		return sanitizeEnv(n.domain) + "_NVME_" + sanitizeEnv(n.ns) + "_" + env
+	}
+	add := func(name, env string) {
+		containerPath := path.Join("/dev", "allocated", n.domain, "nvme", name)
+		response.Devices = append(response.Devices, &pluginapi.DeviceSpec{
+			HostPath:      path.Join("/dev", name),
+			ContainerPath: containerPath,
+			Permissions:   "rw",
+		})
+		response.Envs[envName(env)] = containerPath
+	}
+
+	add(n.ns, "PATH")
+	if n.settings.sysfs.generic(n.ns) {
+		add(nvmeGenericName(n.ns), "GENERIC_PATH")
+	}
+	klog.Info("allocated nvme namespace: ", n.ns)
+	klog.V(2).Infof("%+v", response)
+	return response, nil
+}
+
+// matchNvmeNamespace returns the namespace dev belongs to. dev is either the
+// block device of a namespace or its generic char device. ok is false unless
+// the namespace matches matcher.
+func matchNvmeNamespace(dev udev.Device, matcher *regexp.Regexp, sysfs *nvmeSysfs) (ns string, ok bool) {
+	name := udev.Sysname(dev)
+	switch dev.Subsystem() {
+	case udev.BlockSubsystem:
+		ns = name
+	case udev.NvmeGenericSubsystem:
+		ns = nvmeBlockPrefix + strings.TrimPrefix(name, nvmeGenericPrefix)
+	default:
+		return "", false
+	}
+	if !nvmeNamespaceRegex.MatchString(ns) || !matcher.MatchString(ns) {
+		return "", false
+	}
+	if dev.Subsystem() == udev.NvmeGenericSubsystem {
+		sysfs.sawGeneric(ns)
+	}
+	return ns, true
+}
+
+// NvmeNamespaceTemplater returns a FromDevice function that produces the
+// ResourceTemplate <domain>/nvme-<name> for NVMe namespaces matching matcher.
+func NvmeNamespaceTemplater(domain, name string, matcher *regexp.Regexp, settings *NvmeSettings) FromDevice[*ResourceTemplate] {
+	return func(dev udev.Device) (*ResourceTemplate, error) {
+		if _, ok := matchNvmeNamespace(dev, matcher, settings.sysfs); !ok {
+			return nil, nil
+		}
+
+		return &ResourceTemplate{
+			Domain: domain,
+			Prefix: "nvme-" + name,
+		}, nil
+	}
+}
+
+// NvmeNamespaceInstances returns a FromDevice function that produces one
+// nvmeNamespace instance per NVMe namespace matching matcher. The block and
+// generic devices of a namespace map to the same instance, so that events of
+// either re-evaluate its health.
+func NvmeNamespaceInstances(domain string, matcher *regexp.Regexp, disableTopologyHints bool, settings *NvmeSettings) FromDevice[[]*nvmeNamespace] {
+	return func(dev udev.Device) ([]*nvmeNamespace, error) {
+		ns, ok := matchNvmeNamespace(dev, matcher, settings.sysfs)
+		if !ok {
+			return nil, nil
+		}
+		klog.V(5).Infof("found nvme namespace %s from %s", ns, dev.Id())
+
+		return []*nvmeNamespace{{
+			domain:               domain,
+			ns:                   ns,
+			dev:                  dev,
+			settings:             settings,
+			disableTopologyHints: disableTopologyHints,
+		}}, nil
+	}
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// fakeNvmeSysfs creates the class entries of namespace ns under root, with
+// its generic device if generic is set.
+func fakeNvmeSysfs(root, ns string, generic bool) {
+	Expect(os.MkdirAll(filepath.Join(root, "class", "block", ns), 0o755)).To(Succeed())
+	if generic {
+		Expect(os.MkdirAll(filepath.Join(root, "class", "nvme-generic", nvmeGenericName(ns)), 0o755)).To(Succeed())
+	}
+}
+
+var _ = Describe("NVMe namespaces", func() {
+	var (
+		root     string
+		matcher  *regexp.Regexp
+		settings *NvmeSettings
+	)
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		matcher = regexp.MustCompile(`^nvme\d+n1$`)
+		settings = NewNvmeSettings(root)
+	})
+
+	Describe("NvmeNamespaceTemplater", func() {
+		DescribeTable("matching",
+			func(dev *mockDevice, matched bool) {
+				tmpl, err := NvmeNamespaceTemplater("ydb.tech", "nvme", matcher, settings)(dev)
+				Expect(err).NotTo(HaveOccurred())
+				if matched {
+					Expect(tmpl).To(Equal(&ResourceTemplate{Domain: "ydb.tech", Prefix: "nvme-nvme"}))
+				} else {
+					Expect(tmpl).To(BeNil())
+				}
+			},
+			Entry("matches a namespace block device", nvmeDevice(udev.BlockSubsystem, "nvme0n1", -1), true),
+			Entry("matches a namespace generic device", nvmeDevice(udev.NvmeGenericSubsystem, "ng0n1", -1), true),
+			Entry("skips partitions", nvmeDevice(udev.BlockSubsystem, "nvme0n1p1", -1), false),
+			Entry("skips namespaces not matching", nvmeDevice(udev.NvmeGenericSubsystem, "ng0n2", -1), false),
+			Entry("skips other disks", nvmeDevice(udev.BlockSubsystem, "sda", -1), false),
+			Entry("skips other subsystems", netDevice("eth0", "1000", "up"), false),
+		)
+	})
+
+	Describe("NvmeNamespaceInstances", func() {
+		It("maps the block and generic devices to the same instance", func() {
+			mapper := NvmeNamespaceInstances("ydb.tech", matcher, false, settings)
+			block, err := mapper(nvmeDevice(udev.BlockSubsystem, "nvme0n1", 1))
+			Expect(err).NotTo(HaveOccurred())
+			generic, err := mapper(nvmeDevice(udev.NvmeGenericSubsystem, "ng0n1", 1))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(block).To(HaveLen(1))
+			Expect(generic).To(HaveLen(1))
+			Expect(block[0].Id()).To(Equal(Id("nvme0n1")))
+			Expect(generic[0].Id()).To(Equal(block[0].Id()))
+			Expect(block[0].TopologyHints()).To(Equal(&pluginapi.TopologyInfo{
+				Nodes: []*pluginapi.NUMANode{{ID: 1}},
+			}))
+		})
+	})
+
+	Describe("nvmeNamespace", func() {
+		namespace := func() *nvmeNamespace {
+			instances, err := NvmeNamespaceInstances("ydb.tech", matcher, false, settings)(nvmeDevice(udev.BlockSubsystem, "nvme0n1", -1))
+			Expect(err).NotTo(HaveOccurred())
+			return instances[0]
+		}
+
+		It("passes the block and generic devices", func() {
+			fakeNvmeSysfs(root, "nvme0n1", true)
+			resp, err := namespace().Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Devices).To(Equal([]*pluginapi.DeviceSpec{
+				{HostPath: "/dev/nvme0n1", ContainerPath: "/dev/allocated/ydb.tech/nvme/nvme0n1", Permissions: "rw"},
+				{HostPath: "/dev/ng0n1", ContainerPath: "/dev/allocated/ydb.tech/nvme/ng0n1", Permissions: "rw"},
+			}))
+			Expect(resp.Envs).To(Equal(map[string]string{
+				"YDB_TECH_NVME_NVME0N1_PATH":         "/dev/allocated/ydb.tech/nvme/nvme0n1",
+				"YDB_TECH_NVME_NVME0N1_GENERIC_PATH": "/dev/allocated/ydb.tech/nvme/ng0n1",
+			}))
+		})
+
+		It("passes only the block device without a generic device", func() {
+			fakeNvmeSysfs(root, "nvme0n1", false)
+			ns := namespace()
+			Expect(ns.Health()).To(BeAssignableToTypeOf(Healthy{}))
+			resp, err := ns.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Devices).To(HaveLen(1))
+			Expect(resp.Envs).NotTo(HaveKey("YDB_TECH_NVME_NVME0N1_GENERIC_PATH"))
+		})
+
+		It("is unhealthy without its block device", func() {
+			Expect(namespace().Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		})
+
+		It("is unhealthy once its generic device is gone", func() {
+			fakeNvmeSysfs(root, "nvme0n1", true)
+			ns := namespace()
+			Expect(ns.Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+			Expect(os.RemoveAll(filepath.Join(root, "class", "nvme-generic", "ng0n1"))).To(Succeed())
+			Expect(ns.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		})
+	})
+})
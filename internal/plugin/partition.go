@@ -19,32 +19,92 @@ type partition struct {
 	label                string
 	dev                  udev.Device
 	disableTopologyHints bool
+	mounter              *PartitionMounter // mounts the filesystem instead of passing the device
+	templates            *PartitionTemplates
+	permissions          string // cgroup permissions of the device, "rw" by default
+	multipath            *multipathSysfs
+	diskHealth           []DiskHealth
+
+	replicas int // number of instances sharing the device
+	replica  int // index of this instance among them
+}
+
+// PartitionOption configures the instances of [PartitionLabelMatcherInstances].
+type PartitionOption func(*partition)
+
+// WithPartitionMounter passes partitions to containers as filesystems mounted
+// by m instead of raw block devices.
+func WithPartitionMounter(m *PartitionMounter) PartitionOption {
+	return func(p *partition) { p.mounter = m }
+}
+
+// WithPartitionTemplates renders the container paths and environment of
+// partitions with t.
+func WithPartitionTemplates(t *PartitionTemplates) PartitionOption {
+	return func(p *partition) { p.templates = t }
+}
+
+// WithPartitionPermissions passes devices with the cgroup permissions perm,
+// e.g. "r" for read-only access, instead of "rw".
+func WithPartitionPermissions(perm string) PartitionOption {
+	return func(p *partition) { p.permissions = perm }
+}
+
+// WithPartitionReplicas exposes each partition as n instances, so that up to
+// n pods can use it at the same time.
+func WithPartitionReplicas(n int) PartitionOption {
+	return func(p *partition) { p.replicas = n }
+}
+
+// WithPartitionSysfsRoot looks for multipath maps in sysfs mounted at root,
+// e.g. [DefaultSysfsRoot]. Without it, multipath maps are not recognised.
+func WithPartitionSysfsRoot(root string) PartitionOption {
+	multipath := newMultipathSysfs(root)
+	return func(p *partition) { p.multipath = multipath }
+}
+
+// WithPartitionDiskHealth reports partitions Unhealthy while h fails the disk
+// they are on.
+func WithPartitionDiskHealth(h DiskHealth) PartitionOption {
+	return func(p *partition) { p.diskHealth = append(p.diskHealth, h) }
 }
 
+// Id is the label, followed by "#<replica>" if the partition has several
+// replicas.
 func (p *partition) Id() Id {
+	if p.replicas > 1 {
+		return Id(fmt.Sprintf("%s#%d", p.label, p.replica))
+	}
 	return Id(p.label)
 }
 
+// Health is Healthy, unless the partition is to be mounted and does not carry
+// the expected filesystem, it is on a multipath map that lost paths, or its
+// disk fails a [DiskHealth] check.
 func (p *partition) Health() Health {
-	return Healthy{} //TODO: health check?
+	if isMapPartition(p.dev) {
+		if health := p.multipath.pathsHealth(p.dev); !isHealthy(health) {
+			return health
+		}
+	}
+	if p.mounter != nil {
+		if err := p.mounter.checkFsType(p.dev); err != nil {
+			return Unhealthy{Reason: err.Error()}
+		}
+	}
+	if err := checkDisks(p.diskHealth, p.dev); err != nil {
+		return Unhealthy{Reason: err.Error()}
+	}
+	return Healthy{}
 }
 
+func (p *partition) udevDevices() []udev.Device { return []udev.Device{p.dev} }
+
 func (p *partition) TopologyHints() *pluginapi.TopologyInfo {
 	if p.disableTopologyHints {
 		return nil
 	}
-	numaNode := p.dev.NumaNode()
-	if numaNode < 0 {
-		return nil
-	}
-
-	return &pluginapi.TopologyInfo{
-		Nodes: []*pluginapi.NUMANode{
-			{
-				ID: int64(numaNode),
-			},
-		},
-	}
+	return numaTopology(p.dev.NumaNode())
 }
 
 func sanitizeEnv(s string) string {
@@ -53,32 +113,80 @@ func sanitizeEnv(s string) string {
 }
 
 func (p *partition) Allocate(context.Context) (*pluginapi.ContainerAllocateResponse, error) {
-	response := allocatePartitionDevice(p.dev, p.domain, p.label)
-	klog.Info("allocated partition: ", p.label)
+	if p.mounter != nil {
+		return p.allocateMount()
+	}
+	response, err := allocatePartitionDevice(p.dev, p.domain, p.label, p.templates)
+	if err != nil {
+		return nil, err
+	}
+	if p.permissions != "" {
+		for _, device := range response.Devices {
+			device.Permissions = p.permissions
+		}
+	}
+	klog.Info("allocated partition: ", p.Id())
+	klog.V(2).Infof("%+v", response)
+	return response, nil
+}
+
+// allocateMount mounts the partition and passes the mount instead of the
+// device.
+func (p *partition) allocateMount() (*pluginapi.ContainerAllocateResponse, error) {
+	containerPath, envs, err := p.templates.allocate(p.dev, p.domain, p.label,
+		path.Join("/mnt", "allocated", p.domain, "part", p.label))
+	if err != nil {
+		return nil, err
+	}
+	hostPath, err := p.mounter.mount(p.dev, p.domain, p.label)
+	if err != nil {
+		return nil, err
+	}
+	response := &pluginapi.ContainerAllocateResponse{
+		Mounts: []*pluginapi.Mount{{
+			ContainerPath: containerPath,
+			HostPath:      hostPath,
+			ReadOnly:      p.mounter.config.ReadOnly,
+		}},
+		Envs: envs,
+	}
+	klog.Info("allocated partition mount: ", p.label)
 	klog.V(2).Infof("%+v", response)
 	return response, nil
 }
 
-func allocatePartitionDevice(dev udev.Device, domain, label string) *pluginapi.ContainerAllocateResponse {
+// allocatePartitionDevice passes partition dev, by default as
+// /dev/allocated/<domain>/part/<label>. templates may be nil.
+func allocatePartitionDevice(dev udev.Device, domain, label string, templates *PartitionTemplates) (*pluginapi.ContainerAllocateResponse, error) {
 	response := &pluginapi.ContainerAllocateResponse{}
 
-	containerPath := path.Join("/dev", "allocated", domain, "part", label)
+	containerPath, envs, err := templates.allocate(dev, domain, label,
+		path.Join("/dev", "allocated", domain, "part", label))
+	if err != nil {
+		return nil, err
+	}
 
 	response.Devices = append(response.Devices, &pluginapi.DeviceSpec{
 		HostPath:      dev.DevNode(),
 		ContainerPath: containerPath,
 		Permissions:   "rw",
 	})
+	response.Envs = envs
+
+	return response, nil
+}
 
+// partitionEnvs describes the partition dev available at containerPath.
+func partitionEnvs(dev udev.Device, domain, label, containerPath string) map[string]string {
 	envName := func(env string) string {
 		return sanitizeEnv(domain) + "_PART_" + sanitizeEnv(label) + "_" + sanitizeEnv(env)
 	}
 
-	response.Envs = make(map[string]string)
-	response.Envs[envName("PATH")] = containerPath
-	response.Envs[envName("DISK_ID")] =
+	envs := make(map[string]string)
+	envs[envName("PATH")] = containerPath
+	envs[envName("DISK_ID")] =
 		dev.SystemAttributeLookup(udev.SysAttrWWID)
-	response.Envs[envName("DISK_MODEL")] =
+	envs[envName("DISK_MODEL")] =
 		dev.SystemAttributeLookup(udev.SysAttrModel)
 
 	serial := dev.SystemAttributeLookup(udev.SysAttrSerial)
@@ -86,35 +194,60 @@ func allocatePartitionDevice(dev udev.Device, domain, label string) *pluginapi.C
 		serial = dev.PropertyLookup(udev.PropertyShortSerial)
 	}
 	if serial != "" {
-		response.Envs[envName("DISK_SERIAL")] = serial
+		envs[envName("DISK_SERIAL")] = serial
+	}
+	for _, field := range readPartitionMetadata(dev) {
+		envs[envName(field.Key)] = field.Value
 	}
 
-	return response
+	return envs
 }
 
-// PartitionLabelMatcherTemplater returns a FromDevice function that produces a
-// ResourceTemplate for block partition devices whose PARTNAME matches matcher.
-// The first capture group (if any) is used as the label suffix.
-func PartitionLabelMatcherTemplater(domain string, matcher *regexp.Regexp) FromDevice[*ResourceTemplate] {
-	return func(dev udev.Device) (*ResourceTemplate, error) {
-		if dev.Subsystem() != udev.BlockSubsystem {
-			return nil, nil
+// matchPartition matches the label of partition dev against matcher and
+// returns the label with the matches. The partitions of paths of multipath
+// maps stand for the corresponding partition of the map, which is returned as
+// target, so that their events re-evaluate its health.
+func matchPartition(dev udev.Device, matcher *regexp.Regexp, multipath *multipathSysfs) (target udev.Device, partlabel string, matches []string) {
+	target = dev
+	partlabel, ok := multipath.partitionName(dev)
+	if !ok {
+		if target = multipath.mapPartition(dev); target == nil {
+			return nil, "", nil
 		}
-		if dev.DevType() != udev.DeviceTypePart {
-			return nil, nil
+		if partlabel, ok = multipath.partitionName(target); !ok {
+			return nil, "", nil
 		}
+	}
+	matches = matcher.FindStringSubmatch(partlabel)
+	if len(matches) == 0 {
+		return nil, "", nil
+	}
+	return target, partlabel, matches
+}
 
-		partlabel, found := dev.Properties()[udev.PropertyPartName]
-		if !found {
-			return nil, nil
-		}
+// newPartitionSettings applies opts to a partition that only carries the
+// settings shared by the templater and the instances.
+func newPartitionSettings(opts []PartitionOption) *partition {
+	settings := &partition{}
+	for _, opt := range opts {
+		opt(settings)
+	}
+	return settings
+}
 
-		matches := matcher.FindStringSubmatch(partlabel)
+// PartitionLabelMatcherTemplater returns a FromDevice function that produces a
+// ResourceTemplate for block partition devices whose PARTNAME matches matcher.
+// The first capture group (if any) is used as the label suffix. Pass it the
+// same options as [PartitionLabelMatcherInstances].
+func PartitionLabelMatcherTemplater(domain string, matcher *regexp.Regexp, opts ...PartitionOption) FromDevice[*ResourceTemplate] {
+	settings := newPartitionSettings(opts)
+	return func(dev udev.Device) (*ResourceTemplate, error) {
+		_, _, matches := matchPartition(dev, matcher, settings.multipath)
 		if len(matches) == 0 {
 			return nil, nil
 		}
 
-		partlabel = strings.Join(matches[1:], "_")
+		partlabel := strings.Join(matches[1:], "_")
 
 		return &ResourceTemplate{
 			Domain: domain,
@@ -123,26 +256,26 @@ func PartitionLabelMatcherTemplater(domain string, matcher *regexp.Regexp) FromD
 	}
 }
 
+// PartitionMultipathRelated returns the partitions of a multipath map for
+// [WithScatterRelated], so that the change events the kernel raises on the
+// map when a path fails or comes back re-evaluate the partitions on it. Pass
+// it the same options as [PartitionLabelMatcherInstances].
+func PartitionMultipathRelated(opts ...PartitionOption) func(udev.Device) []udev.Device {
+	return newPartitionSettings(opts).multipath.mapPartitions
+}
+
 // PartitionLabelMatcherInstances returns a FromDevice function that produces
 // partition instances for matching block devices. Each matching device becomes
 // one instance whose label is the first capture group of matcher (or the full
-// PARTNAME if there are no capture groups).
-func PartitionLabelMatcherInstances(domain string, matcher *regexp.Regexp, disableTopologyHints bool) FromDevice[[]*partition] {
+// PARTNAME if there are no capture groups), or one per replica with
+// [WithPartitionReplicas].
+//
+// Partitions of multipath maps are matched on their partition label, while
+// the partitions of the paths of the maps are never exposed themselves.
+func PartitionLabelMatcherInstances(domain string, matcher *regexp.Regexp, disableTopologyHints bool, opts ...PartitionOption) FromDevice[[]*partition] {
+	settings := newPartitionSettings(opts)
 	return func(dev udev.Device) ([]*partition, error) {
-		var part *partition
-		if dev.Subsystem() != udev.BlockSubsystem {
-			return nil, nil
-		}
-		if dev.DevType() != udev.DeviceTypePart {
-			return nil, nil
-		}
-
-		partlabel, found := dev.Properties()[udev.PropertyPartName]
-		if !found {
-			return nil, nil
-		}
-
-		matches := matcher.FindStringSubmatch(partlabel)
+		target, partlabel, matches := matchPartition(dev, matcher, settings.multipath)
 		if len(matches) == 0 {
 			return nil, nil
 		}
@@ -151,13 +284,29 @@ func PartitionLabelMatcherInstances(domain string, matcher *regexp.Regexp, disab
 			partlabel = matches[1]
 		}
 
-		part = &partition{
+		part := &partition{
 			label:                partlabel,
 			domain:               domain,
-			dev:                  dev,
+			dev:                  target,
 			disableTopologyHints: disableTopologyHints,
+			multipath:            settings.multipath,
+		}
+		for _, opt := range opts {
+			opt(part)
+		}
+		if klog.V(2).Enabled() {
+			klog.V(2).Infof("partition %s (%s): %s", partlabel, target.DevNode(), readPartitionMetadata(target))
+		}
+		if part.replicas <= 1 {
+			return []*partition{part}, nil
 		}
 
-		return []*partition{part}, nil
+		replicas := make([]*partition, part.replicas)
+		for i := range replicas {
+			replica := *part
+			replica.replica = i
+			replicas[i] = &replica
+		}
+		return replicas, nil
 	}
 }
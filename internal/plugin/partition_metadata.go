@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+const (
+	// sectorSize is the unit of the size attribute of block devices.
+	sectorSize = 512
+
+	partUUIDLinkDir = "/dev/disk/by-partuuid/"
+)
+
+// transportByBus maps ID_BUS to the transport of a disk. ID_BUS=scsi is also
+// set for virtio-scsi, iSCSI, FC and USB bridges, so it only becomes "sas" with
+// a SAS ancestor, see diskTransport.
+var transportByBus = map[string]string{
+	"ata":  "sata",
+	"scsi": "scsi",
+	"nvme": "nvme",
+	"usb":  "usb",
+}
+
+// transportBySubsystem maps the subsystems of the ancestors of a disk to its
+// transport, for disks without ID_BUS.
+var transportBySubsystem = map[string]string{
+	udev.NvmeSubsystem: "nvme",
+	"ata_port":         "sata",
+	"sas_port":         "sas",
+	"sas_device":       "sas",
+	"virtio":           "virtio",
+}
+
+// metadataField is a fact about a partition, passed as the env
+// <DOMAIN>_PART_<LABEL>_<Key>.
+type metadataField struct {
+	Key   string
+	Value string
+}
+
+// partitionMetadata describes a partition and the disk it is on. Facts that
+// cannot be resolved are left out.
+type partitionMetadata []metadataField
+
+// readPartitionMetadata resolves the metadata of partition dev. The queue
+// attributes only exist on the disk, so they are looked up through the
+// parents of the partition.
+func readPartitionMetadata(dev udev.Device) partitionMetadata {
+	var m partitionMetadata
+	add := func(key, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			m = append(m, metadataField{Key: key, Value: value})
+		}
+	}
+
+	if sectors, err := strconv.ParseUint(strings.TrimSpace(dev.SystemAttribute(udev.SysAttrSize)), 10, 64); err == nil {
+		add("SIZE_BYTES", strconv.FormatUint(sectors*sectorSize, 10))
+	}
+	add("LOGICAL_BLOCK_SIZE", dev.SystemAttributeLookup(udev.SysAttrLogicalBlockSize))
+	add("PHYSICAL_BLOCK_SIZE", dev.SystemAttributeLookup(udev.SysAttrPhysicalBlockSize))
+	add("ROTATIONAL", dev.SystemAttributeLookup(udev.SysAttrRotational))
+	add("TRANSPORT", diskTransport(dev))
+	add("ZONED", dev.SystemAttributeLookup(udev.SysAttrZoned))
+	add("PARTUUID_PATH", partUUIDPath(dev))
+	return m
+}
+
+// String formats m for logging, e.g. "SIZE_BYTES=1048576 ROTATIONAL=0".
+func (m partitionMetadata) String() string {
+	fields := make([]string, len(m))
+	for i, field := range m {
+		fields[i] = field.Key + "=" + field.Value
+	}
+	return strings.Join(fields, " ")
+}
+
+// diskTransport returns the transport of the disk dev is on, e.g. "nvme" or
+// "sata", from ID_BUS or else from the subsystems of its ancestors.
+func diskTransport(dev udev.Device) string {
+	if bus := dev.PropertyLookup(udev.PropertyBus); bus != "" {
+		transport, ok := transportByBus[bus]
+		if !ok {
+			return bus
+		}
+		if transport == "scsi" && ancestorTransport(dev) == "sas" {
+			return "sas"
+		}
+		return transport
+	}
+	return ancestorTransport(dev)
+}
+
+// ancestorTransport returns the transport of the closest ancestor of dev whose
+// subsystem is in transportBySubsystem.
+func ancestorTransport(dev udev.Device) string {
+	for parent := dev.Parent(); parent != nil; parent = parent.Parent() {
+		if transport, ok := transportBySubsystem[parent.Subsystem()]; ok {
+			return transport
+		}
+	}
+	return ""
+}
+
+// partUUIDPath returns the /dev/disk/by-partuuid link of partition dev.
+func partUUIDPath(dev udev.Device) string {
+	for _, link := range dev.DevLinks() {
+		if strings.HasPrefix(link, partUUIDLinkDir) {
+			return link
+		}
+	}
+	if uuid := dev.Property(udev.PropertyPartUUID); uuid != "" {
+		return partUUIDLinkDir + uuid
+	}
+	return ""
+}
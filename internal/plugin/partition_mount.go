@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/ydb-platform/udev-manager/internal/mux"
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+)
+
+// Mounter mounts and unmounts filesystems on the host.
+type Mounter interface {
+	Mount(source, target, fsType string, readOnly bool) error
+	Unmount(target string) error
+}
+
+// sysMounter is a [Mounter] using the mount(2) and umount2(2) syscalls.
+type sysMounter struct{}
+
+func (sysMounter) Mount(source, target, fsType string, readOnly bool) error {
+	var flags uintptr
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	return syscall.Mount(source, target, fsType, flags, "")
+}
+
+// Unmount detaches target lazily, so that containers still using it keep
+// their bind mounts.
+func (sysMounter) Unmount(target string) error {
+	return syscall.Unmount(target, syscall.MNT_DETACH)
+}
+
+// defaultMountInfo is the mount table of the process.
+const defaultMountInfo = "/proc/self/mountinfo"
+
+// PartitionMountConfig describes how partitions are mounted for containers.
+type PartitionMountConfig struct {
+	StagingDir string  // host directory the partitions are mounted under, must be on a shared mount
+	FsType     string  // filesystem partitions must be formatted with, e.g. "ext4"
+	ReadOnly   bool    // mount read-only on the host and in containers
+	Mounter    Mounter // defaults to mounting through syscalls
+	MountInfo  string  // mount table StagingDir is looked up in, defaults to /proc/self/mountinfo
+}
+
+// PartitionMounter mounts partitions at <StagingDir>/<domain>/<label>/<kernel
+// name> when they are allocated, so that partitions with the same label on
+// different disks do not mount over each other. It unmounts them when their
+// device is removed and when it is torn down.
+type PartitionMounter struct {
+	config PartitionMountConfig
+
+	mu     sync.Mutex
+	mounts map[string]string // staging path -> device node mounted there
+}
+
+// NewPartitionMounter returns a PartitionMounter that watches d for removed
+// devices. It fails unless StagingDir is on a shared mount: kubelet looks for
+// the host paths it is given in the mount namespace of the host, which only
+// sees the mounts of udev-manager if they propagate, i.e. the staging dir is
+// mounted into its container with mountPropagation: Bidirectional.
+//
+// Partitions mounted by an earlier run that crashed or was restarted are
+// adopted, as containers restarted by kubelet bind mount them again; any other
+// mount under StagingDir is detached. The returned CancelFunc stops watching
+// and unmounts everything that is still mounted.
+func NewPartitionMounter(d udev.Discovery, config PartitionMountConfig) (*PartitionMounter, mux.CancelFunc, error) {
+	if config.Mounter == nil {
+		config.Mounter = sysMounter{}
+	}
+	if config.MountInfo == "" {
+		config.MountInfo = defaultMountInfo
+	}
+	if err := os.MkdirAll(config.StagingDir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create staging dir: %w", err)
+	}
+	stagingDir, err := filepath.EvalSymlinks(config.StagingDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve staging dir: %w", err)
+	}
+	config.StagingDir = stagingDir
+	if err := checkShared(config.MountInfo, config.StagingDir); err != nil {
+		return nil, nil, err
+	}
+	m := &PartitionMounter{
+		config: config,
+		mounts: make(map[string]string),
+	}
+	if err := m.adopt(); err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan udev.Event, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range ch {
+			if removed, ok := ev.(udev.Removed); ok && removed.Device != nil {
+				m.unmount(removed.DevNode())
+			}
+		}
+	}()
+	cancel := d.Subscribe(mux.SinkFromChan(ch))
+
+	return m, func() {
+		cancel()
+		<-done
+		m.unmountAll()
+	}, nil
+}
+
+// mountInfoUnescaper undoes the octal escapes of paths in mountinfo.
+var mountInfoUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// mountEntry is a line of a mount table (see proc_pid_mountinfo(5)).
+type mountEntry struct {
+	point  string // mount point
+	source string // mount source, e.g. the device node
+	shared bool   // the mount has shared propagation
+}
+
+// readMountInfo returns the entries of the mount table at mountInfo, in the
+// order they were mounted.
+func readMountInfo(mountInfo string) ([]mountEntry, error) {
+	content, err := os.ReadFile(mountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	var entries []mountEntry
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 7 {
+			continue
+		}
+		entry := mountEntry{point: mountInfoUnescaper.Replace(fields[4])}
+		for i, field := range fields[6:] {
+			if field == "-" {
+				if rest := fields[6+i+1:]; len(rest) >= 2 {
+					entry.source = mountInfoUnescaper.Replace(rest[1])
+				}
+				break
+			}
+			if strings.HasPrefix(field, "shared:") {
+				entry.shared = true
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// checkShared fails unless dir is on a mount with shared propagation in the
+// mount table at mountInfo.
+func checkShared(mountInfo, dir string) error {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve staging dir: %w", err)
+	}
+	entries, err := readMountInfo(mountInfo)
+	if err != nil {
+		return err
+	}
+
+	// The mount a path is on is the one with the longest mount point above
+	// it, and the last of those mounted there.
+	var mountPoint string
+	var shared bool
+	for _, entry := range entries {
+		point := entry.point
+		if !(point == "/" || dir == point || strings.HasPrefix(dir, point+"/")) || len(point) < len(mountPoint) {
+			continue
+		}
+		mountPoint, shared = point, entry.shared
+	}
+	switch {
+	case mountPoint == "":
+		return fmt.Errorf("staging dir %s is on no mount in %s", dir, mountInfo)
+	case !shared:
+		return fmt.Errorf("staging dir %s is on mount %s, which is not shared; mount it with mountPropagation: Bidirectional", dir, mountPoint)
+	}
+	return nil
+}
+
+// checkFsType fails unless dev carries a filesystem of the configured type.
+func (m *PartitionMounter) checkFsType(dev udev.Device) error {
+	fsType := dev.Property(udev.PropertyFsType)
+	if fsType != m.config.FsType {
+		return fmt.Errorf("partition %s has filesystem %q, expected %q", dev.DevNode(), fsType, m.config.FsType)
+	}
+	return nil
+}
+
+// stagedMounts returns how often each mount point under the staging dir is
+// mounted, and the source of the last mount there.
+func (m *PartitionMounter) stagedMounts() (counts map[string]int, sources map[string]string, err error) {
+	entries, err := readMountInfo(m.config.MountInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts = make(map[string]int)
+	sources = make(map[string]string)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.point, m.config.StagingDir+"/") {
+			counts[entry.point]++
+			sources[entry.point] = entry.source
+		}
+	}
+	return counts, sources, nil
+}
+
+// adopt takes over the partitions mounted at their staging path by an earlier
+// run, and detaches every other mount under the staging dir, as well as
+// mounts stacked on a staging path.
+func (m *PartitionMounter) adopt() error {
+	counts, sources, err := m.stagedMounts()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for target, count := range counts {
+		source := sources[target]
+		rel, _ := filepath.Rel(m.config.StagingDir, target)
+		staged := len(strings.Split(rel, "/")) == 3 && filepath.Base(source) == filepath.Base(target)
+		if staged {
+			count--
+		}
+		for ; count > 0; count-- {
+			if err := m.config.Mounter.Unmount(target); err != nil {
+				return fmt.Errorf("failed to detach stale mount %s: %w", target, err)
+			}
+			klog.Infof("detached stale mount %s", target)
+		}
+		if staged {
+			klog.Infof("adopted %s mounted at %s", source, target)
+			m.mounts[target] = source
+		}
+	}
+	return nil
+}
+
+// mount mounts dev at its staging path unless it is mounted there already,
+// and returns the staging path. Whatever else is mounted at the staging path
+// is detached first.
+func (m *PartitionMounter) mount(dev udev.Device, domain, label string) (string, error) {
+	if err := m.checkFsType(dev); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	target := filepath.Join(m.config.StagingDir, domain, label, udev.Sysname(dev))
+	if m.mounts[target] == dev.DevNode() {
+		return target, nil
+	}
+	counts, sources, err := m.stagedMounts()
+	if err != nil {
+		return "", err
+	}
+	if counts[target] == 1 && sources[target] == dev.DevNode() {
+		klog.Infof("adopted %s mounted at %s", dev.DevNode(), target)
+		m.mounts[target] = dev.DevNode()
+		return target, nil
+	}
+	for count := counts[target]; count > 0; count-- {
+		if err := m.config.Mounter.Unmount(target); err != nil {
+			return "", fmt.Errorf("failed to detach %s from %s: %w", sources[target], target, err)
+		}
+	}
+	delete(m.mounts, target)
+
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory for %s: %w", dev.DevNode(), err)
+	}
+	if err := m.config.Mounter.Mount(dev.DevNode(), target, m.config.FsType, m.config.ReadOnly); err != nil {
+		return "", fmt.Errorf("failed to mount %s at %s: %w", dev.DevNode(), target, err)
+	}
+	klog.Infof("mounted %s at %s", dev.DevNode(), target)
+	m.mounts[target] = dev.DevNode()
+	return target, nil
+}
+
+// unmount unmounts the staging paths device node devNode is mounted at.
+func (m *PartitionMounter) unmount(devNode string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for target, source := range m.mounts {
+		if source == devNode {
+			m.unmountLocked(target)
+		}
+	}
+}
+
+func (m *PartitionMounter) unmountAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for target := range m.mounts {
+		m.unmountLocked(target)
+	}
+}
+
+func (m *PartitionMounter) unmountLocked(target string) {
+	if err := m.config.Mounter.Unmount(target); err != nil {
+		klog.Errorf("failed to unmount %s: %v", target, err)
+		return
+	}
+	klog.Infof("unmounted %s", target)
+	delete(m.mounts, target)
+}
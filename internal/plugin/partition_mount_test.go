@@ -0,0 +1,291 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// fakeMounter records the targets mounted and unmounted through it.
+type fakeMounter struct {
+	mu        sync.Mutex
+	mounts    map[string]string // target -> source
+	unmounted []string
+}
+
+func newFakeMounter() *fakeMounter {
+	return &fakeMounter{mounts: make(map[string]string)}
+}
+
+func (f *fakeMounter) Mount(source, target, fsType string, readOnly bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mounts[target] = source
+	return nil
+}
+
+func (f *fakeMounter) Unmount(target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.mounts, target)
+	f.unmounted = append(f.unmounted, target)
+	return nil
+}
+
+func (f *fakeMounter) unmounts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.unmounted...)
+}
+
+func (f *fakeMounter) mounted() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mounts := make(map[string]string, len(f.mounts))
+	for target, source := range f.mounts {
+		mounts[target] = source
+	}
+	return mounts
+}
+
+// writeMountInfo writes a mount table with lines to a temporary file and
+// returns its path.
+func writeMountInfo(lines ...string) string {
+	path := filepath.Join(GinkgoT().TempDir(), "mountinfo")
+	Expect(os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)).To(Succeed())
+	return path
+}
+
+var _ = Describe("checkShared", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	It("accepts a dir on a shared mount", func() {
+		Expect(checkShared(writeMountInfo(
+			"1 0 8:1 / / rw,relatime - ext4 /dev/sda1 rw",
+			"2 1 8:2 / "+filepath.Dir(dir)+" rw,relatime shared:7 master:1 - ext4 /dev/sda2 rw",
+		), dir)).To(Succeed())
+	})
+
+	It("refuses a dir on a private mount under a shared one", func() {
+		Expect(checkShared(writeMountInfo(
+			"1 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw",
+			"2 1 8:2 / "+dir+" rw,relatime - ext4 /dev/sda2 rw",
+		), dir)).To(MatchError(ContainSubstring("mountPropagation: Bidirectional")))
+	})
+
+	It("unescapes mount points", func() {
+		spaced := filepath.Join(dir, "staging dir")
+		Expect(os.Mkdir(spaced, 0o755)).To(Succeed())
+		Expect(checkShared(writeMountInfo(
+			"1 0 8:1 / / rw,relatime - ext4 /dev/sda1 rw",
+			`2 1 8:2 / `+strings.ReplaceAll(spaced, " ", `\040`)+` rw shared:2 - ext4 /dev/sda2 rw`,
+		), spaced)).To(Succeed())
+	})
+})
+
+var _ = Describe("PartitionMounter", func() {
+	var (
+		stagingDir string
+		mounter    *fakeMounter
+		discovery  *udev.FakeDiscovery
+		dev        *mockDevice
+		part       *partition
+		unmount    func()
+	)
+
+	BeforeEach(func() {
+		stagingDir = GinkgoT().TempDir()
+		mounter = newFakeMounter()
+		discovery = udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+
+		dev = partitionDevice("sda1", "data_01")
+		dev.properties[udev.PropertyFsType] = "ext4"
+
+		var m *PartitionMounter
+		var err error
+		m, unmount, err = NewPartitionMounter(discovery, PartitionMountConfig{
+			StagingDir: stagingDir,
+			FsType:     "ext4",
+			ReadOnly:   true,
+			Mounter:    mounter,
+			MountInfo:  writeMountInfo("1 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw"),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		part = &partition{domain: "ydb.tech", label: "data_01", dev: dev}
+		WithPartitionMounter(m)(part)
+	})
+
+	It("mounts the partition under the staging dir and passes the mount", func() {
+		resp, err := part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		target := filepath.Join(stagingDir, "ydb.tech", "data_01", "sda1")
+		Expect(target).To(BeADirectory())
+		Expect(mounter.mounted()).To(Equal(map[string]string{target: "/dev/sda1"}))
+		Expect(resp.Devices).To(BeEmpty())
+		Expect(resp.Mounts).To(Equal([]*pluginapi.Mount{{
+			ContainerPath: "/mnt/allocated/ydb.tech/part/data_01",
+			HostPath:      target,
+			ReadOnly:      true,
+		}}))
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_PATH", "/mnt/allocated/ydb.tech/part/data_01"))
+	})
+
+	It("mounts a partition only once", func() {
+		_, err := part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mounter.mounted()).To(HaveLen(1))
+	})
+
+	It("refuses a partition with another filesystem", func() {
+		dev.properties[udev.PropertyFsType] = "xfs"
+		Expect(part.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		_, err := part.Allocate(context.Background())
+		Expect(err).To(MatchError(ContainSubstring(`expected "ext4"`)))
+		Expect(mounter.mounted()).To(BeEmpty())
+	})
+
+	It("refuses a partition whose filesystem is only on its parent", func() {
+		delete(dev.properties, udev.PropertyFsType)
+		dev.parent = &mockDevice{
+			id:         "sda",
+			subsystem:  udev.BlockSubsystem,
+			devType:    udev.DeviceTypeDisk,
+			properties: map[string]string{udev.PropertyFsType: "ext4"},
+			sysattrs:   map[string]string{},
+			numaNode:   -1,
+		}
+		_, err := part.Allocate(context.Background())
+		Expect(err).To(MatchError(ContainSubstring(`has filesystem ""`)))
+		Expect(mounter.mounted()).To(BeEmpty())
+	})
+
+	It("mounts partitions with the same label on different disks apart", func() {
+		other := partitionDevice("sdb1", "data_01")
+		other.properties[udev.PropertyFsType] = "ext4"
+		otherPart := &partition{domain: "ydb.tech", label: "data_01", dev: other, mounter: part.mounter}
+
+		_, err := part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = otherPart.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mounter.mounted()).To(Equal(map[string]string{
+			filepath.Join(stagingDir, "ydb.tech", "data_01", "sda1"): "/dev/sda1",
+			filepath.Join(stagingDir, "ydb.tech", "data_01", "sdb1"): "/dev/sdb1",
+		}))
+	})
+
+	It("unmounts the partition when its device is removed", func() {
+		_, err := part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		discovery.Emit(udev.Removed{Device: dev})
+		Eventually(mounter.mounted).Should(BeEmpty())
+	})
+
+	It("unmounts everything when torn down", func() {
+		_, err := part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		unmount()
+		Expect(mounter.mounted()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("PartitionMounter after a restart", func() {
+	const rootMount = "1 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw"
+
+	var (
+		stagingDir string
+		target     string
+		mounter    *fakeMounter
+		discovery  *udev.FakeDiscovery
+		part       *partition
+	)
+
+	// start starts a PartitionMounter on a mount table with lines besides
+	// the root mount, and returns the path of the mount table and the
+	// teardown of the PartitionMounter.
+	start := func(lines ...string) (string, func()) {
+		mountInfo := writeMountInfo(append([]string{rootMount}, lines...)...)
+		m, unmount, err := NewPartitionMounter(discovery, PartitionMountConfig{
+			StagingDir: stagingDir,
+			FsType:     "ext4",
+			Mounter:    mounter,
+			MountInfo:  mountInfo,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		WithPartitionMounter(m)(part)
+		return mountInfo, unmount
+	}
+
+	BeforeEach(func() {
+		stagingDir = GinkgoT().TempDir()
+		target = filepath.Join(stagingDir, "ydb.tech", "data_01", "sda1")
+		mounter = newFakeMounter()
+		discovery = udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+
+		dev := partitionDevice("sda1", "data_01")
+		dev.properties[udev.PropertyFsType] = "ext4"
+		part = &partition{domain: "ydb.tech", label: "data_01", dev: dev}
+	})
+
+	It("adopts a partition mounted by the earlier run", func() {
+		_, unmount := start("2 1 8:1 / " + target + " rw,relatime shared:2 - ext4 /dev/sda1 rw")
+		Expect(mounter.unmounts()).To(BeEmpty())
+
+		resp, err := part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Mounts[0].HostPath).To(Equal(target))
+		Expect(mounter.mounted()).To(BeEmpty())
+
+		unmount()
+		Expect(mounter.unmounts()).To(Equal([]string{target}))
+	})
+
+	It("detaches stray and stacked mounts under the staging dir", func() {
+		stray := filepath.Join(stagingDir, "ydb.tech", "data_01")
+		start(
+			"2 1 8:3 / "+stray+" rw,relatime shared:2 - ext4 /dev/sdc1 rw",
+			"3 1 8:1 / "+target+" rw,relatime shared:3 - ext4 /dev/sda1 rw",
+			"4 3 8:1 / "+target+" rw,relatime shared:4 - ext4 /dev/sda1 rw",
+		)
+		Expect(mounter.unmounts()).To(ConsistOf(stray, target))
+	})
+
+	It("adopts a partition found mounted at its staging path", func() {
+		mountInfo, _ := start()
+		Expect(os.WriteFile(mountInfo, []byte(rootMount+"\n2 1 8:1 / "+target+" rw,relatime shared:2 - ext4 /dev/sda1 rw\n"), 0o644)).To(Succeed())
+
+		_, err := part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mounter.mounted()).To(BeEmpty())
+		Expect(mounter.unmounts()).To(BeEmpty())
+	})
+
+	It("detaches another device from the staging path before mounting", func() {
+		mountInfo, _ := start()
+		Expect(os.WriteFile(mountInfo, []byte(rootMount+"\n2 1 8:2 / "+target+" rw,relatime shared:2 - ext4 /dev/sdb1 rw\n"), 0o644)).To(Succeed())
+
+		_, err := part.Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mounter.unmounts()).To(Equal([]string{target}))
+		Expect(mounter.mounted()).To(Equal(map[string]string{target: "/dev/sda1"}))
+	})
+})
@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// envNameRegex matches valid environment variable names.
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// partitionTemplateFuncs are the functions available to partition templates
+// besides the text/template builtins.
+var partitionTemplateFuncs = template.FuncMap{
+	"upper":       strings.ToUpper,
+	"lower":       strings.ToLower,
+	"sanitizeEnv": sanitizeEnv,
+	"base":        path.Base,
+	"trimPrefix":  func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"replace":     func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+// PartitionTemplateData is what partition templates are evaluated against,
+// e.g. "/dev/ydb/{{ .Label }}" or "{{ .SysAttr \"wwid\" }}".
+type PartitionTemplateData struct {
+	Domain        string
+	Label         string
+	DevNode       string
+	DevLinks      []string
+	Properties    map[string]string
+	ContainerPath string // empty while the container path itself is rendered
+
+	dev udev.Device
+}
+
+// Property returns the udev property key of the partition or its parents.
+func (d PartitionTemplateData) Property(key string) string {
+	return d.dev.PropertyLookup(key)
+}
+
+// SysAttr returns the sysfs attribute key of the partition or its parents.
+func (d PartitionTemplateData) SysAttr(key string) string {
+	return d.dev.SystemAttributeLookup(key)
+}
+
+func newPartitionTemplateData(dev udev.Device, domain, label string) PartitionTemplateData {
+	return PartitionTemplateData{
+		Domain:     domain,
+		Label:      label,
+		DevNode:    dev.DevNode(),
+		DevLinks:   dev.DevLinks(),
+		Properties: dev.Properties(),
+		dev:        dev,
+	}
+}
+
+// EnvTemplate renders one environment variable.
+type EnvTemplate struct {
+	Name  *template.Template
+	Value *template.Template
+}
+
+// PartitionTemplates overrides how partitions are presented to containers.
+// Nil or empty fields keep the defaults.
+type PartitionTemplates struct {
+	// ContainerPath renders where the device or mount appears in containers.
+	ContainerPath *template.Template
+	// Envs replace the default environment when set.
+	Envs []EnvTemplate
+}
+
+// ParsePartitionTemplate parses text as a partition template named name.
+func ParsePartitionTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(partitionTemplateFuncs).Parse(text)
+}
+
+func render(tmpl *template.Template, data PartitionTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// containerPath renders the container path of the partition, falling back to
+// fallback without a template.
+func (t *PartitionTemplates) containerPath(data PartitionTemplateData, fallback string) (string, error) {
+	if t == nil || t.ContainerPath == nil {
+		return fallback, nil
+	}
+	containerPath, err := render(t.ContainerPath, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render container path of partition %s: %w", data.Label, err)
+	}
+	if !filepath.IsAbs(containerPath) {
+		return "", fmt.Errorf("container path %q of partition %s must be absolute", containerPath, data.Label)
+	}
+	return containerPath, nil
+}
+
+// envs renders the environment of the partition, falling back to the default
+// one without templates.
+func (t *PartitionTemplates) envs(data PartitionTemplateData) (map[string]string, error) {
+	if t == nil || len(t.Envs) == 0 {
+		return partitionEnvs(data.dev, data.Domain, data.Label, data.ContainerPath), nil
+	}
+	envs := make(map[string]string, len(t.Envs))
+	for _, env := range t.Envs {
+		name, err := render(env.Name, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render env name of partition %s: %w", data.Label, err)
+		}
+		if !envNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("env name %q of partition %s is not a valid variable name", name, data.Label)
+		}
+		value, err := render(env.Value, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render env %s of partition %s: %w", name, data.Label, err)
+		}
+		envs[name] = value
+	}
+	return envs, nil
+}
+
+// sampleDiskDevice and samplePartitionDevice stand in for real disks and
+// partitions when templates are validated.
+var sampleDiskDevice = udev.NewFakeDevice("/sys/devices/virtual/block/nvme0n1").
+	WithSubsystem(udev.BlockSubsystem).
+	WithDevType(udev.DeviceTypeDisk).
+	WithDevNode("/dev/nvme0n1").
+	WithProperty(udev.PropertyModel, "SAMSUNG_MZQL2960HCJR").
+	WithProperty(udev.PropertyShortSerial, "S64FNE0R000001").
+	WithProperty(udev.PropertyWWN, "eui.0000000001").
+	WithSysAttr(udev.SysAttrWWID, "eui.0000000001").
+	WithSysAttr(udev.SysAttrModel, "SAMSUNG MZQL2960HCJR").
+	WithSysAttr(udev.SysAttrSerial, "S64FNE0R000001")
+
+var samplePartitionDevice = udev.NewFakeDevice("/sys/devices/virtual/block/nvme0n1/nvme0n1p1").
+	WithParent(sampleDiskDevice).
+	WithSubsystem(udev.BlockSubsystem).
+	WithDevType(udev.DeviceTypePart).
+	WithDevNode("/dev/nvme0n1p1").
+	WithDevLinks("/dev/disk/by-partlabel/ydb_disk_01", "/dev/disk/by-id/nvme-eui.0000000001-part1").
+	WithProperty(udev.PropertyPartName, "ydb_disk_01").
+	WithProperty(udev.PropertyModel, "SAMSUNG_MZQL2960HCJR").
+	WithProperty(udev.PropertyShortSerial, "S64FNE0R000001").
+	WithProperty(udev.PropertyFsType, "ext4").
+	WithSysAttr(udev.SysAttrWWID, "eui.0000000001").
+	WithSysAttr(udev.SysAttrModel, "SAMSUNG MZQL2960HCJR").
+	WithSysAttr(udev.SysAttrSerial, "S64FNE0R000001")
+
+// Validate renders the templates against a sample partition, so that
+// mistakes surface when the config is loaded.
+func (t *PartitionTemplates) Validate() error {
+	data := newPartitionTemplateData(samplePartitionDevice, "example.com", "disk_01")
+	containerPath, err := t.containerPath(data, "/dev/sample")
+	if err != nil {
+		return err
+	}
+	data.ContainerPath = containerPath
+	_, err = t.envs(data)
+	return err
+}
+
+// allocate renders the container path and environment of partition dev,
+// defaulting the path to fallback.
+func (t *PartitionTemplates) allocate(dev udev.Device, domain, label, fallback string) (string, map[string]string, error) {
+	data := newPartitionTemplateData(dev, domain, label)
+	containerPath, err := t.containerPath(data, fallback)
+	if err != nil {
+		return "", nil, err
+	}
+	data.ContainerPath = containerPath
+	envs, err := t.envs(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return containerPath, envs, nil
+}
@@ -2,7 +2,9 @@ package plugin
 
 import (
 	"context"
+	"fmt"
 	"regexp"
+	"text/template"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -37,54 +39,137 @@ var _ = Describe("allocatePartitionDevice", func() {
 	})
 
 	It("creates a single device spec", func() {
-		resp := allocatePartitionDevice(dev, "ydb.tech", "data_01")
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Devices).To(HaveLen(1))
 	})
 
 	It("sets correct host and container paths", func() {
-		resp := allocatePartitionDevice(dev, "ydb.tech", "data_01")
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Devices[0].HostPath).To(Equal("/dev/sda1"))
 		Expect(resp.Devices[0].ContainerPath).To(Equal("/dev/allocated/ydb.tech/part/data_01"))
 		Expect(resp.Devices[0].Permissions).To(Equal("rw"))
 	})
 
 	It("sets PATH env var to container path", func() {
-		resp := allocatePartitionDevice(dev, "ydb.tech", "data_01")
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Envs["YDB_TECH_PART_DATA_01_PATH"]).To(Equal("/dev/allocated/ydb.tech/part/data_01"))
 	})
 
 	It("sets DISK_ID env var from wwid sysattr", func() {
-		resp := allocatePartitionDevice(dev, "ydb.tech", "data_01")
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Envs["YDB_TECH_PART_DATA_01_DISK_ID"]).To(Equal("eui.test123"))
 	})
 
 	It("sets DISK_MODEL env var from model sysattr", func() {
-		resp := allocatePartitionDevice(dev, "ydb.tech", "data_01")
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Envs["YDB_TECH_PART_DATA_01_DISK_MODEL"]).To(Equal("SAMSUNG SSD"))
 	})
 
 	It("sets DISK_SERIAL env var from serial sysattr", func() {
-		resp := allocatePartitionDevice(dev, "ydb.tech", "data_01")
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Envs["YDB_TECH_PART_DATA_01_DISK_SERIAL"]).To(Equal("S001"))
 	})
 
 	It("falls back to PropertyShortSerial when serial sysattr is empty", func() {
 		dev.sysattrs[udev.SysAttrSerial] = ""
 		dev.properties[udev.PropertyShortSerial] = "PROP_SERIAL"
-		resp := allocatePartitionDevice(dev, "ydb.tech", "data_01")
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Envs["YDB_TECH_PART_DATA_01_DISK_SERIAL"]).To(Equal("PROP_SERIAL"))
 	})
 
 	It("omits DISK_SERIAL when neither sysattr nor property has a serial", func() {
 		dev.sysattrs[udev.SysAttrSerial] = ""
-		resp := allocatePartitionDevice(dev, "ydb.tech", "data_01")
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Envs).NotTo(HaveKey("YDB_TECH_PART_DATA_01_DISK_SERIAL"))
 	})
 
 	It("sanitizes domain and label in env var names", func() {
-		resp := allocatePartitionDevice(dev, "my.domain", "my-label")
+		resp, err := allocatePartitionDevice(dev, "my.domain", "my-label", nil)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.Envs).To(HaveKey("MY_DOMAIN_PART_MY_LABEL_PATH"))
 	})
+
+	It("sets the metadata of the partition and its disk", func() {
+		dev.sysattrs[udev.SysAttrSize] = "2048"
+		dev.devLinks = []string{"/dev/disk/by-partuuid/0b7a3c2e-01"}
+		dev.parent = &mockDevice{
+			id:        "/sys/devices/virtual/block/sda",
+			subsystem: udev.BlockSubsystem,
+			properties: map[string]string{
+				udev.PropertyBus: "ata",
+			},
+			sysattrs: map[string]string{
+				udev.SysAttrSize:              "4096",
+				udev.SysAttrLogicalBlockSize:  "512",
+				udev.SysAttrPhysicalBlockSize: "4096",
+				udev.SysAttrRotational:        "1",
+				udev.SysAttrZoned:             "none",
+			},
+		}
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_SIZE_BYTES", "1048576"))
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_LOGICAL_BLOCK_SIZE", "512"))
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_PHYSICAL_BLOCK_SIZE", "4096"))
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_ROTATIONAL", "1"))
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_TRANSPORT", "sata"))
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_ZONED", "none"))
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_PARTUUID_PATH", "/dev/disk/by-partuuid/0b7a3c2e-01"))
+	})
+
+	It("omits metadata that cannot be resolved", func() {
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Envs).NotTo(HaveKey("YDB_TECH_PART_DATA_01_SIZE_BYTES"))
+		Expect(resp.Envs).NotTo(HaveKey("YDB_TECH_PART_DATA_01_TRANSPORT"))
+	})
+})
+
+var _ = Describe("readPartitionMetadata", func() {
+	It("takes the transport of NVMe disks from the controller", func() {
+		ctrl := &mockDevice{id: "/sys/devices/pci0000:00/0000:00:01.0/nvme/nvme0", subsystem: udev.NvmeSubsystem}
+		disk := &mockDevice{id: ctrl.id + "/nvme0n1", subsystem: udev.BlockSubsystem, parent: ctrl}
+		dev := partitionDevice(string(disk.id)+"/nvme0n1p1", "data_01")
+		dev.parent = disk
+		Expect(diskTransport(dev)).To(Equal("nvme"))
+	})
+
+	It("reports SCSI disks as sas only behind a SAS port", func() {
+		host := &mockDevice{id: "/sys/devices/pci0000:00/0000:00:02.0/host0", subsystem: "scsi"}
+		disk := &mockDevice{
+			id:         host.id + "/target0:0:0/0:0:0:0/block/sda",
+			subsystem:  udev.BlockSubsystem,
+			properties: map[string]string{udev.PropertyBus: "scsi"},
+			parent:     host,
+		}
+		dev := partitionDevice(string(disk.id)+"/sda1", "data_01")
+		dev.parent = disk
+		Expect(diskTransport(dev)).To(Equal("scsi"))
+
+		disk.parent = &mockDevice{id: host.id + "/port-0:0", subsystem: "sas_port", parent: host}
+		Expect(diskTransport(dev)).To(Equal("sas"))
+	})
+
+	It("derives the by-partuuid path from the partition UUID", func() {
+		dev := partitionDevice("sda1", "data_01")
+		dev.properties[udev.PropertyPartUUID] = "0b7a3c2e-01"
+		Expect(partUUIDPath(dev)).To(Equal("/dev/disk/by-partuuid/0b7a3c2e-01"))
+	})
+
+	It("formats the metadata for logging", func() {
+		dev := partitionDevice("sda1", "data_01")
+		dev.sysattrs[udev.SysAttrSize] = "1"
+		dev.sysattrs[udev.SysAttrRotational] = "0"
+		Expect(readPartitionMetadata(dev).String()).To(Equal("SIZE_BYTES=512 ROTATIONAL=0"))
+	})
 })
 
 var _ = Describe("PartitionLabelMatcherTemplater", func() {
@@ -181,6 +266,38 @@ var _ = Describe("PartitionLabelMatcherInstances", func() {
 		Expect(instances).To(HaveLen(1))
 		Expect(string(instances[0].Id())).To(Equal("nvme_disk01"))
 	})
+
+	It("keeps the full labels of partitions matching a part of them without a capture group", func() {
+		matcher = regexp.MustCompile("ydb_disk")
+		var ids []string
+		for _, dev := range []*mockDevice{
+			partitionDevice("nvme0n1p1", "ydb_disk_01"),
+			partitionDevice("nvme1n1p1", "ydb_disk_02"),
+		} {
+			instances, err := PartitionLabelMatcherInstances("ydb.tech", matcher, false)(dev)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			ids = append(ids, string(instances[0].Id()))
+		}
+		Expect(ids).To(Equal([]string{"ydb_disk_01", "ydb_disk_02"}))
+	})
+
+	It("returns one instance per replica backed by the same device", func() {
+		dev := partitionDevice("nvme0n1p1", "nvme_disk01")
+		instances, err := PartitionLabelMatcherInstances("ydb.tech", matcher, false,
+			WithPartitionReplicas(3), WithPartitionPermissions("r"))(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(3))
+		for i, instance := range instances {
+			Expect(string(instance.Id())).To(Equal(fmt.Sprintf("disk01#%d", i)))
+
+			resp, err := instance.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Devices).To(HaveLen(1))
+			Expect(resp.Devices[0].HostPath).To(Equal("/dev/nvme0n1p1"))
+			Expect(resp.Devices[0].Permissions).To(Equal("r"))
+		}
+	})
 })
 
 var _ = Describe("partition", func() {
@@ -229,3 +346,58 @@ var _ = Describe("partition", func() {
 		})
 	})
 })
+
+var _ = Describe("PartitionTemplates", func() {
+	var (
+		dev       *mockDevice
+		templates *PartitionTemplates
+	)
+
+	mustParse := func(text string) *template.Template {
+		tmpl, err := ParsePartitionTemplate("test", text)
+		Expect(err).NotTo(HaveOccurred())
+		return tmpl
+	}
+
+	BeforeEach(func() {
+		dev = partitionDevice("sda1", "data_01")
+		dev.properties[udev.PropertyShortSerial] = "S001"
+		dev.sysattrs[udev.SysAttrWWID] = "eui.test123"
+		templates = &PartitionTemplates{
+			ContainerPath: mustParse("/dev/ydb/{{ .Label }}"),
+			Envs: []EnvTemplate{
+				{Name: mustParse("YDB_{{ .Label | upper }}_PATH"), Value: mustParse("{{ .ContainerPath }}")},
+				{Name: mustParse("YDB_{{ .Label | upper }}_ID"), Value: mustParse(`{{ .SysAttr "wwid" }}/{{ .Property "ID_SERIAL_SHORT" }}`)},
+			},
+		}
+	})
+
+	It("renders the container path and replaces the default environment", func() {
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", templates)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Devices[0].ContainerPath).To(Equal("/dev/ydb/data_01"))
+		Expect(resp.Envs).To(Equal(map[string]string{
+			"YDB_DATA_01_PATH": "/dev/ydb/data_01",
+			"YDB_DATA_01_ID":   "eui.test123/S001",
+		}))
+	})
+
+	It("keeps the default environment without env templates", func() {
+		templates.Envs = nil
+		resp, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", templates)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_PART_DATA_01_PATH", "/dev/ydb/data_01"))
+	})
+
+	It("fails when a rendered env name is not a variable name", func() {
+		templates.Envs[0].Name = mustParse("{{ .DevNode }}")
+		_, err := allocatePartitionDevice(dev, "ydb.tech", "data_01", templates)
+		Expect(err).To(MatchError(ContainSubstring("not a valid variable name")))
+	})
+
+	It("validates against a sample partition", func() {
+		Expect(templates.Validate()).To(Succeed())
+		templates.ContainerPath = mustParse(`{{ index .DevLinks 5 }}`)
+		Expect(templates.Validate()).NotTo(Succeed())
+	})
+})
@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
@@ -22,15 +26,22 @@ import (
 type plugin struct {
 	resource  Resource
 	pluginDir string
+	checks    []InstanceCheck
 	cancel    context.CancelFunc
 	stopped   chan struct{} // closed after gRPC server is fully stopped
+	streams   atomic.Int32  // open ListAndWatch streams
+
+	mu           sync.Mutex
+	registeredAt time.Time // when kubelet last accepted the registration
+	registerErr  error     // why the last registration failed
 }
 
-func newPlugin(resource Resource, ctx context.Context, wg *sync.WaitGroup, pluginDir string) (*plugin, error) {
+func newPlugin(resource Resource, ctx context.Context, wg *sync.WaitGroup, pluginDir string, checks []InstanceCheck) (*plugin, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	plugin := &plugin{
 		resource:  resource,
 		pluginDir: pluginDir,
+		checks:    checks,
 		cancel:    cancel,
 		stopped:   make(chan struct{}),
 	}
@@ -98,6 +109,9 @@ func (p *plugin) PreStartContainer(context.Context, *pluginapi.PreStartContainer
 func (p *plugin) ListAndWatch(empty *pluginapi.Empty, stream pluginapi.DevicePlugin_ListAndWatchServer) (err error) {
 	defer klog.Infof("%q: closing ListAndWatch connection, err = %v", p.resource.Name(), err)
 
+	p.streams.Add(1)
+	defer p.streams.Add(-1)
+
 	ctx := stream.Context()
 	instanceCh := p.resource.ListAndWatch(ctx)
 	for {
@@ -110,7 +124,7 @@ func (p *plugin) ListAndWatch(empty *pluginapi.Empty, stream pluginapi.DevicePlu
 			for i, instance := range instances {
 				devices[i] = &pluginapi.Device{
 					ID:       string(instance.Id()),
-					Health:   instance.Health().String(),
+					Health:   p.health(instance).String(),
 					Topology: instance.TopologyHints(),
 				}
 			}
@@ -125,34 +139,188 @@ func (p *plugin) ListAndWatch(empty *pluginapi.Empty, stream pluginapi.DevicePlu
 	}
 }
 
-func mergeResponses(responses ...*pluginapi.ContainerAllocateResponse) *pluginapi.ContainerAllocateResponse {
+// health returns the health of instance, or Unhealthy if one of the instance
+// checks of the registry rejects it.
+func (p *plugin) health(instance Instance) Health {
+	return checkedHealth(p.resource.Name(), instance, p.checks)
+}
+
+// attach records the health history of the resource with the checks of the
+// plugin applied, telling observers about changes.
+func (p *plugin) attach(observers []Observer) {
+	if res, ok := p.resource.(*resource); ok {
+		res.attach(p.checks, observers)
+	}
+}
+
+// checkedHealth returns the health of instance of the named resource, or
+// Unhealthy with the reason of the first of checks that rejects it.
+func checkedHealth(resource string, instance Instance, checks []InstanceCheck) Health {
+	health := instance.Health()
+	if !isHealthy(health) {
+		return health
+	}
+	for _, check := range checks {
+		if err := check.CheckInstance(resource, instance); err != nil {
+			return Unhealthy{Reason: err.Error()}
+		}
+	}
+	return health
+}
+
+// registered records the outcome of registering with kubelet.
+func (p *plugin) registered(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registerErr = err
+	if err == nil {
+		p.registeredAt = time.Now()
+	}
+}
+
+// status describes the plugin and the instances of its resource, with their
+// health transitions if history is set.
+func (p *plugin) status(history bool) ResourceStatus {
+	p.mu.Lock()
+	status := ResourceStatus{
+		Name:         p.resource.Name(),
+		Socket:       p.pluginDir + p.socketPath(),
+		Registered:   p.registerErr == nil && !p.registeredAt.IsZero(),
+		RegisteredAt: p.registeredAt,
+		Subscribers:  int(p.streams.Load()),
+	}
+	if p.registerErr != nil {
+		status.RegisterError = p.registerErr.Error()
+	}
+	p.mu.Unlock()
+
+	res, _ := p.resource.(*resource)
+	for _, instance := range p.resource.Instances() {
+		health := p.health(instance)
+		reason, since := health.details()
+		instanceStatus := InstanceStatus{
+			Id:     instance.Id(),
+			Health: health.String(),
+			Reason: reason,
+			Since:  since,
+		}
+		for _, dev := range instanceDevices(instance) {
+			instanceStatus.Devices = append(instanceStatus.Devices, string(dev.Id()))
+		}
+		if res != nil {
+			transitions := res.transitions(instance.Id())
+			if last := len(transitions) - 1; last >= 0 && transitions[last].Health == instanceStatus.Health {
+				instanceStatus.Since = transitions[last].Time
+			}
+			if history {
+				instanceStatus.History = transitions
+			}
+		}
+		status.Instances = append(status.Instances, instanceStatus)
+	}
+	slices.SortFunc(status.Instances, func(a, b InstanceStatus) int {
+		return strings.Compare(string(a.Id), string(b.Id))
+	})
+	return status
+}
+
+// mergeValue combines two values of the same env or annotation key.
+func mergeValue(kind mergeKind, old, value string) string {
+	switch kind {
+	case mergeList:
+		return joinLists(old, value)
+	case mergeSum:
+		if sum, ok := sumQuantities(old, value); ok {
+			return sum
+		}
+	}
+	return value
+}
+
+// sumQuantities adds two integers that share the same (possibly empty) unit
+// suffix, e.g. "1000" + "500" or "1000M" + "500M".
+func sumQuantities(a, b string) (string, bool) {
+	unit := strings.TrimLeft(a, "0123456789")
+	if unit != strings.TrimLeft(b, "0123456789") {
+		return "", false
+	}
+	x, err := strconv.ParseUint(strings.TrimSuffix(a, unit), 10, 64)
+	if err != nil {
+		return "", false
+	}
+	y, err := strconv.ParseUint(strings.TrimSuffix(b, unit), 10, 64)
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatUint(x+y, 10) + unit, true
+}
+
+// joinLists appends the items of the comma-separated list b missing from a.
+func joinLists(a, b string) string {
+	items := strings.Split(a, ",")
+	seen := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		seen[item] = struct{}{}
+	}
+	for _, item := range strings.Split(b, ",") {
+		if _, ok := seen[item]; !ok {
+			seen[item] = struct{}{}
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// mergeResponses combines the responses of all instances allocated to one
+// container. Devices and mounts passed by several instances, such as the
+// char devices of RDMA instances sharing a device, are kept once. Envs and
+// annotations passed by several instances are merged as policy, declared by
+// the resource passing them, says; a nil policy overwrites them.
+func mergeResponses(policy func(key string) mergeKind, responses ...*pluginapi.ContainerAllocateResponse) *pluginapi.ContainerAllocateResponse {
 	response := &pluginapi.ContainerAllocateResponse{}
 	for _, r := range responses {
 		if r == nil {
 			continue
 		}
-		response.Devices = append(response.Devices, r.Devices...)
-		response.Mounts = append(response.Mounts, r.Mounts...)
+		for _, dev := range r.Devices {
+			if !slices.ContainsFunc(response.Devices, func(d *pluginapi.DeviceSpec) bool {
+				return d.HostPath == dev.HostPath && d.ContainerPath == dev.ContainerPath && d.Permissions == dev.Permissions
+			}) {
+				response.Devices = append(response.Devices, dev)
+			}
+		}
+		for _, mount := range r.Mounts {
+			if !slices.ContainsFunc(response.Mounts, func(m *pluginapi.Mount) bool {
+				return m.HostPath == mount.HostPath && m.ContainerPath == mount.ContainerPath && m.ReadOnly == mount.ReadOnly
+			}) {
+				response.Mounts = append(response.Mounts, mount)
+			}
+		}
 		if len(r.Envs) > 0 {
 			if response.Envs == nil {
 				response.Envs = make(map[string]string)
 			}
-			for key, value := range r.Envs {
-				response.Envs[key] = value
-			}
+			mergeInto(response.Envs, r.Envs, policy)
 		}
 		if len(r.Annotations) > 0 {
 			if response.Annotations == nil {
 				response.Annotations = make(map[string]string)
 			}
-			for key, value := range r.Annotations {
-				response.Annotations[key] = value
-			}
+			mergeInto(response.Annotations, r.Annotations, policy)
 		}
 	}
 	return response
 }
 
+func mergeInto(dst, src map[string]string, policy func(key string) mergeKind) {
+	for key, value := range src {
+		if old, ok := dst[key]; ok && policy != nil {
+			value = mergeValue(policy(key), old, value)
+		}
+		dst[key] = value
+	}
+}
+
 func (p *plugin) Allocate(ctx context.Context, request *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
 	klog.Infof("%q: Received allocation request", p.resource.Name())
 	klog.V(2).Infof("%+v", request)
@@ -161,6 +329,7 @@ func (p *plugin) Allocate(ctx context.Context, request *pluginapi.AllocateReques
 	response := &pluginapi.AllocateResponse{}
 	for _, containerRequest := range request.ContainerRequests {
 		containerResponse := &pluginapi.ContainerAllocateResponse{}
+		allocated := make([]Instance, 0, len(containerRequest.DevicesIDs))
 		klog.V(2).Infof("%q: Processing container request: %+v", p.resource.Name(), containerRequest)
 		for _, id := range containerRequest.DevicesIDs {
 			instance, found := instances[Id(id)]
@@ -173,7 +342,12 @@ func (p *plugin) Allocate(ctx context.Context, request *pluginapi.AllocateReques
 				klog.Errorf("%q: failed to allocate device with ID %q: %v", p.resource.Name(), id, err)
 				return nil, status.Errorf(codes.Internal, "failed to allocate device with ID %q: %s", id, err.Error())
 			}
-			containerResponse = mergeResponses(containerResponse, allocateResponse)
+			containerResponse = mergeResponses(mergePolicy(instance), containerResponse, allocateResponse)
+			allocated = append(allocated, instance)
+		}
+		if err := recordAllocation(allocated, containerResponse); err != nil {
+			klog.Errorf("%q: failed to record allocation of %v: %v", p.resource.Name(), containerRequest.DevicesIDs, err)
+			return nil, status.Errorf(codes.Internal, "failed to record allocation of %v: %s", containerRequest.DevicesIDs, err.Error())
 		}
 		response.ContainerResponses = append(response.ContainerResponses, containerResponse)
 	}
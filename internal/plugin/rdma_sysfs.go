@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rdmaDevDir is where the RDMA character devices live.
+const rdmaDevDir = "/dev/infiniband"
+
+// rdmaCharDeviceClasses are the sysfs classes of per-device RDMA character
+// devices. Each entry has an ibdev attribute naming its RDMA device.
+var rdmaCharDeviceClasses = []string{"infiniband_verbs", "infiniband_mad", "infiniband_cm"}
+
+// sysfsRdmaTopology is an [RdmaTopology] backed by sysfs mounted at root.
+type sysfsRdmaTopology struct {
+	sysfs
+
+	mu      sync.Mutex
+	netdevs map[string][]string // RDMA device name -> last seen netdev names
+}
+
+// NewSysfsRdmaTopology returns an [RdmaTopology] reading sysfs mounted at
+// root, e.g. [DefaultSysfsRoot].
+func NewSysfsRdmaTopology(root string) RdmaTopology {
+	return &sysfsRdmaTopology{
+		sysfs:   sysfs{root: root},
+		netdevs: make(map[string][]string),
+	}
+}
+
+// DeviceForNetdev looks up <root>/class/net/<ifname>/device/infiniband.
+func (s *sysfsRdmaTopology) DeviceForNetdev(ifname string) (string, error) {
+	names, err := s.list(filepath.Join("class", "net", ifname, "device", "infiniband"))
+	if err != nil {
+		return "", fmt.Errorf("failed to get rdma device for %s: %w", ifname, err)
+	}
+	if len(names) == 0 {
+		return s.cachedDeviceForNetdev(ifname), nil
+	}
+	// remember the netdevs so that a later removal of the RDMA device can
+	// be mapped back to them
+	s.NetdevsForDevice(names[0])
+	return names[0], nil
+}
+
+// cachedDeviceForNetdev returns the RDMA device ifname was last seen with,
+// so that the removal of a netdev can still be mapped.
+func (s *sysfsRdmaTopology) cachedDeviceForNetdev(ifname string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for rdmaDev, netdevs := range s.netdevs {
+		for _, netdev := range netdevs {
+			if netdev == ifname {
+				return rdmaDev
+			}
+		}
+	}
+	return ""
+}
+
+// NetdevsForDevice looks up <root>/class/infiniband/<rdmaDev>/device/net.
+func (s *sysfsRdmaTopology) NetdevsForDevice(rdmaDev string) []string {
+	names, _ := s.list(filepath.Join("class", "infiniband", rdmaDev, "device", "net"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(names) == 0 {
+		return s.netdevs[rdmaDev]
+	}
+	s.netdevs[rdmaDev] = names
+	return names
+}
+
+// CharDevices collects the uverbs, umad, issm and ucm devices whose ibdev is
+// rdmaDev, plus rdma_cm if the kernel provides it. A device that is gone has
+// no character devices.
+func (s *sysfsRdmaTopology) CharDevices(rdmaDev string) ([]string, error) {
+	var devices []string
+	for _, class := range rdmaCharDeviceClasses {
+		names, err := s.list(filepath.Join("class", class))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s devices of %s: %w", class, rdmaDev, err)
+		}
+		for _, name := range names {
+			if s.read(filepath.Join("class", class, name, "ibdev")) == rdmaDev {
+				devices = append(devices, filepath.Join(rdmaDevDir, name))
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(s.root, "class", "misc", "rdma_cm")); err == nil {
+		devices = append(devices, filepath.Join(rdmaDevDir, "rdma_cm"))
+	}
+	return devices, nil
+}
+
+// Ports reads <root>/class/infiniband/<rdmaDev>/ports/<n>.
+func (s *sysfsRdmaTopology) Ports(rdmaDev string) []RdmaPort {
+	dir := filepath.Join("class", "infiniband", rdmaDev, "ports")
+	nums, _ := s.list(dir)
+	ports := make([]RdmaPort, 0, len(nums))
+	for _, num := range nums {
+		portDir := filepath.Join(dir, num)
+		ports = append(ports, RdmaPort{
+			Num:       num,
+			State:     stripEnumPrefix(s.read(filepath.Join(portDir, "state"))),
+			PhysState: stripEnumPrefix(s.read(filepath.Join(portDir, "phys_state"))),
+			LinkLayer: s.read(filepath.Join(portDir, "link_layer")),
+			Gid:       s.read(filepath.Join(portDir, "gids", "0")),
+		})
+	}
+	return ports
+}
+
+// Gids reads <root>/class/infiniband/<rdmaDev>/ports/<port>/gids/<i> and the
+// matching gid_attrs/types/<i>. Unpopulated (zero) entries are skipped.
+func (s *sysfsRdmaTopology) Gids(rdmaDev, port string) []RdmaGid {
+	dir := filepath.Join("class", "infiniband", rdmaDev, "ports", port)
+	names, _ := s.list(filepath.Join(dir, "gids"))
+	gids := make([]RdmaGid, 0, len(names))
+	for _, name := range names {
+		index, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		gid := s.read(filepath.Join(dir, "gids", name))
+		if gid == "" || gid == rdmaZeroGid {
+			continue
+		}
+		gids = append(gids, RdmaGid{
+			Index: index,
+			Gid:   gid,
+			Type:  s.read(filepath.Join(dir, "gid_attrs", "types", name)),
+		})
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i].Index < gids[j].Index })
+	return gids
+}
+
+// stripEnumPrefix turns sysfs enum values such as "4: ACTIVE" into "ACTIVE".
+func stripEnumPrefix(value string) string {
+	if _, name, ok := strings.Cut(value, ":"); ok {
+		return strings.TrimSpace(name)
+	}
+	return value
+}
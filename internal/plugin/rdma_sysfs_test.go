@@ -0,0 +1,137 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const testGid = "fe80:0000:0000:0000:0a00:27ff:fe00:0001"
+
+// writeSysfsFile creates file under root with content, including parents.
+func writeSysfsFile(root, file, content string) {
+	path := filepath.Join(root, file)
+	Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+	Expect(os.WriteFile(path, []byte(content+"\n"), 0o644)).To(Succeed())
+}
+
+// fakeRdmaSysfs lays out an RDMA device rdmaDev backing netdev ifname under
+// root, with a single port 1 in the given state.
+func fakeRdmaSysfs(root, rdmaDev, ifname, state, physState, gid string) {
+	Expect(os.MkdirAll(filepath.Join(root, "class", "net", ifname, "device", "infiniband", rdmaDev), 0o755)).To(Succeed())
+	Expect(os.MkdirAll(filepath.Join(root, "class", "infiniband", rdmaDev, "device", "net", ifname), 0o755)).To(Succeed())
+	port := filepath.Join("class", "infiniband", rdmaDev, "ports", "1")
+	writeSysfsFile(root, filepath.Join(port, "state"), state)
+	writeSysfsFile(root, filepath.Join(port, "phys_state"), physState)
+	writeSysfsFile(root, filepath.Join(port, "link_layer"), "Ethernet")
+	writeSysfsFile(root, filepath.Join(port, "gids", "0"), gid)
+}
+
+var _ = Describe("sysfsRdmaTopology", func() {
+	var (
+		root  string
+		sysfs RdmaTopology
+	)
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		sysfs = NewSysfsRdmaTopology(root)
+	})
+
+	It("maps a netdev to its RDMA device and back", func() {
+		fakeRdmaSysfs(root, "mlx5_0", "eth0", "4: ACTIVE", "5: LinkUp", testGid)
+		Expect(sysfs.DeviceForNetdev("eth0")).To(Equal("mlx5_0"))
+		Expect(sysfs.DeviceForNetdev("eth1")).To(BeEmpty())
+		Expect(sysfs.NetdevsForDevice("mlx5_0")).To(Equal([]string{"eth0"}))
+	})
+
+	It("remembers the netdevs of a removed RDMA device", func() {
+		fakeRdmaSysfs(root, "mlx5_0", "eth0", "4: ACTIVE", "5: LinkUp", testGid)
+		Expect(sysfs.NetdevsForDevice("mlx5_0")).To(Equal([]string{"eth0"}))
+		Expect(os.RemoveAll(filepath.Join(root, "class", "infiniband", "mlx5_0"))).To(Succeed())
+		Expect(sysfs.NetdevsForDevice("mlx5_0")).To(Equal([]string{"eth0"}))
+	})
+
+	It("remembers the RDMA device of a removed netdev", func() {
+		fakeRdmaSysfs(root, "mlx5_0", "eth0", "4: ACTIVE", "5: LinkUp", testGid)
+		Expect(sysfs.DeviceForNetdev("eth0")).To(Equal("mlx5_0"))
+		Expect(os.RemoveAll(filepath.Join(root, "class"))).To(Succeed())
+		Expect(sysfs.DeviceForNetdev("eth0")).To(Equal("mlx5_0"))
+	})
+
+	It("collects the character devices of an RDMA device", func() {
+		fakeRdmaSysfs(root, "mlx5_0", "eth0", "4: ACTIVE", "5: LinkUp", testGid)
+		writeSysfsFile(root, "class/infiniband_verbs/uverbs0/ibdev", "mlx5_0")
+		writeSysfsFile(root, "class/infiniband_verbs/uverbs1/ibdev", "mlx5_1")
+		writeSysfsFile(root, "class/infiniband_mad/umad0/ibdev", "mlx5_0")
+		writeSysfsFile(root, "class/infiniband_mad/issm0/ibdev", "mlx5_0")
+		Expect(os.MkdirAll(filepath.Join(root, "class", "misc", "rdma_cm"), 0o755)).To(Succeed())
+
+		devices, err := sysfs.CharDevices("mlx5_0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(ConsistOf(
+			"/dev/infiniband/uverbs0",
+			"/dev/infiniband/umad0",
+			"/dev/infiniband/issm0",
+			"/dev/infiniband/rdma_cm",
+		))
+	})
+
+	It("returns no character devices for a device that is gone", func() {
+		devices, err := sysfs.CharDevices("mlx5_0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(devices).To(BeEmpty())
+	})
+
+	It("reads the port state without the numeric prefix", func() {
+		fakeRdmaSysfs(root, "mlx5_0", "eth0", "4: ACTIVE", "5: LinkUp", testGid)
+		Expect(sysfs.Ports("mlx5_0")).To(Equal([]RdmaPort{{
+			Num:       "1",
+			State:     "ACTIVE",
+			PhysState: "LinkUp",
+			LinkLayer: "Ethernet",
+			Gid:       testGid,
+		}}))
+	})
+
+	DescribeTable("rdmaHealth",
+		func(state, physState, gid string, expected Health) {
+			fakeRdmaSysfs(root, "mlx5_0", "eth0", state, physState, gid)
+			Expect(rdmaHealth(sysfs, "mlx5_0")).To(BeAssignableToTypeOf(expected))
+		},
+		Entry("is Healthy for an active port with a GID", "4: ACTIVE", "5: LinkUp", testGid, Healthy{}),
+		Entry("is Unhealthy for a DOWN port", "1: DOWN", "3: Disabled", testGid, Unhealthy{}),
+		Entry("is Unhealthy for a port in INIT", "2: INIT", "5: LinkUp", testGid, Unhealthy{}),
+		Entry("is Unhealthy when the physical link is down", "4: ACTIVE", "3: Disabled", testGid, Unhealthy{}),
+		Entry("is Unhealthy without a GID", "4: ACTIVE", "5: LinkUp", rdmaZeroGid, Unhealthy{}),
+	)
+
+	It("reads the populated GID table entries with their types", func() {
+		fakeRdmaSysfs(root, "mlx5_0", "eth0", "4: ACTIVE", "5: LinkUp", testGid)
+		port := filepath.Join("class", "infiniband", "mlx5_0", "ports", "1")
+		writeSysfsFile(root, filepath.Join(port, "gid_attrs", "types", "0"), "IB/RoCE v1")
+		writeSysfsFile(root, filepath.Join(port, "gids", "1"), rdmaZeroGid)
+		writeSysfsFile(root, filepath.Join(port, "gids", "10"), "0000:0000:0000:0000:0000:ffff:0a00:0001")
+		writeSysfsFile(root, filepath.Join(port, "gid_attrs", "types", "10"), "RoCE v2")
+
+		Expect(sysfs.Gids("mlx5_0", "1")).To(Equal([]RdmaGid{
+			{Index: 0, Gid: testGid, Type: "IB/RoCE v1"},
+			{Index: 10, Gid: "0000:0000:0000:0000:0000:ffff:0a00:0001", Type: "RoCE v2"},
+		}))
+	})
+
+	It("is Unhealthy for an unknown device", func() {
+		Expect(rdmaHealth(sysfs, "mlx5_9")).To(BeAssignableToTypeOf(Unhealthy{}))
+	})
+
+	It("is Healthy if any port is usable", func() {
+		fakeRdmaSysfs(root, "mlx5_0", "eth0", "1: DOWN", "3: Disabled", rdmaZeroGid)
+		port := filepath.Join("class", "infiniband", "mlx5_0", "ports", "2")
+		writeSysfsFile(root, filepath.Join(port, "state"), "4: ACTIVE")
+		writeSysfsFile(root, filepath.Join(port, "phys_state"), "5: LinkUp")
+		writeSysfsFile(root, filepath.Join(port, "gids", "0"), testGid)
+		Expect(rdmaHealth(sysfs, "mlx5_0")).To(BeAssignableToTypeOf(Healthy{}))
+	})
+})
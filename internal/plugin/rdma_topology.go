@@ -0,0 +1,201 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Values of the RDMA port attributes that make a port usable.
+const (
+	rdmaPortStateActive     = "ACTIVE"
+	rdmaPortPhysStateLinkUp = "LinkUp"
+
+	rdmaZeroGid = "0000:0000:0000:0000:0000:0000:0000:0000"
+
+	// RdmaGidTypeRoCEv2 is the gid_attrs type of routable RoCE GIDs.
+	RdmaGidTypeRoCEv2 = "RoCE v2"
+
+	rdmaIPv4MappedGidPrefix = "0000:0000:0000:0000:0000:ffff:"
+	rdmaLinkLocalGidPrefix  = "fe80:"
+)
+
+// IP families an RDMA GID can be selected for.
+const (
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// RdmaPort is the state of one port of an RDMA device.
+type RdmaPort struct {
+	Num       string
+	State     string // e.g. "ACTIVE"
+	PhysState string // e.g. "LinkUp"
+	LinkLayer string // "InfiniBand" or "Ethernet"
+	Gid       string // GID at index 0
+}
+
+// Usable reports whether the port can carry traffic: it is active, its
+// physical link is up and it has a GID assigned.
+func (p RdmaPort) Usable() bool {
+	return p.State == rdmaPortStateActive &&
+		p.PhysState == rdmaPortPhysStateLinkUp &&
+		p.Gid != "" && p.Gid != rdmaZeroGid
+}
+
+// RdmaGid is one populated entry of a port's GID table.
+type RdmaGid struct {
+	Index int
+	Gid   string
+	Type  string // e.g. "RoCE v2" or "IB/RoCE v1"
+}
+
+// family returns the IP family of a RoCE GID: IPv4 GIDs are IPv4-mapped IPv6
+// addresses, link-local GIDs have none.
+func (g RdmaGid) family() string {
+	switch {
+	case strings.HasPrefix(g.Gid, rdmaIPv4MappedGidPrefix):
+		return IPFamilyIPv4
+	case strings.HasPrefix(g.Gid, rdmaLinkLocalGidPrefix):
+		return ""
+	default:
+		return IPFamilyIPv6
+	}
+}
+
+// roceV2Gid returns the RoCE v2 GID of family with the lowest index.
+func roceV2Gid(gids []RdmaGid, family string) (RdmaGid, bool) {
+	for _, gid := range gids {
+		if gid.Type == RdmaGidTypeRoCEv2 && gid.family() == family {
+			return gid, true
+		}
+	}
+	return RdmaGid{}, false
+}
+
+// RdmaTopology resolves net devices to RDMA devices and RDMA devices to their
+// ports and character devices.
+type RdmaTopology interface {
+	// DeviceForNetdev returns the RDMA device backing netdev ifname, or ""
+	// if it has none.
+	DeviceForNetdev(ifname string) (string, error)
+	// NetdevsForDevice returns the netdevs of RDMA device rdmaDev. Once the
+	// device is gone it returns the netdevs it was last seen with, so that
+	// removals can be mapped.
+	NetdevsForDevice(rdmaDev string) []string
+	// CharDevices returns the paths of the uverbs, rdma_cm, umad and issm
+	// character devices of rdmaDev.
+	CharDevices(rdmaDev string) ([]string, error)
+	// Ports returns the state of all ports of rdmaDev.
+	Ports(rdmaDev string) []RdmaPort
+	// Gids returns the populated GID table entries of port of rdmaDev,
+	// ordered by index.
+	Gids(rdmaDev, port string) []RdmaGid
+}
+
+// rdmaHealth is Healthy if at least one port of rdmaDev is usable.
+func rdmaHealth(topology RdmaTopology, rdmaDev string) Health {
+	ports := topology.Ports(rdmaDev)
+	for _, port := range ports {
+		if port.Usable() {
+			return Healthy{}
+		}
+	}
+	if len(ports) == 0 {
+		return Unhealthy{Reason: fmt.Sprintf("rdma %s has no ports", rdmaDev)}
+	}
+	unusable := make([]string, len(ports))
+	for i, port := range ports {
+		unusable[i] = fmt.Sprintf("port %s (%s) state=%q phys_state=%q gid=%q",
+			port.Num, port.LinkLayer, port.State, port.PhysState, port.Gid)
+	}
+	return Unhealthy{Reason: fmt.Sprintf("rdma %s has no usable port: %s", rdmaDev, strings.Join(unusable, ", "))}
+}
+
+// FakeRdmaTopology is an in-memory [RdmaTopology] for use in tests. Configure
+// it with [FakeRdmaTopology.AddDevice] and [FakeRdmaTopology.SetPorts].
+type FakeRdmaTopology struct {
+	mu          sync.RWMutex
+	netdevs     map[string][]string // RDMA device -> netdevs
+	charDevices map[string][]string // RDMA device -> char devices
+	ports       map[string][]RdmaPort
+	gids        map[string][]RdmaGid // "<RDMA device>/<port>" -> GIDs
+}
+
+// NewFakeRdmaTopology returns an empty FakeRdmaTopology.
+func NewFakeRdmaTopology() *FakeRdmaTopology {
+	return &FakeRdmaTopology{
+		netdevs:     make(map[string][]string),
+		charDevices: make(map[string][]string),
+		ports:       make(map[string][]RdmaPort),
+		gids:        make(map[string][]RdmaGid),
+	}
+}
+
+// AddDevice registers RDMA device rdmaDev backing netdevs with the given
+// character devices.
+func (f *FakeRdmaTopology) AddDevice(rdmaDev string, netdevs []string, charDevices ...string) *FakeRdmaTopology {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.netdevs[rdmaDev] = netdevs
+	f.charDevices[rdmaDev] = charDevices
+	return f
+}
+
+// SetPorts replaces the ports of rdmaDev.
+func (f *FakeRdmaTopology) SetPorts(rdmaDev string, ports ...RdmaPort) *FakeRdmaTopology {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ports[rdmaDev] = ports
+	return f
+}
+
+// SetGids replaces the GID table of port of rdmaDev.
+func (f *FakeRdmaTopology) SetGids(rdmaDev, port string, gids ...RdmaGid) *FakeRdmaTopology {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gids[rdmaDev+"/"+port] = gids
+	return f
+}
+
+// DeviceForNetdev returns the first registered RDMA device backing ifname.
+func (f *FakeRdmaTopology) DeviceForNetdev(ifname string) (string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for rdmaDev, netdevs := range f.netdevs {
+		for _, netdev := range netdevs {
+			if netdev == ifname {
+				return rdmaDev, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// NetdevsForDevice returns the netdevs registered for rdmaDev.
+func (f *FakeRdmaTopology) NetdevsForDevice(rdmaDev string) []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.netdevs[rdmaDev]
+}
+
+// CharDevices returns the character devices registered for rdmaDev.
+func (f *FakeRdmaTopology) CharDevices(rdmaDev string) ([]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.charDevices[rdmaDev], nil
+}
+
+// Ports returns the ports set for rdmaDev.
+func (f *FakeRdmaTopology) Ports(rdmaDev string) []RdmaPort {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.ports[rdmaDev]
+}
+
+// Gids returns the GIDs set for port of rdmaDev.
+func (f *FakeRdmaTopology) Gids(rdmaDev, port string) []RdmaGid {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.gids[rdmaDev+"/"+port]
+}
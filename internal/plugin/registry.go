@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"path"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,11 +23,14 @@ import (
 // It is responsible for (re-)registering plugins with the kubelet.
 type Registry struct {
 	plugins       sync.Map
+	hupMu         sync.Mutex // serializes re-registrations
 	ctx           context.Context
 	wg            *sync.WaitGroup
 	watcher       *fsnotify.Watcher
 	pluginDir     string
 	kubeletSocket string
+	checks        []InstanceCheck
+	observers     []Observer
 }
 
 // RegistryOption configures a [Registry] created by [NewRegistry].
@@ -43,8 +48,63 @@ func WithKubeletSocket(socketPath string) RegistryOption {
 	return func(r *Registry) { r.kubeletSocket = socketPath }
 }
 
+// WithInstanceCheck reports the instances that c rejects Unhealthy to
+// kubelet, whatever their own health.
+func WithInstanceCheck(c InstanceCheck) RegistryOption {
+	return func(r *Registry) { r.checks = append(r.checks, c) }
+}
+
+// Observer is told about the resources of a [Registry]; see [WithObserver].
+// Its methods are called from udev and probe handlers and must not block.
+type Observer interface {
+	// ResourceAdded is called when a resource is added, with its instances.
+	ResourceAdded(status ResourceStatus)
+	// Registered is called after the named resource is registered with
+	// kubelet, with the error if that failed.
+	Registered(resource string, err error)
+	// HealthChanged is called when the health of instance id of the named
+	// resource changes.
+	HealthChanged(resource string, id Id, transition HealthTransition)
+	// ResourceRemoved is called when the named resource is removed.
+	ResourceRemoved(resource string)
+}
+
+// WithObserver tells o about added and removed resources, registrations with
+// kubelet and health changes of instances.
+func WithObserver(o Observer) RegistryOption {
+	return func(r *Registry) { r.observers = append(r.observers, o) }
+}
+
+// ResourceStatus describes a resource of a [Registry] and its plugin.
+type ResourceStatus struct {
+	Name          string           `json:"name"`
+	Socket        string           `json:"socket"`
+	Registered    bool             `json:"registered"`
+	RegisteredAt  time.Time        `json:"registeredAt,omitzero"`
+	RegisterError string           `json:"registerError,omitempty"`
+	Subscribers   int              `json:"subscribers"` // open ListAndWatch streams
+	Instances     []InstanceStatus `json:"instances"`
+}
+
+// InstanceStatus describes an instance as it is reported to kubelet.
+type InstanceStatus struct {
+	Id      Id                 `json:"id"`
+	Health  string             `json:"health"`
+	Reason  string             `json:"reason,omitempty"`
+	Since   time.Time          `json:"since,omitzero"`
+	Devices []string           `json:"devices,omitempty"` // sysfs paths of the backing udev devices
+	History []HealthTransition `json:"history,omitempty"` // oldest first, see [Registry.Debug]
+}
+
 // register advertises plugin socket to the kubelet.
-func (r *Registry) register(plugin *plugin) error {
+func (r *Registry) register(plugin *plugin) (err error) {
+	defer func() {
+		plugin.registered(err)
+		for _, o := range r.observers {
+			o.Registered(plugin.resource.Name(), err)
+		}
+	}()
+
 	addr := "unix://" + r.kubeletSocket
 	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
@@ -77,14 +137,23 @@ func (r *Registry) register(plugin *plugin) error {
 	return nil
 }
 
+// Hup restarts all plugins and registers them with kubelet again, as is done
+// when kubelet restarts.
+func (r *Registry) Hup() {
+	klog.Infof("re-registering all device plugins")
+	r.hup()
+}
+
 // hup registers all plugins with the freshly kubelet.
 // Newely started kubelet removes all socket files, so we need to re-register
 // all plugins. See https://kubernetes.io/docs/concepts/extend-kubernetes/compute-storage-net/device-plugins/#handling-kubelet-restarts
 func (r *Registry) hup() {
+	r.hupMu.Lock()
+	defer r.hupMu.Unlock()
 	r.plugins.Range(func(key, p interface{}) bool {
 		old := p.(*plugin)
 		old.stop()
-		newP, err := newPlugin(old.resource, r.ctx, r.wg, r.pluginDir)
+		newP, err := newPlugin(old.resource, r.ctx, r.wg, r.pluginDir, r.checks)
 		if err != nil {
 			klog.Errorf("failed to create plugin for %s: %v", old.resource.Name(), err)
 			return true
@@ -185,11 +254,51 @@ func (r *Registry) Healthz(resp http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Status describes all resources of the registry, sorted by name.
+func (r *Registry) Status() []ResourceStatus {
+	return r.status(false)
+}
+
+// Debug is Status with the last health transitions of every instance.
+func (r *Registry) Debug() []ResourceStatus {
+	return r.status(true)
+}
+
+func (r *Registry) status(history bool) []ResourceStatus {
+	statuses := make([]ResourceStatus, 0)
+	r.plugins.Range(func(_, p interface{}) bool {
+		statuses = append(statuses, p.(*plugin).status(history))
+		return true
+	})
+	slices.SortFunc(statuses, func(a, b ResourceStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return statuses
+}
+
+// Refresh sends the instances of all resources to kubelet again, so that the
+// health of instances that changed without a udev event, e.g. on kernel I/O
+// errors, is reported. cause is recorded in the health history of the
+// instances that changed.
+func (r *Registry) Refresh(cause string) {
+	r.plugins.Range(func(_, p interface{}) bool {
+		plugin := p.(*plugin)
+		res, ok := plugin.resource.(*resource)
+		if !ok {
+			return true
+		}
+		if err := res.refresh(cause); err != nil {
+			klog.Errorf("failed to refresh %s: %v", res.Name(), err)
+		}
+		return true
+	})
+}
+
 // Add creates a new plugin for given Resource and registers it with the
 // kubelet. Attempts to register resource with the same name twice will result
 // in an error.
 func (r *Registry) Add(resource Resource) error {
-	plugin, err := newPlugin(resource, r.ctx, r.wg, r.pluginDir)
+	plugin, err := newPlugin(resource, r.ctx, r.wg, r.pluginDir, r.checks)
 	if err != nil {
 		klog.Errorf("failed to create plugin for resource %q Cause: %v", resource.Name(), err)
 		return err
@@ -200,9 +309,36 @@ func (r *Registry) Add(resource Resource) error {
 		klog.Errorf("resource with name %q already exists", resource.Name())
 		return fmt.Errorf("resource with name %q already exists", resource.Name())
 	}
+	plugin.attach(r.observers)
+	if len(r.observers) > 0 {
+		status := plugin.status(false)
+		for _, o := range r.observers {
+			o.ResourceAdded(status)
+		}
+	}
 	if err := r.register(plugin); err != nil {
 		klog.Errorf("failed to register resource %q Cause: %v", resource.Name(), err)
 		return err
 	}
 	return nil
 }
+
+// Remove stops the plugin of the named resource and forgets the resource.
+// Kubelet sees the plugin socket go away and stops advertising the resource.
+// The resource itself is not closed.
+func (r *Registry) Remove(name string) error {
+	r.hupMu.Lock()
+	p, loaded := r.plugins.LoadAndDelete(name)
+	if loaded {
+		p.(*plugin).stop()
+	}
+	r.hupMu.Unlock()
+	if !loaded {
+		return fmt.Errorf("resource with name %q does not exist", name)
+	}
+	klog.Infof("removed device %s", name)
+	for _, o := range r.observers {
+		o.ResourceRemoved(name)
+	}
+	return nil
+}
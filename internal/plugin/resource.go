@@ -3,7 +3,9 @@ package plugin
 import (
 	"context"
 	"sync"
+	"time"
 
+	"k8s.io/klog/v2"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 
 	"github.com/ydb-platform/udev-manager/internal/mux"
@@ -11,30 +13,66 @@ import (
 )
 
 // Health is the sealed interface for device health states.
-// The only concrete values are [Healthy] and [Unhealthy].
+// The only concrete types are [Healthy] and [Unhealthy]. Either may say why
+// an instance is in the state and since when, so states are told apart by
+// type rather than compared as values.
 type Health interface {
 	String() string
+	details() (reason string, since time.Time)
 	sealed()
 }
 
 // Healthy indicates that a device instance is available for allocation.
-type Healthy struct{}
+type Healthy struct {
+	Reason string    // e.g. the udev event that added the instance
+	Since  time.Time // when the instance became Healthy, zero if unknown
+}
 
 func (Healthy) sealed() {}
 
+func (h Healthy) details() (string, time.Time) { return h.Reason, h.Since }
+
 func (Healthy) String() string {
 	return "Healthy"
 }
 
 // Unhealthy indicates that a device instance is unavailable.
-type Unhealthy struct{}
+type Unhealthy struct {
+	Reason string    // e.g. the udev event that removed the instance
+	Since  time.Time // when the instance became Unhealthy, zero if unknown
+}
 
 func (Unhealthy) sealed() {}
 
+func (u Unhealthy) details() (string, time.Time) { return u.Reason, u.Since }
+
 func (Unhealthy) String() string {
 	return "Unhealthy"
 }
 
+// isHealthy reports whether h is Healthy, whatever its reason.
+func isHealthy(h Health) bool {
+	_, ok := h.(Healthy)
+	return ok
+}
+
+// stamped returns h with its Since set to now, unless it is set already.
+func stamped(h Health, now time.Time) Health {
+	switch h := h.(type) {
+	case Healthy:
+		if h.Since.IsZero() {
+			h.Since = now
+		}
+		return h
+	case Unhealthy:
+		if h.Since.IsZero() {
+			h.Since = now
+		}
+		return h
+	}
+	return h
+}
+
 // Id is the unique identifier of an [Instance] within a [Resource].
 type Id string
 
@@ -46,6 +84,80 @@ type Instance interface {
 	Allocate(context.Context) (*pluginapi.ContainerAllocateResponse, error)
 }
 
+// deviceInstance is implemented by instances backed by udev devices.
+type deviceInstance interface {
+	udevDevices() []udev.Device
+}
+
+// instanceDevices returns the devices backing instance, if it tells them.
+func instanceDevices(instance Instance) []udev.Device {
+	if backed, ok := unwrapInstance(instance).(deviceInstance); ok {
+		return backed.udevDevices()
+	}
+	return nil
+}
+
+// allocationRecorder is implemented by instances that record each container
+// allocation as a whole, after all instances allocated to the container
+// have been allocated.
+type allocationRecorder interface {
+	recordAllocation(instances []Instance, response *pluginapi.ContainerAllocateResponse) error
+}
+
+// recordAllocation lets the first of instances, all allocated to one
+// container, record the allocation if it is an [allocationRecorder].
+func recordAllocation(instances []Instance, response *pluginapi.ContainerAllocateResponse) error {
+	if len(instances) == 0 {
+		return nil
+	}
+	if recorder, ok := unwrapInstance(instances[0]).(allocationRecorder); ok {
+		return recorder.recordAllocation(instances, response)
+	}
+	return nil
+}
+
+// mergeKind says how two values of one env or annotation key, passed by
+// instances allocated to the same container, are combined.
+type mergeKind int
+
+const (
+	mergeOverwrite mergeKind = iota // the later value wins
+	mergeList                       // comma-separated lists are joined
+	mergeSum                        // quantities are added up
+)
+
+// keyMerger is implemented by instances whose env or annotation keys are
+// combined instead of overwritten when several instances of their resource
+// are allocated to one container. It is asked about the keys it passes only.
+type keyMerger interface {
+	mergeKind(key string) mergeKind
+}
+
+// mergePolicy returns how the keys passed by instance are merged: as its
+// [keyMerger] says, or by overwriting.
+func mergePolicy(instance Instance) func(key string) mergeKind {
+	if merger, ok := unwrapInstance(instance).(keyMerger); ok {
+		return merger.mergeKind
+	}
+	return nil
+}
+
+// unwrapInstance returns the instance wrapped by a health override.
+func unwrapInstance(instance Instance) Instance {
+	if override, ok := instance.(*healthOverride); ok {
+		return override.Instance
+	}
+	return instance
+}
+
+// InstanceCheck vets the instances of all resources of a [Registry] before
+// they are reported to kubelet; see [WithInstanceCheck].
+type InstanceCheck interface {
+	// CheckInstance returns nil if instance of the named resource may be
+	// allocated, or an error saying why not.
+	CheckInstance(resource string, instance Instance) error
+}
+
 // FromDevice is a function that maps a udev device to zero or more instances
 // (or to a resource template). Returning nil, nil means the device does not
 // match and should be ignored.
@@ -56,6 +168,7 @@ type FromDevice[T any] func(dev udev.Device) (T, error)
 type HealthEvent struct {
 	Instances []Instance
 	Health
+	Cause string // the udev event or probe behind the event, defaults to the reason of Health
 }
 
 // Resource is a Kubernetes device-plugin resource backed by a set of
@@ -78,16 +191,62 @@ type ResourceTemplate struct {
 // When the override health is Healthy, it delegates to the inner instance's
 // own Health() so that instance-specific conditions (e.g. NIC operstate) are
 // preserved. Only an Unhealthy override forces the health unconditionally.
+// An inner Healthy without a reason yields the override, which tells the
+// event that made the instance Healthy.
 type healthOverride struct {
 	Instance
 	health Health
 }
 
 func (h *healthOverride) Health() Health {
-	if _, ok := h.health.(Healthy); ok {
-		return h.Instance.Health()
+	if !isHealthy(h.health) {
+		return h.health
+	}
+	health := h.Instance.Health()
+	if reason, _ := health.details(); isHealthy(health) && reason == "" {
+		return h.health
 	}
-	return h.health
+	return health
+}
+
+// healthHistorySize bounds the health transitions kept per instance.
+const healthHistorySize = 16
+
+// HealthTransition is a change of the health of an instance.
+type HealthTransition struct {
+	Time   time.Time `json:"time"`
+	Health string    `json:"health"`
+	Reason string    `json:"reason,omitempty"` // why the instance is in the new state
+	Cause  string    `json:"cause,omitempty"`  // the udev event or probe that led to the change
+}
+
+// healthHistory is a ring buffer of the last transitions of an instance.
+type healthHistory struct {
+	transitions [healthHistorySize]HealthTransition
+	next, len   int
+}
+
+func (h *healthHistory) add(t HealthTransition) {
+	h.transitions[h.next] = t
+	h.next = (h.next + 1) % healthHistorySize
+	h.len = min(h.len+1, healthHistorySize)
+}
+
+// last returns the latest transition, if any.
+func (h *healthHistory) last() (HealthTransition, bool) {
+	if h.len == 0 {
+		return HealthTransition{}, false
+	}
+	return h.transitions[(h.next+healthHistorySize-1)%healthHistorySize], true
+}
+
+// list returns the transitions, oldest first.
+func (h *healthHistory) list() []HealthTransition {
+	list := make([]HealthTransition, 0, h.len)
+	for i := h.next - h.len; i < h.next; i++ {
+		list = append(list, h.transitions[(i+healthHistorySize)%healthHistorySize])
+	}
+	return list
 }
 
 type resource struct {
@@ -97,6 +256,12 @@ type resource struct {
 	broadcast        *mux.Mux[[]Instance]
 	done             chan struct{}
 	doneOnce         sync.Once
+	now              func() time.Time
+
+	historyMu sync.Mutex
+	checks    []InstanceCheck // of the registry, applied to recorded health
+	observers []Observer      // of the registry, told about recorded changes
+	history   map[Id]*healthHistory
 }
 
 // newResource creates a resource. Submit updates are broadcast to all
@@ -107,6 +272,8 @@ func newResource(template ResourceTemplate, instances map[Id]Instance) *resource
 		instances:        instances,
 		broadcast:        mux.Make[[]Instance](),
 		done:             make(chan struct{}),
+		now:              time.Now,
+		history:          make(map[Id]*healthHistory),
 	}
 }
 
@@ -127,21 +294,100 @@ func (r *resource) Instances() map[Id]Instance {
 }
 
 // Submit updates instance health state and broadcasts a snapshot to all
-// active ListAndWatch subscribers.
+// active ListAndWatch subscribers. The reason and cause of ev are logged and
+// the cause is recorded in the health history of the instances that changed.
 func (r *resource) Submit(ev HealthEvent) error {
+	health := stamped(ev.Health, r.now())
+	reason, _ := health.details()
+	cause := ev.Cause
+	if cause == "" {
+		cause = reason
+	}
+	klog.Infof("%q: %d instances %s: %s (%s)", r.Name(), len(ev.Instances), health, reason, cause)
+
 	r.mu.Lock()
 	for _, instance := range ev.Instances {
 		r.instances[instance.Id()] = &healthOverride{
 			Instance: instance,
-			health:   ev.Health,
+			health:   health,
 		}
 	}
 	snapshot := r.snapshotLocked()
 	r.mu.Unlock()
 
+	r.record(snapshot, cause)
 	return r.broadcast.Submit(snapshot)
 }
 
+// refresh broadcasts the instances again, keeping their health overrides, so
+// that subscribers see conditions that changed without a Submit, e.g. on the
+// probe named by cause.
+func (r *resource) refresh(cause string) error {
+	r.mu.RLock()
+	snapshot := r.snapshotLocked()
+	r.mu.RUnlock()
+
+	r.record(snapshot, cause)
+	return r.broadcast.Submit(snapshot)
+}
+
+// attach applies the instance checks of a registry to the recorded health,
+// records the health the resource is registered with and tells observers
+// about later changes.
+func (r *resource) attach(checks []InstanceCheck, observers []Observer) {
+	r.historyMu.Lock()
+	r.checks = checks
+	r.observers = observers
+	r.historyMu.Unlock()
+
+	r.mu.RLock()
+	snapshot := r.snapshotLocked()
+	r.mu.RUnlock()
+	r.record(snapshot, "registered")
+}
+
+// record adds the health of the instances of snapshot to their histories if
+// it changed, with cause as the cause of the change.
+func (r *resource) record(snapshot []Instance, cause string) {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	for _, instance := range snapshot {
+		health := checkedHealth(r.Name(), instance, r.checks)
+		reason, since := health.details()
+		history, ok := r.history[instance.Id()]
+		if !ok {
+			history = &healthHistory{}
+			r.history[instance.Id()] = history
+		}
+		last, ok := history.last()
+		if ok && last.Health == health.String() && last.Reason == reason {
+			continue
+		}
+		if since.IsZero() {
+			since = r.now()
+		}
+		transition := HealthTransition{Time: since, Health: health.String(), Reason: reason, Cause: cause}
+		history.add(transition)
+		if ok {
+			klog.Infof("%q: instance %s is %s: %s (%s)", r.Name(), instance.Id(), health, reason, cause)
+			for _, o := range r.observers {
+				o.HealthChanged(r.Name(), instance.Id(), transition)
+			}
+		}
+	}
+}
+
+// transitions returns the recorded health transitions of the instance id,
+// oldest first.
+func (r *resource) transitions(id Id) []HealthTransition {
+	r.historyMu.Lock()
+	defer r.historyMu.Unlock()
+	if history, ok := r.history[id]; ok {
+		return history.list()
+	}
+	return nil
+}
+
 func (r *resource) snapshotLocked() []Instance {
 	all := make([]Instance, 0, len(r.instances))
 	for _, inst := range r.instances {
@@ -2,6 +2,8 @@ package plugin
 
 import (
 	"context"
+	"strconv"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -72,7 +74,7 @@ var _ = Describe("resource", func() {
 			Eventually(ch).Should(Receive(&instances))
 			Expect(instances).To(HaveLen(1))
 			Expect(instances[0].Id()).To(Equal(p.Id()))
-			Expect(instances[0].Health()).To(Equal(Healthy{}))
+			Expect(instances[0].Health()).To(BeAssignableToTypeOf(Healthy{}))
 		})
 
 		It("closes the output channel when resource is closed", func() {
@@ -122,6 +124,21 @@ var _ = Describe("resource", func() {
 			Eventually(ch2).Should(Receive())
 		})
 
+		It("sends the instances again on refresh, keeping their health", func() {
+			DeferCleanup(r.Close)
+			ch := r.ListAndWatch(ctx)
+			Eventually(ch).Should(Receive()) // drain initial
+
+			Expect(r.Submit(HealthEvent{Instances: []Instance{p}, Health: Unhealthy{}})).To(Succeed())
+			Eventually(ch).Should(Receive())
+
+			Expect(r.refresh("test")).To(Succeed())
+			var instances []Instance
+			Eventually(ch).Should(Receive(&instances))
+			Expect(instances).To(HaveLen(1))
+			Expect(instances[0].Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		})
+
 		It("returns a closed channel when resource is already closed", func() {
 			r.Close()
 			ch := r.ListAndWatch(ctx)
@@ -141,5 +158,47 @@ var _ = Describe("resource", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(r.Instances()).To(HaveKey(p.Id()))
 		})
+
+		It("records health transitions with their reasons and causes", func() {
+			r := newResource(
+				ResourceTemplate{Domain: "ydb.tech", Prefix: "part-disk01"},
+				make(map[Id]Instance),
+			)
+			DeferCleanup(r.Close)
+			now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+			r.now = func() time.Time { return now }
+			p := &partition{label: "disk01", domain: "ydb.tech", dev: partitionDevice("nvme0", "disk01")}
+
+			Expect(r.Submit(HealthEvent{Instances: []Instance{p}, Health: Healthy{Reason: "udev: found nvme0"}})).To(Succeed())
+			Expect(r.Submit(HealthEvent{Instances: []Instance{p}, Health: Healthy{Reason: "udev: found nvme0"}})).To(Succeed())
+			now = now.Add(time.Minute)
+			Expect(r.Submit(HealthEvent{Instances: []Instance{p}, Health: Unhealthy{Reason: "udev: removed nvme0"}, Cause: "udev: removed disk"})).To(Succeed())
+
+			Expect(r.Instances()[p.Id()].Health()).To(Equal(Unhealthy{Reason: "udev: removed nvme0", Since: now}))
+			Expect(r.transitions(p.Id())).To(Equal([]HealthTransition{
+				{Time: now.Add(-time.Minute), Health: "Healthy", Reason: "udev: found nvme0", Cause: "udev: found nvme0"},
+				{Time: now, Health: "Unhealthy", Reason: "udev: removed nvme0", Cause: "udev: removed disk"},
+			}))
+		})
+	})
+
+	Describe("healthHistory", func() {
+		It("keeps the last transitions, oldest first", func() {
+			history := &healthHistory{}
+			_, ok := history.last()
+			Expect(ok).To(BeFalse())
+			Expect(history.list()).To(BeEmpty())
+
+			for i := range healthHistorySize + 3 {
+				history.add(HealthTransition{Cause: strconv.Itoa(i)})
+			}
+			list := history.list()
+			Expect(list).To(HaveLen(healthHistorySize))
+			Expect(list[0].Cause).To(Equal("3"))
+			Expect(list[healthHistorySize-1].Cause).To(Equal(strconv.Itoa(healthHistorySize + 2)))
+			last, ok := history.last()
+			Expect(ok).To(BeTrue())
+			Expect(last).To(Equal(list[healthHistorySize-1]))
+		})
 	})
 })
@@ -1,6 +1,9 @@
 package plugin
 
 import (
+	"fmt"
+	"time"
+
 	"k8s.io/klog/v2"
 
 	"github.com/ydb-platform/udev-manager/internal/mux"
@@ -13,33 +16,110 @@ import (
 type Scatter[T Instance] struct {
 	templater FromDevice[*ResourceTemplate]
 	mapper    FromDevice[[]T]
+	related   func(udev.Device) []udev.Device
+	changes   bool
 	registry  *Registry
 	routes    map[ResourceTemplate]Resource
+	produced  map[udev.Id]map[Id]Instance // device -> instances it was last mapped to
+}
+
+// ScatterOption configures a [Scatter].
+type ScatterOption func(*scatterSettings)
+
+type scatterSettings struct {
+	related func(udev.Device) []udev.Device
+	changes bool
+}
+
+// WithScatterRelated applies the added and changed events of devices the
+// templater does not match to the devices related returns for them instead,
+// e.g. the events of a multipath map to the partitions on it.
+func WithScatterRelated(related func(udev.Device) []udev.Device) ScatterOption {
+	return func(s *scatterSettings) { s.related = related }
+}
+
+// WithScatterChanges re-evaluates the devices the templater matches on their
+// change, bind and unbind events, for resources whose health follows the
+// state of the device itself, such as the link state of a netdev. Without it
+// those events are dropped, as block devices get one on every close after a
+// write.
+func WithScatterChanges() ScatterOption {
+	return func(s *scatterSettings) { s.changes = true }
 }
 
 // NewScatter creates a [Scatter] that subscribes to d and routes matching
-// devices to resources via templater and mapper. It returns a CancelFunc that
-// unsubscribes and stops the scatter goroutine.
+// devices to resources via templater and mapper. It watches the devices with
+// related devices and, given [WithScatterChanges], those templater matches,
+// so that their changes re-evaluate the instances. It returns a CancelFunc
+// that unsubscribes and stops the scatter goroutine.
 func NewScatter[T Instance](
 	d udev.Discovery,
 	registry *Registry,
 	templater FromDevice[*ResourceTemplate],
 	mapper FromDevice[[]T],
+	opts ...ScatterOption,
 ) mux.CancelFunc {
+	settings := &scatterSettings{}
+	for _, opt := range opts {
+		opt(settings)
+	}
 	scatter := &Scatter[T]{
 		templater: templater,
 		mapper:    mapper,
+		related:   settings.related,
+		changes:   settings.changes,
 		registry:  registry,
 		routes:    make(map[ResourceTemplate]Resource),
+		produced:  make(map[udev.Id]map[Id]Instance),
 	}
+	return scatter.subscribe(d)
+}
+
+// subscribe routes the events of d, and watches the devices they concern,
+// until the returned CancelFunc is called.
+func (s *Scatter[T]) subscribe(d udev.Discovery) mux.CancelFunc {
 	ch := make(chan udev.Event, 1)
 
-	go scatter.run(ch)
+	go s.run(ch)
 
-	return d.Subscribe(mux.SinkFromChan(ch))
+	unwatch := d.Watch(s.watches)
+	return mux.ChainCancelFunc(unwatch, d.Subscribe(mux.SinkFromChan(ch)))
+}
+
+// watches reports whether the changes of dev concern s: dev has related
+// devices or, if s follows changes, the templater matches dev.
+func (s *Scatter[T]) watches(dev udev.Device) bool {
+	if s.changes {
+		if template, err := s.templater(dev); err == nil && template != nil {
+			return true
+		}
+	}
+	return len(s.relatedOf(dev)) > 0
+}
+
+// relatedOf returns the devices the events of dev apply to, if any.
+func (s *Scatter[T]) relatedOf(dev udev.Device) []udev.Device {
+	if s.related == nil {
+		return nil
+	}
+	return s.related(dev)
 }
 
-func (s *Scatter[T]) added(dev udev.Device) {
+// Reasons of the health events submitted on udev events, formatted with the
+// sysfs path of the device.
+const (
+	reasonUdevFound   = "udev: found %s"
+	reasonUdevAdded   = "udev: added or changed %s"
+	reasonUdevRemoved = "udev: removed %s"
+	reasonUdevDropped = "udev: changed %s, instance no longer provided"
+	reasonUdevInit    = "udev: initial scan"
+
+	// reasonUdevRelated is formatted with the changed device first, leaving
+	// the verb for the device it applies to.
+	reasonUdevRelated = "udev: changed %s, re-evaluating %%s"
+)
+
+func (s *Scatter[T]) added(dev udev.Device, reason string) {
 	if dev == nil {
 		klog.Errorf("device is nil")
 		return
@@ -51,7 +131,13 @@ func (s *Scatter[T]) added(dev udev.Device) {
 	}
 
 	if template == nil {
-		klog.V(5).Infof("unmatched device: %q, template is nil", dev.Debug())
+		related := s.relatedOf(dev)
+		for _, rel := range related {
+			s.added(rel, fmt.Sprintf(reasonUdevRelated, dev.Id()))
+		}
+		if len(related) == 0 {
+			klog.V(5).Infof("unmatched device: %q, template is nil", dev.Debug())
+		}
 		return
 	}
 
@@ -62,21 +148,32 @@ func (s *Scatter[T]) added(dev udev.Device) {
 	}
 
 	klog.V(5).Infof("Init: Matched device: %q", dev.Debug())
+	dropped := s.produce(dev, instances)
 
 	if res, ok := s.routes[*template]; ok {
 		klog.V(5).Infof("Init: Matched resource: %s", res.Name())
 		if err := res.Submit(HealthEvent{
 			Instances: unpack(instances...),
-			Health:    Healthy{},
+			Health:    Healthy{Reason: fmt.Sprintf(reason, dev.Id())},
+		}); err != nil {
+			klog.Errorf("failed to submit health event for %s: %v", res.Name(), err)
+		}
+		if len(dropped) == 0 {
+			return
+		}
+		if err := res.Submit(HealthEvent{
+			Instances: dropped,
+			Health:    Unhealthy{Reason: fmt.Sprintf(reasonUdevDropped, dev.Id())},
 		}); err != nil {
 			klog.Errorf("failed to submit health event for %s: %v", res.Name(), err)
 		}
 		return
 	}
 
+	health := stamped(Healthy{Reason: fmt.Sprintf(reason, dev.Id())}, time.Now())
 	instanceMap := make(map[Id]Instance, len(instances))
 	for _, instance := range instances {
-		instanceMap[instance.Id()] = instance
+		instanceMap[instance.Id()] = &healthOverride{Instance: instance, health: health}
 	}
 	res := newResource(*template, instanceMap)
 
@@ -112,12 +209,14 @@ func (s *Scatter[T]) removed(dev udev.Device) {
 	}
 
 	klog.V(5).Infof("Removed: Matched device: %q", dev.Debug())
+	gone := append(unpack(instances...), s.produce(dev, nil)...)
+	delete(s.produced, dev.Id())
 
 	if res, ok := s.routes[*template]; ok {
 		klog.V(5).Infof("Removed: Matched resource: %s", res.Name())
 		if err := res.Submit(HealthEvent{
-			Instances: unpack(instances...),
-			Health:    Unhealthy{},
+			Instances: gone,
+			Health:    Unhealthy{Reason: fmt.Sprintf(reasonUdevRemoved, dev.Id())},
 		}); err != nil {
 			klog.Errorf("failed to submit health event for %s: %v", res.Name(), err)
 		}
@@ -126,6 +225,24 @@ func (s *Scatter[T]) removed(dev udev.Device) {
 	}
 }
 
+// produce records that dev now maps to instances, and returns the instances
+// it mapped to before but no longer does, e.g. the shares of a link whose
+// speed dropped.
+func (s *Scatter[T]) produce(dev udev.Device, instances []T) []Instance {
+	current := make(map[Id]Instance, len(instances))
+	for _, instance := range instances {
+		current[instance.Id()] = instance
+	}
+	var dropped []Instance
+	for id, instance := range s.produced[dev.Id()] {
+		if _, ok := current[id]; !ok {
+			dropped = append(dropped, instance)
+		}
+	}
+	s.produced[dev.Id()] = current
+	return dropped
+}
+
 func unpack[T Instance](instances ...T) []Instance {
 	result := make([]Instance, len(instances))
 	for i, instance := range instances {
@@ -139,10 +256,10 @@ func (s *Scatter[T]) run(evCh <-chan udev.Event) {
 		switch ev := ev.(type) {
 		case udev.Init:
 			for _, dev := range ev.Devices {
-				s.added(dev)
+				s.added(dev, reasonUdevFound)
 			}
 		case udev.Added:
-			s.added(ev.Device)
+			s.added(ev.Device, reasonUdevAdded)
 		case udev.Removed:
 			s.removed(ev.Device)
 		}
@@ -2,13 +2,26 @@ package plugin
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"regexp"
 	"time"
 
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
 
+// firstHealth returns the health of the first instance of a ListAndWatch
+// update, or nil if it has none.
+func firstHealth(instances []Instance) Health {
+	if len(instances) == 0 {
+		return nil
+	}
+	return instances[0].Health()
+}
+
 var _ = Describe("Scatter", func() {
 	var (
 		matcher *regexp.Regexp
@@ -32,19 +45,20 @@ var _ = Describe("Scatter", func() {
 			mapper:    PartitionLabelMatcherInstances("ydb.tech", matcher, false),
 			registry:  nil, // not exercised when the route already exists
 			routes:    map[ResourceTemplate]Resource{tmpl: res},
+			produced:  make(map[udev.Id]map[Id]Instance),
 		}
 	})
 
 	Describe("added with an existing route", func() {
 		It("submits a HealthEvent to the existing resource", func() {
 			dev := partitionDevice("nvme0n1p1", "nvme_disk01")
-			scatter.added(dev)
+			scatter.added(dev, reasonUdevAdded)
 			Eventually(watchCh).Should(Receive())
 		})
 
 		It("the submitted event carries the matched instance", func() {
 			dev := partitionDevice("nvme0n1p1", "nvme_disk01")
-			scatter.added(dev)
+			scatter.added(dev, reasonUdevAdded)
 			var instances []Instance
 			Eventually(watchCh).Should(Receive(&instances))
 			Expect(instances).To(HaveLen(1))
@@ -52,7 +66,7 @@ var _ = Describe("Scatter", func() {
 
 		It("does nothing for a non-matching device", func() {
 			dev := partitionDevice("sda1", "data_01")
-			scatter.added(dev)
+			scatter.added(dev, reasonUdevAdded)
 			Consistently(watchCh, 50*time.Millisecond).ShouldNot(Receive())
 		})
 	})
@@ -76,11 +90,80 @@ var _ = Describe("Scatter", func() {
 	// in state, so its later removal produces Removed{nil}.
 	Describe("nil device from TOCTOU gap", func() {
 		It("does not panic when added receives a nil device", func() {
-			Expect(func() { scatter.added(nil) }).NotTo(Panic())
+			Expect(func() { scatter.added(nil, reasonUdevAdded) }).NotTo(Panic())
 		})
 
 		It("does not panic when removed receives a nil device", func() {
 			Expect(func() { scatter.removed(nil) }).NotTo(Panic())
 		})
 	})
+
+	Describe("change events", func() {
+		var discovery *udev.FakeDiscovery
+
+		BeforeEach(func() {
+			discovery = udev.NewFakeDiscovery()
+			DeferCleanup(discovery.Close)
+		})
+
+		It("are dropped for plain partitions", func() {
+			DeferCleanup(scatter.subscribe(discovery))
+
+			Expect(discovery.Change(partitionDevice("nvme0n1p1", "nvme_disk01"))).To(BeFalse())
+			Consistently(watchCh, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("re-evaluate the devices the templater matches when following changes", func() {
+			scatter.changes = true
+			DeferCleanup(scatter.subscribe(discovery))
+
+			Expect(discovery.Change(partitionDevice("sda1", "boot"))).To(BeFalse())
+			Expect(discovery.Change(partitionDevice("nvme0n1p1", "nvme_disk01"))).To(BeTrue())
+			Eventually(watchCh).Should(Receive(HaveLen(1)))
+		})
+	})
+})
+
+var _ = Describe("Scatter re-evaluating a bond", func() {
+	It("withdraws the shares a bond loses with a slave", func() {
+		sysfsRoot := GinkgoT().TempDir()
+		for _, name := range []string{"bond0", "eth0", "eth1"} {
+			dir := filepath.Join(sysfsRoot, "class/net", name)
+			Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "speed"), []byte("10000\n"), 0o644)).To(Succeed())
+		}
+		bond := netDevice("bond0", "", "up")
+		bond.sysattrs[udev.SysAttrBondingSlaves] = "eth0 eth1"
+
+		matcher := regexp.MustCompile(`^(bond0)$`)
+		tmpl := ResourceTemplate{Domain: "ydb.tech", Prefix: "netbw-bond0"}
+		res := newResource(tmpl, make(map[Id]Instance))
+		DeferCleanup(res.Close)
+		bonds := NewNetBWBonds(sysfsRoot)
+		scatter := &Scatter[*networkBandwidth]{
+			templater: NetBWMatcherTemplater("ydb.tech", matcher),
+			mapper:    NetBWMatcherInstances("ydb.tech", matcher, 10000, false, WithBondSlaves(bonds)),
+			related:   bonds.Related,
+			routes:    map[ResourceTemplate]Resource{tmpl: res},
+			produced:  make(map[udev.Id]map[Id]Instance),
+		}
+		healthy := func() int {
+			count := 0
+			for _, instance := range res.Instances() {
+				if isHealthy(instance.Health()) {
+					count++
+				}
+			}
+			return count
+		}
+
+		scatter.added(bond, reasonUdevFound)
+		Expect(healthy()).To(Equal(2))
+
+		bond.sysattrs[udev.SysAttrBondingSlaves] = "eth0"
+		scatter.added(netDevice("eth1", "10000", "up"), reasonUdevAdded)
+		Expect(healthy()).To(Equal(1))
+		Expect(res.Instances()).To(HaveKeyWithValue(Id("bond0_1"),
+			WithTransform(func(instance Instance) Health { return instance.Health() }, BeAssignableToTypeOf(Unhealthy{}))))
+	})
 })
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// Default commands of [SmartProber].
+const (
+	DefaultSmartctlPath = "smartctl"
+
+	// kelvinOffset converts the Kelvin temperatures of NVMe smart logs.
+	kelvinOffset = 273
+)
+
+// ATA SMART attributes that count sectors the disk failed to read or had to
+// remap; their raw values add up to the media errors of ATA disks.
+var ataMediaErrorAttributes = map[int]struct{}{
+	5:   {}, // Reallocated_Sector_Ct
+	187: {}, // Reported_Uncorrect
+	197: {}, // Current_Pending_Sector
+	198: {}, // Offline_Uncorrectable
+}
+
+// CommandRunner runs the command name with args and returns its standard
+// output. Output is returned along with the error of a failed command, as
+// smartctl exits non-zero for failing disks.
+type CommandRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// execRunner is a [CommandRunner] that executes commands on the host.
+func execRunner(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// SmartProber is a [DiskProber] reading SMART data with smartctl, or NVMe
+// smart logs with nvme-cli if NvmePath is set.
+type SmartProber struct {
+	SmartctlPath string        // defaults to DefaultSmartctlPath
+	NvmePath     string        // probe NVMe disks with "nvme smart-log" if set
+	Runner       CommandRunner // defaults to executing the commands
+}
+
+// Probe runs "smartctl --json -a" or "nvme smart-log -o json" on the device
+// node of disk.
+func (p *SmartProber) Probe(ctx context.Context, disk udev.Device) (*DiskReport, error) {
+	runner := p.Runner
+	if runner == nil {
+		runner = execRunner
+	}
+	if p.NvmePath != "" && diskTransport(disk) == "nvme" {
+		out, err := runner(ctx, p.NvmePath, "smart-log", "-o", "json", disk.DevNode())
+		if err != nil {
+			return nil, fmt.Errorf("%s smart-log %s: %w", p.NvmePath, disk.DevNode(), err)
+		}
+		return parseNvmeSmartLog(out)
+	}
+
+	smartctl := p.SmartctlPath
+	if smartctl == "" {
+		smartctl = DefaultSmartctlPath
+	}
+	out, err := runner(ctx, smartctl, "--json", "-a", disk.DevNode())
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || len(out) == 0) {
+		return nil, fmt.Errorf("%s %s: %w", smartctl, disk.DevNode(), err)
+	}
+	return parseSmartctl(out)
+}
+
+// smartctlOutput is the part of "smartctl --json -a" used by parseSmartctl.
+type smartctlOutput struct {
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature *struct {
+		Current int `json:"current"`
+	} `json:"temperature"`
+	EnduranceUsed *struct {
+		CurrentPercent int `json:"current_percent"`
+	} `json:"endurance_used"`
+	NvmeLog *struct {
+		CriticalWarning int    `json:"critical_warning"`
+		PercentageUsed  int    `json:"percentage_used"`
+		MediaErrors     uint64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+	AtaAttributes *struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value uint64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+}
+
+// parseSmartctl reads a DiskReport from "smartctl --json -a". A failed
+// overall assessment of an ATA disk counts as a critical warning.
+func parseSmartctl(out []byte) (*DiskReport, error) {
+	var parsed smartctlOutput
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse smartctl output: %w", err)
+	}
+	if parsed.SmartStatus == nil {
+		return nil, fmt.Errorf("smartctl reported no SMART status")
+	}
+
+	report := &DiskReport{}
+	if parsed.Temperature != nil {
+		report.Temperature = parsed.Temperature.Current
+	}
+	if parsed.EnduranceUsed != nil {
+		report.PercentageUsed = parsed.EnduranceUsed.CurrentPercent
+	}
+	switch {
+	case parsed.NvmeLog != nil:
+		report.CriticalWarning = parsed.NvmeLog.CriticalWarning
+		report.PercentageUsed = parsed.NvmeLog.PercentageUsed
+		report.MediaErrors = parsed.NvmeLog.MediaErrors
+	case parsed.AtaAttributes != nil:
+		for _, attr := range parsed.AtaAttributes.Table {
+			if _, ok := ataMediaErrorAttributes[attr.ID]; ok {
+				report.MediaErrors += attr.Raw.Value
+			}
+		}
+	}
+	if !parsed.SmartStatus.Passed && report.CriticalWarning == 0 {
+		report.CriticalWarning = 1
+	}
+	return report, nil
+}
+
+// nvmeSmartLog is the output of "nvme smart-log -o json". Temperatures are in
+// Kelvin; nvme-cli renamed percent_used to percentage_used at some point.
+type nvmeSmartLog struct {
+	CriticalWarning *int   `json:"critical_warning"`
+	Temperature     int    `json:"temperature"`
+	PercentUsed     int    `json:"percent_used"`
+	PercentageUsed  int    `json:"percentage_used"`
+	MediaErrors     uint64 `json:"media_errors"`
+}
+
+// parseNvmeSmartLog reads a DiskReport from "nvme smart-log -o json".
+func parseNvmeSmartLog(out []byte) (*DiskReport, error) {
+	var parsed nvmeSmartLog
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse nvme smart-log output: %w", err)
+	}
+	if parsed.CriticalWarning == nil {
+		return nil, fmt.Errorf("nvme smart-log reported no critical warning")
+	}
+
+	report := &DiskReport{
+		CriticalWarning: *parsed.CriticalWarning,
+		PercentageUsed:  max(parsed.PercentUsed, parsed.PercentageUsed),
+		MediaErrors:     parsed.MediaErrors,
+	}
+	if parsed.Temperature > 0 {
+		report.Temperature = parsed.Temperature - kelvinOffset
+	}
+	return report, nil
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+const smartctlNvmeJSON = `{
+  "smart_status": {"passed": true, "nvme": {"value": 0}},
+  "nvme_smart_health_information_log": {
+    "critical_warning": 0,
+    "temperature": 41,
+    "percentage_used": 7,
+    "media_errors": 2
+  },
+  "temperature": {"current": 41}
+}`
+
+const smartctlAtaJSON = `{
+  "smart_status": {"passed": false},
+  "ata_smart_attributes": {"table": [
+    {"id": 5, "name": "Reallocated_Sector_Ct", "raw": {"value": 8}},
+    {"id": 9, "name": "Power_On_Hours", "raw": {"value": 31000}},
+    {"id": 197, "name": "Current_Pending_Sector", "raw": {"value": 3}}
+  ]},
+  "endurance_used": {"current_percent": 12},
+  "temperature": {"current": 38}
+}`
+
+const nvmeSmartLogJSON = `{
+  "critical_warning": 4,
+  "temperature": 331,
+  "avail_spare": 100,
+  "percent_used": 101,
+  "media_errors": 0
+}`
+
+// cannedRunner answers commands with out and err, recording the command line.
+type cannedRunner struct {
+	out  string
+	err  error
+	args []string
+}
+
+func (r *cannedRunner) run(_ context.Context, name string, args ...string) ([]byte, error) {
+	r.args = append([]string{name}, args...)
+	return []byte(r.out), r.err
+}
+
+var _ = Describe("parseSmartctl", func() {
+	It("reads the NVMe health log", func() {
+		report, err := parseSmartctl([]byte(smartctlNvmeJSON))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*report).To(Equal(DiskReport{MediaErrors: 2, PercentageUsed: 7, Temperature: 41}))
+	})
+
+	It("sums the error attributes of ATA disks and flags a failed assessment", func() {
+		report, err := parseSmartctl([]byte(smartctlAtaJSON))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*report).To(Equal(DiskReport{CriticalWarning: 1, MediaErrors: 11, PercentageUsed: 12, Temperature: 38}))
+	})
+
+	It("fails without a SMART status", func() {
+		_, err := parseSmartctl([]byte(`{"smartctl": {"exit_status": 2}}`))
+		Expect(err).To(MatchError(ContainSubstring("no SMART status")))
+		_, err = parseSmartctl([]byte(`not json`))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("parseNvmeSmartLog", func() {
+	It("reads the critical warning and converts the temperature to Celsius", func() {
+		report, err := parseNvmeSmartLog([]byte(nvmeSmartLogJSON))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*report).To(Equal(DiskReport{CriticalWarning: 4, PercentageUsed: 101, Temperature: 58}))
+	})
+
+	It("fails without a critical warning", func() {
+		_, err := parseNvmeSmartLog([]byte(`{}`))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("SmartProber", func() {
+	var (
+		runner *cannedRunner
+		nvme   *mockDevice
+	)
+
+	BeforeEach(func() {
+		runner = &cannedRunner{out: smartctlNvmeJSON}
+		nvme = diskDevice("nvme0n1", "S64F01")
+		nvme.properties[udev.PropertyBus] = "nvme"
+	})
+
+	It("runs smartctl by default", func() {
+		prober := &SmartProber{Runner: runner.run}
+		report, err := prober.Probe(context.Background(), nvme)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.MediaErrors).To(BeEquivalentTo(2))
+		Expect(runner.args).To(Equal([]string{"smartctl", "--json", "-a", "/dev/nvme0n1"}))
+	})
+
+	It("runs nvme-cli for NVMe disks if configured", func() {
+		runner.out = nvmeSmartLogJSON
+		prober := &SmartProber{SmartctlPath: "/usr/sbin/smartctl", NvmePath: "/usr/sbin/nvme", Runner: runner.run}
+		report, err := prober.Probe(context.Background(), nvme)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.CriticalWarning).To(Equal(4))
+		Expect(runner.args).To(Equal([]string{"/usr/sbin/nvme", "smart-log", "-o", "json", "/dev/nvme0n1"}))
+
+		_, err = prober.Probe(context.Background(), diskDevice("sda", "Z1X2"))
+		Expect(runner.args[0]).To(Equal("/usr/sbin/smartctl"))
+		Expect(err).To(HaveOccurred()) // the canned output is a smart-log
+	})
+
+	It("parses the output of smartctl exiting with failure bits", func() {
+		exitErr := exec.Command("false").Run()
+		Expect(exitErr).To(BeAssignableToTypeOf(&exec.ExitError{}))
+		runner.out, runner.err = smartctlAtaJSON, exitErr
+		report, err := (&SmartProber{Runner: runner.run}).Probe(context.Background(), diskDevice("sda", "Z1X2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.CriticalWarning).To(Equal(1))
+	})
+
+	It("fails if the command cannot run", func() {
+		runner.out, runner.err = "", errors.New("executable file not found in $PATH")
+		_, err := (&SmartProber{Runner: runner.run}).Probe(context.Background(), nvme)
+		Expect(err).To(HaveOccurred())
+		Expect(strings.HasPrefix(err.Error(), "smartctl /dev/nvme0n1")).To(BeTrue())
+	})
+})
@@ -0,0 +1,348 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// Keys of the environment passed to containers with SR-IOV VFs, prefixed by
+// <DOMAIN>_SRIOV_<NAME>. Their values list the VFs of the container.
+const (
+	sriovPciAddressesEnvSuffix = "_PCI_ADDRESSES"
+	sriovNetdevsEnvSuffix      = "_NETDEVS"
+	sriovRdmaDevicesEnvSuffix  = "_RDMA_DEVICES"
+)
+
+// sriovSysfs resolves SR-IOV relations through the physfn links and net
+// directories of PCI devices in sysfs. It remembers what it resolved, so
+// that removal events can be mapped after the sysfs entries are gone, and
+// events of a PF netdev can be mapped to the VFs seen so far.
+type sriovSysfs struct {
+	sysfs
+
+	mu        sync.Mutex
+	physfns   map[string]string                 // VF PCI address -> PF PCI address
+	pfNetdevs map[string][]string               // PF PCI address -> netdev names
+	vfs       map[string]map[string]udev.Device // PF PCI address -> VF PCI address -> VF
+}
+
+func newSriovSysfs(root string) *sriovSysfs {
+	return &sriovSysfs{
+		sysfs:     sysfs{root: root},
+		physfns:   make(map[string]string),
+		pfNetdevs: make(map[string][]string),
+		vfs:       make(map[string]map[string]udev.Device),
+	}
+}
+
+// physfn returns the PCI address of the PF of VF vf, or "" if vf is not a VF.
+func (s *sriovSysfs) physfn(vf string) string {
+	pf := s.linkName(filepath.Join("bus", "pci", "devices", vf, "physfn"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pf == "" {
+		return s.physfns[vf]
+	}
+	s.physfns[vf] = pf
+	return pf
+}
+
+// pfNetdev returns the first netdev of PF pf.
+func (s *sriovSysfs) pfNetdev(pf string) string {
+	names := s.netdevs(pf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(names) == 0 {
+		names = s.pfNetdevs[pf]
+	} else {
+		s.pfNetdevs[pf] = names
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// addVF remembers dev as VF vf of PF pf.
+func (s *sriovSysfs) addVF(pf, vf string, dev udev.Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.vfs[pf] == nil {
+		s.vfs[pf] = make(map[string]udev.Device)
+	}
+	s.vfs[pf][vf] = dev
+}
+
+// netdevPF returns the PCI address of the PF with netdev ifname among the
+// PFs with VFs seen so far, or "" if there is none.
+func (s *sriovSysfs) netdevPF(ifname string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for pf, names := range s.pfNetdevs {
+		if _, ok := s.vfs[pf]; ok && slices.Contains(names, ifname) {
+			return pf
+		}
+	}
+	return ""
+}
+
+// pfVFs returns the VFs of PF pf seen so far that are still in sysfs, sorted
+// by PCI address.
+func (s *sriovSysfs) pfVFs(pf string) []udev.Device {
+	s.mu.Lock()
+	addrs := make([]string, 0, len(s.vfs[pf]))
+	devs := make(map[string]udev.Device, len(s.vfs[pf]))
+	for vf, dev := range s.vfs[pf] {
+		addrs = append(addrs, vf)
+		devs[vf] = dev
+	}
+	s.mu.Unlock()
+
+	slices.Sort(addrs)
+	vfs := make([]udev.Device, 0, len(addrs))
+	for _, vf := range addrs {
+		if s.exists(filepath.Join("bus", "pci", "devices", vf)) {
+			vfs = append(vfs, devs[vf])
+		}
+	}
+	return vfs
+}
+
+// netdevs returns the netdevs of PCI device addr.
+func (s *sriovSysfs) netdevs(addr string) []string {
+	names, _ := s.list(filepath.Join("bus", "pci", "devices", addr, "net"))
+	return names
+}
+
+// operstate returns the operstate of netdev ifname.
+func (s *sriovSysfs) operstate(ifname string) string {
+	return s.read(filepath.Join("class", "net", ifname, udev.SysAttrOperstate))
+}
+
+// SriovSettings is what the templater and the instances of SR-IOV resources
+// share: the view of sysfs that maps PF netdevs to the VFs seen so far, and
+// the topology the RDMA devices of VFs are resolved through.
+type SriovSettings struct {
+	sysfs    *sriovSysfs
+	topology RdmaTopology
+}
+
+// NewSriovSettings resolves VFs through sysfs mounted at root, e.g.
+// [DefaultSysfsRoot], and their RDMA devices through topology.
+func NewSriovSettings(root string, topology RdmaTopology) *SriovSettings {
+	return &SriovSettings{sysfs: newSriovSysfs(root), topology: topology}
+}
+
+// sriovVF is a single SR-IOV virtual function handed out to one pod.
+type sriovVF struct {
+	domain   string
+	name     string // resource name part derived from the PF's netdev
+	address  string // PCI address of the VF
+	pf       string // PCI address of the PF
+	pfIfname string
+	dev      udev.Device
+	settings *SriovSettings
+
+	disableTopologyHints bool
+}
+
+func (v *sriovVF) Id() Id {
+	return Id(v.address)
+}
+
+func (v *sriovVF) udevDevices() []udev.Device { return []udev.Device{v.dev} }
+
+// Health follows the link of the PF: VFs cannot pass traffic while it is down.
+func (v *sriovVF) Health() Health {
+	operstate := v.settings.sysfs.operstate(v.pfIfname)
+	if operstate == "up" {
+		return Healthy{}
+	}
+	return Unhealthy{Reason: fmt.Sprintf("PF %s is %s", v.pfIfname, operstate)}
+}
+
+// TopologyHints reports the NUMA node of the VF.
+func (v *sriovVF) TopologyHints() *pluginapi.TopologyInfo {
+	if v.disableTopologyHints {
+		return nil
+	}
+	return numaTopology(pciNumaNode(v.dev))
+}
+
+func (v *sriovVF) envPrefix() string {
+	return sanitizeEnv(v.domain) + "_SRIOV_" + sanitizeEnv(v.name)
+}
+
+// mergeKind implements [keyMerger]: the addresses, netdevs and RDMA devices
+// of all VFs of the container are listed.
+func (v *sriovVF) mergeKind(key string) mergeKind {
+	switch key {
+	case v.envPrefix() + sriovPciAddressesEnvSuffix,
+		v.envPrefix() + sriovNetdevsEnvSuffix,
+		v.envPrefix() + sriovRdmaDevicesEnvSuffix:
+		return mergeList
+	}
+	return mergeOverwrite
+}
+
+// Allocate passes the PCI address and netdev of the VF in the environment,
+// and the character devices of its RDMA device, if it has one.
+func (v *sriovVF) Allocate(context.Context) (*pluginapi.ContainerAllocateResponse, error) {
+	prefix := v.envPrefix()
+	response := &pluginapi.ContainerAllocateResponse{
+		Envs: map[string]string{
+			prefix + sriovPciAddressesEnvSuffix: v.address,
+		},
+	}
+
+	netdevs := v.settings.sysfs.netdevs(v.address)
+	if len(netdevs) == 0 {
+		klog.V(2).Infof("sriov VF %s has no netdev", v.address)
+		return response, nil
+	}
+	response.Envs[prefix+sriovNetdevsEnvSuffix] = netdevs[0]
+
+	rdmaDev, err := v.settings.topology.DeviceForNetdev(netdevs[0])
+	if err != nil {
+		return nil, err
+	}
+	if rdmaDev != "" {
+		charDevices, err := v.settings.topology.CharDevices(rdmaDev)
+		if err != nil {
+			return nil, err
+		}
+		for _, dev := range charDevices {
+			response.Devices = append(response.Devices, &pluginapi.DeviceSpec{
+				HostPath:      dev,
+				ContainerPath: dev,
+				Permissions:   "rw",
+			})
+		}
+		response.Envs[prefix+sriovRdmaDevicesEnvSuffix] = rdmaDev
+	}
+	klog.V(2).Infof("%+v", response)
+
+	return response, nil
+}
+
+// matchSriovVF returns the VF address, PF address, PF netdev and resource
+// name for the PCI device dev. ok is false unless dev is a VF of a PF whose
+// netdev matches matcher. Matching VFs are remembered for [matchSriovPF].
+//
+// Netdevs of VFs are deliberately not matched: moving a VF netdev into a
+// pod's network namespace is reported as its removal from the host.
+func matchSriovVF(dev udev.Device, matcher *regexp.Regexp, sysfs *sriovSysfs) (vf, pf, pfIfname, name string, ok bool) {
+	if dev.Subsystem() != udev.PCISubsystem {
+		return "", "", "", "", false
+	}
+
+	vf = udev.Sysname(dev)
+	pf = sysfs.physfn(vf)
+	if pf == "" {
+		return "", "", "", "", false
+	}
+	pfIfname = sysfs.pfNetdev(pf)
+	if pfIfname == "" {
+		return "", "", "", "", false
+	}
+	name, ok = matchInterfaceName(pfIfname, matcher)
+	if ok {
+		sysfs.addVF(pf, vf, dev)
+	}
+	return vf, pf, pfIfname, name, ok
+}
+
+// matchSriovPF returns the PF address, PF netdev, resource name and VFs for
+// the net device dev. ok is false unless dev is the netdev of a PF whose VFs
+// matched before, so that the health of the VFs is re-evaluated on events of
+// the PF netdev, such as operstate changes.
+func matchSriovPF(dev udev.Device, matcher *regexp.Regexp, sysfs *sriovSysfs) (pf, pfIfname, name string, vfs []udev.Device, ok bool) {
+	if dev.Subsystem() != udev.NetSubsystem {
+		return "", "", "", nil, false
+	}
+
+	pfIfname = dev.Property(udev.PropertyInterface)
+	if pfIfname == "" {
+		return "", "", "", nil, false
+	}
+	pf = sysfs.netdevPF(pfIfname)
+	if pf == "" {
+		return "", "", "", nil, false
+	}
+	name, ok = matchInterfaceName(pfIfname, matcher)
+	if !ok {
+		return "", "", "", nil, false
+	}
+	vfs = sysfs.pfVFs(pf)
+	return pf, pfIfname, name, vfs, len(vfs) > 0
+}
+
+// SriovMatcherTemplater returns a FromDevice function that produces one
+// ResourceTemplate per PF whose netdev matches matcher, for the PCI devices
+// of its VFs and for the netdev of the PF.
+func SriovMatcherTemplater(domain string, matcher *regexp.Regexp, settings *SriovSettings) FromDevice[*ResourceTemplate] {
+	return func(dev udev.Device) (*ResourceTemplate, error) {
+		_, _, _, name, ok := matchSriovVF(dev, matcher, settings.sysfs)
+		if !ok {
+			_, _, name, _, ok = matchSriovPF(dev, matcher, settings.sysfs)
+		}
+		if !ok {
+			return nil, nil
+		}
+
+		return &ResourceTemplate{
+			Domain: domain,
+			Prefix: "sriov-" + name,
+		}, nil
+	}
+}
+
+// SriovMatcherInstances returns a FromDevice function that produces one
+// sriovVF instance for each VF of a PF whose netdev matches matcher. The
+// netdev of the PF maps to all of its VFs.
+func SriovMatcherInstances(domain string, matcher *regexp.Regexp, disableTopologyHints bool, settings *SriovSettings) FromDevice[[]*sriovVF] {
+	newVF := func(name, vf, pf, pfIfname string, dev udev.Device) *sriovVF {
+		return &sriovVF{
+			domain:               domain,
+			name:                 name,
+			address:              vf,
+			pf:                   pf,
+			pfIfname:             pfIfname,
+			dev:                  dev,
+			settings:             settings,
+			disableTopologyHints: disableTopologyHints,
+		}
+	}
+	return func(dev udev.Device) ([]*sriovVF, error) {
+		if vf, pf, pfIfname, name, ok := matchSriovVF(dev, matcher, settings.sysfs); ok {
+			klog.V(5).Infof("found sriov VF %s of PF %s (%s)", vf, pf, pfIfname)
+			return []*sriovVF{newVF(name, vf, pf, pfIfname, dev)}, nil
+		}
+
+		pf, pfIfname, name, vfs, ok := matchSriovPF(dev, matcher, settings.sysfs)
+		if !ok {
+			return nil, nil
+		}
+		instances := make([]*sriovVF, 0, len(vfs))
+		addrs := make([]string, 0, len(vfs))
+		for _, vfDev := range vfs {
+			vf := udev.Sysname(vfDev)
+			instances = append(instances, newVF(name, vf, pf, pfIfname, vfDev))
+			addrs = append(addrs, vf)
+		}
+		klog.V(5).Infof("sriov PF %s (%s) changed, re-evaluating VFs %s", pf, pfIfname, strings.Join(addrs, ","))
+		return instances, nil
+	}
+}
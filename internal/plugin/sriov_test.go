@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// fakeSriovSysfs lays out PF pf with netdev pfIfname and its VFs under root.
+// vfs maps VF PCI addresses to their netdevs ("" for none).
+func fakeSriovSysfs(root, pf, pfIfname, operstate string, vfs map[string]string) {
+	devices := filepath.Join(root, "bus", "pci", "devices")
+	Expect(os.MkdirAll(filepath.Join(devices, pf, "net", pfIfname), 0o755)).To(Succeed())
+	writeSysfsFile(root, filepath.Join("class", "net", pfIfname, "operstate"), operstate)
+	for vf, ifname := range vfs {
+		Expect(os.MkdirAll(filepath.Join(devices, vf), 0o755)).To(Succeed())
+		Expect(os.Symlink(filepath.Join("..", pf), filepath.Join(devices, vf, "physfn"))).To(Succeed())
+		if ifname != "" {
+			Expect(os.MkdirAll(filepath.Join(devices, vf, "net", ifname), 0o755)).To(Succeed())
+		}
+	}
+}
+
+var _ = Describe("SR-IOV", func() {
+	const (
+		pf  = "0000:3b:00.0"
+		vf0 = "0000:3b:02.0"
+		vf1 = "0000:3b:02.1"
+	)
+
+	var (
+		root     string
+		matcher  *regexp.Regexp
+		topology *FakeRdmaTopology
+		settings *SriovSettings
+	)
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		matcher = regexp.MustCompile(`^ens1(f\d+)$`)
+		topology = NewFakeRdmaTopology()
+		settings = NewSriovSettings(root, topology)
+		fakeSriovSysfs(root, pf, "ens1f0", "up", map[string]string{vf0: "ens1f0v0", vf1: ""})
+	})
+
+	Describe("SriovMatcherTemplater", func() {
+		It("returns nil for a non-PCI device", func() {
+			tmpl, err := SriovMatcherTemplater("ydb.tech", matcher, settings)(netDevice("ens1f0v0", "1000", "up"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+		})
+
+		It("returns nil for a PCI device that is not a VF", func() {
+			tmpl, err := SriovMatcherTemplater("ydb.tech", matcher, settings)(pciDevice(pf, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+		})
+
+		It("returns nil when the netdev of the PF does not match", func() {
+			matcher = regexp.MustCompile(`^eth\d+$`)
+			tmpl, err := SriovMatcherTemplater("ydb.tech", matcher, settings)(pciDevice(vf0, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+		})
+
+		It("returns a template per PF using the capture group", func() {
+			tmpl, err := SriovMatcherTemplater("ydb.tech", matcher, settings)(pciDevice(vf0, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(Equal(&ResourceTemplate{Domain: "ydb.tech", Prefix: "sriov-f0"}))
+		})
+
+		It("maps the removal of a VF after sysfs is gone", func() {
+			templater := SriovMatcherTemplater("ydb.tech", matcher, settings)
+			_, err := templater(pciDevice(vf0, ""))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.RemoveAll(filepath.Join(root, "bus"))).To(Succeed())
+			tmpl, err := templater(pciDevice(vf0, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).NotTo(BeNil())
+			Expect(tmpl.Prefix).To(Equal("sriov-f0"))
+		})
+	})
+
+	Describe("SriovMatcherInstances", func() {
+		It("returns one instance per VF identified by its PCI address", func() {
+			mapper := SriovMatcherInstances("ydb.tech", matcher, false, settings)
+			instances, err := mapper(pciDevice(vf1, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(string(instances[0].Id())).To(Equal(vf1))
+		})
+
+		It("follows the link of the PF for health", func() {
+			instances, err := SriovMatcherInstances("ydb.tech", matcher, false, settings)(pciDevice(vf0, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances[0].Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+			writeSysfsFile(root, "class/net/ens1f0/operstate", "down")
+			Expect(instances[0].Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		})
+
+		It("reports the NUMA node of the VF", func() {
+			instances, err := SriovMatcherInstances("ydb.tech", matcher, false, settings)(pciDevice(vf0, "1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances[0].TopologyHints().Nodes[0].ID).To(BeEquivalentTo(1))
+		})
+	})
+
+	Describe("events of the PF netdev", func() {
+		var (
+			templater FromDevice[*ResourceTemplate]
+			mapper    FromDevice[[]*sriovVF]
+			pfDev     = netDevice("ens1f0", "25000", "up")
+		)
+
+		BeforeEach(func() {
+			templater = SriovMatcherTemplater("ydb.tech", matcher, settings)
+			mapper = SriovMatcherInstances("ydb.tech", matcher, false, settings)
+		})
+
+		It("are ignored before any VF of the PF is seen", func() {
+			tmpl, err := templater(pfDev)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+			instances, err := mapper(pfDev)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+
+		It("map to the resource and all VFs of the PF", func() {
+			_, err := mapper(pciDevice(vf0, "1"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = mapper(pciDevice(vf1, "1"))
+			Expect(err).NotTo(HaveOccurred())
+
+			tmpl, err := templater(pfDev)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(Equal(&ResourceTemplate{Domain: "ydb.tech", Prefix: "sriov-f0"}))
+
+			instances, err := mapper(pfDev)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+			Expect(string(instances[0].Id())).To(Equal(vf0))
+			Expect(string(instances[1].Id())).To(Equal(vf1))
+			Expect(instances[0].TopologyHints().Nodes[0].ID).To(BeEquivalentTo(1))
+
+			writeSysfsFile(root, "class/net/ens1f0/operstate", "down")
+			Expect(instances[0].Health()).To(Equal(Unhealthy{Reason: "PF ens1f0 is down"}))
+		})
+
+		It("leave out VFs that are gone", func() {
+			_, err := mapper(pciDevice(vf0, ""))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = mapper(pciDevice(vf1, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.RemoveAll(filepath.Join(root, "bus", "pci", "devices", vf1))).To(Succeed())
+
+			instances, err := mapper(pfDev)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(string(instances[0].Id())).To(Equal(vf0))
+		})
+
+		It("are ignored for netdevs of other PFs", func() {
+			_, err := mapper(pciDevice(vf0, ""))
+			Expect(err).NotTo(HaveOccurred())
+			instances, err := mapper(netDevice("ens1f1", "25000", "up"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+	})
+
+	Describe("Allocate", func() {
+		instance := func(vf string) Instance {
+			instances, err := SriovMatcherInstances("ydb.tech", matcher, false, settings)(pciDevice(vf, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			return instances[0]
+		}
+
+		allocate := func(vf string) *pluginapi.ContainerAllocateResponse {
+			resp, err := instance(vf).Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			return resp
+		}
+
+		It("passes the PCI address and netdev of the VF", func() {
+			resp := allocate(vf0)
+			Expect(resp.Envs).To(Equal(map[string]string{
+				"YDB_TECH_SRIOV_F0_PCI_ADDRESSES": vf0,
+				"YDB_TECH_SRIOV_F0_NETDEVS":       "ens1f0v0",
+			}))
+			Expect(resp.Devices).To(BeEmpty())
+		})
+
+		It("passes only the PCI address of a VF without netdev", func() {
+			resp := allocate(vf1)
+			Expect(resp.Envs).To(Equal(map[string]string{
+				"YDB_TECH_SRIOV_F0_PCI_ADDRESSES": vf1,
+			}))
+		})
+
+		It("passes the RDMA character devices of the VF", func() {
+			topology.AddDevice("mlx5_2", []string{"ens1f0v0"}, "/dev/infiniband/uverbs2", "/dev/infiniband/rdma_cm")
+			resp := allocate(vf0)
+			Expect(resp.Envs).To(HaveKeyWithValue("YDB_TECH_SRIOV_F0_RDMA_DEVICES", "mlx5_2"))
+			Expect(resp.Envs).NotTo(HaveKey("YDB_TECH_RDMA_DEVICES"))
+			Expect(resp.Devices).To(HaveLen(2))
+			Expect(resp.Devices[0].HostPath).To(Equal("/dev/infiniband/uverbs2"))
+		})
+
+		It("lists all VFs of a container", func() {
+			merged := mergeResponses(mergePolicy(instance(vf0)), allocate(vf0), allocate(vf1))
+			Expect(merged.Envs).To(HaveKeyWithValue("YDB_TECH_SRIOV_F0_PCI_ADDRESSES", vf0+","+vf1))
+			Expect(merged.Envs).To(HaveKeyWithValue("YDB_TECH_SRIOV_F0_NETDEVS", "ens1f0v0"))
+		})
+	})
+})
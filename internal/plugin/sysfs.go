@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultSysfsRoot is where sysfs is mounted on a regular host.
+const DefaultSysfsRoot = "/sys"
+
+// sysfs reads files below a sysfs mount at root. All paths are relative to
+// root.
+type sysfs struct {
+	root string
+}
+
+// list returns the sorted entry names of dir. A missing dir is not an error
+// and yields no names.
+func (s sysfs) list(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// exists reports whether file exists. Links are not followed.
+func (s sysfs) exists(file string) bool {
+	_, err := os.Lstat(filepath.Join(s.root, file))
+	return err == nil
+}
+
+// read returns the trimmed content of file, or "" if it cannot be read.
+func (s sysfs) read(file string) string {
+	data, err := os.ReadFile(filepath.Join(s.root, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// linkName returns the last element of the target of symlink file, e.g. the
+// PCI address a physfn link points to, or "" if it is not a link.
+func (s sysfs) linkName(file string) string {
+	target, err := os.Readlink(filepath.Join(s.root, file))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
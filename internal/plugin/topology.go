@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"sort"
+	"strconv"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// numaTopology builds the kubelet topology hint for the given NUMA nodes.
+// Negative (unknown) nodes and duplicates are dropped; nil is returned when no
+// node is left, which tells the Topology Manager that the device has no
+// preference.
+func numaTopology(nodes ...int) *pluginapi.TopologyInfo {
+	nodes = distinctNumaNodes(nodes)
+	if len(nodes) == 0 {
+		return nil
+	}
+	info := &pluginapi.TopologyInfo{
+		Nodes: make([]*pluginapi.NUMANode, 0, len(nodes)),
+	}
+	for _, node := range nodes {
+		info.Nodes = append(info.Nodes, &pluginapi.NUMANode{ID: int64(node)})
+	}
+	return info
+}
+
+// distinctNumaNodes returns the sorted set of known (non-negative) nodes.
+func distinctNumaNodes(nodes []int) []int {
+	seen := make(map[int]struct{}, len(nodes))
+	result := make([]int, 0, len(nodes))
+	for _, node := range nodes {
+		if node < 0 {
+			continue
+		}
+		if _, ok := seen[node]; ok {
+			continue
+		}
+		seen[node] = struct{}{}
+		result = append(result, node)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// pciNumaNode returns the NUMA node reported by the closest PCI ancestor of
+// dev (dev itself included), or -1 if there is none or it does not report a
+// node. Network interfaces carry numa_node only on their PCI parent, so this
+// is the lookup used for netdev-backed instances.
+func pciNumaNode(dev udev.Device) int {
+	for d := dev; d != nil; d = d.Parent() {
+		if d.Subsystem() != udev.PCISubsystem {
+			continue
+		}
+		if node, err := strconv.Atoi(d.SystemAttribute(udev.SysAttrNumaNode)); err == nil {
+			return node
+		}
+		return -1
+	}
+	return -1
+}
@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("numaTopology", func() {
+	It("returns nil without any known node", func() {
+		Expect(numaTopology()).To(BeNil())
+		Expect(numaTopology(-1, -1)).To(BeNil())
+	})
+
+	It("returns sorted, de-duplicated nodes and drops unknown ones", func() {
+		info := numaTopology(1, -1, 0, 1)
+		Expect(info).NotTo(BeNil())
+		Expect(info.Nodes).To(HaveLen(2))
+		Expect(info.Nodes[0].ID).To(BeEquivalentTo(0))
+		Expect(info.Nodes[1].ID).To(BeEquivalentTo(1))
+	})
+})
+
+var _ = Describe("pciNumaNode", func() {
+	It("returns the node of the PCI parent", func() {
+		dev := netDevice("eth0", "1000", "up")
+		dev.parent = pciDevice("0000:3b:00.0", "1")
+		Expect(pciNumaNode(dev)).To(Equal(1))
+	})
+
+	It("walks past non-PCI ancestors", func() {
+		dev := netDevice("eth0", "1000", "up")
+		dev.parent = &mockDevice{id: "virtual", subsystem: "virtio", parent: pciDevice("0000:00:03.0", "0")}
+		Expect(pciNumaNode(dev)).To(Equal(0))
+	})
+
+	It("returns -1 without a PCI ancestor", func() {
+		Expect(pciNumaNode(netDevice("bond0", "1000", "up"))).To(Equal(-1))
+	})
+
+	It("returns -1 when the PCI parent reports no node", func() {
+		dev := netDevice("eth0", "1000", "up")
+		dev.parent = pciDevice("0000:3b:00.0", "-1")
+		Expect(pciNumaNode(dev)).To(Equal(-1))
+		dev.parent = pciDevice("0000:3b:00.0", "")
+		Expect(pciNumaNode(dev)).To(Equal(-1))
+	})
+})
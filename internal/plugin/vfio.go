@@ -0,0 +1,288 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// vfioDriver is the driver PCI devices must be bound to for passthrough.
+	vfioDriver = "vfio-pci"
+
+	vfioDevDir       = "/dev/vfio"
+	vfioContainerDev = "/dev/vfio/vfio"
+
+	// vfioPciResourceEnvPrefix prefixes the env key listing the PCI addresses
+	// of a resource, in the format KubeVirt expects.
+	vfioPciResourceEnvPrefix = "PCI_RESOURCE_"
+
+	// pciClassBridge is the class prefix of PCI bridges. Bridges may share an
+	// IOMMU group with passed through devices without being bound to vfio-pci.
+	pciClassBridge = "0x0604"
+)
+
+// VfioMatcher selects PCI devices for passthrough. A device matches if it
+// matches every matcher that is set.
+type VfioMatcher struct {
+	VendorDevice *regexp.Regexp // matched against "vvvv:dddd", e.g. "144d:a80a"
+	Class        *regexp.Regexp // matched against the class attribute, e.g. "0x010802"
+	Address      *regexp.Regexp // matched against the PCI address, e.g. "0000:3b:00.0"
+}
+
+func (m VfioMatcher) match(dev udev.Device) bool {
+	if m.VendorDevice != nil && !m.VendorDevice.MatchString(pciVendorDevice(dev)) {
+		return false
+	}
+	if m.Class != nil && !m.Class.MatchString(dev.SystemAttribute(udev.SysAttrClass)) {
+		return false
+	}
+	if m.Address != nil && !m.Address.MatchString(udev.Sysname(dev)) {
+		return false
+	}
+	return true
+}
+
+// pciVendorDevice returns the "vvvv:dddd" id of PCI device dev, or "" if its
+// attributes cannot be read.
+func pciVendorDevice(dev udev.Device) string {
+	vendor := strings.TrimPrefix(dev.SystemAttribute(udev.SysAttrVendor), "0x")
+	device := strings.TrimPrefix(dev.SystemAttribute(udev.SysAttrDevice), "0x")
+	if vendor == "" || device == "" {
+		return ""
+	}
+	return strings.ToLower(vendor + ":" + device)
+}
+
+// vfioSysfs resolves IOMMU groups through the iommu_group links of PCI
+// devices in sysfs. It remembers the groups of matched devices, so that
+// removal events can be mapped after the sysfs entries are gone.
+type vfioSysfs struct {
+	sysfs
+
+	mu     sync.Mutex
+	groups map[string]string // PCI address -> IOMMU group
+}
+
+func newVfioSysfs(root string) *vfioSysfs {
+	return &vfioSysfs{
+		sysfs:  sysfs{root: root},
+		groups: make(map[string]string),
+	}
+}
+
+// group returns the IOMMU group of the PCI device addr, or "" if it has none.
+func (s *vfioSysfs) group(addr string) string {
+	group := s.linkName(filepath.Join("bus", "pci", "devices", addr, "iommu_group"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group == "" {
+		return s.groups[addr]
+	}
+	s.groups[addr] = group
+	return group
+}
+
+// known reports whether addr was resolved to a group before.
+func (s *vfioSysfs) known(addr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.groups[addr]
+	return ok
+}
+
+// members returns the PCI addresses of all devices in IOMMU group group.
+func (s *vfioSysfs) members(group string) []string {
+	names, _ := s.list(filepath.Join("kernel", "iommu_groups", group, "devices"))
+	return names
+}
+
+// driver returns the driver PCI device addr is bound to, or "" if none.
+func (s *vfioSysfs) driver(addr string) string {
+	return s.linkName(filepath.Join("bus", "pci", "devices", addr, "driver"))
+}
+
+// class returns the class attribute of PCI device addr.
+func (s *vfioSysfs) class(addr string) string {
+	return s.read(filepath.Join("bus", "pci", "devices", addr, udev.SysAttrClass))
+}
+
+// VfioSettings is what the templater and the instances of a VFIO resource
+// share: the view of sysfs that remembers the IOMMU groups of removed
+// devices.
+type VfioSettings struct {
+	sysfs *vfioSysfs
+}
+
+// NewVfioSettings resolves IOMMU groups through sysfs mounted at root, e.g.
+// [DefaultSysfsRoot].
+func NewVfioSettings(root string) *VfioSettings {
+	return &VfioSettings{sysfs: newVfioSysfs(root)}
+}
+
+// vfioGroup is an IOMMU group handed out to one pod as a whole: the kernel
+// only lets a group be opened once, so its devices cannot be split between
+// pods.
+type vfioGroup struct {
+	domain   string
+	name     string // resource name, vfio-<name>
+	group    string
+	dev      udev.Device
+	settings *VfioSettings
+
+	disableTopologyHints bool
+}
+
+func (g *vfioGroup) Id() Id {
+	return Id(g.group)
+}
+
+// devices returns the PCI addresses of the members of the group, leaving out
+// bridges.
+func (g *vfioGroup) devices() []string {
+	var addrs []string
+	for _, addr := range g.settings.sysfs.members(g.group) {
+		if strings.HasPrefix(g.settings.sysfs.class(addr), pciClassBridge) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func (g *vfioGroup) udevDevices() []udev.Device { return []udev.Device{g.dev} }
+
+// Health is Healthy if every device of the group is bound to vfio-pci.
+// Otherwise the group cannot be passed through as a whole.
+func (g *vfioGroup) Health() Health {
+	addrs := g.devices()
+	if len(addrs) == 0 {
+		return Unhealthy{Reason: fmt.Sprintf("vfio group %s has no devices", g.group)}
+	}
+	for _, addr := range addrs {
+		if driver := g.settings.sysfs.driver(addr); driver != vfioDriver {
+			return Unhealthy{Reason: fmt.Sprintf("vfio group %s: %s is bound to %q, not %s", g.group, addr, driver, vfioDriver)}
+		}
+	}
+	return Healthy{}
+}
+
+// TopologyHints reports the NUMA node of the matched device.
+func (g *vfioGroup) TopologyHints() *pluginapi.TopologyInfo {
+	if g.disableTopologyHints {
+		return nil
+	}
+	return numaTopology(pciNumaNode(g.dev))
+}
+
+// Allocate passes the VFIO container and group devices, and lists the PCI
+// addresses of the group in PCI_RESOURCE_<DOMAIN>_VFIO-<NAME>.
+func (g *vfioGroup) Allocate(context.Context) (*pluginapi.ContainerAllocateResponse, error) {
+	response := &pluginapi.ContainerAllocateResponse{
+		Devices: []*pluginapi.DeviceSpec{
+			{
+				HostPath:      vfioContainerDev,
+				ContainerPath: vfioContainerDev,
+				Permissions:   "rw",
+			},
+			{
+				HostPath:      filepath.Join(vfioDevDir, g.group),
+				ContainerPath: filepath.Join(vfioDevDir, g.group),
+				Permissions:   "rw",
+			},
+		},
+		Envs: map[string]string{
+			vfioPciResourceEnv(g.domain, g.name): strings.Join(g.devices(), ","),
+		},
+	}
+	klog.Info("allocated vfio group: ", g.group)
+	klog.V(2).Infof("%+v", response)
+	return response, nil
+}
+
+// mergeKind implements [keyMerger]: the PCI addresses of all groups of the
+// container are listed under the KubeVirt key.
+func (g *vfioGroup) mergeKind(key string) mergeKind {
+	if key == vfioPciResourceEnv(g.domain, g.name) {
+		return mergeList
+	}
+	return mergeOverwrite
+}
+
+// vfioPciResourceEnv returns the key KubeVirt looks up for the PCI addresses
+// of resource <domain>/<name>. KubeVirt upper-cases the resource name and
+// replaces only "/" and "." with "_", so dashes are kept.
+func vfioPciResourceEnv(domain, name string) string {
+	return vfioPciResourceEnvPrefix + strings.NewReplacer("/", "_", ".", "_").Replace(strings.ToUpper(domain+"/"+name))
+}
+
+// matchVfioDevice returns the PCI address and IOMMU group of dev. ok is false
+// unless dev is a PCI device matching matcher that is bound to vfio-pci.
+//
+// Devices that matched before keep matching when they are unbound or gone,
+// so that their group is marked unhealthy rather than forgotten.
+func matchVfioDevice(dev udev.Device, matcher VfioMatcher, sysfs *vfioSysfs) (addr, group string, ok bool) {
+	if dev.Subsystem() != udev.PCISubsystem {
+		return "", "", false
+	}
+
+	addr = udev.Sysname(dev)
+	if sysfs.known(addr) {
+		return addr, sysfs.group(addr), true
+	}
+	if !matcher.match(dev) || sysfs.driver(addr) != vfioDriver {
+		return "", "", false
+	}
+	group = sysfs.group(addr)
+	if group == "" {
+		klog.Warningf("vfio: %s matches but has no IOMMU group, is the IOMMU enabled?", addr)
+		return "", "", false
+	}
+	return addr, group, true
+}
+
+// VfioMatcherTemplater returns a FromDevice function that produces the
+// ResourceTemplate <domain>/vfio-<name> for PCI devices matching matcher.
+func VfioMatcherTemplater(domain, name string, matcher VfioMatcher, settings *VfioSettings) FromDevice[*ResourceTemplate] {
+	return func(dev udev.Device) (*ResourceTemplate, error) {
+		if _, _, ok := matchVfioDevice(dev, matcher, settings.sysfs); !ok {
+			return nil, nil
+		}
+
+		return &ResourceTemplate{
+			Domain: domain,
+			Prefix: "vfio-" + name,
+		}, nil
+	}
+}
+
+// VfioMatcherInstances returns a FromDevice function that produces the
+// vfioGroup instance of the IOMMU group of each PCI device matching matcher.
+// Devices of the same group map to the same instance.
+func VfioMatcherInstances(domain, name string, matcher VfioMatcher, disableTopologyHints bool, settings *VfioSettings) FromDevice[[]*vfioGroup] {
+	return func(dev udev.Device) ([]*vfioGroup, error) {
+		addr, group, ok := matchVfioDevice(dev, matcher, settings.sysfs)
+		if !ok {
+			return nil, nil
+		}
+		klog.V(5).Infof("found vfio device %s in IOMMU group %s", addr, group)
+
+		return []*vfioGroup{{
+			domain:               domain,
+			name:                 "vfio-" + name,
+			group:                group,
+			dev:                  dev,
+			settings:             settings,
+			disableTopologyHints: disableTopologyHints,
+		}}, nil
+	}
+}
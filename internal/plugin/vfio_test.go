@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// fakeVfioSysfs puts the PCI devices drivers maps to their drivers ("" for
+// none) into IOMMU group group under root.
+func fakeVfioSysfs(root, group string, drivers map[string]string) {
+	devices := filepath.Join(root, "bus", "pci", "devices")
+	groupDevices := filepath.Join(root, "kernel", "iommu_groups", group, "devices")
+	Expect(os.MkdirAll(groupDevices, 0o755)).To(Succeed())
+	for addr, driver := range drivers {
+		Expect(os.MkdirAll(filepath.Join(devices, addr), 0o755)).To(Succeed())
+		Expect(os.Symlink(filepath.Join("..", "..", "..", "kernel", "iommu_groups", group), filepath.Join(devices, addr, "iommu_group"))).To(Succeed())
+		Expect(os.Symlink(filepath.Join("..", "..", "..", "..", "bus", "pci", "devices", addr), filepath.Join(groupDevices, addr))).To(Succeed())
+		if driver != "" {
+			setVfioDriver(root, addr, driver)
+		}
+	}
+}
+
+// setVfioDriver rebinds PCI device addr to driver.
+func setVfioDriver(root, addr, driver string) {
+	link := filepath.Join(root, "bus", "pci", "devices", addr, "driver")
+	Expect(os.RemoveAll(link)).To(Succeed())
+	Expect(os.Symlink(filepath.Join("..", "..", "..", "bus", "pci", "drivers", driver), link)).To(Succeed())
+}
+
+// vfioPciDevice is a PCI device with the given vendor:device id and class.
+func vfioPciDevice(addr, vendor, device, class string) *mockDevice {
+	dev := pciDevice(addr, "1")
+	dev.sysattrs["vendor"] = vendor
+	dev.sysattrs["device"] = device
+	dev.sysattrs["class"] = class
+	return dev
+}
+
+var _ = Describe("VFIO", func() {
+	const (
+		nvme0 = "0000:5e:00.0"
+		nvme1 = "0000:5f:00.0"
+		func0 = "0000:af:00.0"
+		func1 = "0000:af:00.1"
+	)
+
+	var (
+		root     string
+		matcher  VfioMatcher
+		settings *VfioSettings
+	)
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		matcher = VfioMatcher{VendorDevice: regexp.MustCompile(`^144d:a80a$`)}
+		settings = NewVfioSettings(root)
+		fakeVfioSysfs(root, "10", map[string]string{nvme0: "vfio-pci"})
+		fakeVfioSysfs(root, "11", map[string]string{nvme1: "nvme"})
+		fakeVfioSysfs(root, "20", map[string]string{func0: "vfio-pci", func1: "vfio-pci"})
+	})
+
+	Describe("VfioMatcher", func() {
+		It("requires every matcher that is set", func() {
+			dev := vfioPciDevice(nvme0, "0x144d", "0xA80A", "0x010802")
+			Expect(VfioMatcher{}.match(dev)).To(BeTrue())
+			Expect(matcher.match(dev)).To(BeTrue())
+
+			matcher.Class = regexp.MustCompile(`^0x0108`)
+			Expect(matcher.match(dev)).To(BeTrue())
+
+			matcher.Address = regexp.MustCompile(`^0000:af:`)
+			Expect(matcher.match(dev)).To(BeFalse())
+		})
+	})
+
+	Describe("VfioMatcherTemplater", func() {
+		It("returns nil for a non-PCI device", func() {
+			tmpl, err := VfioMatcherTemplater("ydb.tech", "nvme", matcher, settings)(netDevice("eth0", "1000", "up"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+		})
+
+		It("returns nil for a device that does not match", func() {
+			tmpl, err := VfioMatcherTemplater("ydb.tech", "nvme", matcher, settings)(vfioPciDevice(nvme0, "0x8086", "0x0a54", "0x010802"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+		})
+
+		It("returns nil for a device not bound to vfio-pci", func() {
+			tmpl, err := VfioMatcherTemplater("ydb.tech", "nvme", matcher, settings)(vfioPciDevice(nvme1, "0x144d", "0xa80a", "0x010802"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+		})
+
+		It("returns nil for a device without an IOMMU group", func() {
+			Expect(os.Remove(filepath.Join(root, "bus", "pci", "devices", nvme0, "iommu_group"))).To(Succeed())
+			tmpl, err := VfioMatcherTemplater("ydb.tech", "nvme", matcher, settings)(vfioPciDevice(nvme0, "0x144d", "0xa80a", "0x010802"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(BeNil())
+		})
+
+		It("returns the configured resource", func() {
+			tmpl, err := VfioMatcherTemplater("ydb.tech", "nvme", matcher, settings)(vfioPciDevice(nvme0, "0x144d", "0xa80a", "0x010802"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(Equal(&ResourceTemplate{Domain: "ydb.tech", Prefix: "vfio-nvme"}))
+		})
+
+		It("maps the removal of a device after sysfs is gone", func() {
+			templater := VfioMatcherTemplater("ydb.tech", "nvme", matcher, settings)
+			_, err := templater(vfioPciDevice(nvme0, "0x144d", "0xa80a", "0x010802"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.RemoveAll(filepath.Join(root, "bus", "pci", "devices", nvme0))).To(Succeed())
+			tmpl, err := templater(pciDevice(nvme0, ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tmpl).To(Equal(&ResourceTemplate{Domain: "ydb.tech", Prefix: "vfio-nvme"}))
+		})
+	})
+
+	Describe("VfioMatcherInstances", func() {
+		It("maps the functions of one IOMMU group to one instance", func() {
+			matcher = VfioMatcher{Address: regexp.MustCompile(`^0000:af:`)}
+			mapper := VfioMatcherInstances("ydb.tech", "cx5", matcher, false, settings)
+
+			first, err := mapper(vfioPciDevice(func0, "0x15b3", "0x1017", "0x020000"))
+			Expect(err).NotTo(HaveOccurred())
+			second, err := mapper(vfioPciDevice(func1, "0x15b3", "0x1017", "0x020000"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first).To(HaveLen(1))
+			Expect(second).To(HaveLen(1))
+			Expect(first[0].Id()).To(Equal(Id("20")))
+			Expect(second[0].Id()).To(Equal(first[0].Id()))
+		})
+
+		It("reports the NUMA node of the device unless disabled", func() {
+			instances, err := VfioMatcherInstances("ydb.tech", "nvme", matcher, false, settings)(vfioPciDevice(nvme0, "0x144d", "0xa80a", "0x010802"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances[0].TopologyHints()).To(Equal(&pluginapi.TopologyInfo{
+				Nodes: []*pluginapi.NUMANode{{ID: 1}},
+			}))
+
+			instances, err = VfioMatcherInstances("ydb.tech", "nvme", matcher, true, settings)(vfioPciDevice(nvme0, "0x144d", "0xa80a", "0x010802"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances[0].TopologyHints()).To(BeNil())
+		})
+	})
+
+	Describe("vfioGroup", func() {
+		var group *vfioGroup
+
+		BeforeEach(func() {
+			matcher = VfioMatcher{Address: regexp.MustCompile(`^0000:af:`)}
+			instances, err := VfioMatcherInstances("ydb.tech", "cx5", matcher, false, settings)(vfioPciDevice(func0, "0x15b3", "0x1017", "0x020000"))
+			Expect(err).NotTo(HaveOccurred())
+			group = instances[0]
+		})
+
+		It("is healthy while all devices of the group are bound to vfio-pci", func() {
+			Expect(group.Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+			setVfioDriver(root, func1, "mlx5_core")
+			Expect(group.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		})
+
+		It("ignores bridges in the group", func() {
+			fakeVfioSysfs(root, "20", map[string]string{"0000:ae:00.0": "pcieport"})
+			writeSysfsFile(root, filepath.Join("bus", "pci", "devices", "0000:ae:00.0", "class"), "0x060400")
+			Expect(group.Health()).To(BeAssignableToTypeOf(Healthy{}))
+		})
+
+		It("keeps matching a device after it is unbound", func() {
+			setVfioDriver(root, func0, "mlx5_core")
+			instances, err := VfioMatcherInstances("ydb.tech", "cx5", matcher, false, settings)(vfioPciDevice(func0, "0x15b3", "0x1017", "0x020000"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(instances[0].Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+		})
+
+		It("passes the VFIO devices and the PCI addresses of the whole group", func() {
+			resp, err := group.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Devices).To(Equal([]*pluginapi.DeviceSpec{
+				{HostPath: "/dev/vfio/vfio", ContainerPath: "/dev/vfio/vfio", Permissions: "rw"},
+				{HostPath: "/dev/vfio/20", ContainerPath: "/dev/vfio/20", Permissions: "rw"},
+			}))
+			Expect(resp.Envs).To(Equal(map[string]string{
+				"PCI_RESOURCE_YDB_TECH_VFIO-CX5": func0 + "," + func1,
+			}))
+		})
+
+		It("lists the PCI addresses of all groups of a container", func() {
+			other := &pluginapi.ContainerAllocateResponse{Envs: map[string]string{
+				"PCI_RESOURCE_YDB_TECH_VFIO-CX5": "0000:5e:00.0",
+			}}
+			resp, err := group.Allocate(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			merged := mergeResponses(mergePolicy(group), resp, other)
+			Expect(merged.Envs).To(HaveKeyWithValue("PCI_RESOURCE_YDB_TECH_VFIO-CX5", func0+","+func1+",0000:5e:00.0"))
+		})
+	})
+})
+
+var _ = Describe("vfioPciResourceEnv", func() {
+	DescribeTable("names the env key as KubeVirt does",
+		func(domain, name, expected string) {
+			Expect(vfioPciResourceEnv(domain, name)).To(Equal(expected))
+		},
+		Entry("plain name", "ydb.tech", "cx5", "PCI_RESOURCE_YDB_TECH_CX5"),
+		Entry("name with a dash", "ydb.tech", "nvme-ssd", "PCI_RESOURCE_YDB_TECH_NVME-SSD"),
+		Entry("domain with a dash", "storage-node.example.com", "gpu", "PCI_RESOURCE_STORAGE-NODE_EXAMPLE_COM_GPU"),
+	)
+})
@@ -0,0 +1,218 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// Kernel name prefixes of device-mapper devices and md arrays.
+const (
+	dmPrefix = "dm-"
+	mdPrefix = "md"
+
+	dmMapperDir = "/dev/mapper"
+	mdNamedDir  = "/dev/md"
+)
+
+// volumeKind tells device-mapper devices (LVM logical volumes, dm-crypt
+// mappings, ...) and md arrays apart.
+type volumeKind int
+
+const (
+	volumeNone volumeKind = iota
+	volumeDm
+	volumeMd
+)
+
+// volumeKindOf returns the kind of block device dev, or volumeNone if it is
+// neither a device-mapper device nor an md array.
+func volumeKindOf(dev udev.Device) volumeKind {
+	if dev.Subsystem() != udev.BlockSubsystem || dev.DevType() == udev.DeviceTypePart {
+		return volumeNone
+	}
+	name := udev.Sysname(dev)
+	switch {
+	case strings.HasPrefix(name, dmPrefix) && dev.Property(udev.PropertyDmName) != "":
+		return volumeDm
+	case strings.HasPrefix(name, mdPrefix) && dev.Property(udev.PropertyMdLevel) != "":
+		return volumeMd
+	default:
+		return volumeNone
+	}
+}
+
+// VolumeMatcher selects device-mapper devices and md arrays. A device matches
+// if it matches every matcher that is set; VG, LV and UUID only match
+// device-mapper devices, Level only matches md arrays.
+type VolumeMatcher struct {
+	Name  *regexp.Regexp // matched against DM_NAME or MD_NAME
+	VG    *regexp.Regexp // matched against DM_VG_NAME of LVM logical volumes
+	LV    *regexp.Regexp // matched against DM_LV_NAME of LVM logical volumes
+	UUID  *regexp.Regexp // matched against DM_UUID, e.g. "^CRYPT-" or "^LVM-"
+	Level *regexp.Regexp // matched against MD_LEVEL, e.g. "^raid1$"
+}
+
+func (m VolumeMatcher) match(dev udev.Device, kind volumeKind) bool {
+	matches := func(re *regexp.Regexp, property string) bool {
+		return re == nil || re.MatchString(dev.Property(property))
+	}
+	switch kind {
+	case volumeDm:
+		return m.Level == nil &&
+			matches(m.Name, udev.PropertyDmName) &&
+			matches(m.VG, udev.PropertyDmVgName) &&
+			matches(m.LV, udev.PropertyDmLvName) &&
+			matches(m.UUID, udev.PropertyDmUUID)
+	case volumeMd:
+		return m.VG == nil && m.LV == nil && m.UUID == nil &&
+			matches(m.Name, udev.PropertyMdName) &&
+			matches(m.Level, udev.PropertyMdLevel)
+	default:
+		return false
+	}
+}
+
+// VolumeSettings holds what the instances of a volume resource share.
+type VolumeSettings struct {
+	sysfs sysfs
+}
+
+// NewVolumeSettings checks the state of volumes in sysfs mounted at root,
+// e.g. [DefaultSysfsRoot].
+func NewVolumeSettings(root string) *VolumeSettings {
+	return &VolumeSettings{sysfs: sysfs{root: root}}
+}
+
+// volume is a device-mapper device or md array handed out to one pod.
+type volume struct {
+	domain   string
+	kind     volumeKind
+	dev      udev.Device
+	settings *VolumeSettings
+}
+
+// name returns the stable name of the volume: DM_NAME, or MD_DEVNAME with the
+// kernel name as a fallback for unnamed arrays.
+func (v *volume) name() string {
+	if v.kind == volumeDm {
+		return v.dev.Property(udev.PropertyDmName)
+	}
+	if name := v.dev.Property(udev.PropertyMdDevname); name != "" {
+		return name
+	}
+	return udev.Sysname(v.dev)
+}
+
+func (v *volume) Id() Id {
+	return Id(v.name())
+}
+
+// Health is Unhealthy while the device is gone, its dm table is suspended or
+// its md array is degraded.
+func (v *volume) Health() Health {
+	dir := filepath.Join("class", "block", udev.Sysname(v.dev))
+	if !v.settings.sysfs.exists(dir) {
+		return Unhealthy{Reason: fmt.Sprintf("volume %s is gone", v.name())}
+	}
+	switch v.kind {
+	case volumeDm:
+		if v.settings.sysfs.read(filepath.Join(dir, udev.SysAttrDmSuspended)) == "1" {
+			return Unhealthy{Reason: fmt.Sprintf("volume %s is suspended", v.name())}
+		}
+	case volumeMd:
+		// Arrays without redundancy have no degraded attribute.
+		if degraded := v.settings.sysfs.read(filepath.Join(dir, udev.SysAttrMdDegraded)); degraded != "" && degraded != "0" {
+			return Unhealthy{Reason: fmt.Sprintf("volume %s is degraded, %s members missing", v.name(), degraded)}
+		}
+	}
+	return Healthy{}
+}
+
+func (v *volume) udevDevices() []udev.Device { return []udev.Device{v.dev} }
+
+// TopologyHints is nil: virtual block devices have no NUMA node.
+func (v *volume) TopologyHints() *pluginapi.TopologyInfo {
+	return nil
+}
+
+// containerPath returns where the volume appears in containers: its
+// /dev/mapper path for device-mapper devices, /dev/md/<name> for named md
+// arrays and /dev/<name> for others.
+func (v *volume) containerPath() string {
+	if v.kind == volumeDm {
+		return path.Join(dmMapperDir, v.name())
+	}
+	if name := v.dev.Property(udev.PropertyMdDevname); name != "" {
+		return path.Join(mdNamedDir, name)
+	}
+	return path.Join("/dev", udev.Sysname(v.dev))
+}
+
+// Allocate passes the device at its containerPath, which is also set in
+// <DOMAIN>_VOLUME_<NAME>_PATH.
+func (v *volume) Allocate(context.Context) (*pluginapi.ContainerAllocateResponse, error) {
+	containerPath := v.containerPath()
+	envName := func(env string) string {
+		return sanitizeEnv(v.domain) + "_VOLUME_" + sanitizeEnv(v.name()) + "_" + env
+	}
+	response := &pluginapi.ContainerAllocateResponse{
+		Devices: []*pluginapi.DeviceSpec{{
+			HostPath:      v.dev.DevNode(),
+			ContainerPath: containerPath,
+			Permissions:   "rw",
+		}},
+		Envs: map[string]string{
+			envName("PATH"): containerPath,
+		},
+	}
+	if v.kind == volumeMd {
+		response.Envs[envName("LEVEL")] = v.dev.Property(udev.PropertyMdLevel)
+	}
+	klog.Info("allocated volume: ", v.name())
+	klog.V(2).Infof("%+v", response)
+	return response, nil
+}
+
+// VolumeMatcherTemplater returns a FromDevice function that produces the
+// ResourceTemplate <domain>/volume-<name> for device-mapper devices and md arrays
+// matching matcher.
+func VolumeMatcherTemplater(domain, name string, matcher VolumeMatcher) FromDevice[*ResourceTemplate] {
+	return func(dev udev.Device) (*ResourceTemplate, error) {
+		if !matcher.match(dev, volumeKindOf(dev)) {
+			return nil, nil
+		}
+
+		return &ResourceTemplate{
+			Domain: domain,
+			Prefix: "volume-" + name,
+		}, nil
+	}
+}
+
+// VolumeMatcherInstances returns a FromDevice function that produces one
+// volume instance per device-mapper device or md array matching matcher.
+func VolumeMatcherInstances(domain string, matcher VolumeMatcher, settings *VolumeSettings) FromDevice[[]*volume] {
+	return func(dev udev.Device) ([]*volume, error) {
+		kind := volumeKindOf(dev)
+		if !matcher.match(dev, kind) {
+			return nil, nil
+		}
+		klog.V(5).Infof("found volume %s", dev.Id())
+
+		return []*volume{{
+			domain:   domain,
+			kind:     kind,
+			dev:      dev,
+			settings: settings,
+		}}, nil
+	}
+}
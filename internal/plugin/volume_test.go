@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+
+	"github.com/ydb-platform/udev-manager/internal/udev"
+)
+
+// volumeDevice returns a mock block device named name with the given udev
+// properties.
+func volumeDevice(name string, properties map[string]string) *mockDevice {
+	return &mockDevice{
+		id:         udev.Id("/sys/devices/virtual/block/" + name),
+		subsystem:  udev.BlockSubsystem,
+		devType:    udev.DeviceTypeDisk,
+		devNode:    "/dev/" + name,
+		properties: properties,
+		sysattrs:   map[string]string{},
+		numaNode:   -1,
+	}
+}
+
+func lvmVolume() *mockDevice {
+	return volumeDevice("dm-3", map[string]string{
+		udev.PropertyDmName:   "ydb-data",
+		udev.PropertyDmVgName: "ydb",
+		udev.PropertyDmLvName: "data",
+		udev.PropertyDmUUID:   "LVM-0123456789abcdef",
+	})
+}
+
+func mdArray() *mockDevice {
+	return volumeDevice("md127", map[string]string{
+		udev.PropertyMdName:    "host:wal",
+		udev.PropertyMdDevname: "wal",
+		udev.PropertyMdLevel:   "raid1",
+	})
+}
+
+var _ = Describe("VolumeMatcher", func() {
+	It("matches device-mapper devices on their dm properties", func() {
+		dev := lvmVolume()
+		Expect(VolumeMatcher{VG: regexp.MustCompile(`^ydb$`)}.match(dev, volumeDm)).To(BeTrue())
+		Expect(VolumeMatcher{UUID: regexp.MustCompile(`^LVM-`), LV: regexp.MustCompile(`^data$`)}.match(dev, volumeDm)).To(BeTrue())
+		Expect(VolumeMatcher{UUID: regexp.MustCompile(`^CRYPT-`)}.match(dev, volumeDm)).To(BeFalse())
+		Expect(VolumeMatcher{Level: regexp.MustCompile(`.*`)}.match(dev, volumeDm)).To(BeFalse())
+	})
+
+	It("matches md arrays on their name and level", func() {
+		dev := mdArray()
+		Expect(VolumeMatcher{Name: regexp.MustCompile(`:wal$`)}.match(dev, volumeMd)).To(BeTrue())
+		Expect(VolumeMatcher{Level: regexp.MustCompile(`^raid0$`)}.match(dev, volumeMd)).To(BeFalse())
+		Expect(VolumeMatcher{VG: regexp.MustCompile(`.*`)}.match(dev, volumeMd)).To(BeFalse())
+	})
+
+	It("tells volumes apart from other block devices", func() {
+		Expect(volumeKindOf(lvmVolume())).To(Equal(volumeDm))
+		Expect(volumeKindOf(mdArray())).To(Equal(volumeMd))
+		Expect(volumeKindOf(volumeDevice("dm-4", map[string]string{}))).To(Equal(volumeNone))
+		Expect(volumeKindOf(partitionDevice("md127p1", "data"))).To(Equal(volumeNone))
+		Expect(volumeKindOf(netDevice("eth0", "1000", "up"))).To(Equal(volumeNone))
+	})
+})
+
+var _ = Describe("VolumeMatcherTemplater", func() {
+	It("returns the configured resource for matching volumes only", func() {
+		templater := VolumeMatcherTemplater("ydb.tech", "lvm", VolumeMatcher{VG: regexp.MustCompile(`^ydb$`)})
+
+		tmpl, err := templater(lvmVolume())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tmpl).To(Equal(&ResourceTemplate{Domain: "ydb.tech", Prefix: "volume-lvm"}))
+
+		tmpl, err = templater(mdArray())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tmpl).To(BeNil())
+	})
+})
+
+var _ = Describe("volume", func() {
+	var (
+		root     string
+		settings *VolumeSettings
+	)
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		settings = NewVolumeSettings(root)
+		writeSysfsFile(root, "class/block/dm-3/dm/suspended", "0")
+		writeSysfsFile(root, "class/block/md127/md/degraded", "0")
+	})
+
+	instance := func(dev udev.Device) *volume {
+		instances, err := VolumeMatcherInstances("ydb.tech", VolumeMatcher{}, settings)(dev)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instances).To(HaveLen(1))
+		return instances[0]
+	}
+
+	It("is identified by DM_NAME or MD_DEVNAME", func() {
+		Expect(instance(lvmVolume()).Id()).To(Equal(Id("ydb-data")))
+		Expect(instance(mdArray()).Id()).To(Equal(Id("wal")))
+
+		unnamed := mdArray()
+		delete(unnamed.properties, udev.PropertyMdDevname)
+		Expect(instance(unnamed).Id()).To(Equal(Id("md127")))
+	})
+
+	It("is unhealthy while its dm table is suspended", func() {
+		dm := instance(lvmVolume())
+		Expect(dm.Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+		writeSysfsFile(root, "class/block/dm-3/dm/suspended", "1")
+		Expect(dm.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+	})
+
+	It("is unhealthy while its md array is degraded", func() {
+		md := instance(mdArray())
+		Expect(md.Health()).To(BeAssignableToTypeOf(Healthy{}))
+
+		writeSysfsFile(root, "class/block/md127/md/degraded", "1")
+		Expect(md.Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+	})
+
+	It("is healthy for md arrays without redundancy", func() {
+		Expect(os.Remove(filepath.Join(root, "class/block/md127/md/degraded"))).To(Succeed())
+		Expect(instance(mdArray()).Health()).To(BeAssignableToTypeOf(Healthy{}))
+	})
+
+	It("turns unhealthy on a change event of a degraded md array", func() {
+		discovery := udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+		md := mdArray()
+		discovery.AddDevice(md)
+		tmpl := ResourceTemplate{Domain: "ydb.tech", Prefix: "volume-wal"}
+		res := newResource(tmpl, make(map[Id]Instance))
+		DeferCleanup(res.Close)
+		watchCh := res.ListAndWatch(context.Background())
+		Eventually(watchCh).Should(Receive())
+
+		matcher := VolumeMatcher{Level: regexp.MustCompile(`^raid1$`)}
+		scatter := &Scatter[*volume]{
+			templater: VolumeMatcherTemplater("ydb.tech", "wal", matcher),
+			mapper:    VolumeMatcherInstances("ydb.tech", matcher, settings),
+			changes:   true,
+			routes:    map[ResourceTemplate]Resource{tmpl: res},
+			produced:  make(map[udev.Id]map[Id]Instance),
+		}
+		DeferCleanup(scatter.subscribe(discovery))
+		Eventually(watchCh).Should(Receive(WithTransform(firstHealth, BeAssignableToTypeOf(Healthy{}))))
+
+		writeSysfsFile(root, "class/block/md127/md/degraded", "1")
+		Expect(discovery.Change(md)).To(BeTrue())
+		Eventually(watchCh).Should(Receive(WithTransform(firstHealth, BeAssignableToTypeOf(Unhealthy{}))))
+	})
+
+	It("is unhealthy once the device is gone", func() {
+		Expect(os.RemoveAll(filepath.Join(root, "class/block/dm-3"))).To(Succeed())
+		Expect(instance(lvmVolume()).Health()).To(BeAssignableToTypeOf(Unhealthy{}))
+	})
+
+	It("passes device-mapper devices at their /dev/mapper path", func() {
+		resp, err := instance(lvmVolume()).Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Devices).To(Equal([]*pluginapi.DeviceSpec{
+			{HostPath: "/dev/dm-3", ContainerPath: "/dev/mapper/ydb-data", Permissions: "rw"},
+		}))
+		Expect(resp.Envs).To(Equal(map[string]string{
+			"YDB_TECH_VOLUME_YDB_DATA_PATH": "/dev/mapper/ydb-data",
+		}))
+	})
+
+	It("passes named md arrays at their /dev/md path with their level", func() {
+		resp, err := instance(mdArray()).Allocate(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Devices).To(Equal([]*pluginapi.DeviceSpec{
+			{HostPath: "/dev/md127", ContainerPath: "/dev/md/wal", Permissions: "rw"},
+		}))
+		Expect(resp.Envs).To(Equal(map[string]string{
+			"YDB_TECH_VOLUME_WAL_PATH":  "/dev/md/wal",
+			"YDB_TECH_VOLUME_WAL_LEVEL": "raid1",
+		}))
+	})
+})
@@ -1,12 +1,22 @@
 package udev
 
 import (
+	"path"
+	"sync"
+	"time"
+
 	"github.com/ydb-platform/udev-manager/internal/mux"
 )
 
 // Id is the unique identifier for a device, typically its sysfs path.
 type Id string
 
+// Sysname returns the kernel name of dev, i.e. the last element of its sysfs
+// path (e.g. "mlx5_0" or "uverbs0").
+func Sysname(dev Device) string {
+	return path.Base(string(dev.Id()))
+}
+
 // Device represents a single udev device and exposes its attributes.
 type Device interface {
 	Id() Id
@@ -42,7 +52,8 @@ type Init struct {
 
 func (Init) eventSealed() {}
 
-// Added is emitted when a new device appears in the system.
+// Added is emitted when a new device appears in the system, and again when
+// a watched device changes (see [Discovery]).
 type Added struct {
 	Device
 }
@@ -64,10 +75,87 @@ type Slice interface {
 
 // Discovery is the top-level interface for device enumeration and monitoring.
 // Subscribe delivers an [Init] snapshot followed by [Added]/[Removed] events.
+//
+// The change, bind and unbind events of a device are delivered as [Added]
+// only if a filter passed to Watch matches the device, so that subscribers
+// re-evaluate it; the others are dropped. Resources watch only the devices
+// whose state their health follows, so that the change events block devices
+// get on every close after a write do not re-evaluate partitions. They rely
+// on these events for:
+//   - the link and port state of net and infiniband devices (RDMA),
+//   - the netdev of an SR-IOV PF and the slaves of a bond (SR-IOV, netbw),
+//   - the driver a PCI device is bound to (VFIO),
+//   - degraded md arrays and suspended dm tables (volumes),
+//   - the paths of a multipath map (partitions of the map).
 type Discovery interface {
 	mux.Source[Event]
 	DeviceById(Id) Device
 	State(mux.FilterFunc[Device]) map[Id]Device
 	Slice(mux.FilterFunc[Device]) Slice
+	Watch(mux.FilterFunc[Device]) mux.CancelFunc
+	Stats() Stats
 	Close()
 }
+
+// watchers are the filters passed to [Discovery.Watch].
+type watchers struct {
+	mu      sync.RWMutex
+	next    int
+	filters map[int]mux.FilterFunc[Device]
+}
+
+// add registers filter until the returned CancelFunc is called.
+func (w *watchers) add(filter mux.FilterFunc[Device]) mux.CancelFunc {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.filters == nil {
+		w.filters = make(map[int]mux.FilterFunc[Device])
+	}
+	key := w.next
+	w.next++
+	w.filters[key] = filter
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.filters, key)
+	}
+}
+
+// refreshes reports whether an event with action on dev is delivered: add,
+// remove and their like always are, change, bind and unbind only for watched
+// devices.
+func (w *watchers) refreshes(action string, dev Device) bool {
+	switch action {
+	case ActionChange, ActionBind, ActionUnbind:
+	default:
+		return true
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, filter := range w.filters {
+		if filter(dev) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats describes the devices a [Discovery] knows and the events it has seen
+// since it started.
+type Stats struct {
+	Devices    int            `json:"devices"`
+	Subsystems map[string]int `json:"subsystems"` // devices by subsystem
+	Added      uint64         `json:"added"`      // Added events, including changes
+	Removed    uint64         `json:"removed"`
+	Reconnects uint64         `json:"reconnects"` // reconnections to the udev monitor
+	LastEvent  time.Time      `json:"lastEvent,omitzero"`
+}
+
+// statsOf counts the devices of state into a Stats.
+func statsOf(state map[Id]Device) Stats {
+	stats := Stats{Devices: len(state), Subsystems: make(map[string]int)}
+	for _, dev := range state {
+		stats.Subsystems[dev.Subsystem()]++
+	}
+	return stats
+}
@@ -3,6 +3,7 @@ package udev
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ydb-platform/udev-manager/internal/mux"
 )
@@ -148,13 +149,16 @@ func (d *FakeDevice) Debug() string { return fmt.Sprintf("FakeDevice[%s]", d.id)
 // ---------------------------------------------------------------------------
 
 // FakeDiscovery is an in-memory [Discovery] that test code drives by calling
-// [FakeDiscovery.AddDevice] and [FakeDiscovery.Emit]. It implements the full
+// [FakeDiscovery.AddDevice], [FakeDiscovery.Emit] and [FakeDiscovery.Change]. It implements the full
 // Discovery interface and can be passed wherever a real udev Discovery is
 // expected.
 type FakeDiscovery struct {
-	mu    sync.RWMutex
-	state map[Id]Device
-	m     *mux.Mux[Event]
+	mu     sync.RWMutex
+	state  map[Id]Device
+	m      *mux.Mux[Event]
+	events Stats
+
+	watchers watchers
 }
 
 // NewFakeDiscovery creates a FakeDiscovery with an empty device state.
@@ -184,12 +188,30 @@ func (f *FakeDiscovery) Emit(ev Event) {
 	switch e := ev.(type) {
 	case Added:
 		f.state[e.Id()] = e.Device
+		f.events.Added++
 	case Removed:
 		delete(f.state, e.Id())
+		f.events.Removed++
 	}
+	f.events.LastEvent = time.Now()
 	_ = f.m.Submit(ev)
 }
 
+// Change emits dev as [Added], as the udev discovery does on a change event,
+// if a filter passed to Watch matches it, and reports whether it did.
+func (f *FakeDiscovery) Change(dev Device) bool {
+	if !f.watchers.refreshes(ActionChange, dev) {
+		return false
+	}
+	f.Emit(Added{dev})
+	return true
+}
+
+// Watch registers filter for [FakeDiscovery.Change].
+func (f *FakeDiscovery) Watch(filter mux.FilterFunc[Device]) mux.CancelFunc {
+	return f.watchers.add(filter)
+}
+
 // Subscribe delivers an [Init] event carrying the current device state to
 // sink, then subscribes it to all subsequent events. The returned [mux.CancelFunc]
 // unsubscribes sink.
@@ -236,6 +258,17 @@ func (f *FakeDiscovery) Slice(filter mux.FilterFunc[Device]) Slice {
 	return makeSlice(f, filter)
 }
 
+// Stats counts the current devices and the events passed to Emit.
+func (f *FakeDiscovery) Stats() Stats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	stats := statsOf(f.state)
+	stats.Added = f.events.Added
+	stats.Removed = f.events.Removed
+	stats.LastEvent = f.events.LastEvent
+	return stats
+}
+
 // Close shuts down the FakeDiscovery and closes all subscriber sinks.
 func (f *FakeDiscovery) Close() {
 	f.m.Close()
@@ -19,14 +19,37 @@ import (
 // sysfs attribute names, and action strings used throughout the package.
 const (
 	BlockSubsystem = "block"
+	NvmeSubsystem  = "nvme" // NVMe controllers, the parents of namespaces
 	NetSubsystem   = "net"
+	PCISubsystem   = "pci"
+
+	InfinibandSubsystem      = "infiniband"
+	InfinibandVerbsSubsystem = "infiniband_verbs"
+
+	NvmeGenericSubsystem = "nvme-generic" // char devices of NVMe namespaces, e.g. ng0n1
 
 	DeviceTypeKey  = "DEVTYPE"
+	DeviceTypeDisk = "disk"
 	DeviceTypePart = "partition"
 
-	PropertyPartName    = "PARTNAME"
-	PropertyModel       = "ID_MODEL"
-	PropertyShortSerial = "ID_SERIAL_SHORT"
+	PropertyPartName      = "PARTNAME"
+	PropertyModel         = "ID_MODEL"
+	PropertyShortSerial   = "ID_SERIAL_SHORT"
+	PropertyFsType        = "ID_FS_TYPE"
+	PropertyWWN           = "ID_WWN"
+	PropertyBus           = "ID_BUS"
+	PropertyPartUUID      = "ID_PART_ENTRY_UUID"
+	PropertyPartEntryName = "ID_PART_ENTRY_NAME" // partition label as probed by blkid
+	PropertyPartNumber    = "PARTN"
+
+	PropertyDmName   = "DM_NAME"
+	PropertyDmVgName = "DM_VG_NAME"
+	PropertyDmLvName = "DM_LV_NAME"
+	PropertyDmUUID   = "DM_UUID"
+
+	PropertyMdName    = "MD_NAME"
+	PropertyMdDevname = "MD_DEVNAME" // name under /dev/md of named arrays
+	PropertyMdLevel   = "MD_LEVEL"
 
 	PropertyInterface = "INTERFACE"
 
@@ -34,13 +57,34 @@ const (
 	SysAttrModel  = "model"
 	SysAttrSerial = "serial"
 
-	SysAttrSpeed     = "speed"
-	SysAttrOperstate = "operstate"
+	SysAttrSize              = "size" // in 512-byte sectors, whatever the block size
+	SysAttrLogicalBlockSize  = "queue/logical_block_size"
+	SysAttrPhysicalBlockSize = "queue/physical_block_size"
+	SysAttrRotational        = "queue/rotational"
+	SysAttrZoned             = "queue/zoned"
+
+	SysAttrDmSuspended = "dm/suspended"
+	SysAttrMdDegraded  = "md/degraded" // number of missing members of redundant arrays
+
+	SysAttrSpeed         = "speed"
+	SysAttrOperstate     = "operstate"
+	SysAttrBondingSlaves = "bonding/slaves"
+
+	SysAttrNumaNode = "numa_node"
+
+	SysAttrVendor = "vendor" // PCI vendor id, e.g. "0x144d"
+	SysAttrDevice = "device" // PCI device id, e.g. "0xa80a"
+	SysAttrClass  = "class"  // PCI class, e.g. "0x010802"
+
+	SysAttrIbdev = "ibdev" // RDMA device of an infiniband_verbs device
 
 	ActionAdd     = "add"
 	ActionRemove  = "remove"
 	ActionOffline = "offline"
 	ActionOnline  = "online"
+	ActionChange  = "change"
+	ActionBind    = "bind"
+	ActionUnbind  = "unbind"
 )
 
 type monitorRequest interface {
@@ -164,7 +208,7 @@ func (g *generic) Tags() []string {
 }
 
 func (g *generic) NumaNode() int {
-	numaNodeStr := g.SystemAttributeLookup("numa_node")
+	numaNodeStr := g.SystemAttributeLookup(SysAttrNumaNode)
 	if numaNode, err := strconv.Atoi(numaNodeStr); err == nil {
 		return numaNode
 	}
@@ -233,6 +277,8 @@ type udevDiscovery struct {
 	mux      *mux.Mux[Event]
 	wg       *sync.WaitGroup
 	done     chan struct{} // closed when monitor exits
+	events   Stats         // event counters, guarded by mu
+	watchers watchers
 }
 
 // NewDiscovery creates a real udev-backed Discovery. It starts a monitor
@@ -284,6 +330,24 @@ func (d *udevDiscovery) Slice(filter mux.FilterFunc[Device]) Slice {
 	return makeSlice(d, filter)
 }
 
+// Watch delivers the change, bind and unbind events of the devices filter
+// matches until the returned CancelFunc is called. filter runs on the monitor
+// goroutine and must not block.
+func (d *udevDiscovery) Watch(filter mux.FilterFunc[Device]) mux.CancelFunc {
+	return d.watchers.add(filter)
+}
+
+func (d *udevDiscovery) Stats() Stats {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	stats := statsOf(d.state)
+	stats.Added = d.events.Added
+	stats.Removed = d.events.Removed
+	stats.Reconnects = d.events.Reconnects
+	stats.LastEvent = d.events.LastEvent
+	return stats
+}
+
 // makeSlice creates a Slice backed by any event Source. It subscribes to src
 // (receiving an Init followed by Add/Remove events), applies filter, and
 // publishes the current matching device set to downstream subscribers each
@@ -416,15 +480,21 @@ func (d *udevDiscovery) monitor(wg *sync.WaitGroup) {
 		select {
 		case dev := <-devChan:
 			klog.V(5).Infof("Received device event (%s): %s", dev.Action(), dev.Syspath())
-			switch dev.Action() {
-			case ActionAdd, ActionOnline:
+			action := dev.Action()
+			switch action {
+			case ActionAdd, ActionOnline, ActionChange, ActionBind, ActionUnbind:
 				id := Id(dev.Syspath())
 				dev := &generic{
 					udev: d,
 					dev:  dev,
 				}
+				if !d.watchers.refreshes(action, dev) {
+					continue
+				}
 				d.mu.Lock()
 				d.state[id] = dev
+				d.events.Added++
+				d.events.LastEvent = time.Now()
 				d.mu.Unlock()
 				if err := d.mux.Submit(Added{dev}); err != nil {
 					klog.Errorf("udev: failed to submit Added event: %v", err)
@@ -434,6 +504,10 @@ func (d *udevDiscovery) monitor(wg *sync.WaitGroup) {
 				d.mu.Lock()
 				dev, ok := d.state[id]
 				delete(d.state, id)
+				if ok {
+					d.events.Removed++
+					d.events.LastEvent = time.Now()
+				}
 				d.mu.Unlock()
 				if !ok {
 					klog.V(5).Infof("udev: ignoring Remove for unknown device %s", id)
@@ -482,6 +556,9 @@ func (d *udevDiscovery) monitor(wg *sync.WaitGroup) {
 				time.Sleep(1 * time.Second)
 				goto retry
 			}
+			d.mu.Lock()
+			d.events.Reconnects++
+			d.mu.Unlock()
 			klog.Infof("Successfully reconnected to udev")
 		}
 	}
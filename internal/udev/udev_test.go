@@ -26,6 +26,17 @@ func isBlock(dev udev.Device) bool {
 	return dev.Subsystem() == udev.BlockSubsystem && dev.DevType() == udev.DeviceTypePart
 }
 
+// ---------------------------------------------------------------------------
+// Sysname
+// ---------------------------------------------------------------------------
+
+var _ = Describe("Sysname", func() {
+	It("returns the last element of the sysfs path", func() {
+		dev := udev.NewFakeDevice("/sys/devices/pci0000:00/0000:00:01.0/infiniband/mlx5_0")
+		Expect(udev.Sysname(dev)).To(Equal("mlx5_0"))
+	})
+})
+
 // ---------------------------------------------------------------------------
 // FakeDevice
 // ---------------------------------------------------------------------------
@@ -449,6 +460,42 @@ var _ = Describe("FakeDiscovery DeviceById", func() {
 	})
 })
 
+// ---------------------------------------------------------------------------
+// FakeDiscovery — Watch
+// ---------------------------------------------------------------------------
+
+var _ = Describe("FakeDiscovery Watch", func() {
+	var (
+		discovery *udev.FakeDiscovery
+		events    chan udev.Event
+		dev       *udev.FakeDevice
+	)
+
+	BeforeEach(func() {
+		discovery = udev.NewFakeDiscovery()
+		DeferCleanup(discovery.Close)
+		events = make(chan udev.Event, 4)
+		DeferCleanup(discovery.Subscribe(mux.SinkFromChan(events)))
+		Eventually(events).Should(Receive(BeAssignableToTypeOf(udev.Init{})))
+		dev = blockPartition("sda1", "data_01")
+	})
+
+	It("drops changes of devices nobody watches", func() {
+		Expect(discovery.Change(dev)).To(BeFalse())
+		Consistently(events).ShouldNot(Receive())
+	})
+
+	It("delivers changes of watched devices as Added until cancelled", func() {
+		cancel := discovery.Watch(isBlock)
+		Expect(discovery.Change(udev.NewFakeDevice("eth0").WithSubsystem(udev.NetSubsystem))).To(BeFalse())
+		Expect(discovery.Change(dev)).To(BeTrue())
+		Eventually(events).Should(Receive(Equal(udev.Added{Device: dev})))
+
+		cancel()
+		Expect(discovery.Change(dev)).To(BeFalse())
+	})
+})
+
 // ---------------------------------------------------------------------------
 // Slice
 // ---------------------------------------------------------------------------
@@ -606,3 +653,27 @@ var _ = Describe("Slice", func() {
 		Eventually(ch2).Should(Receive(ConsistOf(dev)))
 	})
 })
+
+// ---------------------------------------------------------------------------
+// FakeDiscovery — Stats
+// ---------------------------------------------------------------------------
+
+var _ = Describe("FakeDiscovery Stats", func() {
+	It("counts devices by subsystem and the emitted events", func() {
+		d := udev.NewFakeDiscovery()
+		defer d.Close()
+		Expect(d.Stats()).To(Equal(udev.Stats{Subsystems: map[string]int{}}))
+
+		d.AddDevice(blockPartition("nvme0n1p1", "data"))
+		d.Emit(udev.Added{Device: blockPartition("nvme0n1p2", "log")})
+		d.Emit(udev.Added{Device: udev.NewFakeDevice("eth0").WithSubsystem("net")})
+		d.Emit(udev.Removed{Device: blockPartition("nvme0n1p1", "data")})
+
+		stats := d.Stats()
+		Expect(stats.Devices).To(Equal(2))
+		Expect(stats.Subsystems).To(Equal(map[string]int{"block": 1, "net": 1}))
+		Expect(stats.Added).To(BeEquivalentTo(2))
+		Expect(stats.Removed).To(BeEquivalentTo(1))
+		Expect(stats.LastEvent).NotTo(BeZero())
+	})
+})